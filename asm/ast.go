@@ -11,7 +11,9 @@ type block struct {
 }
 
 type ASTBuilder struct {
-	AST   *AST
+	AST *AST
+	// Pos is given to statements as they are added, when it is known.
+	Pos   SourcePos
 	stack []block
 }
 
@@ -33,7 +35,21 @@ func (builder *ASTBuilder) OpenLoop(operand int) {
 	builder.stack = append(builder.stack, blk)
 }
 
+func (builder *ASTBuilder) OpenProcedure(name string, stack int) {
+	builder.prepare()
+
+	proc := &ProcedureStmt{Name: name}
+	proc.Operand = stack
+	builder.appendToBlock(proc)
+	blk := block{statements: &proc.Nest}
+	builder.stack = append(builder.stack, blk)
+}
+
 func (builder *ASTBuilder) appendToBlock(stmt Statement) {
+	if builder.Pos.IsKnown() {
+		Locate(builder.Pos, stmt)
+	}
+
 	tip := builder.stack[len(builder.stack)-1]
 	*tip.statements = append(*tip.statements, stmt)
 }
@@ -67,7 +83,7 @@ func (ast *AST) Visit(visitor ASTVisitor) {
 	visitor.VisitAST(ast)
 
 	for _, stmt := range ast.Statements {
-		stmt.Visit(visitor)
+		visitStatement(visitor, stmt)
 	}
 
 	visitor.LeaveAST(ast)
@@ -106,13 +122,42 @@ type ASTVisitor interface {
 	VisitCopy(copy *CopyStmt)
 	VisitJump(jump *JumpStmt)
 	VisitCall(call *CallStmt)
+	VisitLabel(label *LabelStmt)
+	VisitAddImmediate(add *AddImmediateStmt)
+	VisitSubImmediate(sub *SubImmediateStmt)
+	VisitPushImmediate(push *PushImmediateStmt)
+	VisitMul(mul *MulStmt)
+	VisitDiv(div *DivStmt)
+	VisitMod(mod *ModStmt)
+	VisitSignedDiv(sdiv *SignedDivStmt)
+	VisitSignedMod(smod *SignedModStmt)
+	VisitAnd(and *AndStmt)
+	VisitOr(or *OrStmt)
+	VisitXor(xor *XorStmt)
+	VisitNot(not *NotStmt)
+	VisitShiftLeft(shl *ShiftLeftStmt)
+	VisitShiftRight(shr *ShiftRightStmt)
+	VisitSignedShiftRight(sar *SignedShiftRightStmt)
+	VisitJumpZero(jump *JumpZeroStmt)
+	VisitJumpAlways(jump *JumpAlwaysStmt)
+	VisitEqual(eq *EqualStmt)
+	VisitLess(lt *LessStmt)
+	VisitGreater(gt *GreaterStmt)
+	VisitSignedLess(slt *SignedLessStmt)
+	VisitSignedGreater(sgt *SignedGreaterStmt)
+	VisitProcedure(proc *ProcedureStmt)
+	LeaveProcedure(proc *ProcedureStmt)
+	VisitCallSub(call *CallSubStmt)
+	VisitReturn(ret *ReturnStmt)
 }
 
 type OneOperandStmt struct {
+	Located
 	Operand int
 }
 
 type TwoOperandStmt struct {
+	Located
 	Operand [2]int
 }
 
@@ -153,8 +198,11 @@ type CopyStmt struct {
 	TwoOperandStmt
 }
 
+// JumpStmt jumps to the address in Operand[1], unless Label is set, in which
+// case it jumps to the LabelStmt of that name.
 type JumpStmt struct {
 	TwoOperandStmt
+	Label string
 }
 
 type CallStmt struct {
@@ -162,13 +210,158 @@ type CallStmt struct {
 	OneOperandStmt
 }
 
+// AddImmediateStmt adds the constant Operand[1] to the register Operand[0].
+type AddImmediateStmt struct {
+	TwoOperandStmt
+}
+
+// SubImmediateStmt subtracts the constant Operand[1] from the register
+// Operand[0].
+type SubImmediateStmt struct {
+	TwoOperandStmt
+}
+
+// PushImmediateStmt pushes the constant Operand[1] onto the stack Operand[0].
+type PushImmediateStmt struct {
+	TwoOperandStmt
+}
+
+type MulStmt struct {
+	TwoOperandStmt
+}
+
+type DivStmt struct {
+	TwoOperandStmt
+}
+
+type ModStmt struct {
+	TwoOperandStmt
+}
+
+type SignedDivStmt struct {
+	TwoOperandStmt
+}
+
+type SignedModStmt struct {
+	TwoOperandStmt
+}
+
+type AndStmt struct {
+	TwoOperandStmt
+}
+
+type OrStmt struct {
+	TwoOperandStmt
+}
+
+type XorStmt struct {
+	TwoOperandStmt
+}
+
+type NotStmt struct {
+	OneOperandStmt
+}
+
+type ShiftLeftStmt struct {
+	TwoOperandStmt
+}
+
+type ShiftRightStmt struct {
+	TwoOperandStmt
+}
+
+type SignedShiftRightStmt struct {
+	TwoOperandStmt
+}
+
+// JumpZeroStmt jumps if the register Operand[0] is zero, otherwise it behaves
+// like JumpStmt.
+type JumpZeroStmt struct {
+	TwoOperandStmt
+	Label string
+}
+
+// JumpAlwaysStmt jumps unconditionally to the address in Operand, unless Label
+// is set.
+type JumpAlwaysStmt struct {
+	OneOperandStmt
+	Label string
+}
+
+type EqualStmt struct {
+	TwoOperandStmt
+}
+
+type LessStmt struct {
+	TwoOperandStmt
+}
+
+type GreaterStmt struct {
+	TwoOperandStmt
+}
+
+type SignedLessStmt struct {
+	TwoOperandStmt
+}
+
+type SignedGreaterStmt struct {
+	TwoOperandStmt
+}
+
+// LabelStmt names the address of the statement that follows it.
+type LabelStmt struct {
+	Located
+	Name string
+}
+
+// ProcedureStmt defines a subroutine called Name that takes its parameters on
+// the stack Operand.  Control skips over the definition, and the subroutine
+// returns when it reaches the end of Nest.
+type ProcedureStmt struct {
+	Name string
+	OneOperandStmt
+	Nest []Statement
+}
+
+// CallSubStmt calls the subroutine at the address in Operand[1], unless Label
+// is set, passing parameters on the stack Operand[0].
+type CallSubStmt struct {
+	TwoOperandStmt
+	Label string
+}
+
+// ReturnStmt returns early from a subroutine.
+type ReturnStmt struct {
+	Located
+}
+
+func (stmt *ProcedureStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitProcedure(stmt)
+
+	for _, nestedStmt := range stmt.Nest {
+		visitStatement(visitor, nestedStmt)
+	}
+
+	visitPosition(visitor, stmt)
+	visitor.LeaveProcedure(stmt)
+}
+
+func (stmt *CallSubStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitCallSub(stmt)
+}
+
+func (stmt *ReturnStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitReturn(stmt)
+}
+
 func (stmt *LoopStmt) Visit(visitor ASTVisitor) {
 	visitor.VisitLoop(stmt)
 
 	for _, nestedStmt := range stmt.Nest {
-		nestedStmt.Visit(visitor)
+		visitStatement(visitor, nestedStmt)
 	}
 
+	visitPosition(visitor, stmt)
 	visitor.LeaveLoop(stmt)
 }
 
@@ -212,6 +405,98 @@ func (stmt *CallStmt) Visit(visitor ASTVisitor) {
 	visitor.VisitCall(stmt)
 }
 
+func (stmt *JumpZeroStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitJumpZero(stmt)
+}
+
+func (stmt *JumpAlwaysStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitJumpAlways(stmt)
+}
+
+func (stmt *EqualStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitEqual(stmt)
+}
+
+func (stmt *LessStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitLess(stmt)
+}
+
+func (stmt *GreaterStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitGreater(stmt)
+}
+
+func (stmt *SignedLessStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitSignedLess(stmt)
+}
+
+func (stmt *SignedGreaterStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitSignedGreater(stmt)
+}
+
+func (stmt *LabelStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitLabel(stmt)
+}
+
+func (stmt *AddImmediateStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitAddImmediate(stmt)
+}
+
+func (stmt *SubImmediateStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitSubImmediate(stmt)
+}
+
+func (stmt *PushImmediateStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitPushImmediate(stmt)
+}
+
+func (stmt *MulStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitMul(stmt)
+}
+
+func (stmt *DivStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitDiv(stmt)
+}
+
+func (stmt *ModStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitMod(stmt)
+}
+
+func (stmt *SignedDivStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitSignedDiv(stmt)
+}
+
+func (stmt *SignedModStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitSignedMod(stmt)
+}
+
+func (stmt *AndStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitAnd(stmt)
+}
+
+func (stmt *OrStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitOr(stmt)
+}
+
+func (stmt *XorStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitXor(stmt)
+}
+
+func (stmt *NotStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitNot(stmt)
+}
+
+func (stmt *ShiftLeftStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitShiftLeft(stmt)
+}
+
+func (stmt *ShiftRightStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitShiftRight(stmt)
+}
+
+func (stmt *SignedShiftRightStmt) Visit(visitor ASTVisitor) {
+	visitor.VisitSignedShiftRight(stmt)
+}
+
 func (loop *LoopStmt) String() string {
 	buff := &bytes.Buffer{}
 	buff.WriteString(fmt.Sprintf("Loop(%d) { ", loop.Operand))
@@ -225,6 +510,19 @@ func (loop *LoopStmt) String() string {
 	return buff.String()
 }
 
+func (proc *ProcedureStmt) String() string {
+	buff := &bytes.Buffer{}
+	buff.WriteString(fmt.Sprintf("Procedure(%s, %d) { ", proc.Name, proc.Operand))
+
+	for _, stmt := range proc.Nest {
+		buff.WriteString(fmt.Sprint(stmt))
+		buff.WriteRune(' ')
+	}
+	buff.WriteString("};")
+
+	return buff.String()
+}
+
 func (stmt *AddStmt) String() string {
 	return fmt.Sprintf("Add(%d, %d);", stmt.Operand[0], stmt.Operand[1])
 }
@@ -250,8 +548,95 @@ func (stmt *CopyStmt) String() string {
 	return fmt.Sprintf("Copy(%d, %d);", stmt.Operand[0], stmt.Operand[1])
 }
 func (stmt *JumpStmt) String() string {
+	if stmt.Label != "" {
+		return fmt.Sprintf("Jump(%d, %s);", stmt.Operand[0], stmt.Label)
+	}
 	return fmt.Sprintf("Jump(%d, %d);", stmt.Operand[0], stmt.Operand[1])
 }
 func (stmt *CallStmt) String() string {
 	return fmt.Sprintf("Call(%s, %d);", stmt.VmFunc, stmt.Operand)
 }
+func (stmt *LabelStmt) String() string {
+	return fmt.Sprintf("Label(%s);", stmt.Name)
+}
+func (stmt *AddImmediateStmt) String() string {
+	return fmt.Sprintf("AddImmediate(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *SubImmediateStmt) String() string {
+	return fmt.Sprintf("SubImmediate(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *PushImmediateStmt) String() string {
+	return fmt.Sprintf("PushImmediate(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *MulStmt) String() string {
+	return fmt.Sprintf("Mul(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *DivStmt) String() string {
+	return fmt.Sprintf("Div(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *ModStmt) String() string {
+	return fmt.Sprintf("Mod(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *SignedDivStmt) String() string {
+	return fmt.Sprintf("SignedDiv(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *SignedModStmt) String() string {
+	return fmt.Sprintf("SignedMod(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *AndStmt) String() string {
+	return fmt.Sprintf("And(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *OrStmt) String() string {
+	return fmt.Sprintf("Or(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *XorStmt) String() string {
+	return fmt.Sprintf("Xor(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *NotStmt) String() string {
+	return fmt.Sprintf("Not(%d);", stmt.Operand)
+}
+func (stmt *ShiftLeftStmt) String() string {
+	return fmt.Sprintf("ShiftLeft(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *ShiftRightStmt) String() string {
+	return fmt.Sprintf("ShiftRight(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *SignedShiftRightStmt) String() string {
+	return fmt.Sprintf("SignedShiftRight(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *JumpZeroStmt) String() string {
+	if stmt.Label != "" {
+		return fmt.Sprintf("JumpZero(%d, %s);", stmt.Operand[0], stmt.Label)
+	}
+	return fmt.Sprintf("JumpZero(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *JumpAlwaysStmt) String() string {
+	if stmt.Label != "" {
+		return fmt.Sprintf("JumpAlways(%s);", stmt.Label)
+	}
+	return fmt.Sprintf("JumpAlways(%d);", stmt.Operand)
+}
+func (stmt *EqualStmt) String() string {
+	return fmt.Sprintf("Equal(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *LessStmt) String() string {
+	return fmt.Sprintf("Less(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *GreaterStmt) String() string {
+	return fmt.Sprintf("Greater(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *SignedLessStmt) String() string {
+	return fmt.Sprintf("SignedLess(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *SignedGreaterStmt) String() string {
+	return fmt.Sprintf("SignedGreater(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *CallSubStmt) String() string {
+	if stmt.Label != "" {
+		return fmt.Sprintf("CallSub(%d, %s);", stmt.Operand[0], stmt.Label)
+	}
+	return fmt.Sprintf("CallSub(%d, %d);", stmt.Operand[0], stmt.Operand[1])
+}
+func (stmt *ReturnStmt) String() string {
+	return "Return();"
+}
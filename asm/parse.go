@@ -0,0 +1,696 @@
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse reads Shapes assembly text into an AST.
+//
+// A program has one statement per line.  Anything after '#' or ';' is a
+// comment.  Registers are written rN and stacks sN, where N is between 0 and
+// 255.  Immediates are decimal integers and may be negative.
+//
+//	set r0 10        # r0 = 10
+//	copy r1 r0       # r1 = r0
+//	add r0 r1        # r0 += r1
+//	sub r0 r1        # r0 -= r1
+//	addi r0 5        # r0 += 5
+//	subi r0 5        # r0 -= 5
+//	mul r0 r1        # r0 *= r1
+//	div r0 r1        # r0 /= r1, unsigned; sdiv is signed
+//	mod r0 r1        # r0 %= r1, unsigned; smod is signed
+//	and r0 r1        # r0 &= r1
+//	or r0 r1         # r0 |= r1
+//	xor r0 r1        # r0 ^= r1
+//	not r0           # r0 = ^r0
+//	shl r0 r1        # r0 <<= r1
+//	shr r0 r1        # r0 >>= r1, unsigned; sar is signed
+//	push s0 r0       # push r0 onto s0
+//	pop s0 r0        # pop s0 into r0
+//	pushi s0 5       # push 5 onto s0
+//	read r0          # read a byte of input into r0
+//	write r0         # write r0 as a byte of output
+//	jmpnz r0 12      # jump to bytecode address 12 if r0 is not zero
+//	jmpnz r0 done    # jump to the label done if r0 is not zero
+//	jz r0 done       # jump to the label done if r0 is zero
+//	jmp done         # jump to the label done
+//	eq r0 r1         # r0 = 1 if r0 == r1, otherwise 0
+//	lt r0 r1         # r0 = 1 if r0 < r1, unsigned; slt is signed
+//	gt r0 r1         # r0 = 1 if r0 > r1, unsigned; sgt is signed
+//	call tape_new s0 # call a VmFunction, passing stack s0
+//	loop r0 {        # repeat the block while r0 is not zero
+//	}
+//	done:            # label the next statement
+//	ret              # return early from a subroutine
+//
+// Subroutines are defined with proc, naming the stack on which they take
+// their parameters, and called with callsub.  A subroutine returns when it
+// reaches the end of its block.
+//
+//	proc double s1 {
+//		pop s1 r0
+//		add r0 r0
+//		push s1 r0
+//	}
+//	pushi s1 21
+//	callsub double s1
+//
+// Names may be given to registers and stacks with alias, after which the name
+// may be used anywhere its register or stack could be:
+//
+//	alias counter r3
+//	alias tape s0
+//	pushi tape 0
+//	pushi tape 8
+//	call tape_new tape
+//	pop tape counter
+func Parse(source []byte) (*AST, error) {
+	parser := &parser{
+		builder: &ASTBuilder{},
+		aliases: map[string]operand{},
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(source))
+
+	for scanner.Scan() {
+		parser.line++
+		err := parser.parseLine(scanner.Text())
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", parser.line, err.Error())
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if parser.blockDepth != 0 {
+		return nil, fmt.Errorf("Unexpected block nesting depth %d", parser.blockDepth)
+	}
+
+	if parser.builder.AST == nil {
+		return &AST{}, nil
+	}
+
+	return parser.builder.AST, nil
+}
+
+type operandKind byte
+
+const (
+	__REGISTER_OPERAND = operandKind(iota)
+	__STACK_OPERAND
+)
+
+func (kind operandKind) String() string {
+	if kind == __STACK_OPERAND {
+		return "stack"
+	}
+
+	return "register"
+}
+
+type operand struct {
+	kind    operandKind
+	address int
+}
+
+type parser struct {
+	builder    *ASTBuilder
+	aliases    map[string]operand
+	line       int
+	blockDepth int
+}
+
+func (p *parser) parseLine(line string) error {
+	fields := strings.Fields(stripComment(line))
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	mnemonic := fields[0]
+	args := fields[1:]
+
+	if strings.HasSuffix(mnemonic, ":") {
+		return p.label(mnemonic, args)
+	}
+
+	switch mnemonic {
+	case "}":
+		return p.closeBlock(args)
+	case "loop":
+		return p.openLoop(args)
+	case "proc":
+		return p.openProcedure(args)
+	case "callsub":
+		return p.callSub(args)
+	case "ret":
+		return p.ret(args)
+	case "alias":
+		return p.alias(args)
+	case "call":
+		return p.call(args)
+	case "read", "write", "not":
+		return p.oneOperand(mnemonic, args)
+	case "jmpnz", "jz":
+		return p.jump(mnemonic, args)
+	case "jmp":
+		return p.jumpAlways(args)
+	case "set", "addi", "subi":
+		return p.registerImmediate(mnemonic, args)
+	case "pushi":
+		return p.stackImmediate(mnemonic, args)
+	case "add", "sub", "copy", "mul", "div", "mod", "sdiv", "smod", "and", "or", "xor", "shl", "shr", "sar", "eq", "lt", "gt", "slt", "sgt":
+		return p.registerRegister(mnemonic, args)
+	case "push", "pop":
+		return p.stackRegister(mnemonic, args)
+	}
+
+	return fmt.Errorf("Unknown instruction '%s'", mnemonic)
+}
+
+func (p *parser) openLoop(args []string) error {
+	if len(args) != 2 || args[1] != "{" {
+		return errors.New("Expected 'loop REGISTER {'")
+	}
+
+	reg, err := p.operand(args[0], __REGISTER_OPERAND)
+
+	if err != nil {
+		return err
+	}
+
+	p.builder.OpenLoop(reg)
+	p.blockDepth++
+
+	return nil
+}
+
+func (p *parser) openProcedure(args []string) error {
+	if len(args) != 3 || args[2] != "{" {
+		return errors.New("Expected 'proc NAME STACK {'")
+	}
+
+	if !isIdentifier(args[0]) {
+		return fmt.Errorf("Invalid procedure name '%s'", args[0])
+	}
+
+	stack, err := p.operand(args[1], __STACK_OPERAND)
+
+	if err != nil {
+		return err
+	}
+
+	p.builder.OpenProcedure(args[0], stack)
+	p.blockDepth++
+
+	return nil
+}
+
+func (p *parser) closeBlock(args []string) error {
+	if len(args) != 0 {
+		return errors.New("Expected nothing after '}'")
+	}
+
+	if p.blockDepth == 0 {
+		return errors.New("Closed non-existent block")
+	}
+
+	p.blockDepth--
+
+	return p.builder.LeaveBlock()
+}
+
+func (p *parser) alias(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Expected 'alias NAME OPERAND'")
+	}
+
+	name := args[0]
+
+	if !isIdentifier(name) {
+		return fmt.Errorf("Invalid alias name '%s'", name)
+	}
+
+	if _, err := parseLiteralOperand(name); err == nil {
+		return fmt.Errorf("Alias '%s' would shadow an operand", name)
+	}
+
+	if _, ok := p.aliases[name]; ok {
+		return fmt.Errorf("Duplicate alias '%s'", name)
+	}
+
+	target, err := p.anyOperand(args[1])
+
+	if err != nil {
+		return err
+	}
+
+	p.aliases[name] = target
+
+	return nil
+}
+
+func (p *parser) label(mnemonic string, args []string) error {
+	name := strings.TrimSuffix(mnemonic, ":")
+
+	if len(args) != 0 {
+		return fmt.Errorf("Expected nothing after label '%s'", name)
+	}
+
+	if !isIdentifier(name) {
+		return fmt.Errorf("Invalid label name '%s'", name)
+	}
+
+	p.builder.Append(&LabelStmt{Name: name})
+
+	return nil
+}
+
+func (p *parser) jump(mnemonic string, args []string) error {
+	if len(args) != 2 || !isIdentifier(args[1]) {
+		return p.registerImmediate(mnemonic, args)
+	}
+
+	reg, err := p.operand(args[0], __REGISTER_OPERAND)
+
+	if err != nil {
+		return err
+	}
+
+	if mnemonic == "jz" {
+		stmt := &JumpZeroStmt{Label: args[1]}
+		stmt.Operand[0] = reg
+		p.builder.Append(stmt)
+	} else {
+		stmt := &JumpStmt{Label: args[1]}
+		stmt.Operand[0] = reg
+		p.builder.Append(stmt)
+	}
+
+	return nil
+}
+
+func (p *parser) jumpAlways(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Expected 'jmp TARGET'")
+	}
+
+	stmt := &JumpAlwaysStmt{}
+
+	if isIdentifier(args[0]) {
+		stmt.Label = args[0]
+	} else {
+		target, err := parseImmediate(args[0])
+
+		if err != nil {
+			return err
+		}
+
+		stmt.Operand = target
+	}
+
+	p.builder.Append(stmt)
+
+	return nil
+}
+
+func (p *parser) callSub(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Expected 'callsub TARGET STACK'")
+	}
+
+	stack, err := p.operand(args[1], __STACK_OPERAND)
+
+	if err != nil {
+		return err
+	}
+
+	stmt := &CallSubStmt{}
+	stmt.Operand[0] = stack
+
+	if isIdentifier(args[0]) {
+		stmt.Label = args[0]
+	} else {
+		target, err := parseImmediate(args[0])
+
+		if err != nil {
+			return err
+		}
+
+		stmt.Operand[1] = target
+	}
+
+	p.builder.Append(stmt)
+
+	return nil
+}
+
+func (p *parser) ret(args []string) error {
+	if len(args) != 0 {
+		return errors.New("Expected nothing after 'ret'")
+	}
+
+	p.builder.Append(&ReturnStmt{})
+
+	return nil
+}
+
+func (p *parser) call(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Expected 'call FUNCTION STACK'")
+	}
+
+	if !isIdentifier(args[0]) {
+		return fmt.Errorf("Invalid function name '%s'", args[0])
+	}
+
+	stack, err := p.operand(args[1], __STACK_OPERAND)
+
+	if err != nil {
+		return err
+	}
+
+	stmt := &CallStmt{VmFunc: args[0]}
+	stmt.Operand = stack
+	p.builder.Append(stmt)
+
+	return nil
+}
+
+func (p *parser) oneOperand(mnemonic string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected '%s REGISTER'", mnemonic)
+	}
+
+	reg, err := p.operand(args[0], __REGISTER_OPERAND)
+
+	if err != nil {
+		return err
+	}
+
+	var stmt Statement
+
+	switch mnemonic {
+	case "read":
+		read := &ReadStmt{}
+		read.Operand = reg
+		stmt = read
+	case "write":
+		write := &WriteStmt{}
+		write.Operand = reg
+		stmt = write
+	case "not":
+		not := &NotStmt{}
+		not.Operand = reg
+		stmt = not
+	}
+
+	p.builder.Append(stmt)
+
+	return nil
+}
+
+func (p *parser) registerImmediate(mnemonic string, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Expected '%s REGISTER IMMEDIATE'", mnemonic)
+	}
+
+	reg, err := p.operand(args[0], __REGISTER_OPERAND)
+
+	if err != nil {
+		return err
+	}
+
+	imm, err := parseImmediate(args[1])
+
+	if err != nil {
+		return err
+	}
+
+	return p.appendTwoOperand(mnemonic, [2]int{reg, imm})
+}
+
+func (p *parser) stackImmediate(mnemonic string, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Expected '%s STACK IMMEDIATE'", mnemonic)
+	}
+
+	stack, err := p.operand(args[0], __STACK_OPERAND)
+
+	if err != nil {
+		return err
+	}
+
+	imm, err := parseImmediate(args[1])
+
+	if err != nil {
+		return err
+	}
+
+	return p.appendTwoOperand(mnemonic, [2]int{stack, imm})
+}
+
+func (p *parser) registerRegister(mnemonic string, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Expected '%s REGISTER REGISTER'", mnemonic)
+	}
+
+	return p.twoOperand(mnemonic, args, __REGISTER_OPERAND, __REGISTER_OPERAND)
+}
+
+func (p *parser) stackRegister(mnemonic string, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Expected '%s STACK REGISTER'", mnemonic)
+	}
+
+	return p.twoOperand(mnemonic, args, __STACK_OPERAND, __REGISTER_OPERAND)
+}
+
+func (p *parser) twoOperand(mnemonic string, args []string, kindZero, kindOne operandKind) error {
+	valZero, err := p.operand(args[0], kindZero)
+
+	if err != nil {
+		return err
+	}
+
+	valOne, err := p.operand(args[1], kindOne)
+
+	if err != nil {
+		return err
+	}
+
+	return p.appendTwoOperand(mnemonic, [2]int{valZero, valOne})
+}
+
+func (p *parser) appendTwoOperand(mnemonic string, operands [2]int) error {
+	var stmt Statement
+
+	switch mnemonic {
+	case "set":
+		set := &SetStmt{}
+		set.Operand = operands
+		stmt = set
+	case "jmpnz":
+		jump := &JumpStmt{}
+		jump.Operand = operands
+		stmt = jump
+	case "jz":
+		jump := &JumpZeroStmt{}
+		jump.Operand = operands
+		stmt = jump
+	case "add":
+		add := &AddStmt{}
+		add.Operand = operands
+		stmt = add
+	case "sub":
+		sub := &SubStmt{}
+		sub.Operand = operands
+		stmt = sub
+	case "copy":
+		copy := &CopyStmt{}
+		copy.Operand = operands
+		stmt = copy
+	case "push":
+		push := &PushStmt{}
+		push.Operand = operands
+		stmt = push
+	case "pop":
+		pop := &PopStmt{}
+		pop.Operand = operands
+		stmt = pop
+	case "addi":
+		add := &AddImmediateStmt{}
+		add.Operand = operands
+		stmt = add
+	case "subi":
+		sub := &SubImmediateStmt{}
+		sub.Operand = operands
+		stmt = sub
+	case "pushi":
+		push := &PushImmediateStmt{}
+		push.Operand = operands
+		stmt = push
+	case "mul":
+		mulStmt := &MulStmt{}
+		mulStmt.Operand = operands
+		stmt = mulStmt
+	case "div":
+		divStmt := &DivStmt{}
+		divStmt.Operand = operands
+		stmt = divStmt
+	case "mod":
+		modStmt := &ModStmt{}
+		modStmt.Operand = operands
+		stmt = modStmt
+	case "sdiv":
+		sdivStmt := &SignedDivStmt{}
+		sdivStmt.Operand = operands
+		stmt = sdivStmt
+	case "smod":
+		smodStmt := &SignedModStmt{}
+		smodStmt.Operand = operands
+		stmt = smodStmt
+	case "and":
+		andStmt := &AndStmt{}
+		andStmt.Operand = operands
+		stmt = andStmt
+	case "or":
+		orStmt := &OrStmt{}
+		orStmt.Operand = operands
+		stmt = orStmt
+	case "xor":
+		xorStmt := &XorStmt{}
+		xorStmt.Operand = operands
+		stmt = xorStmt
+	case "shl":
+		shlStmt := &ShiftLeftStmt{}
+		shlStmt.Operand = operands
+		stmt = shlStmt
+	case "shr":
+		shrStmt := &ShiftRightStmt{}
+		shrStmt.Operand = operands
+		stmt = shrStmt
+	case "sar":
+		sarStmt := &SignedShiftRightStmt{}
+		sarStmt.Operand = operands
+		stmt = sarStmt
+	case "eq":
+		eqStmt := &EqualStmt{}
+		eqStmt.Operand = operands
+		stmt = eqStmt
+	case "lt":
+		ltStmt := &LessStmt{}
+		ltStmt.Operand = operands
+		stmt = ltStmt
+	case "gt":
+		gtStmt := &GreaterStmt{}
+		gtStmt.Operand = operands
+		stmt = gtStmt
+	case "slt":
+		sltStmt := &SignedLessStmt{}
+		sltStmt.Operand = operands
+		stmt = sltStmt
+	case "sgt":
+		sgtStmt := &SignedGreaterStmt{}
+		sgtStmt.Operand = operands
+		stmt = sgtStmt
+	default:
+		return fmt.Errorf("Unknown instruction '%s'", mnemonic)
+	}
+
+	p.builder.Append(stmt)
+
+	return nil
+}
+
+func (p *parser) operand(text string, kind operandKind) (int, error) {
+	op, err := p.anyOperand(text)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if op.kind != kind {
+		return 0, fmt.Errorf("Expected %v but received %v '%s'", kind, op.kind, text)
+	}
+
+	return op.address, nil
+}
+
+func (p *parser) anyOperand(text string) (operand, error) {
+	if op, ok := p.aliases[text]; ok {
+		return op, nil
+	}
+
+	return parseLiteralOperand(text)
+}
+
+func parseLiteralOperand(text string) (operand, error) {
+	if len(text) < 2 {
+		return operand{}, fmt.Errorf("Invalid operand '%s'", text)
+	}
+
+	var kind operandKind
+
+	switch text[0] {
+	case 'r':
+		kind = __REGISTER_OPERAND
+	case 's':
+		kind = __STACK_OPERAND
+	default:
+		return operand{}, fmt.Errorf("Invalid operand '%s'", text)
+	}
+
+	address, err := strconv.ParseUint(text[1:], 10, 8)
+
+	if err != nil {
+		return operand{}, fmt.Errorf("Invalid operand '%s'", text)
+	}
+
+	return operand{kind: kind, address: int(address)}, nil
+}
+
+func parseImmediate(text string) (int, error) {
+	imm, err := strconv.ParseInt(text, 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("Invalid immediate '%s'", text)
+	}
+
+	return int(imm), nil
+}
+
+func stripComment(line string) string {
+	cut := strings.IndexAny(line, "#;")
+
+	if cut >= 0 {
+		return line[:cut]
+	}
+
+	return line
+}
+
+func isIdentifier(text string) bool {
+	if text == "" {
+		return false
+	}
+
+	for i, chr := range text {
+		isLetter := chr == '_' || (chr >= 'a' && chr <= 'z') || (chr >= 'A' && chr <= 'Z')
+		isDigit := chr >= '0' && chr <= '9'
+
+		if !isLetter && !(isDigit && i > 0) {
+			return false
+		}
+	}
+
+	return true
+}
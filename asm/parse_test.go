@@ -0,0 +1,181 @@
+package asm
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	source := `
+# Count down from three.
+alias counter r3
+alias tape s1
+
+set counter 3   ; initial value
+set r4 1
+loop counter {
+	write counter
+	sub counter r4
+}
+push tape r4
+pop s1 r0
+copy r1 r0
+add r1 r4
+read r2
+jmpnz r2 -1
+call tape_new tape
+end:
+jmpnz r0 end
+addi r1 7
+subi r1 -2
+pushi s2 -3
+mul r1 r2
+smod r3 r4
+not r5
+shl r6 r7
+jz r1 end
+jmp end
+jmp 3
+sgt r1 r2
+proc double s1 {
+	pop s1 r0
+	ret
+}
+callsub double s1
+callsub 7 s2
+`
+
+	set1 := &SetStmt{}
+	set1.Operand = [2]int{3, 3}
+	set2 := &SetStmt{}
+	set2.Operand = [2]int{4, 1}
+	write := &WriteStmt{}
+	write.Operand = 3
+	sub := &SubStmt{}
+	sub.Operand = [2]int{3, 4}
+	loop := &LoopStmt{Nest: []Statement{write, sub}}
+	loop.Operand = 3
+	push := &PushStmt{}
+	push.Operand = [2]int{1, 4}
+	pop := &PopStmt{}
+	pop.Operand = [2]int{1, 0}
+	copy := &CopyStmt{}
+	copy.Operand = [2]int{1, 0}
+	add := &AddStmt{}
+	add.Operand = [2]int{1, 4}
+	read := &ReadStmt{}
+	read.Operand = 2
+	jump := &JumpStmt{}
+	jump.Operand = [2]int{2, -1}
+	call := &CallStmt{VmFunc: "tape_new"}
+	call.Operand = 1
+	label := &LabelStmt{Name: "end"}
+	labelJump := &JumpStmt{Label: "end"}
+	labelJump.Operand[0] = 0
+	addi := &AddImmediateStmt{}
+	addi.Operand = [2]int{1, 7}
+	subi := &SubImmediateStmt{}
+	subi.Operand = [2]int{1, -2}
+	pushi := &PushImmediateStmt{}
+	pushi.Operand = [2]int{2, -3}
+	mul := &MulStmt{}
+	mul.Operand = [2]int{1, 2}
+	smod := &SignedModStmt{}
+	smod.Operand = [2]int{3, 4}
+	not := &NotStmt{}
+	not.Operand = 5
+	shl := &ShiftLeftStmt{}
+	shl.Operand = [2]int{6, 7}
+	jz := &JumpZeroStmt{Label: "end"}
+	jz.Operand[0] = 1
+	jmpLabel := &JumpAlwaysStmt{Label: "end"}
+	jmpAddress := &JumpAlwaysStmt{}
+	jmpAddress.Operand = 3
+	sgt := &SignedGreaterStmt{}
+	sgt.Operand = [2]int{1, 2}
+	procPop := &PopStmt{}
+	procPop.Operand = [2]int{1, 0}
+	proc := &ProcedureStmt{Name: "double", Nest: []Statement{procPop, &ReturnStmt{}}}
+	proc.Operand = 1
+	callLabel := &CallSubStmt{Label: "double"}
+	callLabel.Operand[0] = 1
+	callAddress := &CallSubStmt{}
+	callAddress.Operand = [2]int{2, 7}
+
+	expected := &AST{
+		Statements: []Statement{
+			set1, set2, loop, push, pop, copy, add, read, jump, call,
+			label, labelJump, addi, subi, pushi,
+			mul, smod, not, shl, jz, jmpLabel, jmpAddress, sgt,
+			proc, callLabel, callAddress,
+		},
+	}
+
+	actual, err := Parse([]byte(source))
+
+	if err != nil {
+		t.Errorf("Unexpected parse error: %s", err.Error())
+		return
+	}
+
+	assertASTEqual(t, expected, actual)
+}
+
+func TestParse_Empty(t *testing.T) {
+	actual, err := Parse([]byte("# nothing\n\n"))
+
+	if err != nil {
+		t.Errorf("Unexpected parse error: %s", err.Error())
+		return
+	}
+
+	assertASTEqual(t, &AST{}, actual)
+}
+
+func TestParse_Failure(t *testing.T) {
+	failureCases := []string{
+		"nop",
+		"set r0",
+		"set r0 r1",
+		"add r0 10",
+		"push r0 r1",
+		"pop s0 s1",
+		"write s0",
+		"set r256 1",
+		"set x0 1",
+		"loop r0 {",
+		"}",
+		"loop r0",
+		"loop r0 {\n} r0",
+		"call tape_new r0",
+		"call 9lives s0",
+		"alias r1 r2",
+		"alias a r1\nalias a r2",
+		"alias b r1\npush b b",
+		"9lives:",
+		"end: write r0",
+		"jmpnz s0 end",
+		"addi r0 r1",
+		"pushi r0 1",
+		"mul r0 1",
+		"not r0 r1",
+		"jmp",
+		"jmp r0 end",
+		"jz end",
+		"eq r0",
+		"proc double s1",
+		"proc double r1 {\n}",
+		"proc 9lives s1 {\n}",
+		"proc double s1 {",
+		"callsub double",
+		"callsub double r1",
+		"ret r0",
+	}
+
+	for i, source := range failureCases {
+		_, err := Parse([]byte(source))
+
+		if err == nil {
+			t.Errorf("Expected parse failure in case %d: %s", i, source)
+		}
+	}
+}
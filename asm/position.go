@@ -0,0 +1,114 @@
+package asm
+
+import (
+	"fmt"
+)
+
+// SourcePos locates a statement in the source it was parsed from.  Lines and
+// columns count from 1, so the zero value is an unknown position.
+type SourcePos struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (pos SourcePos) IsKnown() bool {
+	return pos.Line > 0
+}
+
+func (pos SourcePos) String() string {
+	if !pos.IsKnown() {
+		return "unknown position"
+	}
+
+	if pos.File == "" {
+		return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+
+	return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Column)
+}
+
+// Located is embedded in every statement to carry its optional SourcePos.
+type Located struct {
+	Pos SourcePos
+}
+
+func (located *Located) Position() SourcePos {
+	return located.Pos
+}
+
+func (located *Located) SetPosition(pos SourcePos) {
+	located.Pos = pos
+}
+
+type Locatable interface {
+	Position() SourcePos
+	SetPosition(pos SourcePos)
+}
+
+// Locate sets the position of statements.
+func Locate(pos SourcePos, statements ...Statement) {
+	for _, stmt := range statements {
+		if locatable, ok := stmt.(Locatable); ok {
+			locatable.SetPosition(pos)
+		}
+	}
+}
+
+// PositionVisitor is an ASTVisitor that is told the position of each statement
+// before visiting it, and the position of each block before leaving it.
+type PositionVisitor interface {
+	VisitPosition(pos SourcePos)
+}
+
+func visitStatement(visitor ASTVisitor, stmt Statement) {
+	visitPosition(visitor, stmt)
+	stmt.Visit(visitor)
+}
+
+func visitPosition(visitor ASTVisitor, stmt Statement) {
+	positionVisitor, isPositionVisitor := visitor.(PositionVisitor)
+	locatable, isLocatable := stmt.(Locatable)
+
+	if isPositionVisitor && isLocatable {
+		positionVisitor.VisitPosition(locatable.Position())
+	}
+}
+
+// Walk calls f on every statement of the AST, including those nested in
+// blocks.
+func (ast *AST) Walk(f func(stmt Statement)) {
+	walkStatements(ast.Statements, f)
+}
+
+func walkStatements(statements []Statement, f func(stmt Statement)) {
+	for _, stmt := range statements {
+		f(stmt)
+
+		switch block := stmt.(type) {
+		case *LoopStmt:
+			walkStatements(block.Nest, f)
+		case *ProcedureStmt:
+			walkStatements(block.Nest, f)
+		}
+	}
+}
+
+// SetSourceFile names the file of every known position in the AST, for
+// frontends that parse bytes without knowing where they came from.
+func (ast *AST) SetSourceFile(file string) {
+	ast.Walk(func(stmt Statement) {
+		locatable, ok := stmt.(Locatable)
+
+		if !ok {
+			return
+		}
+
+		pos := locatable.Position()
+
+		if pos.IsKnown() {
+			pos.File = file
+			locatable.SetPosition(pos)
+		}
+	})
+}
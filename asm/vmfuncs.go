@@ -4,3 +4,70 @@ const TAPE_NEW = "tape_new"
 const TAPE_MOVE_HEAD = "tape_move_head"
 const TAPE_READ_HEAD = "tape_read_head"
 const TAPE_WRITE_HEAD = "tape_write_head"
+const TAPE_SCAN = "tape_scan"
+
+// Overflow policies for tape_new, which decide what happens when a value too
+// large for a cell is written to the tape.
+const (
+	TAPE_OVERFLOW_WRAP = iota
+	TAPE_OVERFLOW_SATURATE
+	TAPE_OVERFLOW_ERROR
+)
+
+const PLAYFIELD_NEW = "playfield_new"
+const PLAYFIELD_GET = "playfield_get"
+const PLAYFIELD_PUT = "playfield_put"
+const PLAYFIELD_CURRENT = "playfield_current"
+const PLAYFIELD_STEP = "playfield_step"
+const PLAYFIELD_TURN = "playfield_turn"
+
+// Directions for playfield_turn.
+const (
+	PLAYFIELD_EAST = iota
+	PLAYFIELD_SOUTH
+	PLAYFIELD_WEST
+	PLAYFIELD_NORTH
+	// Any of the above, at random.
+	PLAYFIELD_RANDOM
+	// Relative to the current direction, as in Funge-98.
+	PLAYFIELD_LEFT
+	PLAYFIELD_RIGHT
+	PLAYFIELD_REVERSE
+)
+
+const IO_READ_INT = "io_read_int"
+const IO_WRITE_INT = "io_write_int"
+
+const FUNGE_SKIP = "funge_skip"
+const FUNGE_JUMP = "funge_jump"
+const FUNGE_POINTER = "funge_pointer"
+const FUNGE_MOVE_TO = "funge_move_to"
+const FUNGE_SET_DELTA = "funge_set_delta"
+const FUNGE_DEPTH = "funge_depth"
+const FUNGE_BEGIN_BLOCK = "funge_begin_block"
+const FUNGE_END_BLOCK = "funge_end_block"
+const FUNGE_STACK_UNDER = "funge_stack_under"
+const FUNGE_SYSINFO = "funge_sysinfo"
+const FUNGE_LOAD = "funge_load"
+const FUNGE_UNLOAD = "funge_unload"
+const FUNGE_EXECUTE = "funge_execute"
+const FUNGE_READ_CHAR = "funge_read_char"
+const FUNGE_READ_INT = "funge_read_int"
+
+// What funge_skip passes over.
+const (
+	// Spaces, and comments between semicolons.
+	FUNGE_SKIP_COMMENTS = iota
+	// Spaces only, as in string mode.
+	FUNGE_SKIP_SPACES
+)
+
+const HEAP_STORE = "heap_store"
+const HEAP_RETRIEVE = "heap_retrieve"
+
+const THREAD_FORK = "thread_fork"
+const THREAD_YIELD = "thread_yield"
+const THREAD_EXIT = "thread_exit"
+
+const PBRAIN_DEFINE = "pbrain_define"
+const PBRAIN_LOOKUP = "pbrain_lookup"
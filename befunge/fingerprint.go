@@ -0,0 +1,142 @@
+package befunge
+
+import (
+	"github.com/johnny-morrice/shapes"
+)
+
+// The fingerprints that Funge-98 programs may load with (.
+func init() {
+	shapes.RegisterModule(shapes.FingerprintModule("NULL"), nullFingerprint)
+	shapes.RegisterModule(shapes.FingerprintModule("BOOL"), boolFingerprint)
+	shapes.RegisterModule(shapes.FingerprintModule("ROMA"), romaFingerprint)
+	shapes.RegisterModule(shapes.FingerprintModule("MODU"), moduFingerprint)
+}
+
+// nullFingerprint binds every instruction to reflect.
+func nullFingerprint() *shapes.Library {
+	lib := &shapes.Library{}
+
+	for chr := 'A'; chr <= 'Z'; chr++ {
+		lib.AddFunction(string(chr), fingerprintFunction(func(process *shapes.Process, stack shapes.Address) bool {
+			return false
+		}))
+	}
+
+	return lib
+}
+
+// boolFingerprint has the bitwise And, Not, Or and Xor.
+func boolFingerprint() *shapes.Library {
+	lib := &shapes.Library{}
+	lib.AddFunction("A", binaryFunction(func(a, b int64) int64 { return a & b }))
+	lib.AddFunction("N", fingerprintFunction(func(process *shapes.Process, stack shapes.Address) bool {
+		process.Push(stack, ^process.PopCell(stack))
+		return true
+	}))
+	lib.AddFunction("O", binaryFunction(func(a, b int64) int64 { return a | b }))
+	lib.AddFunction("X", binaryFunction(func(a, b int64) int64 { return a ^ b }))
+
+	return lib
+}
+
+// romaFingerprint pushes the values of the Roman numerals.
+func romaFingerprint() *shapes.Library {
+	lib := &shapes.Library{}
+	numerals := []struct {
+		name  string
+		value uint64
+	}{
+		{"C", 100},
+		{"D", 500},
+		{"I", 1},
+		{"L", 50},
+		{"M", 1000},
+		{"V", 5},
+		{"X", 10},
+	}
+
+	for _, numeral := range numerals {
+		value := numeral.value
+		lib.AddFunction(numeral.name, fingerprintFunction(func(process *shapes.Process, stack shapes.Address) bool {
+			process.Push(stack, value)
+			return true
+		}))
+	}
+
+	return lib
+}
+
+// moduFingerprint has three kinds of remainder, each zero for a divisor of
+// zero: M takes the sign of the divisor, U is never negative, and R takes the
+// sign of the dividend, as in C.
+func moduFingerprint() *shapes.Library {
+	lib := &shapes.Library{}
+	lib.AddFunction("M", binaryFunction(func(a, b int64) int64 {
+		if b == 0 {
+			return 0
+		}
+
+		r := a % b
+
+		if r != 0 && (r < 0) != (b < 0) {
+			r += b
+		}
+
+		return r
+	}))
+	lib.AddFunction("U", binaryFunction(func(a, b int64) int64 {
+		if b == 0 {
+			return 0
+		}
+
+		r := a % b
+
+		if r < 0 {
+			if b < 0 {
+				r -= b
+			} else {
+				r += b
+			}
+		}
+
+		return r
+	}))
+	lib.AddFunction("R", binaryFunction(func(a, b int64) int64 {
+		if b == 0 {
+			return 0
+		}
+
+		return a % b
+	}))
+
+	return lib
+}
+
+// binaryFunction pops b and then a, and pushes the result of op.
+func binaryFunction(op func(a, b int64) int64) shapes.VmFunction {
+	return fingerprintFunction(func(process *shapes.Process, stack shapes.Address) bool {
+		b := int64(process.PopCell(stack))
+		a := int64(process.PopCell(stack))
+		process.Push(stack, uint64(op(a, b)))
+
+		return true
+	})
+}
+
+// fingerprintFunction makes a VmFunction of f, which works on the data stack
+// and gives false to reflect.
+func fingerprintFunction(f func(process *shapes.Process, stack shapes.Address) bool) shapes.VmFunction {
+	return func(runtime *shapes.Runtime, stackAddr shapes.Address) {
+		runtime.Process.Pop(stackAddr)
+
+		if runtime.Process.Error != nil {
+			return
+		}
+
+		if !f(runtime.Process, stackAddr) {
+			shapes.Reflect(runtime)
+		}
+
+		runtime.Process.IncrementPC()
+	}
+}
@@ -0,0 +1,510 @@
+package befunge
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Parse98 compiles Funge-98 in two dimensions, as Befunge-98.  The source is
+// loaded onto an unbounded playfield, and interpreted by the program as with
+// Parse.  Instructions that are not implemented, such as i, o, = and t,
+// reflect the instruction pointer, as the standard allows.
+func Parse98(source []byte) (*asm.AST, error) {
+	return Lower98(ParseCells98(source)), nil
+}
+
+// ParseCells98 finds the cells of the source that are not spaces.  Lines may
+// end with any of "\n", "\r\n" or "\r", and form feeds are ignored.
+func ParseCells98(source []byte) []Cell {
+	cells := []Cell{}
+	source = bytes.Replace(source, []byte("\r\n"), []byte("\n"), -1)
+	source = bytes.Replace(source, []byte("\r"), []byte("\n"), -1)
+	source = bytes.Replace(source, []byte("\f"), nil, -1)
+
+	for y, line := range bytes.Split(source, []byte("\n")) {
+		for x, chr := range line {
+			if chr != ' ' {
+				cells = append(cells, Cell{X: x, Y: y, Value: chr})
+			}
+		}
+	}
+
+	return cells
+}
+
+// Lower98 gives a program that loads the cells onto an unbounded playfield
+// and then interprets them as Funge-98.  The data stack is the top of the
+// stack-stack, the rest of which the funge VmFunctions keep, and the storage
+// offset is kept in registers.
+func Lower98(cells []Cell) *asm.AST {
+	lower := &lowerer{}
+	lower.prologue(cells, 0, 0)
+	lower.interpreter98()
+
+	return &asm.AST{Statements: lower.statements}
+}
+
+// interpreter98 differs from the Befunge-93 interpreter in skipping spaces and
+// comments before fetching, in reading strings with runs of spaces as one
+// space, and in repeating commands for k.  Characters that are not commands
+// reflect the instruction pointer.
+func (lower *lowerer) interpreter98() {
+	stringFetch := lower.newLabel()
+	stringSpace := lower.newLabel()
+	iterated := lower.newLabel()
+
+	lower.label(__FETCH_LABEL)
+	lower.append(jumpNotZero(__STRING_MODE_REGISTER, stringFetch))
+	lower.skip(asm.FUNGE_SKIP_COMMENTS)
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_CURRENT),
+		pop(__COMMAND_REGISTER),
+	)
+
+	lower.label(__DISPATCH_LABEL)
+
+	commands := sortedCommands98()
+
+	for _, chr := range commands {
+		lower.jumpIfCommand(chr, commandLabel(chr))
+	}
+
+	lower.reflect()
+	lower.jump(__NEXT_LABEL)
+
+	for _, chr := range commands {
+		lower.label(commandLabel(chr))
+		__COMMANDS_98[chr](lower)
+		lower.jump(__NEXT_LABEL)
+	}
+
+	// Repeat the command while k's count lasts, and then pass over it, unless
+	// it moved the instruction pointer.
+	lower.label(__NEXT_LABEL)
+	lower.append(
+		jumpZero(__COUNT_REGISTER, __STEP_LABEL),
+		subImmediate(__COUNT_REGISTER, 1),
+		jumpZero(__COUNT_REGISTER, iterated),
+		&asm.CopyStmt{TwoOperandStmt: operands(__COMMAND_REGISTER, __ITERATED_REGISTER)},
+	)
+	lower.jump(__DISPATCH_LABEL)
+	lower.label(iterated)
+	lower.pointer(__A_REGISTER, __B_REGISTER)
+	lower.append(
+		&asm.EqualStmt{TwoOperandStmt: operands(__A_REGISTER, __SAVED_X_REGISTER)},
+		&asm.EqualStmt{TwoOperandStmt: operands(__B_REGISTER, __SAVED_Y_REGISTER)},
+		&asm.AndStmt{TwoOperandStmt: operands(__A_REGISTER, __B_REGISTER)},
+		jumpZero(__A_REGISTER, __STEP_LABEL),
+	)
+	lower.nextInstruction()
+
+	lower.label(__STEP_LABEL)
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_STEP),
+	)
+	lower.jump(__FETCH_LABEL)
+
+	lower.label(stringFetch)
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_CURRENT),
+		pop(__COMMAND_REGISTER),
+	)
+	lower.jumpIfCommand('"', __STRING_END_LABEL)
+	lower.pushData(__COMMAND_REGISTER)
+	lower.jumpIfCommand(' ', stringSpace)
+	lower.jump(__STEP_LABEL)
+	lower.label(stringSpace)
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_STEP),
+	)
+	lower.skip(asm.FUNGE_SKIP_SPACES)
+	lower.jump(__FETCH_LABEL)
+	lower.label(__STRING_END_LABEL)
+	lower.append(set(__STRING_MODE_REGISTER, 0))
+	lower.jump(__STEP_LABEL)
+
+	lower.label(__END_LABEL)
+}
+
+func sortedCommands98() []byte {
+	commands := []byte{}
+
+	for chr := range __COMMANDS_98 {
+		commands = append(commands, chr)
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i] < commands[j]
+	})
+
+	return commands
+}
+
+var __COMMANDS_98 map[byte]func(lower *lowerer)
+
+func init() {
+	__COMMANDS_98 = commands93()
+
+	commands := map[byte]func(lower *lowerer){
+		'p':  put98,
+		'g':  get98,
+		'&':  readReflecting(asm.FUNGE_READ_INT),
+		'~':  readReflecting(asm.FUNGE_READ_CHAR),
+		'\'': fetchChar,
+		's':  storeChar,
+		'[':  turn(asm.PLAYFIELD_LEFT),
+		']':  turn(asm.PLAYFIELD_RIGHT),
+		'r':  turn(asm.PLAYFIELD_REVERSE),
+		'x':  absoluteDelta,
+		'j':  jumpForward,
+		'k':  iterate,
+		'n':  clearStack,
+		'q':  quit,
+		'w':  compare,
+		'z':  func(lower *lowerer) {},
+		'{':  beginBlock,
+		'}':  endBlock,
+		'u':  stackUnderStack,
+		'y':  sysInfo,
+		'(':  fingerprint(asm.FUNGE_LOAD),
+		')':  fingerprint(asm.FUNGE_UNLOAD),
+	}
+
+	for chr, command := range commands {
+		__COMMANDS_98[chr] = command
+	}
+
+	for digit := 10; digit <= 15; digit++ {
+		__COMMANDS_98[byte('a'+digit-10)] = pushDigit(digit)
+	}
+
+	for chr := byte('A'); chr <= 'Z'; chr++ {
+		__COMMANDS_98[chr] = executeFingerprint(chr)
+	}
+}
+
+// put98 is put relative to the storage offset.
+func put98(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.popData(__B_REGISTER)
+	lower.popData(__C_REGISTER)
+	lower.append(
+		&asm.AddStmt{TwoOperandStmt: operands(__A_REGISTER, __OFFSET_Y_REGISTER)},
+		&asm.AddStmt{TwoOperandStmt: operands(__B_REGISTER, __OFFSET_X_REGISTER)},
+		push(__C_REGISTER),
+		push(__A_REGISTER),
+		push(__B_REGISTER),
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_PUT),
+	)
+}
+
+// get98 is get relative to the storage offset.
+func get98(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.popData(__B_REGISTER)
+	lower.append(
+		&asm.AddStmt{TwoOperandStmt: operands(__A_REGISTER, __OFFSET_Y_REGISTER)},
+		&asm.AddStmt{TwoOperandStmt: operands(__B_REGISTER, __OFFSET_X_REGISTER)},
+		push(__A_REGISTER),
+		push(__B_REGISTER),
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_GET),
+		pop(__A_REGISTER),
+	)
+	lower.pushData(__A_REGISTER)
+}
+
+// readReflecting reflects at the end of the input.
+func readReflecting(vmFunc string) func(lower *lowerer) {
+	return func(lower *lowerer) {
+		lower.append(
+			call(vmFunc),
+			pop(__TEST_REGISTER),
+			pop(__A_REGISTER),
+		)
+		lower.reflectUnless(__TEST_REGISTER, func() {
+			lower.pushData(__A_REGISTER)
+		})
+	}
+}
+
+// fetchChar pushes the next cell, which is then stepped over.
+func fetchChar(lower *lowerer) {
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_STEP),
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_CURRENT),
+		pop(__A_REGISTER),
+	)
+	lower.pushData(__A_REGISTER)
+}
+
+// storeChar pops a value into the next cell, which is then stepped over.
+func storeChar(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_STEP),
+	)
+	lower.pointer(__B_REGISTER, __C_REGISTER)
+	lower.append(
+		push(__A_REGISTER),
+		push(__C_REGISTER),
+		push(__B_REGISTER),
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_PUT),
+	)
+}
+
+// absoluteDelta pops dy and then dx.
+func absoluteDelta(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.popData(__B_REGISTER)
+	lower.append(
+		push(__A_REGISTER),
+		push(__B_REGISTER),
+		push(__FIELD_REGISTER),
+		call(asm.FUNGE_SET_DELTA),
+	)
+}
+
+func jumpForward(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.append(
+		push(__A_REGISTER),
+		push(__FIELD_REGISTER),
+		call(asm.FUNGE_JUMP),
+	)
+}
+
+// iterate pops a count, and runs the next instruction that many times from
+// where k is.  A count less than one passes over the instruction.
+func iterate(lower *lowerer) {
+	passOver := lower.newLabel()
+
+	lower.popData(__A_REGISTER)
+	lower.pointer(__SAVED_X_REGISTER, __SAVED_Y_REGISTER)
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_STEP),
+	)
+	lower.skip(asm.FUNGE_SKIP_COMMENTS)
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_CURRENT),
+		pop(__ITERATED_REGISTER),
+		push(__SAVED_Y_REGISTER),
+		push(__SAVED_X_REGISTER),
+		push(__FIELD_REGISTER),
+		call(asm.FUNGE_MOVE_TO),
+		jumpZero(__A_REGISTER, passOver),
+		set(__CONSTANT_REGISTER, 0),
+		&asm.CopyStmt{TwoOperandStmt: operands(__TEST_REGISTER, __A_REGISTER)},
+		&asm.SignedLessStmt{TwoOperandStmt: operands(__TEST_REGISTER, __CONSTANT_REGISTER)},
+		jumpNotZero(__TEST_REGISTER, passOver),
+		&asm.CopyStmt{TwoOperandStmt: operands(__COUNT_REGISTER, __A_REGISTER)},
+		&asm.CopyStmt{TwoOperandStmt: operands(__COMMAND_REGISTER, __ITERATED_REGISTER)},
+	)
+	lower.jump(__DISPATCH_LABEL)
+	lower.label(passOver)
+	lower.nextInstruction()
+}
+
+func clearStack(lower *lowerer) {
+	loop := lower.newLabel()
+	done := lower.newLabel()
+
+	lower.label(loop)
+	lower.append(jumpZero(__DEPTH_REGISTER, done))
+	lower.popData(__A_REGISTER)
+	lower.jump(loop)
+	lower.label(done)
+}
+
+// quit ends the program, as @ does.  Processes have no exit code, so the one
+// popped is lost.
+func quit(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.jump(__END_LABEL)
+}
+
+// compare pops b and then a, turning left if a is less than b, and right if
+// it is greater.
+func compare(lower *lowerer) {
+	right := lower.newLabel()
+	done := lower.newLabel()
+
+	lower.popData(__A_REGISTER)
+	lower.popData(__B_REGISTER)
+	lower.append(
+		&asm.CopyStmt{TwoOperandStmt: operands(__TEST_REGISTER, __B_REGISTER)},
+		&asm.SignedGreaterStmt{TwoOperandStmt: operands(__TEST_REGISTER, __A_REGISTER)},
+		jumpNotZero(__TEST_REGISTER, right),
+		&asm.CopyStmt{TwoOperandStmt: operands(__TEST_REGISTER, __B_REGISTER)},
+		&asm.SignedLessStmt{TwoOperandStmt: operands(__TEST_REGISTER, __A_REGISTER)},
+		jumpZero(__TEST_REGISTER, done),
+		pushImmediate(asm.PLAYFIELD_LEFT),
+	)
+	lower.turn()
+	lower.jump(done)
+	lower.label(right)
+	lower.append(pushImmediate(asm.PLAYFIELD_RIGHT))
+	lower.turn()
+	lower.label(done)
+}
+
+func beginBlock(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.append(
+		push(__OFFSET_Y_REGISTER),
+		push(__OFFSET_X_REGISTER),
+		push(__A_REGISTER),
+		pushImmediate(__DATA_STACK),
+		push(__FIELD_REGISTER),
+		call(asm.FUNGE_BEGIN_BLOCK),
+		pop(__OFFSET_X_REGISTER),
+		pop(__OFFSET_Y_REGISTER),
+	)
+	lower.refreshDepth()
+}
+
+func endBlock(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.append(
+		push(__A_REGISTER),
+		pushImmediate(__DATA_STACK),
+		call(asm.FUNGE_END_BLOCK),
+		pop(__TEST_REGISTER),
+		pop(__A_REGISTER),
+		pop(__B_REGISTER),
+	)
+	lower.refreshDepth()
+	lower.reflectUnless(__TEST_REGISTER, func() {
+		lower.append(
+			&asm.CopyStmt{TwoOperandStmt: operands(__OFFSET_X_REGISTER, __A_REGISTER)},
+			&asm.CopyStmt{TwoOperandStmt: operands(__OFFSET_Y_REGISTER, __B_REGISTER)},
+		)
+	})
+}
+
+func stackUnderStack(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.append(
+		push(__A_REGISTER),
+		pushImmediate(__DATA_STACK),
+		call(asm.FUNGE_STACK_UNDER),
+		pop(__TEST_REGISTER),
+	)
+	lower.refreshDepth()
+	lower.reflectUnless(__TEST_REGISTER, func() {})
+}
+
+func sysInfo(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.append(
+		push(__A_REGISTER),
+		push(__OFFSET_Y_REGISTER),
+		push(__OFFSET_X_REGISTER),
+		pushImmediate(__DATA_STACK),
+		push(__FIELD_REGISTER),
+		call(asm.FUNGE_SYSINFO),
+	)
+	lower.refreshDepth()
+}
+
+// fingerprint loads or unloads the fingerprint on the data stack.
+func fingerprint(vmFunc string) func(lower *lowerer) {
+	return func(lower *lowerer) {
+		lower.append(
+			pushImmediate(__DATA_STACK),
+			call(vmFunc),
+			pop(__TEST_REGISTER),
+		)
+		lower.refreshDepth()
+		lower.reflectUnless(__TEST_REGISTER, func() {})
+	}
+}
+
+// executeFingerprint runs the fingerprint function bound to the instruction.
+func executeFingerprint(chr byte) func(lower *lowerer) {
+	return func(lower *lowerer) {
+		lower.append(
+			pushImmediate(int(chr)),
+			pushImmediate(__DATA_STACK),
+			call(asm.FUNGE_EXECUTE),
+			pop(__TEST_REGISTER),
+		)
+		lower.refreshDepth()
+		lower.reflectUnless(__TEST_REGISTER, func() {})
+	}
+}
+
+// reflectUnless runs then if the register is set, and otherwise reflects.
+func (lower *lowerer) reflectUnless(register int, then func()) {
+	reflect := lower.newLabel()
+	done := lower.newLabel()
+
+	lower.append(jumpZero(register, reflect))
+	then()
+	lower.jump(done)
+	lower.label(reflect)
+	lower.reflect()
+	lower.label(done)
+}
+
+func (lower *lowerer) reflect() {
+	lower.append(pushImmediate(asm.PLAYFIELD_REVERSE))
+	lower.turn()
+}
+
+// refreshDepth reads the depth of the data stack after VmFunctions change it.
+func (lower *lowerer) refreshDepth() {
+	lower.append(
+		pushImmediate(__DATA_STACK),
+		call(asm.FUNGE_DEPTH),
+		pop(__DEPTH_REGISTER),
+	)
+}
+
+func (lower *lowerer) skip(mode int) {
+	lower.append(
+		pushImmediate(mode),
+		push(__FIELD_REGISTER),
+		call(asm.FUNGE_SKIP),
+	)
+}
+
+// nextInstruction moves the instruction pointer to the next instruction in its
+// path.
+func (lower *lowerer) nextInstruction() {
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_STEP),
+	)
+	lower.skip(asm.FUNGE_SKIP_COMMENTS)
+}
+
+func (lower *lowerer) pointer(x, y int) {
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.FUNGE_POINTER),
+		pop(x),
+		pop(y),
+	)
+}
+
+func jumpNotZero(register int, label string) *asm.JumpStmt {
+	stmt := &asm.JumpStmt{Label: label}
+	stmt.Operand[0] = register
+	return stmt
+}
+
+const __NEXT_LABEL = "befunge_next"
@@ -0,0 +1,462 @@
+package befunge
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Lower gives a program that loads the cells onto a playfield and then
+// interprets it.  The Befunge stack is a VM stack, with its depth kept in a
+// register so that popping it when empty gives zero.
+func Lower(cells []Cell) *asm.AST {
+	lower := &lowerer{}
+	lower.prologue(cells, WIDTH, HEIGHT)
+	lower.interpreter()
+
+	return &asm.AST{Statements: lower.statements}
+}
+
+type lowerer struct {
+	statements []asm.Statement
+	labelCount int
+}
+
+func (lower *lowerer) prologue(cells []Cell, width, height int) {
+	lower.append(
+		pushImmediate(height),
+		pushImmediate(width),
+		call(asm.PLAYFIELD_NEW),
+		pop(__FIELD_REGISTER),
+	)
+
+	for _, cell := range cells {
+		lower.append(
+			pushImmediate(int(cell.Value)),
+			pushImmediate(cell.Y),
+			pushImmediate(cell.X),
+			push(__FIELD_REGISTER),
+			call(asm.PLAYFIELD_PUT),
+		)
+	}
+}
+
+// interpreter fetches the command under the instruction pointer, jumps to its
+// implementation, and then steps.  Characters that are not commands do
+// nothing.
+func (lower *lowerer) interpreter() {
+	lower.label(__FETCH_LABEL)
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_CURRENT),
+		pop(__COMMAND_REGISTER),
+		jumpZero(__STRING_MODE_REGISTER, __DISPATCH_LABEL),
+	)
+	lower.jumpIfCommand('"', __STRING_END_LABEL)
+	lower.pushData(__COMMAND_REGISTER)
+	lower.jump(__STEP_LABEL)
+	lower.label(__STRING_END_LABEL)
+	lower.append(set(__STRING_MODE_REGISTER, 0))
+	lower.jump(__STEP_LABEL)
+
+	lower.label(__DISPATCH_LABEL)
+	lower.jumpIfCommand(' ', __STEP_LABEL)
+
+	commands := sortedCommands()
+
+	for _, chr := range commands {
+		lower.jumpIfCommand(chr, commandLabel(chr))
+	}
+
+	lower.jump(__STEP_LABEL)
+
+	for _, chr := range commands {
+		lower.label(commandLabel(chr))
+		__COMMANDS[chr](lower)
+		lower.jump(__STEP_LABEL)
+	}
+
+	lower.label(__STEP_LABEL)
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_STEP),
+	)
+	lower.jump(__FETCH_LABEL)
+	lower.label(__END_LABEL)
+}
+
+func sortedCommands() []byte {
+	commands := []byte{}
+
+	for chr := range __COMMANDS {
+		commands = append(commands, chr)
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i] < commands[j]
+	})
+
+	return commands
+}
+
+func commandLabel(chr byte) string {
+	return fmt.Sprintf("befunge_%d", chr)
+}
+
+var __COMMANDS map[byte]func(lower *lowerer)
+
+func init() {
+	__COMMANDS = commands93()
+}
+
+func commands93() map[byte]func(lower *lowerer) {
+	commands := map[byte]func(lower *lowerer){
+		'+':  binary(func(a, b int) asm.Statement { return &asm.AddStmt{TwoOperandStmt: operands(b, a)} }),
+		'-':  binary(func(a, b int) asm.Statement { return &asm.SubStmt{TwoOperandStmt: operands(b, a)} }),
+		'*':  binary(func(a, b int) asm.Statement { return &asm.MulStmt{TwoOperandStmt: operands(b, a)} }),
+		'/':  divide(func(a, b int) asm.Statement { return &asm.SignedDivStmt{TwoOperandStmt: operands(b, a)} }),
+		'%':  divide(func(a, b int) asm.Statement { return &asm.SignedModStmt{TwoOperandStmt: operands(b, a)} }),
+		'`':  binary(func(a, b int) asm.Statement { return &asm.SignedGreaterStmt{TwoOperandStmt: operands(b, a)} }),
+		'!':  not,
+		'>':  turn(asm.PLAYFIELD_EAST),
+		'v':  turn(asm.PLAYFIELD_SOUTH),
+		'<':  turn(asm.PLAYFIELD_WEST),
+		'^':  turn(asm.PLAYFIELD_NORTH),
+		'?':  turn(asm.PLAYFIELD_RANDOM),
+		'_':  branch(asm.PLAYFIELD_EAST, asm.PLAYFIELD_WEST),
+		'|':  branch(asm.PLAYFIELD_SOUTH, asm.PLAYFIELD_NORTH),
+		'"':  stringMode,
+		':':  duplicate,
+		'\\': swap,
+		'$':  discard,
+		'.':  outputInt,
+		',':  outputChar,
+		'#':  bridge,
+		'p':  put,
+		'g':  get,
+		'&':  inputInt,
+		'~':  inputChar,
+		'@':  end,
+	}
+
+	for digit := 0; digit <= 9; digit++ {
+		commands[byte('0'+digit)] = pushDigit(digit)
+	}
+
+	return commands
+}
+
+func pushDigit(digit int) func(lower *lowerer) {
+	return func(lower *lowerer) {
+		lower.append(set(__A_REGISTER, digit))
+		lower.pushData(__A_REGISTER)
+	}
+}
+
+// binary pops a and then b, and pushes the result that op leaves in b.
+func binary(op func(a, b int) asm.Statement) func(lower *lowerer) {
+	return func(lower *lowerer) {
+		lower.popData(__A_REGISTER)
+		lower.popData(__B_REGISTER)
+		lower.append(op(__A_REGISTER, __B_REGISTER))
+		lower.pushData(__B_REGISTER)
+	}
+}
+
+// divide is like binary, but pushes zero when a is zero.
+func divide(op func(a, b int) asm.Statement) func(lower *lowerer) {
+	return func(lower *lowerer) {
+		byZero := lower.newLabel()
+		done := lower.newLabel()
+
+		lower.popData(__A_REGISTER)
+		lower.popData(__B_REGISTER)
+		lower.append(
+			jumpZero(__A_REGISTER, byZero),
+			op(__A_REGISTER, __B_REGISTER),
+		)
+		lower.jump(done)
+		lower.label(byZero)
+		lower.append(set(__B_REGISTER, 0))
+		lower.label(done)
+		lower.pushData(__B_REGISTER)
+	}
+}
+
+func not(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.append(
+		set(__CONSTANT_REGISTER, 0),
+		&asm.EqualStmt{TwoOperandStmt: operands(__A_REGISTER, __CONSTANT_REGISTER)},
+	)
+	lower.pushData(__A_REGISTER)
+}
+
+func turn(direction int) func(lower *lowerer) {
+	return func(lower *lowerer) {
+		lower.append(pushImmediate(direction))
+		lower.turn()
+	}
+}
+
+// branch pops a value and turns to ifZero if it is zero, or else otherwise.
+func branch(ifZero, otherwise int) func(lower *lowerer) {
+	return func(lower *lowerer) {
+		chosen := lower.newLabel()
+
+		lower.popData(__A_REGISTER)
+		lower.append(
+			set(__B_REGISTER, ifZero),
+			jumpZero(__A_REGISTER, chosen),
+			set(__B_REGISTER, otherwise),
+		)
+		lower.label(chosen)
+		lower.append(push(__B_REGISTER))
+		lower.turn()
+	}
+}
+
+// turn takes the direction from the top of the call stack.
+func (lower *lowerer) turn() {
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_TURN),
+	)
+}
+
+func stringMode(lower *lowerer) {
+	lower.append(set(__STRING_MODE_REGISTER, 1))
+}
+
+func duplicate(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.pushData(__A_REGISTER)
+	lower.pushData(__A_REGISTER)
+}
+
+func swap(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.popData(__B_REGISTER)
+	lower.pushData(__A_REGISTER)
+	lower.pushData(__B_REGISTER)
+}
+
+func discard(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+}
+
+// outputInt writes the number followed by a space.
+func outputInt(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.append(
+		push(__A_REGISTER),
+		call(asm.IO_WRITE_INT),
+		set(__CONSTANT_REGISTER, ' '),
+		write(__CONSTANT_REGISTER),
+	)
+}
+
+func outputChar(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.append(write(__A_REGISTER))
+}
+
+// bridge skips the next cell by stepping once more than usual.
+func bridge(lower *lowerer) {
+	lower.append(
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_STEP),
+	)
+}
+
+// put pops y, x and then the value.
+func put(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.popData(__B_REGISTER)
+	lower.popData(__C_REGISTER)
+	lower.append(
+		push(__C_REGISTER),
+		push(__A_REGISTER),
+		push(__B_REGISTER),
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_PUT),
+	)
+}
+
+// get pops y and then x.
+func get(lower *lowerer) {
+	lower.popData(__A_REGISTER)
+	lower.popData(__B_REGISTER)
+	lower.append(
+		push(__A_REGISTER),
+		push(__B_REGISTER),
+		push(__FIELD_REGISTER),
+		call(asm.PLAYFIELD_GET),
+		pop(__A_REGISTER),
+	)
+	lower.pushData(__A_REGISTER)
+}
+
+func inputInt(lower *lowerer) {
+	lower.append(
+		call(asm.IO_READ_INT),
+		pop(__A_REGISTER),
+	)
+	lower.pushData(__A_REGISTER)
+}
+
+func inputChar(lower *lowerer) {
+	read := &asm.ReadStmt{}
+	read.Operand = __A_REGISTER
+
+	lower.append(read)
+	lower.pushData(__A_REGISTER)
+}
+
+func end(lower *lowerer) {
+	lower.jump(__END_LABEL)
+}
+
+func (lower *lowerer) append(statements ...asm.Statement) {
+	lower.statements = append(lower.statements, statements...)
+}
+
+func (lower *lowerer) newLabel() string {
+	lower.labelCount++
+	return fmt.Sprintf("befunge_label_%d", lower.labelCount)
+}
+
+func (lower *lowerer) label(name string) {
+	lower.append(&asm.LabelStmt{Name: name})
+}
+
+func (lower *lowerer) jump(label string) {
+	lower.append(&asm.JumpAlwaysStmt{Label: label})
+}
+
+func (lower *lowerer) jumpIfCommand(chr byte, label string) {
+	jump := &asm.JumpStmt{Label: label}
+	jump.Operand[0] = __TEST_REGISTER
+
+	lower.append(
+		set(__CONSTANT_REGISTER, int(chr)),
+		&asm.CopyStmt{TwoOperandStmt: operands(__TEST_REGISTER, __COMMAND_REGISTER)},
+		&asm.EqualStmt{TwoOperandStmt: operands(__TEST_REGISTER, __CONSTANT_REGISTER)},
+		jump,
+	)
+}
+
+func (lower *lowerer) pushData(register int) {
+	stmt := &asm.PushStmt{}
+	stmt.Operand = [2]int{__DATA_STACK, register}
+
+	lower.append(stmt, addImmediate(__DEPTH_REGISTER, 1))
+}
+
+// popData sets register to zero if the stack is empty.
+func (lower *lowerer) popData(register int) {
+	empty := lower.newLabel()
+	stmt := &asm.PopStmt{}
+	stmt.Operand = [2]int{__DATA_STACK, register}
+
+	lower.append(
+		set(register, 0),
+		jumpZero(__DEPTH_REGISTER, empty),
+		stmt,
+		subImmediate(__DEPTH_REGISTER, 1),
+	)
+	lower.label(empty)
+}
+
+func operands(first, second int) asm.TwoOperandStmt {
+	stmt := asm.TwoOperandStmt{}
+	stmt.Operand = [2]int{first, second}
+	return stmt
+}
+
+func set(register, value int) *asm.SetStmt {
+	stmt := &asm.SetStmt{}
+	stmt.Operand = [2]int{register, value}
+	return stmt
+}
+
+func addImmediate(register, value int) *asm.AddImmediateStmt {
+	stmt := &asm.AddImmediateStmt{}
+	stmt.Operand = [2]int{register, value}
+	return stmt
+}
+
+func subImmediate(register, value int) *asm.SubImmediateStmt {
+	stmt := &asm.SubImmediateStmt{}
+	stmt.Operand = [2]int{register, value}
+	return stmt
+}
+
+func jumpZero(register int, label string) *asm.JumpZeroStmt {
+	stmt := &asm.JumpZeroStmt{Label: label}
+	stmt.Operand[0] = register
+	return stmt
+}
+
+func write(register int) *asm.WriteStmt {
+	stmt := &asm.WriteStmt{}
+	stmt.Operand = register
+	return stmt
+}
+
+func pushImmediate(value int) *asm.PushImmediateStmt {
+	stmt := &asm.PushImmediateStmt{}
+	stmt.Operand = [2]int{__CALL_STACK, value}
+	return stmt
+}
+
+func push(register int) *asm.PushStmt {
+	stmt := &asm.PushStmt{}
+	stmt.Operand = [2]int{__CALL_STACK, register}
+	return stmt
+}
+
+func pop(register int) *asm.PopStmt {
+	stmt := &asm.PopStmt{}
+	stmt.Operand = [2]int{__CALL_STACK, register}
+	return stmt
+}
+
+func call(vmFunc string) *asm.CallStmt {
+	stmt := &asm.CallStmt{VmFunc: vmFunc}
+	stmt.Operand = __CALL_STACK
+	return stmt
+}
+
+// VmFunctions take their arguments on the call stack, and the Befunge stack is
+// the data stack.
+const (
+	__CALL_STACK = iota
+	__DATA_STACK
+)
+
+const (
+	__FIELD_REGISTER = iota
+	__COMMAND_REGISTER
+	__STRING_MODE_REGISTER
+	__DEPTH_REGISTER
+	__A_REGISTER
+	__B_REGISTER
+	__C_REGISTER
+	__TEST_REGISTER
+	__CONSTANT_REGISTER
+	// Funge-98 only.
+	__OFFSET_X_REGISTER
+	__OFFSET_Y_REGISTER
+	__COUNT_REGISTER
+	__ITERATED_REGISTER
+	__SAVED_X_REGISTER
+	__SAVED_Y_REGISTER
+)
+
+const __FETCH_LABEL = "befunge_fetch"
+const __DISPATCH_LABEL = "befunge_dispatch"
+const __STRING_END_LABEL = "befunge_string_end"
+const __STEP_LABEL = "befunge_step"
+const __END_LABEL = "befunge_end"
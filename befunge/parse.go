@@ -0,0 +1,55 @@
+package befunge
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Parse compiles Befunge-93.  The source is loaded onto an 80x25 playfield by
+// the program itself, which then interprets it, so that p may change it.
+func Parse(source []byte) (*asm.AST, error) {
+	cells, err := ParseCells(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Lower(cells), nil
+}
+
+// Cell is a command on the playfield.
+type Cell struct {
+	X     int
+	Y     int
+	Value byte
+}
+
+// ParseCells finds the cells of the source that are not spaces.
+func ParseCells(source []byte) ([]Cell, error) {
+	cells := []Cell{}
+	lines := bytes.Split(source, []byte("\n"))
+
+	for y, line := range lines {
+		line = bytes.TrimRight(line, "\r")
+
+		for x, chr := range line {
+			if chr == ' ' {
+				continue
+			}
+
+			if x >= WIDTH || y >= HEIGHT {
+				pos := asm.SourcePos{Line: y + 1, Column: x + 1}
+				return nil, fmt.Errorf("Command outside the %dx%d playfield at %v", WIDTH, HEIGHT, pos)
+			}
+
+			cells = append(cells, Cell{X: x, Y: y, Value: chr})
+		}
+	}
+
+	return cells, nil
+}
+
+const WIDTH = 80
+const HEIGHT = 25
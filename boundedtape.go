@@ -0,0 +1,151 @@
+package shapes
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// TapeEdge decides what happens when the head of a BoundedTape moves past
+// either end.
+type TapeEdge byte
+
+const (
+	// Fail with ErrTapeEdge.
+	TAPE_EDGE_ERROR = TapeEdge(iota)
+	// Continue from the other end.
+	TAPE_EDGE_WRAP
+	// Stop at the end.
+	TAPE_EDGE_CLAMP
+)
+
+// BoundedTape has a fixed number of cells, with the head starting on the
+// leftmost.
+type BoundedTape struct {
+	cellFormat
+	cells []uint64
+	index int
+	edge  TapeEdge
+}
+
+func MakeBoundedTape(length int, edge TapeEdge, cellBits, overflow int) (*BoundedTape, error) {
+	if length < 1 {
+		return nil, fmt.Errorf("Invalid tape length %d", length)
+	}
+
+	switch edge {
+	case TAPE_EDGE_ERROR, TAPE_EDGE_WRAP, TAPE_EDGE_CLAMP:
+	default:
+		return nil, fmt.Errorf("Unknown tape edge %d", edge)
+	}
+
+	format, err := makeCellFormat(cellBits, overflow)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tape := &BoundedTape{
+		cellFormat: format,
+		cells:      make([]uint64, length),
+		edge:       edge,
+	}
+
+	return tape, nil
+}
+
+func (tape *BoundedTape) MoveHead(offset int) error {
+	length := len(tape.cells)
+	index := tape.index + offset
+
+	if index >= 0 && index < length {
+		tape.index = index
+		return nil
+	}
+
+	switch tape.edge {
+	case TAPE_EDGE_WRAP:
+		tape.index = ((index % length) + length) % length
+	case TAPE_EDGE_CLAMP:
+		if index < 0 {
+			tape.index = 0
+		} else {
+			tape.index = length - 1
+		}
+	default:
+		return errors.Wrapf(ErrTapeEdge, "head moved to %d on tape of length %d", index, length)
+	}
+
+	return nil
+}
+
+func (tape *BoundedTape) ReadHead() uint64 {
+	return tape.cells[tape.index]
+}
+
+func (tape *BoundedTape) WriteHead(val uint64) error {
+	val, err := tape.fit(val)
+
+	if err != nil {
+		return err
+	}
+
+	tape.cells[tape.index] = val
+
+	return nil
+}
+
+func (tape *BoundedTape) Head() int {
+	return tape.index
+}
+
+func (tape *BoundedTape) Cell(index int) uint64 {
+	if index < 0 || index >= len(tape.cells) {
+		return 0
+	}
+
+	return tape.cells[index]
+}
+
+// Scan moves the head by step until it finds a zero cell.  It fails with
+// ErrTapeEdge if the head is clamped at an end, or goes round a wrapping tape,
+// without finding one.
+func (tape *BoundedTape) Scan(step int) error {
+	for moves := 0; tape.ReadHead() != 0; moves++ {
+		if moves == len(tape.cells) {
+			return errors.Wrapf(ErrTapeEdge, "no zero cell scanning by %d from %d", step, tape.index)
+		}
+
+		index := tape.index
+		err := tape.MoveHead(step)
+
+		if err != nil {
+			return err
+		}
+
+		if tape.index == index {
+			return errors.Wrapf(ErrTapeEdge, "head stopped at %d scanning by %d", index, step)
+		}
+	}
+
+	return nil
+}
+
+// BoundedTapeLibrary registers the tape VmFunctions for bounded tapes, and may
+// be used to Override the infinite tapes of the StdLib.  Every cell is charged
+// to the meter when the tape is created.
+func BoundedTapeLibrary(length int, edge TapeEdge) *Library {
+	return TapeLibrary(func(cellBits, overflow int, meter MemoryMeter) (Tape, error) {
+		if length > 0 {
+			err := meter.Allocate(uint64(length) * __CELL_BYTES)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return MakeBoundedTape(length, edge, cellBits, overflow)
+	})
+}
+
+var ErrTapeEdge = errors.New("tape head moved past the edge")
@@ -0,0 +1,134 @@
+package shapes
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+func TestBoundedTapeMoveHead(t *testing.T) {
+	testCases := []struct {
+		edge   TapeEdge
+		offset int
+		index  int
+		ok     bool
+	}{
+		{TAPE_EDGE_ERROR, 4, 4, true},
+		{TAPE_EDGE_ERROR, 5, 0, false},
+		{TAPE_EDGE_ERROR, -1, 0, false},
+		{TAPE_EDGE_WRAP, 5, 0, true},
+		{TAPE_EDGE_WRAP, -1, 4, true},
+		{TAPE_EDGE_WRAP, -11, 4, true},
+		{TAPE_EDGE_WRAP, 12, 2, true},
+		{TAPE_EDGE_CLAMP, 9, 4, true},
+		{TAPE_EDGE_CLAMP, -3, 0, true},
+	}
+
+	for i, test := range testCases {
+		tape, err := MakeBoundedTape(5, test.edge, 64, asm.TAPE_OVERFLOW_WRAP)
+
+		if err != nil {
+			t.Errorf("Unexpected error in case %d: %s", i, err.Error())
+			continue
+		}
+
+		err = tape.MoveHead(test.offset)
+
+		if (err == nil) != test.ok {
+			t.Errorf("Unexpected error state in case %d: %v", i, err)
+			continue
+		}
+
+		if err != nil && errors.Cause(err) != ErrTapeEdge {
+			t.Errorf("Expected tape edge error in case %d but received %v", i, err)
+		}
+
+		if tape.index != test.index {
+			t.Errorf("Expected head at %d in case %d but was at %d", test.index, i, tape.index)
+		}
+	}
+}
+
+func TestBoundedTapeScan(t *testing.T) {
+	tape, err := MakeBoundedTape(4, TAPE_EDGE_WRAP, 8, asm.TAPE_OVERFLOW_WRAP)
+
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	tape.WriteHead(1)
+	tape.MoveHead(3)
+	tape.WriteHead(1)
+	tape.MoveHead(-3)
+
+	err = tape.Scan(-1)
+
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+
+	if tape.index != 2 {
+		t.Errorf("Expected head at 2 but was at %d", tape.index)
+	}
+
+	tape.MoveHead(1)
+	tape.edge = TAPE_EDGE_ERROR
+
+	if errors.Cause(tape.Scan(1)) != ErrTapeEdge {
+		t.Error("Expected scan to fail at the edge")
+	}
+}
+
+func TestBoundedTapeScan_NoZero(t *testing.T) {
+	testCases := []struct {
+		edge  TapeEdge
+		cells []uint64
+		step  int
+	}{
+		{TAPE_EDGE_CLAMP, []uint64{1, 1, 1, 1}, 1},
+		{TAPE_EDGE_CLAMP, []uint64{1, 1, 1, 1}, -2},
+		{TAPE_EDGE_WRAP, []uint64{1, 1, 1, 1}, 1},
+		{TAPE_EDGE_WRAP, []uint64{1, 1, 1, 1}, -3},
+		{TAPE_EDGE_WRAP, []uint64{1, 0, 1, 1}, 2},
+		{TAPE_EDGE_WRAP, []uint64{0, 1, 1, 0}, 0},
+	}
+
+	for i, test := range testCases {
+		tape, err := MakeBoundedTape(len(test.cells), test.edge, 8, asm.TAPE_OVERFLOW_WRAP)
+
+		if err != nil {
+			t.Errorf("Unexpected error in case %d: %s", i, err.Error())
+			continue
+		}
+
+		copy(tape.cells, test.cells)
+		tape.index = 2
+
+		if errors.Cause(tape.Scan(test.step)) != ErrTapeEdge {
+			t.Errorf("Expected scan to fail in case %d", i)
+		}
+	}
+}
+
+func TestMakeBoundedTape_Failure(t *testing.T) {
+	failureCases := []struct {
+		length   int
+		edge     TapeEdge
+		cellBits int
+	}{
+		{0, TAPE_EDGE_ERROR, 8},
+		{10, TapeEdge(3), 8},
+		{10, TAPE_EDGE_ERROR, 7},
+	}
+
+	for i, test := range failureCases {
+		_, err := MakeBoundedTape(test.length, test.edge, test.cellBits, asm.TAPE_OVERFLOW_WRAP)
+
+		if err == nil {
+			t.Errorf("Expected failure in case %d", i)
+		}
+	}
+}
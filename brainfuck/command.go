@@ -0,0 +1,505 @@
+package brainfuck
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Command is a brainfuck program in a form that is easy to rewrite.  The value
+// register always holds the cell under the tape head, so changes to the cell
+// only reach the tape with an explicit STORE.
+type Command struct {
+	Kind    CommandKind
+	Value   int
+	Targets []MultiplyTarget
+	Nest    []Command
+	// Pos is the source of the command, given to the statements it lowers to.
+	Pos asm.SourcePos
+}
+
+// MultiplyTarget adds Factor times the current cell to the cell at Offset.
+type MultiplyTarget struct {
+	Offset int
+	Factor int
+}
+
+type CommandKind byte
+
+const (
+	// Add Value to the value register.
+	ADD = CommandKind(iota)
+	// Move the head by Value cells and load the new cell.
+	MOVE
+	// Write the value register to the cell.
+	STORE
+	OUTPUT
+	INPUT
+	// Repeat Nest while the value register is not zero.
+	LOOP
+	// Set the value register to zero.
+	CLEAR
+	// Apply Targets, then zero the cell.
+	MULTIPLY
+	// Move the head by Value cells until it reaches a zero cell.
+	SCAN
+	// Define Nest as procedure number Value under the value register, for
+	// pbrain.
+	DEFINE
+	// Call the procedure defined under the value register.
+	INVOKE
+	// Start a thread, for Brainfork.  The value register is set to 0, and the
+	// new thread moves the head right and sets the cell to 1.
+	FORK
+	// End the program, for Extended Brainfuck Type I.
+	HALT
+	// Copy the value register to the storage register.
+	SAVE
+	// Copy the storage register to the value register.
+	RESTORE
+	// Shift the value register by one bit.
+	SHIFT_RIGHT
+	SHIFT_LEFT
+	NOT
+	// Combine the value register with the storage register.
+	XOR
+	AND
+	OR
+)
+
+var __COMMAND_STRING = []string{
+	"ADD",
+	"MOVE",
+	"STORE",
+	"OUTPUT",
+	"INPUT",
+	"LOOP",
+	"CLEAR",
+	"MULTIPLY",
+	"SCAN",
+	"DEFINE",
+	"INVOKE",
+	"FORK",
+	"HALT",
+	"SAVE",
+	"RESTORE",
+	"SHIFT_RIGHT",
+	"SHIFT_LEFT",
+	"NOT",
+	"XOR",
+	"AND",
+	"OR",
+}
+
+func (kind CommandKind) String() string {
+	return __COMMAND_STRING[kind]
+}
+
+func (cmd Command) String() string {
+	switch cmd.Kind {
+	case ADD, MOVE, SCAN:
+		return fmt.Sprintf("%v(%d)", cmd.Kind, cmd.Value)
+	case LOOP:
+		return fmt.Sprintf("%v%v", cmd.Kind, cmd.Nest)
+	case DEFINE:
+		return fmt.Sprintf("%v(%d)%v", cmd.Kind, cmd.Value, cmd.Nest)
+	case MULTIPLY:
+		return fmt.Sprintf("%v%v", cmd.Kind, cmd.Targets)
+	}
+
+	return cmd.Kind.String()
+}
+
+// Lower translates commands into an AST that runs on a tape chosen by
+// options.
+func Lower(commands []Command, options Options) *asm.AST {
+	lower := &lowerer{
+		options:        options,
+		builder:        &asm.ASTBuilder{},
+		procedureCount: countProcedures(commands),
+		threaded:       containsCommand(commands, FORK),
+	}
+	lower.builder.Append(options.prologue()...)
+	lower.block(commands)
+	lower.epilogue(containsCommand(commands, HALT))
+
+	return lower.builder.AST
+}
+
+type lowerer struct {
+	options Options
+	builder *asm.ASTBuilder
+	// The number of pbrain procedures in the program.
+	procedureCount int
+	// Whether the program starts Brainfork threads.
+	threaded   bool
+	labelCount int
+}
+
+func (lower *lowerer) block(commands []Command) {
+	builder := lower.builder
+
+	for _, cmd := range commands {
+		builder.Pos = cmd.Pos
+
+		switch cmd.Kind {
+		case LOOP:
+			builder.OpenLoop(__VALUE_REGISTER)
+			lower.yield()
+			lower.block(cmd.Nest)
+			builder.LeaveBlock()
+		case DEFINE:
+			lower.define(cmd)
+		case INVOKE:
+			lower.invoke()
+		case FORK:
+			lower.fork()
+			lower.yield()
+		case HALT:
+			builder.Append(&asm.JumpAlwaysStmt{Label: __END_LABEL})
+		case MOVE:
+			builder.Append(lowerMove(cmd.Value)...)
+
+			if lower.threaded {
+				builder.Append(addImmediate(__HEAD_REGISTER, cmd.Value))
+			}
+
+			lower.yield()
+		case STORE, OUTPUT:
+			builder.Append(lower.options.lowerCommand(cmd)...)
+			lower.yield()
+		default:
+			builder.Append(lower.options.lowerCommand(cmd)...)
+		}
+	}
+}
+
+func (lower *lowerer) newLabel() string {
+	lower.labelCount++
+	return fmt.Sprintf("brainfuck_label_%d", lower.labelCount)
+}
+
+func (options Options) lowerCommand(cmd Command) []asm.Statement {
+	switch cmd.Kind {
+	case ADD:
+		return lowerAdd(cmd.Value)
+	case MOVE:
+		return lowerMove(cmd.Value)
+	case STORE:
+		return options.lowerStore()
+	case OUTPUT:
+		output := &asm.WriteStmt{}
+		output.Operand = __VALUE_REGISTER
+		return []asm.Statement{output}
+	case INPUT:
+		input := &asm.ReadStmt{}
+		input.Operand = __VALUE_REGISTER
+		return []asm.Statement{input}
+	case CLEAR:
+		return []asm.Statement{set(__VALUE_REGISTER, 0)}
+	case MULTIPLY:
+		return options.lowerMultiply(cmd.Targets)
+	case SCAN:
+		return lowerScan(cmd.Value)
+	case SAVE:
+		return []asm.Statement{copyRegister(__STORAGE_REGISTER, __VALUE_REGISTER)}
+	case RESTORE:
+		return []asm.Statement{copyRegister(__VALUE_REGISTER, __STORAGE_REGISTER)}
+	case SHIFT_RIGHT:
+		shift := &asm.ShiftRightStmt{}
+		shift.Operand = [2]int{__VALUE_REGISTER, __SCRATCH_REGISTER}
+		return []asm.Statement{set(__SCRATCH_REGISTER, 1), shift}
+	case SHIFT_LEFT:
+		shift := &asm.ShiftLeftStmt{}
+		shift.Operand = [2]int{__VALUE_REGISTER, __SCRATCH_REGISTER}
+		return []asm.Statement{set(__SCRATCH_REGISTER, 1), shift}
+	case NOT:
+		return options.lowerNot()
+	case XOR:
+		xor := &asm.XorStmt{}
+		xor.Operand = [2]int{__VALUE_REGISTER, __STORAGE_REGISTER}
+		return []asm.Statement{xor}
+	case AND:
+		and := &asm.AndStmt{}
+		and.Operand = [2]int{__VALUE_REGISTER, __STORAGE_REGISTER}
+		return []asm.Statement{and}
+	case OR:
+		or := &asm.OrStmt{}
+		or.Operand = [2]int{__VALUE_REGISTER, __STORAGE_REGISTER}
+		return []asm.Statement{or}
+	}
+
+	panic(fmt.Sprintf("Cannot lower %v", cmd))
+}
+
+func lowerAdd(value int) []asm.Statement {
+	return []asm.Statement{addImmediate(__VALUE_REGISTER, value)}
+}
+
+func lowerMove(offset int) []asm.Statement {
+	return []asm.Statement{
+		pushImmediate(offset),
+		push(__TAPE_INDEX_REGISTER),
+		call(asm.TAPE_MOVE_HEAD),
+		push(__TAPE_INDEX_REGISTER),
+		call(asm.TAPE_READ_HEAD),
+		pop(__VALUE_REGISTER),
+	}
+}
+
+// Cells narrower than a register may not hold the value written, so the value
+// register is reloaded from the cell to keep the two in agreement.
+func (options Options) lowerStore() []asm.Statement {
+	statements := []asm.Statement{
+		push(__VALUE_REGISTER),
+		push(__TAPE_INDEX_REGISTER),
+		call(asm.TAPE_WRITE_HEAD),
+	}
+
+	if options.CellBits < 64 {
+		statements = append(statements,
+			push(__TAPE_INDEX_REGISTER),
+			call(asm.TAPE_READ_HEAD),
+			pop(__VALUE_REGISTER),
+		)
+	}
+
+	return statements
+}
+
+func lowerScan(step int) []asm.Statement {
+	return []asm.Statement{
+		pushImmediate(step),
+		push(__TAPE_INDEX_REGISTER),
+		call(asm.TAPE_SCAN),
+		push(__TAPE_INDEX_REGISTER),
+		call(asm.TAPE_READ_HEAD),
+		pop(__VALUE_REGISTER),
+	}
+}
+
+// The cell is copied to the multiplicand register and zeroed before visiting
+// each target, so that the head returns to a zero cell.
+func (options Options) lowerMultiply(targets []MultiplyTarget) []asm.Statement {
+	keep := &asm.CopyStmt{}
+	keep.Operand = [2]int{__MULTIPLICAND_REGISTER, __VALUE_REGISTER}
+
+	statements := []asm.Statement{keep, set(__VALUE_REGISTER, 0)}
+	statements = append(statements, options.lowerStore()...)
+
+	head := 0
+
+	for _, target := range targets {
+		statements = append(statements, lowerMove(target.Offset-head)...)
+		head = target.Offset
+
+		for i := 0; i < target.Factor; i++ {
+			add := &asm.AddStmt{}
+			add.Operand = [2]int{__VALUE_REGISTER, __MULTIPLICAND_REGISTER}
+			statements = append(statements, add)
+		}
+
+		for i := 0; i > target.Factor; i-- {
+			sub := &asm.SubStmt{}
+			sub.Operand = [2]int{__VALUE_REGISTER, __MULTIPLICAND_REGISTER}
+			statements = append(statements, sub)
+		}
+
+		statements = append(statements, options.lowerStore()...)
+	}
+
+	if head != 0 {
+		statements = append(statements, lowerMove(-head)...)
+	}
+
+	return statements
+}
+
+// Inverting every bit of a register sets those above the cell, so they are
+// cleared to keep the value within the cell.
+func (options Options) lowerNot() []asm.Statement {
+	not := &asm.NotStmt{}
+	not.Operand = __VALUE_REGISTER
+	statements := []asm.Statement{not}
+
+	if options.CellBits < 64 {
+		and := &asm.AndStmt{}
+		and.Operand = [2]int{__VALUE_REGISTER, __SCRATCH_REGISTER}
+		statements = append(statements, set(__SCRATCH_REGISTER, 1<<uint(options.CellBits)-1), and)
+	}
+
+	return statements
+}
+
+func (options Options) prologue() []asm.Statement {
+	return []asm.Statement{
+		pushImmediate(options.Overflow),
+		pushImmediate(options.CellBits),
+		call(asm.TAPE_NEW),
+		pop(__TAPE_INDEX_REGISTER),
+	}
+}
+
+func set(register, value int) *asm.SetStmt {
+	stmt := &asm.SetStmt{}
+	stmt.Operand = [2]int{register, value}
+	return stmt
+}
+
+func addImmediate(register, value int) asm.Statement {
+	if value < 0 {
+		sub := &asm.SubImmediateStmt{}
+		sub.Operand = [2]int{register, -value}
+		return sub
+	}
+
+	add := &asm.AddImmediateStmt{}
+	add.Operand = [2]int{register, value}
+	return add
+}
+
+func copyRegister(to, from int) *asm.CopyStmt {
+	stmt := &asm.CopyStmt{}
+	stmt.Operand = [2]int{to, from}
+	return stmt
+}
+
+func push(register int) *asm.PushStmt {
+	stmt := &asm.PushStmt{}
+	stmt.Operand = [2]int{__STACK_INDEX, register}
+	return stmt
+}
+
+func pushImmediate(value int) *asm.PushImmediateStmt {
+	stmt := &asm.PushImmediateStmt{}
+	stmt.Operand = [2]int{__STACK_INDEX, value}
+	return stmt
+}
+
+func pop(register int) *asm.PopStmt {
+	stmt := &asm.PopStmt{}
+	stmt.Operand = [2]int{__STACK_INDEX, register}
+	return stmt
+}
+
+func call(vmFunc string) *asm.CallStmt {
+	stmt := &asm.CallStmt{VmFunc: vmFunc}
+	stmt.Operand = __STACK_INDEX
+	return stmt
+}
+
+// Lift recovers commands and options from an AST built by Lower.  MULTIPLY
+// and the commands of extensions cannot be recovered, so Lift fails on ASTs
+// that contain them.
+func Lift(ast *asm.AST) ([]Command, Options, error) {
+	options, ok := liftOptions(ast.Statements)
+
+	if !ok {
+		return nil, Options{}, fmt.Errorf("AST does not begin with the brainfuck prologue")
+	}
+
+	commands, err := options.liftBlock(ast.Statements[len(options.prologue()):])
+
+	if err != nil {
+		return nil, Options{}, err
+	}
+
+	return commands, options, nil
+}
+
+func liftOptions(statements []asm.Statement) (Options, bool) {
+	if len(statements) < 2 {
+		return Options{}, false
+	}
+
+	overflow, isOverflow := statements[0].(*asm.PushImmediateStmt)
+	cellBits, isCellBits := statements[1].(*asm.PushImmediateStmt)
+
+	if !isOverflow || !isCellBits {
+		return Options{}, false
+	}
+
+	options := Options{CellBits: cellBits.Operand[1], Overflow: overflow.Operand[1]}
+	start := options.prologue()
+
+	if len(statements) < len(start) || !reflect.DeepEqual(start, statements[:len(start)]) {
+		return Options{}, false
+	}
+
+	return options, options.Validate() == nil
+}
+
+func (options Options) liftBlock(statements []asm.Statement) ([]Command, error) {
+	commands := []Command{}
+
+	for i := 0; i < len(statements); {
+		if loop, ok := statements[i].(*asm.LoopStmt); ok {
+			if loop.Operand != __VALUE_REGISTER {
+				return nil, fmt.Errorf("Loop on unexpected register %d", loop.Operand)
+			}
+
+			nest, err := options.liftBlock(loop.Nest)
+
+			if err != nil {
+				return nil, err
+			}
+
+			commands = append(commands, Command{Kind: LOOP, Nest: nest, Pos: loop.Pos})
+			i++
+			continue
+		}
+
+		cmd, length, ok := options.liftCommand(statements[i:])
+
+		if !ok {
+			return nil, fmt.Errorf("Unrecognized statement %v", statements[i])
+		}
+
+		commands = append(commands, cmd)
+		i += length
+	}
+
+	return commands, nil
+}
+
+// The statements lowered from a command share its position, so candidates are
+// given the position of the first statement before comparison.
+func (options Options) liftCommand(statements []asm.Statement) (Command, int, bool) {
+	candidates := []Command{
+		Command{Kind: STORE},
+		Command{Kind: OUTPUT},
+		Command{Kind: INPUT},
+		Command{Kind: CLEAR},
+	}
+
+	switch stmt := statements[0].(type) {
+	case *asm.AddImmediateStmt:
+		candidates = append(candidates, Command{Kind: ADD, Value: stmt.Operand[1]})
+	case *asm.SubImmediateStmt:
+		candidates = append(candidates, Command{Kind: ADD, Value: -stmt.Operand[1]})
+	case *asm.PushImmediateStmt:
+		candidates = append(candidates,
+			Command{Kind: MOVE, Value: stmt.Operand[1]},
+			Command{Kind: SCAN, Value: stmt.Operand[1]},
+		)
+	}
+
+	var pos asm.SourcePos
+
+	if locatable, ok := statements[0].(asm.Locatable); ok {
+		pos = locatable.Position()
+	}
+
+	for _, cmd := range candidates {
+		cmd.Pos = pos
+		lowered := options.lowerCommand(cmd)
+		asm.Locate(pos, lowered...)
+
+		if len(lowered) <= len(statements) && reflect.DeepEqual(lowered, statements[:len(lowered)]) {
+			return cmd, len(lowered), true
+		}
+	}
+
+	return Command{}, 0, false
+}
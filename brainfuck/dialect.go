@@ -0,0 +1,222 @@
+package brainfuck
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Dialect is brainfuck with each of its eight commands spelled by another
+// token, in the fields named for the commands: Right for '>', Left for '<',
+// Increment for '+', Decrement for '-', Output for '.', Input for ',', Open for
+// '[' and Close for ']'.  A space in a token matches any run of whitespace in
+// the source, so that tokens may be broken across lines.  Everything between
+// tokens is a comment.
+type Dialect struct {
+	Right     string
+	Left      string
+	Increment string
+	Decrement string
+	Output    string
+	Input     string
+	Open      string
+	Close     string
+}
+
+// Parse compiles the dialect for a tape with the DefaultOptions.
+func (dialect *Dialect) Parse(source []byte) (*asm.AST, error) {
+	return dialect.ParseOptions(source, DefaultOptions())
+}
+
+func (dialect *Dialect) ParseOptions(source []byte, options Options) (*asm.AST, error) {
+	err := options.Validate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Extension != EXTENSION_NONE {
+		return nil, fmt.Errorf("Dialects do not support the %v extension", options.Extension)
+	}
+
+	commands, err := dialect.ParseCommands(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Lower(commands, options), nil
+}
+
+// ParseCommands gives the same commands as brainfuck spelled with the usual
+// characters, each at the position where its token starts.  Where tokens of
+// different length match, the longest is taken.
+func (dialect *Dialect) ParseCommands(source []byte) ([]Command, error) {
+	err := dialect.Validate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := dialect.tokens()
+	symbols := []symbol{}
+	pos := asm.SourcePos{Line: 1, Column: 1}
+
+	for len(source) > 0 {
+		longest := 0
+		var chr byte
+
+		for _, tok := range tokens {
+			length := matchToken(source, tok.text)
+
+			if length > longest {
+				longest = length
+				chr = tok.chr
+			}
+		}
+
+		if longest == 0 {
+			longest = 1
+		} else {
+			symbols = append(symbols, symbol{chr: chr, pos: pos})
+		}
+
+		for _, skipped := range source[:longest] {
+			pos = advance(pos, skipped)
+		}
+
+		source = source[longest:]
+	}
+
+	return parseSymbols(symbols, EXTENSION_NONE)
+}
+
+// Validate requires a distinct token for each command.
+func (dialect *Dialect) Validate() error {
+	seen := map[string]byte{}
+
+	for _, tok := range dialect.tokens() {
+		if tok.text == "" {
+			return fmt.Errorf("No token for '%c'", tok.chr)
+		}
+
+		if isSpace(tok.text[0]) || isSpace(tok.text[len(tok.text)-1]) {
+			return fmt.Errorf("Token '%s' for '%c' starts or ends with whitespace", tok.text, tok.chr)
+		}
+
+		if other, ok := seen[tok.text]; ok {
+			return fmt.Errorf("Token '%s' is used for both '%c' and '%c'", tok.text, other, tok.chr)
+		}
+
+		seen[tok.text] = tok.chr
+	}
+
+	return nil
+}
+
+type dialectToken struct {
+	chr  byte
+	text string
+}
+
+func (dialect *Dialect) tokens() []dialectToken {
+	return []dialectToken{
+		{'>', dialect.Right},
+		{'<', dialect.Left},
+		{'+', dialect.Increment},
+		{'-', dialect.Decrement},
+		{'.', dialect.Output},
+		{',', dialect.Input},
+		{'[', dialect.Open},
+		{']', dialect.Close},
+	}
+}
+
+// matchToken gives the length of the token at the start of the source, or
+// zero if it is not there.
+func matchToken(source []byte, token string) int {
+	length := 0
+
+	for i := 0; i < len(token); i++ {
+		if token[i] == ' ' {
+			start := length
+
+			for length < len(source) && isSpace(source[length]) {
+				length++
+			}
+
+			if length == start {
+				return 0
+			}
+
+			continue
+		}
+
+		if length == len(source) || source[length] != token[i] {
+			return 0
+		}
+
+		length++
+	}
+
+	return length
+}
+
+func isSpace(chr byte) bool {
+	switch chr {
+	case ' ', '\t', '\r', '\n':
+		return true
+	}
+
+	return false
+}
+
+// LookupDialect finds a built-in dialect by name.
+func LookupDialect(name string) (*Dialect, bool) {
+	dialect, ok := __DIALECTS[name]
+
+	if !ok {
+		return nil, false
+	}
+
+	copied := dialect
+
+	return &copied, true
+}
+
+// DialectNames lists the built-in dialects in order.
+func DialectNames() []string {
+	names := []string{}
+
+	for name := range __DIALECTS {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+var __DIALECTS = map[string]Dialect{
+	"ook":         ookish("Ook"),
+	"blub":        ookish("Blub"),
+	"alphuck":     Dialect{Right: "a", Left: "c", Increment: "e", Decrement: "i", Output: "j", Input: "o", Open: "p", Close: "s"},
+	"pikalang":    Dialect{Right: "pipi", Left: "pichu", Increment: "pi", Decrement: "ka", Output: "pikachu", Input: "pikapi", Open: "pika", Close: "chu"},
+	"trollscript": Dialect{Right: "ooo", Left: "ool", Increment: "olo", Decrement: "oll", Output: "loo", Input: "lol", Open: "llo", Close: "lll"},
+}
+
+// ookish spells each command with two words, each ending in '.', '?' or '!',
+// as Ook! does.
+func ookish(word string) Dialect {
+	return Dialect{
+		Right:     word + ". " + word + "?",
+		Left:      word + "? " + word + ".",
+		Increment: word + ". " + word + ".",
+		Decrement: word + "! " + word + "!",
+		Output:    word + "! " + word + ".",
+		Input:     word + ". " + word + "!",
+		Open:      word + "! " + word + "?",
+		Close:     word + "? " + word + "!",
+	}
+}
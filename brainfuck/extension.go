@@ -0,0 +1,237 @@
+package brainfuck
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Extension adds commands to brainfuck.  Each extension's characters are
+// comments in the others.
+type Extension byte
+
+const (
+	EXTENSION_NONE = Extension(iota)
+	// pbrain defines a procedure with '(' and ')' under the value of the
+	// current cell, and ':' calls the procedure defined under the value of
+	// the current cell.
+	EXTENSION_PBRAIN
+	// Brainfork starts a thread with 'Y'.  The cell is set to 0 in the
+	// current thread, while the new thread moves the head right and sets that
+	// cell to 1.  Threads share the tape and take turns to run a command.
+	EXTENSION_BRAINFORK
+	// Extended Brainfuck Type I ends the program with '@', copies the cell to
+	// storage with '$' and back with '!', shifts the cell right with '}' and
+	// left with '{', inverts it with '~', and combines it with storage by
+	// '^', '&' and '|'.  Whatever follows an '@' outside loops is data.
+	EXTENSION_EXTENDED_TYPE_I
+)
+
+var __EXTENSION_STRING = []string{
+	"none",
+	"pbrain",
+	"brainfork",
+	"extended1",
+}
+
+// The characters of each extension, besides those of brainfuck.
+var __EXTENSION_SYMBOLS = []string{
+	"",
+	"():",
+	"Y",
+	"@$!}{~^&|",
+}
+
+func (extension Extension) String() string {
+	return __EXTENSION_STRING[extension]
+}
+
+// LookupExtension finds an extension by name.
+func LookupExtension(name string) (Extension, bool) {
+	for i, extensionName := range __EXTENSION_STRING {
+		if name == extensionName {
+			return Extension(i), true
+		}
+	}
+
+	return EXTENSION_NONE, false
+}
+
+// ExtensionNames lists the extensions in order.
+func ExtensionNames() []string {
+	return append([]string(nil), __EXTENSION_STRING[1:]...)
+}
+
+// ParseCommands records the line and column of each command, as the
+// ParseCommands function does, with the commands of the extension.
+func (extension Extension) ParseCommands(source []byte) ([]Command, error) {
+	symbols := []symbol{}
+	pos := asm.SourcePos{Line: 1, Column: 1}
+
+	for _, chr := range source {
+		symbols = append(symbols, symbol{chr: chr, pos: pos})
+		pos = advance(pos, chr)
+	}
+
+	return parseSymbols(symbols, extension)
+}
+
+func (extension Extension) isCommand(chr byte) bool {
+	return strings.IndexByte("<>+-.,[]", chr) >= 0 || strings.IndexByte(__EXTENSION_SYMBOLS[extension], chr) >= 0
+}
+
+// define registers the procedure when control reaches it, and the compiler
+// skips over its body.
+func (lower *lowerer) define(cmd Command) {
+	builder := lower.builder
+	builder.Append(
+		pushImmediate(cmd.Value),
+		push(__VALUE_REGISTER),
+		call(asm.PBRAIN_DEFINE),
+	)
+	builder.OpenProcedure(procedureName(cmd.Value), __STACK_INDEX)
+	lower.block(cmd.Nest)
+	builder.LeaveBlock()
+}
+
+// Subroutines are called at fixed addresses, so invoke compares the number of
+// the procedure with that of each procedure in the program.
+func (lower *lowerer) invoke() {
+	builder := lower.builder
+	done := lower.newLabel()
+
+	builder.Append(
+		push(__VALUE_REGISTER),
+		call(asm.PBRAIN_LOOKUP),
+		pop(__PROCEDURE_REGISTER),
+	)
+
+	for i := 0; i < lower.procedureCount; i++ {
+		next := lower.newLabel()
+		compare := &asm.JumpStmt{Label: next}
+		compare.Operand = [2]int{__SCRATCH_REGISTER, 0}
+		callSub := &asm.CallSubStmt{Label: procedureName(i)}
+		callSub.Operand = [2]int{__STACK_INDEX, 0}
+
+		builder.Append(
+			copyRegister(__SCRATCH_REGISTER, __PROCEDURE_REGISTER),
+			addImmediate(__SCRATCH_REGISTER, -i),
+			compare,
+			callSub,
+			&asm.JumpAlwaysStmt{Label: done},
+			&asm.LabelStmt{Name: next},
+		)
+	}
+
+	builder.Append(&asm.LabelStmt{Name: done})
+}
+
+// The new thread resumes at the same place as the current, with the head
+// on the first cell, so it returns to its own cell before moving right.
+func (lower *lowerer) fork() {
+	builder := lower.builder
+	parent := lower.newLabel()
+	done := lower.newLabel()
+	isParent := &asm.JumpZeroStmt{Label: parent}
+	isParent.Operand = [2]int{__SCRATCH_REGISTER, 0}
+
+	builder.Append(
+		call(asm.THREAD_FORK),
+		pop(__SCRATCH_REGISTER),
+		isParent,
+	)
+	builder.Append(resume()...)
+	builder.Append(lowerMove(1)...)
+	builder.Append(addImmediate(__HEAD_REGISTER, 1), set(__VALUE_REGISTER, 1))
+	builder.Append(lower.options.lowerStore()...)
+	builder.Append(
+		&asm.JumpAlwaysStmt{Label: done},
+		&asm.LabelStmt{Name: parent},
+		set(__VALUE_REGISTER, 0),
+	)
+	builder.Append(lower.options.lowerStore()...)
+	builder.Append(&asm.LabelStmt{Name: done})
+}
+
+// Threads share the head, so a thread leaves it on the first cell when it
+// yields, and returns to its own cell, which other threads may have changed,
+// when it resumes.  Threads yield after each command that keeps the value
+// register and the cell in agreement.
+func (lower *lowerer) yield() {
+	if !lower.threaded {
+		return
+	}
+
+	lower.builder.Append(rewind()...)
+	lower.builder.Append(call(asm.THREAD_YIELD))
+	lower.builder.Append(resume()...)
+}
+
+func (lower *lowerer) epilogue(halts bool) {
+	builder := lower.builder
+	builder.Pos = asm.SourcePos{}
+
+	if halts {
+		builder.Append(&asm.LabelStmt{Name: __END_LABEL})
+	}
+
+	if lower.threaded {
+		builder.Append(rewind()...)
+		builder.Append(call(asm.THREAD_EXIT))
+	}
+}
+
+func rewind() []asm.Statement {
+	back := &asm.SubStmt{}
+	back.Operand = [2]int{__SCRATCH_REGISTER, __HEAD_REGISTER}
+
+	return []asm.Statement{
+		set(__SCRATCH_REGISTER, 0),
+		back,
+		push(__SCRATCH_REGISTER),
+		push(__TAPE_INDEX_REGISTER),
+		call(asm.TAPE_MOVE_HEAD),
+	}
+}
+
+func resume() []asm.Statement {
+	return []asm.Statement{
+		push(__HEAD_REGISTER),
+		push(__TAPE_INDEX_REGISTER),
+		call(asm.TAPE_MOVE_HEAD),
+		push(__TAPE_INDEX_REGISTER),
+		call(asm.TAPE_READ_HEAD),
+		pop(__VALUE_REGISTER),
+	}
+}
+
+func procedureName(number int) string {
+	return fmt.Sprintf("pbrain_%d", number)
+}
+
+func countProcedures(commands []Command) int {
+	count := 0
+
+	for _, cmd := range commands {
+		if cmd.Kind == DEFINE {
+			count++
+		}
+
+		count += countProcedures(cmd.Nest)
+	}
+
+	return count
+}
+
+func containsCommand(commands []Command, kind CommandKind) bool {
+	for _, cmd := range commands {
+		if cmd.Kind == kind || containsCommand(cmd.Nest, kind) {
+			return true
+		}
+	}
+
+	return false
+}
+
+const __END_LABEL = "brainfuck_end"
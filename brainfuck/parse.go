@@ -1,111 +1,225 @@
 package brainfuck
 
 import (
-	"errors"
 	"fmt"
 
 	"github.com/johnny-morrice/shapes/asm"
 )
 
+// Parse compiles brainfuck for a tape with the DefaultOptions.
 func Parse(source []byte) (*asm.AST, error) {
-	loopDepth := 0
-	builder := &asm.ASTBuilder{}
-
-	pushValue := &asm.PushStmt{}
-	pushValue.Operand[0] = __STACK_INDEX
-	pushValue.Operand[1] = __VALUE_REGISTER
-	popValue := &asm.PopStmt{}
-	popValue.Operand[0] = __STACK_INDEX
-	popValue.Operand[1] = __VALUE_REGISTER
-	increment := &asm.AddStmt{}
-	increment.Operand[0] = __VALUE_REGISTER
-	increment.Operand[1] = __INCREMENT_REGISTER
-	decrement := &asm.SubStmt{}
-	decrement.Operand[0] = __VALUE_REGISTER
-	decrement.Operand[1] = __INCREMENT_REGISTER
-
-	newTape := &asm.CallStmt{
-		VmFunc: asm.TAPE_NEW,
-	}
-	newTape.Operand = __STACK_INDEX
-	moveTape := &asm.CallStmt{
-		VmFunc: asm.TAPE_MOVE_HEAD,
-	}
-	moveTape.Operand = __STACK_INDEX
-	writeTape := &asm.CallStmt{
-		VmFunc: asm.TAPE_WRITE_HEAD,
-	}
-	writeTape.Operand = __STACK_INDEX
-	readTape := &asm.CallStmt{
-		VmFunc: asm.TAPE_READ_HEAD,
-	}
-	readTape.Operand = __STACK_INDEX
-
-	pushTapeLeft := &asm.PushStmt{}
-	pushTapeLeft.Operand[0] = __STACK_INDEX
-	pushTapeLeft.Operand[1] = __TAPE_LEFT_REGISTER
-	pushTapeRight := &asm.PushStmt{}
-	pushTapeRight.Operand[0] = __STACK_INDEX
-	pushTapeRight.Operand[1] = __TAPE_RIGHT_REGISTER
-	pushTapeIndex := &asm.PushStmt{}
-	pushTapeIndex.Operand[0] = __STACK_INDEX
-	pushTapeIndex.Operand[1] = __TAPE_INDEX_REGISTER
-	popTapeIndex := &asm.PopStmt{}
-	popTapeIndex.Operand[0] = __STACK_INDEX
-	popTapeIndex.Operand[1] = __TAPE_INDEX_REGISTER
-
-	output := &asm.WriteStmt{}
-	output.Operand = __VALUE_REGISTER
-	input := &asm.ReadStmt{}
-	input.Operand = __VALUE_REGISTER
-
-	setTapeLeft := &asm.SetStmt{}
-	setTapeLeft.Operand[0] = __TAPE_LEFT_REGISTER
-	setTapeLeft.Operand[1] = -1
-	setTapeRight := &asm.SetStmt{}
-	setTapeRight.Operand[0] = __TAPE_RIGHT_REGISTER
-	setTapeRight.Operand[1] = 1
-	setIncrement := &asm.SetStmt{}
-	setIncrement.Operand[0] = __INCREMENT_REGISTER
-	setIncrement.Operand[1] = 1
-	resetValue := &asm.SetStmt{}
-	resetValue.Operand[0] = __VALUE_REGISTER
-	resetValue.Operand[1] = 0
-
-	builder.Append(setTapeLeft, setTapeRight, setIncrement)
-	builder.Append(newTape, popTapeIndex)
-
-	for _, chr := range source {
-		switch chr {
+	return ParseOptions(source, DefaultOptions())
+}
+
+func ParseOptions(source []byte, options Options) (*asm.AST, error) {
+	err := options.Validate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	commands, err := options.Extension.ParseCommands(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Lower(commands, options), nil
+}
+
+// Options choose the tape that a brainfuck program runs on, and the extension
+// that adds to its commands.
+type Options struct {
+	CellBits int
+	// Overflow is one of the asm.TAPE_OVERFLOW policies.
+	Overflow  int
+	Extension Extension
+}
+
+// DefaultOptions gives the usual brainfuck tape of wrapping 8-bit cells.
+func DefaultOptions() Options {
+	return Options{
+		CellBits: 8,
+		Overflow: asm.TAPE_OVERFLOW_WRAP,
+	}
+}
+
+func (options Options) Validate() error {
+	switch options.CellBits {
+	case 8, 16, 32, 64:
+	default:
+		return fmt.Errorf("Unsupported cell width %d bits", options.CellBits)
+	}
+
+	switch options.Overflow {
+	case asm.TAPE_OVERFLOW_WRAP, asm.TAPE_OVERFLOW_SATURATE, asm.TAPE_OVERFLOW_ERROR:
+	default:
+		return fmt.Errorf("Unknown overflow policy %d", options.Overflow)
+	}
+
+	if options.CellBits == 64 && options.Overflow != asm.TAPE_OVERFLOW_WRAP {
+		return fmt.Errorf("%d-bit cells must use the wrap overflow policy", options.CellBits)
+	}
+
+	if int(options.Extension) >= len(__EXTENSION_STRING) {
+		return fmt.Errorf("Unknown extension %d", options.Extension)
+	}
+
+	return nil
+}
+
+// ParseCommands records the line and column of each command, so that its
+// statements can be traced back to the source.
+func ParseCommands(source []byte) ([]Command, error) {
+	return EXTENSION_NONE.ParseCommands(source)
+}
+
+// symbol is a character of brainfuck, or of a token that spells it, at its
+// position in the source.
+type symbol struct {
+	chr byte
+	pos asm.SourcePos
+}
+
+func advance(pos asm.SourcePos, chr byte) asm.SourcePos {
+	if chr == '\n' {
+		pos.Line++
+		pos.Column = 1
+	} else {
+		pos.Column++
+	}
+
+	return pos
+}
+
+// parseSymbols ignores symbols that are not commands of the extension.
+func parseSymbols(symbols []symbol, extension Extension) ([]Command, error) {
+	stack := [][]Command{[]Command{}}
+	// The LOOP or DEFINE opened by each bracket, without its Nest.
+	openStack := []Command{}
+	procedureCount := 0
+	pos := asm.SourcePos{}
+
+	appendCommands := func(commands ...Command) {
+		tip := len(stack) - 1
+
+		for _, cmd := range commands {
+			cmd.Pos = pos
+			stack[tip] = append(stack[tip], cmd)
+		}
+	}
+
+	add := func(value int) {
+		appendCommands(Command{Kind: ADD, Value: value}, Command{Kind: STORE})
+	}
+
+	change := func(kind CommandKind) {
+		appendCommands(Command{Kind: kind}, Command{Kind: STORE})
+	}
+
+	open := func(cmd Command) {
+		cmd.Pos = pos
+		stack = append(stack, []Command{})
+		openStack = append(openStack, cmd)
+	}
+
+	close := func(kind CommandKind) error {
+		openTip := len(openStack) - 1
+
+		if openTip < 0 || openStack[openTip].Kind != kind {
+			return fmt.Errorf("Closed non-existent %s at %v", blockName(kind), pos)
+		}
+
+		tip := len(stack) - 1
+		cmd := openStack[openTip]
+		cmd.Nest = stack[tip]
+		stack = stack[:tip]
+		openStack = openStack[:openTip]
+		stack[tip-1] = append(stack[tip-1], cmd)
+
+		return nil
+	}
+
+Symbols:
+	for _, sym := range symbols {
+		pos = sym.pos
+
+		if !extension.isCommand(sym.chr) {
+			continue
+		}
+
+		var err error
+
+		switch sym.chr {
 		case '<':
-			builder.Append(pushTapeLeft, pushTapeIndex, moveTape, pushTapeIndex, readTape, popValue)
+			appendCommands(Command{Kind: MOVE, Value: -1})
 		case '>':
-			builder.Append(pushTapeRight, pushTapeIndex, moveTape, pushTapeIndex, readTape, popValue)
+			appendCommands(Command{Kind: MOVE, Value: 1})
 		case '+':
-			builder.Append(increment, pushValue, pushTapeIndex, writeTape)
+			add(1)
 		case '-':
-			builder.Append(decrement, pushValue, pushTapeIndex, writeTape)
+			add(-1)
 		case '.':
-			builder.Append(output)
+			appendCommands(Command{Kind: OUTPUT})
 		case ',':
-			builder.Append(input, pushValue, pushTapeIndex, writeTape)
+			appendCommands(Command{Kind: INPUT}, Command{Kind: STORE})
 		case '[':
-			builder.OpenLoop(__VALUE_REGISTER)
-			loopDepth++
+			open(Command{Kind: LOOP})
 		case ']':
-			err := builder.LeaveBlock()
-			if err != nil {
-				return nil, errors.New("Closed non-existent loop")
+			err = close(LOOP)
+		case '(':
+			open(Command{Kind: DEFINE, Value: procedureCount})
+			procedureCount++
+		case ')':
+			err = close(DEFINE)
+		case ':':
+			appendCommands(Command{Kind: INVOKE})
+		case 'Y':
+			appendCommands(Command{Kind: FORK})
+		case '@':
+			appendCommands(Command{Kind: HALT})
+
+			// Whatever follows the end of the program is data.
+			if len(openStack) == 0 {
+				break Symbols
 			}
-			loopDepth--
+		case '$':
+			appendCommands(Command{Kind: SAVE})
+		case '!':
+			change(RESTORE)
+		case '}':
+			change(SHIFT_RIGHT)
+		case '{':
+			change(SHIFT_LEFT)
+		case '~':
+			change(NOT)
+		case '^':
+			change(XOR)
+		case '&':
+			change(AND)
+		case '|':
+			change(OR)
+		}
+
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	if loopDepth != 0 {
-		return nil, fmt.Errorf("Unexpected loop nesting depth %d", loopDepth)
+	if len(openStack) != 0 {
+		unclosed := openStack[len(openStack)-1]
+		return nil, fmt.Errorf("Unclosed %s at %v", blockName(unclosed.Kind), unclosed.Pos)
+	}
+
+	return stack[0], nil
+}
+
+func blockName(kind CommandKind) string {
+	if kind == DEFINE {
+		return "procedure"
 	}
 
-	return builder.AST, nil
+	return "loop"
 }
 
 const __STACK_INDEX = 0
@@ -113,7 +227,11 @@ const __STACK_INDEX = 0
 const (
 	__VALUE_REGISTER = iota
 	__TAPE_INDEX_REGISTER
-	__INCREMENT_REGISTER
-	__TAPE_LEFT_REGISTER
-	__TAPE_RIGHT_REGISTER
+	__MULTIPLICAND_REGISTER
+	// The storage of Extended Brainfuck Type I.
+	__STORAGE_REGISTER
+	// The offset of the head from the first cell, kept by threads.
+	__HEAD_REGISTER
+	__PROCEDURE_REGISTER
+	__SCRATCH_REGISTER
 )
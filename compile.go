@@ -1,6 +1,8 @@
 package shapes
 
 import (
+	"fmt"
+
 	"github.com/johnny-morrice/shapes/asm"
 )
 
@@ -8,35 +10,96 @@ type loopStack struct {
 	startAddress []int
 }
 
+func (stack *loopStack) push(address int) {
+	stack.startAddress = append(stack.startAddress, address)
+}
+
+func (stack *loopStack) pop() int {
+	tipIndex := len(stack.startAddress) - 1
+	address := stack.startAddress[tipIndex]
+	stack.startAddress = stack.startAddress[:tipIndex]
+	return address
+}
+
+type labelFixup struct {
+	address int
+	label   string
+}
+
 type CompileVisitor struct {
 	Process *Process
 	Error   error
 	Library *Library
 
-	loopStack loopStack
+	loopStack      loopStack
+	procedureStack loopStack
+	labels         map[string]int
+	fixups         []labelFixup
+	// Procedure name to parameter stack.
+	procedures map[string]int
+	// Position of the statement being compiled.
+	position asm.SourcePos
 }
 
 func (c *CompileVisitor) VisitAST(ast *asm.AST) {
 	c.Process = &Process{}
+	c.labels = map[string]int{}
+	c.procedures = map[string]int{}
 }
 
+// Jumps may refer to labels defined later in the program, so they are
+// resolved once the whole AST has been visited.  The position table is
+// dropped when no position is known.
 func (c *CompileVisitor) LeaveAST(ast *asm.AST) {
+	if !hasKnownPosition(c.Process.Positions) {
+		c.Process.Positions = nil
+	}
+
+	for _, fixup := range c.fixups {
+		address, ok := c.labels[fixup.label]
+
+		if !ok {
+			c.fail(fmt.Errorf("Undefined label '%s'", fixup.label))
+			continue
+		}
 
+		op := &c.Process.ByteCode[fixup.address]
+		stack, isProcedure := c.procedures[fixup.label]
+
+		if op.OpCode == OP_CALLSUB && isProcedure && op.Operand[0] != makeOperand(stack) {
+			c.fail(fmt.Errorf("Procedure '%s' takes parameters on stack %d but was called with stack %d", fixup.label, stack, op.Operand[0]))
+			continue
+		}
+
+		op.Operand[1] = makeOperand(address)
+	}
 }
 
+// The loop is entered with a JZ whose target is unknown until LeaveLoop, so
+// we record its address and patch it once the end of the loop is emitted.
 func (c *CompileVisitor) VisitLoop(l *asm.LoopStmt) {
-	startAddress := len(c.Process.ByteCode)
-	c.loopStack.startAddress = append(c.loopStack.startAddress, startAddress)
+	entryAddress := len(c.Process.ByteCode)
+	c.loopStack.push(entryAddress)
+	c.appendByteCode(Operation{
+		OpCode:  OP_JZ,
+		Operand: [2]Operand{makeOperand(l.Operand), 0},
+	})
 }
 
 func (c *CompileVisitor) LeaveLoop(l *asm.LoopStmt) {
-	tipIndex := len(c.loopStack.startAddress) - 1
-	startAddress := c.loopStack.startAddress[tipIndex]
-	c.loopStack.startAddress = c.loopStack.startAddress[:tipIndex]
+	entryAddress := c.loopStack.pop()
+	bodyAddress := entryAddress + 1
 	c.appendByteCode(Operation{
 		OpCode:  OP_JMPNZ,
-		Operand: [2]Operand{Operand(l.Operand), Operand(startAddress)},
+		Operand: [2]Operand{makeOperand(l.Operand), makeOperand(bodyAddress)},
 	})
+
+	exitAddress := len(c.Process.ByteCode)
+	c.Process.ByteCode[entryAddress].Operand[1] = makeOperand(exitAddress)
+}
+
+func (c *CompileVisitor) VisitPosition(pos asm.SourcePos) {
+	c.position = pos
 }
 
 func (c *CompileVisitor) VisitAdd(stmt *asm.AddStmt) {
@@ -71,15 +134,166 @@ func (c *CompileVisitor) VisitSub(stmt *asm.SubStmt) {
 	c.appendByteCode(twoOp(OP_SUB, stmt.TwoOperandStmt))
 }
 
+func (c *CompileVisitor) VisitAddImmediate(stmt *asm.AddImmediateStmt) {
+	c.appendByteCode(twoOp(OP_ADDI, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitSubImmediate(stmt *asm.SubImmediateStmt) {
+	c.appendByteCode(twoOp(OP_SUBI, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitPushImmediate(stmt *asm.PushImmediateStmt) {
+	c.appendByteCode(twoOp(OP_PUSHI, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitMul(stmt *asm.MulStmt) {
+	c.appendByteCode(twoOp(OP_MUL, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitDiv(stmt *asm.DivStmt) {
+	c.appendByteCode(twoOp(OP_DIV, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitMod(stmt *asm.ModStmt) {
+	c.appendByteCode(twoOp(OP_MOD, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitSignedDiv(stmt *asm.SignedDivStmt) {
+	c.appendByteCode(twoOp(OP_SDIV, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitSignedMod(stmt *asm.SignedModStmt) {
+	c.appendByteCode(twoOp(OP_SMOD, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitAnd(stmt *asm.AndStmt) {
+	c.appendByteCode(twoOp(OP_AND, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitOr(stmt *asm.OrStmt) {
+	c.appendByteCode(twoOp(OP_OR, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitXor(stmt *asm.XorStmt) {
+	c.appendByteCode(twoOp(OP_XOR, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitNot(stmt *asm.NotStmt) {
+	c.appendByteCode(oneOp(OP_NOT, stmt.OneOperandStmt))
+}
+
+func (c *CompileVisitor) VisitShiftLeft(stmt *asm.ShiftLeftStmt) {
+	c.appendByteCode(twoOp(OP_SHL, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitShiftRight(stmt *asm.ShiftRightStmt) {
+	c.appendByteCode(twoOp(OP_SHR, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitSignedShiftRight(stmt *asm.SignedShiftRightStmt) {
+	c.appendByteCode(twoOp(OP_SAR, stmt.TwoOperandStmt))
+}
+
 func (c *CompileVisitor) VisitJump(stmt *asm.JumpStmt) {
-	c.appendByteCode(twoOp(OP_JMPNZ, stmt.TwoOperandStmt))
+	c.appendJump(twoOp(OP_JMPNZ, stmt.TwoOperandStmt), stmt.Label)
+}
+
+func (c *CompileVisitor) VisitJumpZero(stmt *asm.JumpZeroStmt) {
+	c.appendJump(twoOp(OP_JZ, stmt.TwoOperandStmt), stmt.Label)
+}
+
+func (c *CompileVisitor) VisitJumpAlways(stmt *asm.JumpAlwaysStmt) {
+	jump := Operation{
+		OpCode:  OP_JMP,
+		Operand: [2]Operand{0, makeOperand(stmt.Operand)},
+	}
+	c.appendJump(jump, stmt.Label)
+}
+
+// appendJump records a jump whose target, in Operand[1], is given by label
+// rather than address.
+func (c *CompileVisitor) appendJump(jump Operation, label string) {
+	if label != "" {
+		c.fixups = append(c.fixups, labelFixup{
+			address: len(c.Process.ByteCode),
+			label:   label,
+		})
+	}
+
+	c.appendByteCode(jump)
+}
+
+func (c *CompileVisitor) VisitEqual(stmt *asm.EqualStmt) {
+	c.appendByteCode(twoOp(OP_EQ, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitLess(stmt *asm.LessStmt) {
+	c.appendByteCode(twoOp(OP_LT, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitGreater(stmt *asm.GreaterStmt) {
+	c.appendByteCode(twoOp(OP_GT, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitSignedLess(stmt *asm.SignedLessStmt) {
+	c.appendByteCode(twoOp(OP_SLT, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitSignedGreater(stmt *asm.SignedGreaterStmt) {
+	c.appendByteCode(twoOp(OP_SGT, stmt.TwoOperandStmt))
+}
+
+func (c *CompileVisitor) VisitLabel(stmt *asm.LabelStmt) {
+	c.defineLabel(stmt.Name)
+}
+
+func (c *CompileVisitor) defineLabel(name string) {
+	if _, ok := c.labels[name]; ok {
+		c.fail(fmt.Errorf("Duplicate label '%s'", name))
+		return
+	}
+
+	c.labels[name] = len(c.Process.ByteCode)
+}
+
+// A procedure is compiled in place, behind a JMP that skips it.  Like a loop,
+// the JMP is patched once the end of the procedure is emitted.
+func (c *CompileVisitor) VisitProcedure(stmt *asm.ProcedureStmt) {
+	skipAddress := len(c.Process.ByteCode)
+	c.procedureStack.push(skipAddress)
+	c.appendByteCode(Operation{OpCode: OP_JMP})
+
+	c.defineLabel(stmt.Name)
+	c.procedures[stmt.Name] = stmt.Operand
+
+	if c.Process.Procedures == nil {
+		c.Process.Procedures = map[Address]string{}
+	}
+
+	c.Process.Procedures[Address(len(c.Process.ByteCode))] = stmt.Name
+}
+
+func (c *CompileVisitor) LeaveProcedure(stmt *asm.ProcedureStmt) {
+	skipAddress := c.procedureStack.pop()
+	c.appendByteCode(Operation{OpCode: OP_RET})
+
+	exitAddress := len(c.Process.ByteCode)
+	c.Process.ByteCode[skipAddress].Operand[1] = makeOperand(exitAddress)
+}
+
+func (c *CompileVisitor) VisitCallSub(stmt *asm.CallSubStmt) {
+	c.appendJump(twoOp(OP_CALLSUB, stmt.TwoOperandStmt), stmt.Label)
+}
+
+func (c *CompileVisitor) VisitReturn(stmt *asm.ReturnStmt) {
+	c.appendByteCode(Operation{OpCode: OP_RET})
 }
 
 func (c *CompileVisitor) VisitCall(stmt *asm.CallStmt) {
 	index, err := c.Library.GetFunctionIndex(stmt.VmFunc)
 
 	if err != nil {
-		c.Error = err
+		c.fail(err)
 		return
 	}
 
@@ -89,8 +303,28 @@ func (c *CompileVisitor) VisitCall(stmt *asm.CallStmt) {
 	c.appendByteCode(twoOp(OP_CALL, twoOpStmt))
 }
 
+func (c *CompileVisitor) fail(err error) {
+	if c.Error == nil {
+		c.Error = err
+	}
+}
+
 func (c *CompileVisitor) appendByteCode(operations ...Operation) {
 	c.Process.ByteCode = append(c.Process.ByteCode, operations...)
+
+	for range operations {
+		c.Process.Positions = append(c.Process.Positions, c.position)
+	}
+}
+
+func hasKnownPosition(positions []asm.SourcePos) bool {
+	for _, pos := range positions {
+		if pos.IsKnown() {
+			return true
+		}
+	}
+
+	return false
 }
 
 func twoOp(opCode OpCode, stmt asm.TwoOperandStmt) Operation {
@@ -17,8 +17,14 @@ func TestCompile(t *testing.T) {
 		writeCompilation(),
 		setRegisterCompilation(),
 		loopCompilation(),
+		nestedLoopCompilation(),
 		severalStatementsCompilation(),
 		callVmFuncCompilation(),
+		labelCompilation(),
+		immediateCompilation(),
+		aluCompilation(),
+		conditionalCompilation(),
+		procedureCompilation(),
 	}
 
 	for i, test := range successCases {
@@ -31,6 +37,12 @@ func TestCompile(t *testing.T) {
 
 	failureCases := []*asm.AST{
 		unknownFunctionFailure(),
+		undefinedLabelFailure(),
+		duplicateLabelFailure(),
+		undefinedJumpZeroLabelFailure(),
+		undefinedJumpAlwaysLabelFailure(),
+		procedureStackFailure(),
+		procedureLabelClashFailure(),
 	}
 
 	for i, test := range failureCases {
@@ -232,6 +244,10 @@ func loopCompilation() compilation {
 			OpCode:  OP_SET,
 			Operand: [2]Operand{8, 10},
 		},
+		Operation{
+			OpCode:  OP_JZ,
+			Operand: [2]Operand{8, 5},
+		},
 		Operation{
 			OpCode:  OP_WRITE,
 			Operand: [2]Operand{8, 0},
@@ -242,7 +258,53 @@ func loopCompilation() compilation {
 		},
 		Operation{
 			OpCode:  OP_JMPNZ,
-			Operand: [2]Operand{8, 1},
+			Operand: [2]Operand{8, 2},
+		},
+	}
+
+	return makeCompilation(statements, expected)
+}
+
+func nestedLoopCompilation() compilation {
+	writeStmt := &asm.WriteStmt{}
+	writeStmt.Operand = 3
+
+	innerLoop := &asm.LoopStmt{}
+	innerLoop.Operand = 4
+	innerLoop.Nest = []asm.Statement{
+		writeStmt,
+	}
+
+	outerLoop := &asm.LoopStmt{}
+	outerLoop.Operand = 5
+	outerLoop.Nest = []asm.Statement{
+		innerLoop,
+	}
+
+	statements := []asm.Statement{
+		outerLoop,
+	}
+
+	expected := []Operation{
+		Operation{
+			OpCode:  OP_JZ,
+			Operand: [2]Operand{5, 5},
+		},
+		Operation{
+			OpCode:  OP_JZ,
+			Operand: [2]Operand{4, 4},
+		},
+		Operation{
+			OpCode:  OP_WRITE,
+			Operand: [2]Operand{3, 0},
+		},
+		Operation{
+			OpCode:  OP_JMPNZ,
+			Operand: [2]Operand{4, 2},
+		},
+		Operation{
+			OpCode:  OP_JMPNZ,
+			Operand: [2]Operand{5, 1},
 		},
 	}
 
@@ -284,6 +346,234 @@ func severalStatementsCompilation() compilation {
 	return makeCompilation(statements, expected)
 }
 
+func labelCompilation() compilation {
+	backJump := &asm.JumpStmt{Label: "start"}
+	backJump.Operand[0] = 3
+	forwardJump := &asm.JumpStmt{Label: "end"}
+	forwardJump.Operand[0] = 4
+
+	writeStmt := &asm.WriteStmt{}
+	writeStmt.Operand = 3
+
+	statements := []asm.Statement{
+		forwardJump,
+		&asm.LabelStmt{Name: "start"},
+		writeStmt,
+		backJump,
+		&asm.LabelStmt{Name: "end"},
+	}
+
+	expected := []Operation{
+		Operation{
+			OpCode:  OP_JMPNZ,
+			Operand: [2]Operand{4, 3},
+		},
+		Operation{
+			OpCode:  OP_WRITE,
+			Operand: [2]Operand{3, 0},
+		},
+		Operation{
+			OpCode:  OP_JMPNZ,
+			Operand: [2]Operand{3, 1},
+		},
+	}
+
+	return makeCompilation(statements, expected)
+}
+
+func immediateCompilation() compilation {
+	addStmt := &asm.AddImmediateStmt{}
+	addStmt.Operand[0] = 12
+	addStmt.Operand[1] = 40
+
+	subStmt := &asm.SubImmediateStmt{}
+	subStmt.Operand[0] = 13
+	subStmt.Operand[1] = 50
+
+	pushStmt := &asm.PushImmediateStmt{}
+	pushStmt.Operand[0] = 14
+	pushStmt.Operand[1] = -1
+
+	statements := []asm.Statement{
+		addStmt,
+		subStmt,
+		pushStmt,
+	}
+
+	expected := []Operation{
+		Operation{
+			OpCode:  OP_ADDI,
+			Operand: [2]Operand{12, 40},
+		},
+		Operation{
+			OpCode:  OP_SUBI,
+			Operand: [2]Operand{13, 50},
+		},
+		Operation{
+			OpCode:  OP_PUSHI,
+			Operand: [2]Operand{14, makeOperand(-1)},
+		},
+	}
+
+	return makeCompilation(statements, expected)
+}
+
+func aluCompilation() compilation {
+	mulStmt := &asm.MulStmt{}
+	mulStmt.Operand = [2]int{1, 2}
+	sdivStmt := &asm.SignedDivStmt{}
+	sdivStmt.Operand = [2]int{3, 4}
+	xorStmt := &asm.XorStmt{}
+	xorStmt.Operand = [2]int{5, 6}
+	notStmt := &asm.NotStmt{}
+	notStmt.Operand = 7
+	sarStmt := &asm.SignedShiftRightStmt{}
+	sarStmt.Operand = [2]int{8, 9}
+
+	statements := []asm.Statement{
+		mulStmt,
+		sdivStmt,
+		xorStmt,
+		notStmt,
+		sarStmt,
+	}
+
+	expected := []Operation{
+		Operation{OpCode: OP_MUL, Operand: [2]Operand{1, 2}},
+		Operation{OpCode: OP_SDIV, Operand: [2]Operand{3, 4}},
+		Operation{OpCode: OP_XOR, Operand: [2]Operand{5, 6}},
+		Operation{OpCode: OP_NOT, Operand: [2]Operand{7, 0}},
+		Operation{OpCode: OP_SAR, Operand: [2]Operand{8, 9}},
+	}
+
+	return makeCompilation(statements, expected)
+}
+
+func conditionalCompilation() compilation {
+	lessStmt := &asm.LessStmt{}
+	lessStmt.Operand = [2]int{1, 2}
+	skipJump := &asm.JumpZeroStmt{Label: "skip"}
+	skipJump.Operand[0] = 1
+	writeStmt := &asm.WriteStmt{}
+	writeStmt.Operand = 2
+	loopJump := &asm.JumpAlwaysStmt{Label: "top"}
+
+	statements := []asm.Statement{
+		&asm.LabelStmt{Name: "top"},
+		lessStmt,
+		skipJump,
+		writeStmt,
+		loopJump,
+		&asm.LabelStmt{Name: "skip"},
+	}
+
+	expected := []Operation{
+		Operation{OpCode: OP_LT, Operand: [2]Operand{1, 2}},
+		Operation{OpCode: OP_JZ, Operand: [2]Operand{1, 4}},
+		Operation{OpCode: OP_WRITE, Operand: [2]Operand{2, 0}},
+		Operation{OpCode: OP_JMP, Operand: [2]Operand{0, 0}},
+	}
+
+	return makeCompilation(statements, expected)
+}
+
+func procedureCompilation() compilation {
+	popStmt := &asm.PopStmt{}
+	popStmt.Operand = [2]int{1, 0}
+	skipJump := &asm.JumpZeroStmt{Label: "done"}
+	skipJump.Operand[0] = 0
+	writeStmt := &asm.WriteStmt{}
+	writeStmt.Operand = 0
+	proc := &asm.ProcedureStmt{
+		Name: "show",
+		Nest: []asm.Statement{
+			popStmt,
+			skipJump,
+			writeStmt,
+			&asm.ReturnStmt{},
+			&asm.LabelStmt{Name: "done"},
+		},
+	}
+	proc.Operand = 1
+	pushStmt := &asm.PushImmediateStmt{}
+	pushStmt.Operand = [2]int{1, 65}
+	callStmt := &asm.CallSubStmt{Label: "show"}
+	callStmt.Operand[0] = 1
+
+	statements := []asm.Statement{
+		proc,
+		pushStmt,
+		callStmt,
+	}
+
+	expected := []Operation{
+		Operation{OpCode: OP_JMP, Operand: [2]Operand{0, 6}},
+		Operation{OpCode: OP_POP, Operand: [2]Operand{1, 0}},
+		Operation{OpCode: OP_JZ, Operand: [2]Operand{0, 5}},
+		Operation{OpCode: OP_WRITE, Operand: [2]Operand{0, 0}},
+		Operation{OpCode: OP_RET, Operand: [2]Operand{0, 0}},
+		Operation{OpCode: OP_RET, Operand: [2]Operand{0, 0}},
+		Operation{OpCode: OP_PUSHI, Operand: [2]Operand{1, 65}},
+		Operation{OpCode: OP_CALLSUB, Operand: [2]Operand{1, 1}},
+	}
+
+	return makeCompilation(statements, expected)
+}
+
+func procedureStackFailure() *asm.AST {
+	proc := &asm.ProcedureStmt{Name: "show"}
+	proc.Operand = 1
+	callStmt := &asm.CallSubStmt{Label: "show"}
+	callStmt.Operand[0] = 2
+
+	ast := &asm.AST{}
+	ast.Statements = []asm.Statement{proc, callStmt}
+	return ast
+}
+
+func procedureLabelClashFailure() *asm.AST {
+	ast := &asm.AST{}
+	ast.Statements = []asm.Statement{
+		&asm.LabelStmt{Name: "show"},
+		&asm.ProcedureStmt{Name: "show"},
+	}
+	return ast
+}
+
+func undefinedJumpZeroLabelFailure() *asm.AST {
+	ast := &asm.AST{}
+	ast.Statements = []asm.Statement{
+		&asm.JumpZeroStmt{Label: "nowhere"},
+	}
+	return ast
+}
+
+func undefinedJumpAlwaysLabelFailure() *asm.AST {
+	ast := &asm.AST{}
+	ast.Statements = []asm.Statement{
+		&asm.LabelStmt{Name: "somewhere"},
+		&asm.JumpAlwaysStmt{Label: "nowhere"},
+	}
+	return ast
+}
+
+func undefinedLabelFailure() *asm.AST {
+	ast := &asm.AST{}
+	ast.Statements = []asm.Statement{
+		&asm.JumpStmt{Label: "nowhere"},
+	}
+	return ast
+}
+
+func duplicateLabelFailure() *asm.AST {
+	ast := &asm.AST{}
+	ast.Statements = []asm.Statement{
+		&asm.LabelStmt{Name: "twice"},
+		&asm.LabelStmt{Name: "twice"},
+	}
+	return ast
+}
+
 func unknownFunctionFailure() *asm.AST {
 	ast := &asm.AST{}
 	ast.Statements = []asm.Statement{
@@ -294,6 +584,51 @@ func unknownFunctionFailure() *asm.AST {
 	return ast
 }
 
+func TestCompile_Positions(t *testing.T) {
+	outer := asm.SourcePos{File: "prog.bf", Line: 1, Column: 1}
+	inner := asm.SourcePos{File: "prog.bf", Line: 2, Column: 3}
+
+	builder := &asm.ASTBuilder{}
+	builder.Append(&asm.SetStmt{})
+	builder.Pos = outer
+	builder.OpenLoop(0)
+	builder.Pos = inner
+	builder.Append(&asm.WriteStmt{})
+	builder.LeaveBlock()
+
+	process, err := Compile(builder.AST, LibTest())
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	expected := []asm.SourcePos{asm.SourcePos{}, outer, inner, outer}
+
+	if len(process.Positions) != len(expected) {
+		t.Fatalf("Expected %d positions but received %v", len(expected), process.Positions)
+	}
+
+	for i, pos := range expected {
+		if process.Position(Address(i)) != pos {
+			t.Errorf("Expected %v at %d but was %v", pos, i, process.Position(Address(i)))
+		}
+	}
+
+	if process.Position(99) != (asm.SourcePos{}) {
+		t.Errorf("Expected unknown position past the end of the bytecode")
+	}
+
+	process, err = Compile(writeCompilation().ast, LibTest())
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if process.Positions != nil {
+		t.Errorf("Expected no position table but received %v", process.Positions)
+	}
+}
+
 func compileHelper(t *testing.T, ast *asm.AST, expected *Process) bool {
 	t.Helper()
 
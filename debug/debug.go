@@ -0,0 +1,476 @@
+package debug
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/johnny-morrice/shapes"
+)
+
+// Debugger pauses a Runtime to take commands.  Install its Hook on the
+// RuntimeBuilder.  It pauses before the first step, on breakpoints, and when a
+// watched register changes.
+type Debugger struct {
+	input       *bufio.Scanner
+	output      io.Writer
+	breakpoints map[shapes.Address]bool
+	watches     map[shapes.Address]uint64
+	// Steps to run before pausing again, or zero to run until a breakpoint.
+	stepsLeft uint64
+}
+
+func New(input io.Reader, output io.Writer) *Debugger {
+	return &Debugger{
+		input:       bufio.NewScanner(input),
+		output:      output,
+		breakpoints: map[shapes.Address]bool{},
+		watches:     map[shapes.Address]uint64{},
+		stepsLeft:   1,
+	}
+}
+
+// Break sets a breakpoint on a bytecode address.
+func (debugger *Debugger) Break(pc shapes.Address) {
+	debugger.breakpoints[pc] = true
+}
+
+// Hook is a shapes.StepHook.  It returns shapes.ErrHalted when the user quits.
+func (debugger *Debugger) Hook(runtime *shapes.Runtime) error {
+	pause := debugger.checkWatches(runtime)
+
+	if debugger.stepsLeft > 0 {
+		debugger.stepsLeft--
+
+		if debugger.stepsLeft == 0 {
+			pause = true
+		}
+	}
+
+	if debugger.breakpoints[runtime.Process.PC] {
+		fmt.Fprintf(debugger.output, "Breakpoint at %d\n", runtime.Process.PC)
+		pause = true
+	}
+
+	if !pause {
+		return nil
+	}
+
+	debugger.stepsLeft = 0
+	debugger.printCurrent(runtime)
+
+	return debugger.prompt(runtime)
+}
+
+func (debugger *Debugger) checkWatches(runtime *shapes.Runtime) bool {
+	changed := false
+
+	for _, reg := range debugger.sortedWatches() {
+		old := debugger.watches[reg]
+		val := runtime.Process.Register[reg]
+
+		if val != old {
+			fmt.Fprintf(debugger.output, "Register %d changed from %d to %d\n", reg, old, val)
+			debugger.watches[reg] = val
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func (debugger *Debugger) prompt(runtime *shapes.Runtime) error {
+	for {
+		fmt.Fprint(debugger.output, __PROMPT)
+
+		if !debugger.input.Scan() {
+			fmt.Fprintln(debugger.output)
+			return shapes.ErrHalted
+		}
+
+		fields := strings.Fields(debugger.input.Text())
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		command, ok := __COMMANDS[fields[0]]
+
+		if !ok {
+			fmt.Fprintf(debugger.output, "Unknown command '%s'; try help\n", fields[0])
+			continue
+		}
+
+		parse := parseArgs
+
+		if command.parse != nil {
+			parse = command.parse
+		}
+
+		args, err := parse(runtime, fields[1:])
+
+		if err != nil {
+			fmt.Fprintln(debugger.output, err.Error())
+			continue
+		}
+
+		resume, err := command.run(debugger, runtime, args)
+
+		if err != nil {
+			return err
+		}
+
+		if resume {
+			return nil
+		}
+	}
+}
+
+func parseArgs(runtime *shapes.Runtime, fields []string) ([]uint64, error) {
+	args := make([]uint64, len(fields))
+
+	for i, field := range fields {
+		arg, err := strconv.ParseUint(field, 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Expected number but received '%s'", field)
+		}
+
+		args[i] = arg
+	}
+
+	return args, nil
+}
+
+// parseBreakpoints reads bytecode addresses, or source positions written as
+// LINE:COLUMN or FILE:LINE:COLUMN, which give the first operation compiled
+// from that position.
+func parseBreakpoints(runtime *shapes.Runtime, fields []string) ([]uint64, error) {
+	addresses := []uint64{}
+
+	for _, field := range fields {
+		if !strings.Contains(field, ":") {
+			pc, err := parseArgs(runtime, []string{field})
+
+			if err != nil {
+				return nil, err
+			}
+
+			addresses = append(addresses, pc...)
+			continue
+		}
+
+		pc, err := findPosition(runtime.Process, field)
+
+		if err != nil {
+			return nil, err
+		}
+
+		addresses = append(addresses, uint64(pc))
+	}
+
+	return addresses, nil
+}
+
+func findPosition(process *shapes.Process, text string) (shapes.Address, error) {
+	parts := strings.Split(text, ":")
+	file := strings.Join(parts[:len(parts)-2], ":")
+	numbers, err := parseArgs(nil, parts[len(parts)-2:])
+
+	if err != nil {
+		return 0, fmt.Errorf("Expected position but received '%s'", text)
+	}
+
+	for i, pos := range process.Positions {
+		matchFile := file == "" || file == pos.File
+
+		if matchFile && pos.Line == int(numbers[0]) && pos.Column == int(numbers[1]) {
+			return shapes.Address(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("No operation at %s", text)
+}
+
+// commandFunc runs a debugger command, returning true to resume execution.
+type commandFunc func(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error)
+
+type command struct {
+	run   commandFunc
+	usage string
+	// parse reads the arguments, which are numbers unless it is set.
+	parse func(runtime *shapes.Runtime, fields []string) ([]uint64, error)
+}
+
+var __COMMANDS map[string]command
+
+func init() {
+	__COMMANDS = map[string]command{
+		"step":      command{run: step, usage: "step [N]: execute N operations, default 1"},
+		"continue":  command{run: cont, usage: "continue: run until a breakpoint or watch"},
+		"break":     command{run: setBreak, usage: "break [PC|LINE:COLUMN]: set a breakpoint, or list them", parse: parseBreakpoints},
+		"delete":    command{run: deleteBreak, usage: "delete PC: remove a breakpoint"},
+		"registers": command{run: registers, usage: "registers [R...]: show registers, default all nonzero"},
+		"stack":     command{run: stack, usage: "stack [S...]: show stacks, default all nonempty"},
+		"tape":      command{run: tape, usage: "tape [T] [RADIUS]: show cells around the head of tape T"},
+		"watch":     command{run: watch, usage: "watch R: pause when register R changes, or list watches"},
+		"unwatch":   command{run: unwatch, usage: "unwatch R: stop watching register R"},
+		"list":      command{run: list, usage: "list [RADIUS]: show bytecode around the PC"},
+		"quit":      command{run: quit, usage: "quit: stop the program"},
+		"help":      command{run: help, usage: "help: show this message"},
+	}
+
+	for _, name := range []string{"step", "continue", "break", "delete", "registers", "list", "quit", "help"} {
+		__COMMANDS[name[:1]] = __COMMANDS[name]
+	}
+}
+
+func step(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	debugger.stepsLeft = 1
+
+	if len(args) > 0 && args[0] > 0 {
+		debugger.stepsLeft = args[0]
+	}
+
+	return true, nil
+}
+
+func cont(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	return true, nil
+}
+
+func setBreak(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	for _, pc := range args {
+		if pc >= uint64(len(runtime.Process.ByteCode)) {
+			fmt.Fprintf(debugger.output, "No operation at %d\n", pc)
+			continue
+		}
+
+		debugger.Break(shapes.Address(pc))
+	}
+
+	if len(args) == 0 {
+		for _, pc := range sortedAddresses(debugger.breakpoints) {
+			debugger.printOperation(runtime.Process, pc, " ")
+		}
+	}
+
+	return false, nil
+}
+
+func deleteBreak(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	for _, pc := range args {
+		delete(debugger.breakpoints, shapes.Address(pc))
+	}
+
+	return false, nil
+}
+
+func registers(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	if len(args) == 0 {
+		for i, val := range runtime.Process.Register {
+			if val != 0 {
+				args = append(args, uint64(i))
+			}
+		}
+	}
+
+	for _, reg := range args {
+		if reg >= shapes.REGISTER_COUNT {
+			fmt.Fprintf(debugger.output, "No register %d\n", reg)
+			continue
+		}
+
+		fmt.Fprintf(debugger.output, "r%d = %d\n", reg, runtime.Process.Register[reg])
+	}
+
+	return false, nil
+}
+
+func stack(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	if len(args) == 0 {
+		for i, stk := range runtime.Process.Stack {
+			if len(stk) > 0 {
+				args = append(args, uint64(i))
+			}
+		}
+	}
+
+	for _, stackAddr := range args {
+		if stackAddr >= shapes.REGISTER_COUNT {
+			fmt.Fprintf(debugger.output, "No stack %d\n", stackAddr)
+			continue
+		}
+
+		fmt.Fprintf(debugger.output, "s%d = %v\n", stackAddr, runtime.Process.Stack[stackAddr])
+	}
+
+	return false, nil
+}
+
+func tape(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	var index uint64
+	radius := __DEFAULT_RADIUS
+
+	if len(args) > 0 {
+		index = args[0]
+	}
+
+	if len(args) > 1 {
+		radius = int(args[1])
+	}
+
+	t, err := runtime.Tape(index)
+
+	if err != nil {
+		fmt.Fprintln(debugger.output, err.Error())
+		return false, nil
+	}
+
+	view, ok := t.(shapes.TapeView)
+
+	if !ok {
+		fmt.Fprintf(debugger.output, "Tape %d cannot be inspected\n", index)
+		return false, nil
+	}
+
+	head := view.Head()
+
+	for i := head - radius; i <= head+radius; i++ {
+		marker := " "
+
+		if i == head {
+			marker = "*"
+		}
+
+		fmt.Fprintf(debugger.output, "%s %d %d\n", marker, i, view.Cell(i))
+	}
+
+	return false, nil
+}
+
+func watch(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	for _, reg := range args {
+		if reg >= shapes.REGISTER_COUNT {
+			fmt.Fprintf(debugger.output, "No register %d\n", reg)
+			continue
+		}
+
+		debugger.watches[shapes.Address(reg)] = runtime.Process.Register[reg]
+	}
+
+	if len(args) == 0 {
+		for _, reg := range debugger.sortedWatches() {
+			fmt.Fprintf(debugger.output, "r%d = %d\n", reg, runtime.Process.Register[reg])
+		}
+	}
+
+	return false, nil
+}
+
+func unwatch(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	for _, reg := range args {
+		delete(debugger.watches, shapes.Address(reg))
+	}
+
+	return false, nil
+}
+
+func list(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	radius := __DEFAULT_RADIUS
+
+	if len(args) > 0 {
+		radius = int(args[0])
+	}
+
+	pc := int(runtime.Process.PC)
+	byteCode := runtime.Process.ByteCode
+
+	for i := pc - radius; i <= pc+radius; i++ {
+		if i < 0 || i >= len(byteCode) {
+			continue
+		}
+
+		marker := " "
+
+		if i == pc {
+			marker = "*"
+		} else if debugger.breakpoints[shapes.Address(i)] {
+			marker = "b"
+		}
+
+		debugger.printOperation(runtime.Process, shapes.Address(i), marker)
+	}
+
+	return false, nil
+}
+
+func quit(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	return false, shapes.ErrHalted
+}
+
+func help(debugger *Debugger, runtime *shapes.Runtime, args []uint64) (bool, error) {
+	names := []string{}
+
+	for name := range __COMMANDS {
+		if len(name) > 1 {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintln(debugger.output, __COMMANDS[name].usage)
+	}
+
+	return false, nil
+}
+
+func (debugger *Debugger) printCurrent(runtime *shapes.Runtime) {
+	debugger.printOperation(runtime.Process, runtime.Process.PC, "")
+}
+
+func (debugger *Debugger) printOperation(process *shapes.Process, pc shapes.Address, marker string) {
+	if marker != "" {
+		fmt.Fprintf(debugger.output, "%s ", marker)
+	}
+
+	fmt.Fprintf(debugger.output, "%d %v", pc, process.ByteCode[pc])
+
+	if pos := process.Position(pc); pos.IsKnown() {
+		fmt.Fprintf(debugger.output, " %v", pos)
+	}
+
+	fmt.Fprintln(debugger.output)
+}
+
+func (debugger *Debugger) sortedWatches() []shapes.Address {
+	set := map[shapes.Address]bool{}
+
+	for reg := range debugger.watches {
+		set[reg] = true
+	}
+
+	return sortedAddresses(set)
+}
+
+func sortedAddresses(set map[shapes.Address]bool) []shapes.Address {
+	addresses := []shapes.Address{}
+
+	for addr := range set {
+		addresses = append(addresses, addr)
+	}
+
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i] < addresses[j]
+	})
+
+	return addresses
+}
+
+const __PROMPT = "(debug) "
+const __DEFAULT_RADIUS = 5
@@ -0,0 +1,111 @@
+package debug
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/johnny-morrice/shapes"
+	"github.com/johnny-morrice/shapes/brainfuck"
+)
+
+func TestDebugger(t *testing.T) {
+	testCases := []struct {
+		commands string
+		expect   []string
+		reject   []string
+		output   []byte
+	}{
+		{
+			commands: "q\n",
+			expect:   []string{"0 PUSHI 0 0"},
+		},
+		{
+			commands: "step 4\nlist 1\nc\n",
+			expect:   []string{"* 4 ADDI 0 1", "  5 PUSH 0 0"},
+			output:   []byte{2},
+		},
+		{
+			commands: "watch 0\nc\nr 0\nc\nc\n",
+			expect:   []string{"Register 0 changed from 0 to 1", "r0 = 1", "Register 0 changed from 1 to 2"},
+			output:   []byte{2},
+		},
+		{
+			commands: "watch 0\nc\nunwatch 0\nc\n",
+			reject:   []string{"from 1 to 2"},
+			output:   []byte{2},
+		},
+		{
+			commands: "break 1000\nbreak 12\nbreak\nc\ntape 0 1\nquit\n",
+			expect:   []string{"No operation at 1000", "Breakpoint at 12", "* 0 1", "  1 0"},
+			output:   []byte{},
+		},
+		{
+			commands: "break 1:3\nbreak 1:9\nbreak 1:x\nc\nq\n",
+			expect:   []string{"No operation at 1:9", "Expected position but received '1:x'", "Breakpoint at 18", "18 WRITE 0 0 1:3"},
+			output:   []byte{},
+		},
+		{
+			commands: "b 12\ndelete 12\nc\n",
+			reject:   []string{"Breakpoint at"},
+			output:   []byte{2},
+		},
+		{
+			commands: "bogus\nstep x\nhelp\nc\n",
+			expect:   []string{"Unknown command 'bogus'", "Expected number but received 'x'", "quit: stop the program"},
+			output:   []byte{2},
+		},
+		{
+			commands: "",
+			output:   []byte{},
+		},
+	}
+
+	ast, err := brainfuck.Parse([]byte("++."))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, test := range testCases {
+		process, err := shapes.Compile(ast, shapes.StdLib())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		debugOutput := &bytes.Buffer{}
+		programOutput := &bytes.Buffer{}
+		debugger := New(strings.NewReader(test.commands), debugOutput)
+		builder := &shapes.RuntimeBuilder{
+			Process: process,
+			Library: shapes.StdLib(),
+			Input:   &bytes.Buffer{},
+			Output:  programOutput,
+			Hooks:   []shapes.StepHook{debugger.Hook},
+		}
+		err = builder.Build().Execute()
+
+		if err != nil {
+			t.Errorf("Test case %d: unexpected error: %s", i, err.Error())
+		}
+
+		transcript := debugOutput.String()
+
+		for _, expect := range test.expect {
+			if !strings.Contains(transcript, expect) {
+				t.Errorf("Test case %d: expected '%s' in:\n%s", i, expect, transcript)
+			}
+		}
+
+		for _, reject := range test.reject {
+			if strings.Contains(transcript, reject) {
+				t.Errorf("Test case %d: unexpected '%s' in:\n%s", i, reject, transcript)
+			}
+		}
+
+		if !bytes.Equal(test.output, programOutput.Bytes()) {
+			t.Errorf("Test case %d: expected output %v but received %v", i, test.output, programOutput.Bytes())
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package shapes
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Disassemble renders bytecode as Shapes assembly that Assemble turns back
+// into the same bytecode.  Loops compiled from LoopStmt are recovered, and the
+// targets of other jumps are given labels.
+func Disassemble(byteCode []Operation, lib *Library) (string, error) {
+	return DisassembleProcess(&Process{ByteCode: byteCode}, lib)
+}
+
+// DisassembleProcess is like Disassemble, but also comments each line with the
+// source position of its operation, when known.
+func DisassembleProcess(process *Process, lib *Library) (string, error) {
+	const errMsg = "Disassemble failed"
+
+	byteCode := process.ByteCode
+	dis := &disassembler{
+		byteCode:  byteCode,
+		positions: process.Positions,
+		library:   lib,
+		loops:     map[int]int{},
+		backEdge:  map[int]bool{},
+		labels:    map[int]bool{},
+		buff:      &bytes.Buffer{},
+	}
+
+	err := dis.findLoops(0, len(byteCode))
+
+	if err != nil {
+		return "", errors.Wrap(err, errMsg)
+	}
+
+	dis.findLabels()
+
+	err = dis.emitBlock(0, len(byteCode), 0)
+
+	if err != nil {
+		return "", errors.Wrap(err, errMsg)
+	}
+
+	return dis.buff.String(), nil
+}
+
+// Assemble parses Shapes assembly and compiles it against lib.
+func Assemble(source []byte, lib *Library) (*Process, error) {
+	const errMsg = "Assemble failed"
+
+	ast, err := asm.Parse(source)
+
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	process, err := Compile(ast, lib)
+
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	return process, nil
+}
+
+type disassembler struct {
+	byteCode  []Operation
+	positions []asm.SourcePos
+	library   *Library
+	// Loop entry address to loop exit address.
+	loops    map[int]int
+	backEdge map[int]bool
+	labels   map[int]bool
+	buff     *bytes.Buffer
+}
+
+// A loop is a JZ at entry jumping to exit, with a JMPNZ on the same register
+// just before exit that jumps back to the start of the body.
+func (dis *disassembler) findLoops(start, end int) error {
+	for i := start; i < end; i++ {
+		op := dis.byteCode[i]
+
+		if op.OpCode != OP_JZ {
+			continue
+		}
+
+		exit := int(op.Operand[1])
+
+		if !dis.isLoop(i, exit, end) {
+			continue
+		}
+
+		dis.loops[i] = exit
+		dis.backEdge[exit-1] = true
+
+		err := dis.findLoops(i+1, exit-1)
+
+		if err != nil {
+			return err
+		}
+
+		i = exit - 1
+	}
+
+	return nil
+}
+
+func (dis *disassembler) isLoop(entry, exit, end int) bool {
+	if exit < entry+2 || exit > end {
+		return false
+	}
+
+	entryOp := dis.byteCode[entry]
+	backOp := dis.byteCode[exit-1]
+
+	return backOp.OpCode == OP_JMPNZ &&
+		backOp.Operand[0] == entryOp.Operand[0] &&
+		int(backOp.Operand[1]) == entry+1
+}
+
+func (dis *disassembler) findLabels() {
+	for i, op := range dis.byteCode {
+		if !isJump(op.OpCode) || dis.backEdge[i] {
+			continue
+		}
+
+		if _, isLoopEntry := dis.loops[i]; isLoopEntry {
+			continue
+		}
+
+		target := int(op.Operand[1])
+
+		if target <= len(dis.byteCode) {
+			dis.labels[target] = true
+		}
+	}
+}
+
+func (dis *disassembler) emitBlock(start, end, depth int) error {
+	for i := start; i < end; i++ {
+		dis.emitLabel(i, depth)
+
+		if exit, ok := dis.loops[i]; ok {
+			dis.emitLine(depth, "loop %s {%s", registerText(dis.byteCode[i].Operand[0]), dis.positionComment(i))
+
+			err := dis.emitBlock(i+1, exit-1, depth+1)
+
+			if err != nil {
+				return err
+			}
+
+			dis.emitLine(depth, "}")
+			i = exit - 1
+			continue
+		}
+
+		err := dis.emitOperation(i, depth)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	dis.emitLabel(end, depth)
+
+	return nil
+}
+
+func (dis *disassembler) emitLabel(address, depth int) {
+	if dis.labels[address] {
+		dis.emitLine(depth, "%s:", labelText(address))
+	}
+}
+
+func (dis *disassembler) emitOperation(address, depth int) error {
+	op := dis.byteCode[address]
+	mnemonic := strings.ToLower(op.OpCode.String())
+	var text string
+
+	switch op.OpCode {
+	case OP_ADD, OP_SUB, OP_COPY, OP_MUL, OP_DIV, OP_MOD, OP_SDIV, OP_SMOD, OP_AND, OP_OR, OP_XOR, OP_SHL, OP_SHR, OP_SAR, OP_EQ, OP_LT, OP_GT, OP_SLT, OP_SGT:
+		text = fmt.Sprintf("%s %s %s", mnemonic, registerText(op.Operand[0]), registerText(op.Operand[1]))
+	case OP_PUSH, OP_POP:
+		text = fmt.Sprintf("%s %s %s", mnemonic, stackText(op.Operand[0]), registerText(op.Operand[1]))
+	case OP_READ, OP_WRITE, OP_NOT:
+		text = fmt.Sprintf("%s %s", mnemonic, registerText(op.Operand[0]))
+	case OP_SET, OP_ADDI, OP_SUBI:
+		text = fmt.Sprintf("%s %s %d", mnemonic, registerText(op.Operand[0]), int64(op.Operand[1]))
+	case OP_PUSHI:
+		text = fmt.Sprintf("%s %s %d", mnemonic, stackText(op.Operand[0]), int64(op.Operand[1]))
+	case OP_JMPNZ, OP_JZ:
+		text = fmt.Sprintf("%s %s %s", mnemonic, registerText(op.Operand[0]), dis.jumpTarget(op.Operand[1]))
+	case OP_JMP:
+		text = fmt.Sprintf("%s %s", mnemonic, dis.jumpTarget(op.Operand[1]))
+	case OP_CALLSUB:
+		text = fmt.Sprintf("%s %s %s", mnemonic, dis.jumpTarget(op.Operand[1]), stackText(op.Operand[0]))
+	case OP_RET:
+		text = mnemonic
+	case OP_CALL:
+		name, err := dis.library.GetFunctionName(int(op.Operand[0]))
+
+		if err != nil {
+			return err
+		}
+
+		text = fmt.Sprintf("%s %s %s", mnemonic, name, stackText(op.Operand[1]))
+	default:
+		return fmt.Errorf("Cannot disassemble %v", op)
+	}
+
+	dis.emitLine(depth, "%s%s", text, dis.positionComment(address))
+
+	return nil
+}
+
+func (dis *disassembler) positionComment(address int) string {
+	if address < len(dis.positions) && dis.positions[address].IsKnown() {
+		return fmt.Sprintf(" ; %v", dis.positions[address])
+	}
+
+	return ""
+}
+
+func (dis *disassembler) jumpTarget(target Operand) string {
+	if dis.labels[int(target)] {
+		return labelText(int(target))
+	}
+
+	return fmt.Sprint(int64(target))
+}
+
+func (dis *disassembler) emitLine(depth int, format string, args ...interface{}) {
+	dis.buff.WriteString(strings.Repeat("\t", depth))
+	fmt.Fprintf(dis.buff, format, args...)
+	dis.buff.WriteRune('\n')
+}
+
+func isJump(opCode OpCode) bool {
+	return opCode == OP_JMPNZ || opCode == OP_JZ || opCode == OP_JMP || opCode == OP_CALLSUB
+}
+
+func registerText(operand Operand) string {
+	return fmt.Sprintf("r%d", operand)
+}
+
+func stackText(operand Operand) string {
+	return fmt.Sprintf("s%d", operand)
+}
+
+func labelText(address int) string {
+	return fmt.Sprintf("L%d", address)
+}
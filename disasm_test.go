@@ -0,0 +1,155 @@
+package shapes
+
+import (
+	"testing"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+func TestDisassemble(t *testing.T) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_SET, Operand: [2]Operand{0, 3}},
+		Operation{OpCode: OP_SET, Operand: [2]Operand{1, makeOperand(-1)}},
+		Operation{OpCode: OP_JZ, Operand: [2]Operand{0, 9}},
+		Operation{OpCode: OP_WRITE, Operand: [2]Operand{0, 0}},
+		Operation{OpCode: OP_JZ, Operand: [2]Operand{2, 6}},
+		Operation{OpCode: OP_JMPNZ, Operand: [2]Operand{2, 5}},
+		Operation{OpCode: OP_ADD, Operand: [2]Operand{0, 1}},
+		Operation{OpCode: OP_JMPNZ, Operand: [2]Operand{4, 11}},
+		Operation{OpCode: OP_JMPNZ, Operand: [2]Operand{0, 3}},
+		Operation{OpCode: OP_PUSH, Operand: [2]Operand{2, 0}},
+		Operation{OpCode: OP_CALL, Operand: [2]Operand{1, 2}},
+		Operation{OpCode: OP_POP, Operand: [2]Operand{2, 5}},
+		Operation{OpCode: OP_JMPNZ, Operand: [2]Operand{5, 7}},
+		Operation{OpCode: OP_ADDI, Operand: [2]Operand{6, 2}},
+		Operation{OpCode: OP_SUBI, Operand: [2]Operand{7, 3}},
+		Operation{OpCode: OP_PUSHI, Operand: [2]Operand{8, makeOperand(-4)}},
+		Operation{OpCode: OP_MOD, Operand: [2]Operand{1, 2}},
+		Operation{OpCode: OP_NOT, Operand: [2]Operand{3, 0}},
+		Operation{OpCode: OP_SHR, Operand: [2]Operand{4, 5}},
+		Operation{OpCode: OP_JZ, Operand: [2]Operand{1, 22}},
+		Operation{OpCode: OP_EQ, Operand: [2]Operand{1, 2}},
+		Operation{OpCode: OP_SGT, Operand: [2]Operand{3, 4}},
+		Operation{OpCode: OP_JMP, Operand: [2]Operand{0, 19}},
+		Operation{OpCode: OP_JMP, Operand: [2]Operand{0, 99}},
+		Operation{OpCode: OP_CALLSUB, Operand: [2]Operand{3, 19}},
+		Operation{OpCode: OP_RET, Operand: [2]Operand{0, 0}},
+	}
+
+	expected := `set r0 3
+set r1 -1
+loop r0 {
+	write r0
+	loop r2 {
+	}
+	add r0 r1
+	L7:
+	jmpnz r4 L11
+}
+push s2 r0
+call vm_func_b s2
+L11:
+pop s2 r5
+jmpnz r5 L7
+addi r6 2
+subi r7 3
+pushi s8 -4
+mod r1 r2
+not r3
+shr r4 r5
+L19:
+jz r1 L22
+eq r1 r2
+sgt r3 r4
+L22:
+jmp L19
+jmp 99
+callsub L19 s3
+ret
+`
+
+	actual, err := Disassemble(byteCode, LibTest())
+
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	if expected != actual {
+		t.Errorf("Expected:\n%s\nbut received:\n%s", expected, actual)
+	}
+
+	process, err := Assemble([]byte(actual), LibTest())
+
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	if !MakeProcess(byteCode).IsSameByteCode(process) {
+		t.Error("Expected same bytecode")
+		logByteCode(process)
+	}
+}
+
+func TestDisassembleProcess(t *testing.T) {
+	process := &Process{
+		ByteCode: []Operation{
+			Operation{OpCode: OP_SET, Operand: [2]Operand{0, 1}},
+			Operation{OpCode: OP_JZ, Operand: [2]Operand{0, 4}},
+			Operation{OpCode: OP_WRITE, Operand: [2]Operand{0, 0}},
+			Operation{OpCode: OP_JMPNZ, Operand: [2]Operand{0, 2}},
+		},
+		Positions: []asm.SourcePos{
+			asm.SourcePos{},
+			asm.SourcePos{File: "prog.bf", Line: 1, Column: 1},
+			asm.SourcePos{File: "prog.bf", Line: 1, Column: 2},
+			asm.SourcePos{File: "prog.bf", Line: 1, Column: 1},
+		},
+	}
+
+	expected := `set r0 1
+loop r0 { ; prog.bf:1:1
+	write r0 ; prog.bf:1:2
+}
+`
+
+	actual, err := DisassembleProcess(process, LibTest())
+
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	if expected != actual {
+		t.Errorf("Expected:\n%s\nbut received:\n%s", expected, actual)
+	}
+
+	assembled, err := Assemble([]byte(actual), LibTest())
+
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	if !process.IsSameByteCode(assembled) {
+		t.Error("Expected same bytecode")
+		logByteCode(assembled)
+	}
+}
+
+func TestDisassemble_Failure(t *testing.T) {
+	failureCases := [][]Operation{
+		[]Operation{
+			Operation{OpCode: OP_CALL, Operand: [2]Operand{9, 0}},
+		},
+	}
+
+	for i, byteCode := range failureCases {
+		_, err := Disassemble(byteCode, LibTest())
+
+		if err == nil {
+			t.Errorf("Expected failure in case %d", i)
+		}
+	}
+}
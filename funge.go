@@ -0,0 +1,776 @@
+package shapes
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// FungeVmWrapper exposes what Funge-98 adds to Befunge-93: instruction pointer
+// movement over playfields, the stack-stack over a data stack, system
+// information, and fingerprints.  VmFunctions that take a playfield take its
+// index first, and those that take a data stack take its address next.
+// Results that tell whether the instruction succeeded are popped first, and
+// are zero when the instruction pointer should reflect.
+type FungeVmWrapper struct {
+	fields PlayfieldVmWrapper
+}
+
+type fungeState struct {
+	// The stacks beneath the top of each stack-stack, the last nearest the top.
+	beneath map[Address][][]uint64
+	// The fingerprint functions bound to each of the instructions A to Z, the
+	// last being in effect.
+	semantics [__FINGERPRINT_INSTRUCTIONS][]VmFunction
+	reflected bool
+}
+
+type fungeKey struct{}
+
+func runtimeFungeState(runtime *Runtime) *fungeState {
+	return runtime.State(fungeKey{}, func() interface{} {
+		return &fungeState{beneath: map[Address][][]uint64{}}
+	}).(*fungeState)
+}
+
+// Skip takes one of the asm.FUNGE_SKIP modes, and moves the instruction
+// pointer to the next instruction.
+func (wrapper *FungeVmWrapper) Skip(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_skip failed"
+
+	wrapper.fields.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		mode := runtime.Process.Pop(stackAddr)
+
+		if runtime.hasError() {
+			return
+		}
+
+		err := field.Skip(mode == asm.FUNGE_SKIP_COMMENTS)
+
+		if err != nil {
+			runtime.Process.Error = err
+		}
+	})
+}
+
+// Jump takes the number of steps, as Funge-98's j does.
+func (wrapper *FungeVmWrapper) Jump(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_jump failed"
+
+	wrapper.fields.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		n := runtime.Process.Pop(stackAddr)
+
+		if !runtime.hasError() {
+			field.Jump(int64(n))
+		}
+	})
+}
+
+// Pointer pushes the y and then the x of the instruction pointer.
+func (wrapper *FungeVmWrapper) Pointer(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_pointer failed"
+
+	wrapper.fields.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		x, y := field.Pointer()
+		runtime.Process.Push(stackAddr, uint64(y))
+		runtime.Process.Push(stackAddr, uint64(x))
+	})
+}
+
+// MoveTo takes x and then y.
+func (wrapper *FungeVmWrapper) MoveTo(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_move_to failed"
+
+	wrapper.fields.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		x := runtime.Process.Pop(stackAddr)
+		y := runtime.Process.Pop(stackAddr)
+
+		if !runtime.hasError() {
+			field.MoveTo(int64(x), int64(y))
+		}
+	})
+}
+
+// SetDelta takes dx and then dy, as Funge-98's x does.
+func (wrapper *FungeVmWrapper) SetDelta(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_set_delta failed"
+
+	wrapper.fields.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		dx := runtime.Process.Pop(stackAddr)
+		dy := runtime.Process.Pop(stackAddr)
+
+		if !runtime.hasError() {
+			field.SetDelta(int64(dx), int64(dy))
+		}
+	})
+}
+
+// Depth pushes the number of cells on the data stack.
+func (wrapper *FungeVmWrapper) Depth(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_depth failed"
+
+	wrapper.withStack(runtime, stackAddr, errMsg, func(stacks *stackStack) {
+		runtime.Process.Push(stackAddr, uint64(len(stacks.toss())))
+	})
+}
+
+// BeginBlock takes the number of cells to carry over and then the x and y of
+// the storage offset, as Funge-98's { does.  It pushes the y and then the x of
+// the new storage offset, which is the cell after the instruction pointer.
+func (wrapper *FungeVmWrapper) BeginBlock(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_begin_block failed"
+
+	wrapper.fields.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		stacks := wrapper.popStackStack(runtime, stackAddr)
+		n := runtime.Process.Pop(stackAddr)
+		offsetX := runtime.Process.Pop(stackAddr)
+		offsetY := runtime.Process.Pop(stackAddr)
+
+		if runtime.hasError() {
+			return
+		}
+
+		err := stacks.begin(int64(n), offsetX, offsetY)
+
+		if err != nil {
+			runtime.Process.Error = err
+			return
+		}
+
+		x, y := field.Pointer()
+		dx, dy := field.Delta()
+		runtime.Process.Push(stackAddr, uint64(y+dy))
+		runtime.Process.Push(stackAddr, uint64(x+dx))
+	})
+}
+
+// EndBlock takes the number of cells to carry over, as Funge-98's } does.  It
+// pushes the y and x of the restored storage offset, and then whether there
+// was a block to end.
+func (wrapper *FungeVmWrapper) EndBlock(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_end_block failed"
+
+	wrapper.withStack(runtime, stackAddr, errMsg, func(stacks *stackStack) {
+		n := runtime.Process.Pop(stackAddr)
+
+		if runtime.hasError() {
+			return
+		}
+
+		offsetX, offsetY, ok, err := stacks.end(int64(n))
+
+		if err != nil {
+			runtime.Process.Error = err
+			return
+		}
+
+		runtime.Process.Push(stackAddr, offsetY)
+		runtime.Process.Push(stackAddr, offsetX)
+		runtime.Process.Push(stackAddr, flag(ok))
+	})
+}
+
+// StackUnder takes the number of cells to move from the stack beneath onto
+// the data stack, or back if it is negative, as Funge-98's u does.  It pushes
+// whether there was a stack beneath.
+func (wrapper *FungeVmWrapper) StackUnder(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_stack_under failed"
+
+	wrapper.withStack(runtime, stackAddr, errMsg, func(stacks *stackStack) {
+		n := runtime.Process.Pop(stackAddr)
+
+		if runtime.hasError() {
+			return
+		}
+
+		ok, err := stacks.under(int64(n))
+
+		if err != nil {
+			runtime.Process.Error = err
+			return
+		}
+
+		runtime.Process.Push(stackAddr, flag(ok))
+	})
+}
+
+// SysInfo takes the x and y of the storage offset and then n, and pushes the
+// system information of Funge-98's y to the data stack.  If n is positive,
+// only its nth cell is left, counting down from the top of the stack.
+func (wrapper *FungeVmWrapper) SysInfo(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_sysinfo failed"
+
+	wrapper.fields.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		stacks := wrapper.popStackStack(runtime, stackAddr)
+		offsetX := runtime.Process.Pop(stackAddr)
+		offsetY := runtime.Process.Pop(stackAddr)
+		n := int64(runtime.Process.Pop(stackAddr))
+
+		if runtime.hasError() {
+			return
+		}
+
+		info := sysInfo(field, stacks, offsetX, offsetY, time.Now())
+
+		if n > 0 {
+			whole := append(append([]uint64{}, stacks.toss()...), info...)
+			info = []uint64{0}
+
+			if n <= int64(len(whole)) {
+				info[0] = whole[int64(len(whole))-n]
+			}
+		}
+
+		for _, cell := range info {
+			runtime.Process.Push(stacks.address, cell)
+		}
+	})
+}
+
+// sysInfo gives the cells pushed by Funge-98's y, bottom first.
+func sysInfo(field *Playfield, stacks *stackStack, offsetX, offsetY uint64, now time.Time) []uint64 {
+	info := []uint64{}
+	push := func(cells ...int64) {
+		for _, cell := range cells {
+			info = append(info, uint64(cell))
+		}
+	}
+
+	x, y := field.Pointer()
+	dx, dy := field.Delta()
+	leastX, leastY, greatestX, greatestY := field.Bounds()
+	sizes := stacks.sizes()
+
+	// No environment, and no command line arguments.
+	push(0)
+	push(0, 0)
+
+	for i := len(sizes) - 1; i >= 0; i-- {
+		push(int64(sizes[i]))
+	}
+
+	push(int64(len(sizes)))
+	push(int64(now.Hour()*256*256 + now.Minute()*256 + now.Second()))
+	push(int64((now.Year()-1900)*256*256 + int(now.Month())*256 + now.Day()))
+	push(greatestX-leastX, greatestY-leastY)
+	push(leastX, leastY)
+	push(int64(offsetX), int64(offsetY))
+	push(dx, dy)
+	push(x, y)
+	// The team and the instruction pointer, of which there is only one.
+	push(0, 0)
+	// Two dimensions, separated by slashes, and no = to run commands with.
+	push(2, '/', 0)
+	push(__FUNGE_VERSION, __FUNGE_HANDPRINT, __CELL_BYTES)
+	// None of t, i, o or =.
+	push(0)
+
+	return info
+}
+
+// Load pops a fingerprint from the data stack, as Funge-98's ( does, and binds
+// the instructions of its module.  Then it pushes the fingerprint and one to
+// the data stack.  It pushes whether the fingerprint was found.
+func (wrapper *FungeVmWrapper) Load(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_load failed"
+
+	wrapper.withStack(runtime, stackAddr, errMsg, func(stacks *stackStack) {
+		fingerprint := stacks.popFingerprint()
+		module, ok := lookupModule(FingerprintModule(fingerprintName(fingerprint)))
+
+		if ok {
+			state := runtimeFungeState(runtime)
+			lib := module()
+
+			for i := range state.semantics {
+				vmFunc, err := lib.GetFunction(fingerprintInstruction(i))
+
+				if err == nil {
+					state.semantics[i] = append(state.semantics[i], vmFunc)
+				}
+			}
+
+			runtime.Process.Push(stacks.address, fingerprint)
+			runtime.Process.Push(stacks.address, 1)
+		}
+
+		runtime.Process.Push(stackAddr, flag(ok))
+	})
+}
+
+// Unload pops a fingerprint from the data stack, as Funge-98's ) does, and
+// unbinds the instructions that its module has.  It pushes whether the
+// fingerprint was found.
+func (wrapper *FungeVmWrapper) Unload(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_unload failed"
+
+	wrapper.withStack(runtime, stackAddr, errMsg, func(stacks *stackStack) {
+		fingerprint := stacks.popFingerprint()
+		module, ok := lookupModule(FingerprintModule(fingerprintName(fingerprint)))
+
+		if ok {
+			state := runtimeFungeState(runtime)
+			lib := module()
+
+			for i, bound := range state.semantics {
+				_, err := lib.GetFunction(fingerprintInstruction(i))
+
+				if err == nil && len(bound) > 0 {
+					state.semantics[i] = bound[:len(bound)-1]
+				}
+			}
+		}
+
+		runtime.Process.Push(stackAddr, flag(ok))
+	})
+}
+
+// Execute takes one of the instructions A to Z, and calls the fingerprint
+// function bound to it on the data stack.  It pushes whether there was one
+// that did not reflect.
+func (wrapper *FungeVmWrapper) Execute(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_execute failed"
+
+	wrapper.withStack(runtime, stackAddr, errMsg, func(stacks *stackStack) {
+		instruction := runtime.Process.Pop(stackAddr) - 'A'
+
+		if runtime.hasError() {
+			return
+		}
+
+		state := runtimeFungeState(runtime)
+		ok := false
+
+		if instruction < __FINGERPRINT_INSTRUCTIONS && len(state.semantics[instruction]) > 0 {
+			bound := state.semantics[instruction]
+			pc := runtime.Process.PC
+			state.reflected = false
+			runtime.Process.Push(stacks.address, uint64(pc))
+
+			if runtime.hasError() {
+				return
+			}
+
+			bound[len(bound)-1](runtime, stacks.address)
+
+			if runtime.hasError() {
+				return
+			}
+
+			runtime.Process.PC = pc
+			ok = !state.reflected
+		}
+
+		runtime.Process.Push(stackAddr, flag(ok))
+	})
+}
+
+// ReadChar pushes a byte of input and then one, or zero twice at the end of
+// the input.
+func (wrapper *FungeVmWrapper) ReadChar(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_read_char failed"
+
+	wrapper.read(runtime, stackAddr, errMsg, func() (uint64, error) {
+		buff := []byte{0}
+		_, err := io.ReadFull(runtime.Input, buff)
+
+		return uint64(buff[0]), err
+	})
+}
+
+// ReadInt pushes a signed decimal read as io_read_int does and then one, or
+// zero twice at the end of the input.
+func (wrapper *FungeVmWrapper) ReadInt(runtime *Runtime, stackAddr Address) {
+	const errMsg = "funge_read_int failed"
+
+	wrapper.read(runtime, stackAddr, errMsg, func() (uint64, error) {
+		return readDecimal(runtime.Input)
+	})
+}
+
+func (wrapper *FungeVmWrapper) read(runtime *Runtime, stackAddr Address, errMsg string, f func() (uint64, error)) {
+	runtime.Process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	val, err := f()
+	ok := err == nil
+
+	if err == io.EOF {
+		err = nil
+	}
+
+	if err != nil {
+		runtime.Process.Error = errors.Wrap(err, errMsg)
+		return
+	}
+
+	runtime.Process.Push(stackAddr, val)
+	runtime.Process.Push(stackAddr, flag(ok))
+
+	if runtime.hasError() {
+		return
+	}
+
+	runtime.Process.IncrementPC()
+}
+
+// withStack pops the return address and the data stack, then applies f to its
+// stack-stack.
+func (wrapper *FungeVmWrapper) withStack(runtime *Runtime, stackAddr Address, errMsg string, f func(stacks *stackStack)) {
+	runtime.Process.Pop(stackAddr)
+	stacks := wrapper.popStackStack(runtime, stackAddr)
+
+	if runtime.hasError() {
+		runtime.Process.Error = errors.Wrap(runtime.Process.Error, errMsg)
+		return
+	}
+
+	f(stacks)
+
+	if runtime.hasError() {
+		runtime.Process.Error = errors.Wrap(runtime.Process.Error, errMsg)
+		return
+	}
+
+	runtime.Process.IncrementPC()
+}
+
+func (wrapper *FungeVmWrapper) popStackStack(runtime *Runtime, stackAddr Address) *stackStack {
+	address := runtime.Process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return nil
+	}
+
+	if address >= REGISTER_COUNT {
+		runtime.Process.Error = fmt.Errorf("No stack at address %d", address)
+		return nil
+	}
+
+	state := runtimeFungeState(runtime)
+
+	return &stackStack{
+		process: runtime.Process,
+		address: Address(address),
+		beneath: state.beneath[Address(address)],
+		save: func(beneath [][]uint64) {
+			state.beneath[Address(address)] = beneath
+		},
+	}
+}
+
+// stackStack is the stack of stacks of Funge-98.  Its top is a VM stack, and
+// those beneath are kept in the runtime.
+type stackStack struct {
+	process *Process
+	address Address
+	beneath [][]uint64
+	save    func(beneath [][]uint64)
+}
+
+func (stacks *stackStack) toss() []uint64 {
+	return stacks.process.Stack[stacks.address]
+}
+
+// sizes gives the number of cells on each stack, the top first.
+func (stacks *stackStack) sizes() []int {
+	sizes := []int{len(stacks.toss())}
+
+	for i := len(stacks.beneath) - 1; i >= 0; i-- {
+		sizes = append(sizes, len(stacks.beneath[i]))
+	}
+
+	return sizes
+}
+
+// begin pushes a stack with the top n cells of the old one, or pushes -n zeros
+// to the old one if n is negative.  The storage offset is pushed to the old
+// stack.
+func (stacks *stackStack) begin(n int64, offsetX, offsetY uint64) error {
+	toss := stacks.toss()
+	top := []uint64{}
+	zeros := magnitude(n)
+
+	if n > 0 {
+		zeros = shortfall(zeros, toss)
+	}
+
+	if err := stacks.reserve(zeros + 2); err != nil {
+		return err
+	}
+
+	if n > 0 {
+		top, toss = transfer(top, toss, n)
+	} else {
+		toss = append(copyCells(toss), make([]uint64, -n)...)
+	}
+
+	toss = append(toss, offsetX, offsetY)
+
+	return stacks.commit(top, append(stacks.beneath, toss))
+}
+
+// end pops the stack, moving its top n cells to the stack beneath, or popping
+// -n cells from it if n is negative.  It gives the storage offset that was
+// saved on the stack beneath, and false if there was none.
+func (stacks *stackStack) end(n int64) (offsetX, offsetY uint64, ok bool, err error) {
+	if len(stacks.beneath) == 0 {
+		return 0, 0, false, nil
+	}
+
+	soss := copyCells(stacks.beneath[len(stacks.beneath)-1])
+	offsetY, soss = popCell(soss)
+	offsetX, soss = popCell(soss)
+
+	if n > 0 {
+		if err = stacks.reserve(shortfall(uint64(n), stacks.toss())); err != nil {
+			return 0, 0, false, err
+		}
+
+		soss, _ = transfer(soss, stacks.toss(), n)
+	}
+
+	for ; n < 0 && len(soss) > 0; n++ {
+		_, soss = popCell(soss)
+	}
+
+	err = stacks.commit(soss, stacks.beneath[:len(stacks.beneath)-1])
+
+	return offsetX, offsetY, err == nil, err
+}
+
+// under pops n cells from the stack beneath and pushes them to the top, or
+// the other way if n is negative.  It gives false if there is no stack
+// beneath.
+func (stacks *stackStack) under(n int64) (bool, error) {
+	if len(stacks.beneath) == 0 {
+		return false, nil
+	}
+
+	toss := copyCells(stacks.toss())
+	soss := copyCells(stacks.beneath[len(stacks.beneath)-1])
+	from, to := &soss, &toss
+	count := magnitude(n)
+
+	if n < 0 {
+		from, to = to, from
+	}
+
+	if err := stacks.reserve(shortfall(count, *from)); err != nil {
+		return true, err
+	}
+
+	for ; count > 0; count-- {
+		var cell uint64
+		cell, *from = popCell(*from)
+		*to = append(*to, cell)
+	}
+
+	beneath := append(stacks.beneath[:len(stacks.beneath)-1:len(stacks.beneath)-1], soss)
+
+	return true, stacks.commit(toss, beneath)
+}
+
+// reserve fails if the stacks may not gain the given number of cells, so that
+// a huge count fails before any cells are made.
+func (stacks *stackStack) reserve(cells uint64) error {
+	if cells > MAX_BLOCK_CELLS {
+		return ErrBlockTooLarge
+	}
+
+	resource := Resource{Kind: RESOURCE_STACK, Index: uint64(stacks.address)}
+
+	return stacks.process.Memory.Check(resource, cells*__CELL_BYTES)
+}
+
+// commit replaces the stacks, charging or freeing the memory of any cells
+// gained or lost.
+func (stacks *stackStack) commit(toss []uint64, beneath [][]uint64) error {
+	before := countCells(stacks.toss(), stacks.beneath)
+	after := countCells(toss, beneath)
+
+	if after > before {
+		resource := Resource{Kind: RESOURCE_STACK, Index: uint64(stacks.address)}
+		err := stacks.process.Memory.Allocate(resource, (after-before)*__CELL_BYTES)
+
+		if err != nil {
+			return err
+		}
+	} else {
+		stacks.process.Memory.Free((before - after) * __CELL_BYTES)
+	}
+
+	stacks.process.Stack[stacks.address] = toss
+	stacks.beneath = beneath
+	stacks.save(beneath)
+
+	return nil
+}
+
+// popFingerprint pops a count and then that many cells, the first being the
+// most significant byte.  The cells the stack lacks are zeros.
+func (stacks *stackStack) popFingerprint() uint64 {
+	count := int64(stacks.process.PopCell(stacks.address))
+	fingerprint := uint64(0)
+
+	for ; count > 0 && len(stacks.toss()) > 0; count-- {
+		fingerprint = fingerprint*256 + stacks.process.PopCell(stacks.address)
+	}
+
+	if count >= 8 {
+		return 0
+	}
+
+	if count > 0 {
+		fingerprint <<= 8 * uint64(count)
+	}
+
+	return fingerprint
+}
+
+// transfer moves the top n cells of from onto a copy of to, keeping their
+// order.  Zeros stand in for the cells that from lacks.
+func transfer(to, from []uint64, n int64) (newTo, newFrom []uint64) {
+	count := n
+
+	if count > int64(len(from)) {
+		count = int64(len(from))
+	}
+
+	rest := int64(len(from)) - count
+	newTo = append(copyCells(to), make([]uint64, n-count)...)
+	newTo = append(newTo, from[rest:]...)
+
+	return newTo, copyCells(from[:rest])
+}
+
+// shortfall gives the number of zeros that stand in for the cells that stack
+// lacks when count cells are taken from it.
+func shortfall(count uint64, stack []uint64) uint64 {
+	if count <= uint64(len(stack)) {
+		return 0
+	}
+
+	return count - uint64(len(stack))
+}
+
+func magnitude(n int64) uint64 {
+	if n < 0 {
+		return uint64(-n)
+	}
+
+	return uint64(n)
+}
+
+func popCell(stack []uint64) (uint64, []uint64) {
+	if len(stack) == 0 {
+		return 0, stack
+	}
+
+	return stack[len(stack)-1], stack[:len(stack)-1]
+}
+
+func copyCells(stack []uint64) []uint64 {
+	return append([]uint64{}, stack...)
+}
+
+func countCells(toss []uint64, beneath [][]uint64) uint64 {
+	count := uint64(len(toss))
+
+	for _, stack := range beneath {
+		count += uint64(len(stack))
+	}
+
+	return count
+}
+
+func flag(ok bool) uint64 {
+	if ok {
+		return 1
+	}
+
+	return 0
+}
+
+// PopCell pops the stack as Funge-98 does, giving zero if it is empty.
+func (process *Process) PopCell(stackAddr Address) uint64 {
+	if len(process.Stack[stackAddr]) == 0 {
+		return 0
+	}
+
+	return process.Pop(stackAddr)
+}
+
+// Reflect makes the fingerprint function in progress reflect the instruction
+// pointer, as instructions that fail do in Funge-98.
+func Reflect(runtime *Runtime) {
+	runtimeFungeState(runtime).reflected = true
+}
+
+// FingerprintModule names the library module of a Funge-98 fingerprint, such
+// as "ROMA".  Register a module by that name to make the fingerprint loadable.
+// Its functions are named by the instructions A to Z they bind, and are
+// called on the data stack as VmFunctions.  They should take cells with
+// PopCell, and call Reflect to fail.
+func FingerprintModule(name string) string {
+	return __FINGERPRINT_PREFIX + name
+}
+
+// fingerprintName spells out the bytes of the fingerprint.
+func fingerprintName(fingerprint uint64) string {
+	name := []string{}
+
+	for ; fingerprint > 0; fingerprint /= 256 {
+		name = append([]string{string(rune(fingerprint % 256))}, name...)
+	}
+
+	return strings.Join(name, "")
+}
+
+func fingerprintInstruction(index int) string {
+	return string(rune('A' + index))
+}
+
+// FungeLibrary registers the Funge-98 VmFunctions.
+func FungeLibrary() *Library {
+	wrapper := &FungeVmWrapper{}
+	lib := &Library{}
+	lib.AddFunction(asm.FUNGE_SKIP, wrapper.Skip)
+	lib.AddFunction(asm.FUNGE_JUMP, wrapper.Jump)
+	lib.AddFunction(asm.FUNGE_POINTER, wrapper.Pointer)
+	lib.AddFunction(asm.FUNGE_MOVE_TO, wrapper.MoveTo)
+	lib.AddFunction(asm.FUNGE_SET_DELTA, wrapper.SetDelta)
+	lib.AddFunction(asm.FUNGE_DEPTH, wrapper.Depth)
+	lib.AddFunction(asm.FUNGE_BEGIN_BLOCK, wrapper.BeginBlock)
+	lib.AddFunction(asm.FUNGE_END_BLOCK, wrapper.EndBlock)
+	lib.AddFunction(asm.FUNGE_STACK_UNDER, wrapper.StackUnder)
+	lib.AddFunction(asm.FUNGE_SYSINFO, wrapper.SysInfo)
+	lib.AddFunction(asm.FUNGE_LOAD, wrapper.Load)
+	lib.AddFunction(asm.FUNGE_UNLOAD, wrapper.Unload)
+	lib.AddFunction(asm.FUNGE_EXECUTE, wrapper.Execute)
+	lib.AddFunction(asm.FUNGE_READ_CHAR, wrapper.ReadChar)
+	lib.AddFunction(asm.FUNGE_READ_INT, wrapper.ReadInt)
+
+	return lib
+}
+
+// MAX_BLOCK_CELLS limits the cells that one of {, } and u may make up, so that
+// a huge count fails with ErrBlockTooLarge even without a memory quota.
+const MAX_BLOCK_CELLS = 1 << 24
+
+var ErrBlockTooLarge = errors.New("too many cells for the stack-stack")
+
+const __FINGERPRINT_PREFIX = "fingerprint."
+const __FINGERPRINT_INSTRUCTIONS = 26
+
+// The handprint is "SHPS".
+const __FUNGE_HANDPRINT = 0x53485053
+const __FUNGE_VERSION = 1
@@ -0,0 +1,172 @@
+package shapes
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStackStack(t *testing.T) {
+	process := &Process{Memory: &MemoryAccount{}}
+	process.Stack[1] = []uint64{1, 2, 3}
+	process.Memory.used = 3 * __CELL_BYTES
+	beneath := [][]uint64{}
+	stacks := &stackStack{
+		process: process,
+		address: 1,
+		save: func(saved [][]uint64) {
+			beneath = saved
+		},
+	}
+
+	if ok, _ := stacks.under(1); ok {
+		t.Error("Expected u to fail without a stack beneath")
+	}
+
+	if err := stacks.begin(4, 7, 8); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(process.Stack[1], []uint64{0, 1, 2, 3}) || !reflect.DeepEqual(beneath, [][]uint64{{7, 8}}) {
+		t.Errorf("Unexpected stacks %v and %v after begin", process.Stack[1], beneath)
+	}
+
+	if ok, err := stacks.under(-2); !ok || err != nil {
+		t.Fatal("Expected u to succeed")
+	}
+
+	if !reflect.DeepEqual(process.Stack[1], []uint64{0, 1}) || !reflect.DeepEqual(beneath, [][]uint64{{7, 8, 3, 2}}) {
+		t.Errorf("Unexpected stacks %v and %v after under", process.Stack[1], beneath)
+	}
+
+	offsetX, offsetY, ok, err := stacks.end(1)
+
+	if !ok || err != nil {
+		t.Fatal("Expected end to succeed")
+	}
+
+	if offsetX != 3 || offsetY != 2 {
+		t.Errorf("Expected offset (3, 2) but was (%d, %d)", offsetX, offsetY)
+	}
+
+	if !reflect.DeepEqual(process.Stack[1], []uint64{7, 8, 1}) || len(beneath) != 0 {
+		t.Errorf("Unexpected stacks %v and %v after end", process.Stack[1], beneath)
+	}
+
+	if used := process.Memory.Used(); used != 3*__CELL_BYTES {
+		t.Errorf("Expected %d bytes used but was %d", 3*__CELL_BYTES, used)
+	}
+
+	process.Memory.Limit = 4 * __CELL_BYTES
+
+	if stacks.begin(-2, 0, 0) == nil {
+		t.Error("Expected memory quota error")
+	}
+
+	if !reflect.DeepEqual(process.Stack[1], []uint64{7, 8, 1}) {
+		t.Errorf("Expected stack unchanged by failed begin but was %v", process.Stack[1])
+	}
+}
+
+func TestStackStack_HugeCount(t *testing.T) {
+	process := &Process{Memory: &MemoryAccount{}}
+	stacks := &stackStack{
+		process: process,
+		address: 1,
+		save:    func(saved [][]uint64) {},
+	}
+
+	for _, n := range []int64{math.MinInt64, -MAX_BLOCK_CELLS - 1, math.MaxInt64} {
+		if err := stacks.begin(n, 0, 0); err != ErrBlockTooLarge {
+			t.Errorf("Expected begin(%d) to fail with ErrBlockTooLarge but received %v", n, err)
+		}
+	}
+
+	if err := stacks.begin(0, 0, 0); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	for _, n := range []int64{math.MinInt64, math.MaxInt64} {
+		if _, err := stacks.under(n); err != ErrBlockTooLarge {
+			t.Errorf("Expected under(%d) to fail with ErrBlockTooLarge but received %v", n, err)
+		}
+	}
+
+	process.Memory.Limit = process.Memory.Used() + 4*__CELL_BYTES
+
+	if _, err := stacks.under(7); err == nil {
+		t.Error("Expected memory quota error")
+	} else if _, ok := err.(*QuotaError); !ok {
+		t.Errorf("Expected memory quota error but received %v", err)
+	}
+
+	if _, _, _, err := stacks.end(math.MaxInt64); err != ErrBlockTooLarge {
+		t.Errorf("Expected end to fail with ErrBlockTooLarge but received %v", err)
+	}
+}
+
+func TestSysInfo(t *testing.T) {
+	field, _ := MakePlayfield(0, 0)
+	field.Put(-1, 2, 'x')
+	field.Put(4, 5, 'x')
+	field.MoveTo(3, 4)
+	process := &Process{}
+	process.Stack[1] = []uint64{9, 9}
+	stacks := &stackStack{process: process, address: 1, beneath: [][]uint64{{1, 2, 3}}}
+	now := time.Date(2017, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+	info := sysInfo(field, stacks, 10, 11, now)
+	least := int64(-1)
+	expected := []uint64{
+		0, 0, 0,
+		3, 2, 2,
+		5*256*256 + 6*256 + 7,
+		117*256*256 + 3*256 + 4,
+		5, 3, uint64(least), 2,
+		10, 11, 1, 0, 3, 4,
+		0, 0, 2, '/', 0,
+		__FUNGE_VERSION, __FUNGE_HANDPRINT, __CELL_BYTES, 0,
+	}
+
+	if !reflect.DeepEqual(expected, info) {
+		t.Errorf("Expected %v but was %v", expected, info)
+	}
+}
+
+func TestFingerprintName(t *testing.T) {
+	if name := fingerprintName(0x524f4d41); name != "ROMA" {
+		t.Errorf("Expected ROMA but was %s", name)
+	}
+
+	if name := fingerprintName(0); name != "" {
+		t.Errorf("Expected empty name but was %s", name)
+	}
+}
+
+func TestStackStack_PopFingerprint(t *testing.T) {
+	testCases := []struct {
+		stack       []uint64
+		fingerprint uint64
+	}{
+		{[]uint64{'A', 'M', 'O', 'R', 4}, 0x524f4d41},
+		{[]uint64{'R', 2}, 0x5200},
+		{[]uint64{'R', 'O', 9}, 0x5200000000000000},
+		{[]uint64{'R', math.MaxInt64}, 0},
+		{[]uint64{}, 0},
+	}
+
+	for i, test := range testCases {
+		process := &Process{}
+		process.Stack[1] = test.stack
+		stacks := &stackStack{process: process, address: 1}
+
+		if fingerprint := stacks.popFingerprint(); fingerprint != test.fingerprint {
+			t.Errorf("Case %d: expected fingerprint %x but was %x", i, test.fingerprint, fingerprint)
+		}
+
+		if len(process.Stack[1]) != 0 {
+			t.Errorf("Case %d: expected empty stack but was %v", i, process.Stack[1])
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package shapes
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Heap is a memory of cells at signed addresses, as Whitespace's heap is.
+// Cells that were never stored hold zero, and each cell stored is charged to
+// the meter.
+type Heap struct {
+	cells map[int64]uint64
+	meter MemoryMeter
+}
+
+func (heap *Heap) Store(address int64, val uint64) error {
+	if heap.cells == nil {
+		heap.cells = map[int64]uint64{}
+	}
+
+	if _, ok := heap.cells[address]; !ok {
+		err := heap.meter.Allocate(__CELL_BYTES)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	heap.cells[address] = val
+
+	return nil
+}
+
+func (heap *Heap) Retrieve(address int64) uint64 {
+	return heap.cells[address]
+}
+
+// HeapVmWrapper exposes a heap to the VM.  Each Runtime has one heap.
+type HeapVmWrapper struct{}
+
+// heapKey keeps the heap in the Runtime.
+type heapKey struct{}
+
+func (wrapper *HeapVmWrapper) heap(runtime *Runtime) *Heap {
+	return runtime.State(heapKey{}, func() interface{} {
+		return &Heap{
+			meter: MemoryMeter{
+				Account:  runtime.Process.Memory,
+				Resource: Resource{Kind: RESOURCE_HEAP},
+			},
+		}
+	}).(*Heap)
+}
+
+// Store takes the address and then the value.
+func (wrapper *HeapVmWrapper) Store(runtime *Runtime, stackAddr Address) {
+	const errMsg = "heap_store failed"
+
+	runtime.Process.Pop(stackAddr)
+	address := runtime.Process.Pop(stackAddr)
+	val := runtime.Process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	err := wrapper.heap(runtime).Store(int64(address), val)
+
+	if err != nil {
+		runtime.Process.Error = errors.Wrap(err, errMsg)
+		return
+	}
+
+	runtime.Process.IncrementPC()
+}
+
+// Retrieve takes the address and pushes the value stored there.
+func (wrapper *HeapVmWrapper) Retrieve(runtime *Runtime, stackAddr Address) {
+	runtime.Process.Pop(stackAddr)
+	address := runtime.Process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	runtime.Process.Push(stackAddr, wrapper.heap(runtime).Retrieve(int64(address)))
+
+	if runtime.hasError() {
+		return
+	}
+
+	runtime.Process.IncrementPC()
+}
+
+// HeapLibrary registers the heap VmFunctions.
+func HeapLibrary() *Library {
+	wrapper := &HeapVmWrapper{}
+	lib := &Library{}
+	lib.AddFunction(asm.HEAP_STORE, wrapper.Store)
+	lib.AddFunction(asm.HEAP_RETRIEVE, wrapper.Retrieve)
+
+	return lib
+}
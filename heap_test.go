@@ -0,0 +1,61 @@
+package shapes
+
+import (
+	"testing"
+)
+
+func TestHeap(t *testing.T) {
+	heap := &Heap{}
+	testCases := []struct {
+		address int64
+		val     uint64
+	}{
+		{address: 0, val: 5},
+		{address: -3, val: 7},
+		{address: 0, val: 9},
+	}
+
+	for i, test := range testCases {
+		err := heap.Store(test.address, test.val)
+
+		if err != nil {
+			t.Fatalf("Test case %d: unexpected error: %s", i, err.Error())
+		}
+
+		if actual := heap.Retrieve(test.address); actual != test.val {
+			t.Errorf("Test case %d: expected %d but received %d", i, test.val, actual)
+		}
+	}
+
+	if heap.Retrieve(1) != 0 {
+		t.Error("Expected zero where nothing was stored")
+	}
+}
+
+func TestHeap_MemoryLimit(t *testing.T) {
+	account := &MemoryAccount{Limit: 2 * __CELL_BYTES}
+	resource := Resource{Kind: RESOURCE_HEAP}
+	heap := &Heap{meter: MemoryMeter{Account: account, Resource: resource}}
+
+	for _, address := range []int64{1, 2, 1, 2} {
+		err := heap.Store(address, 1)
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	quota, ok := heap.Store(3, 1).(*QuotaError)
+
+	if !ok {
+		t.Fatal("Expected quota error")
+	}
+
+	if quota.Resource != resource {
+		t.Errorf("Expected quota exceeded by %v but was %v", resource, quota.Resource)
+	}
+
+	if heap.Retrieve(3) != 0 {
+		t.Error("Expected failed store to leave the heap alone")
+	}
+}
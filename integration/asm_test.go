@@ -0,0 +1,102 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+func TestAsm(t *testing.T) {
+	testCases := []integrationTest{
+		integrationTest{
+			parseFunc:      asm.Parse,
+			source:         []byte("set r0 65\nwrite r0"),
+			parseOk:        true,
+			expectedOutput: []byte("A"),
+		},
+		integrationTest{
+			parseFunc: asm.Parse,
+			source: []byte(`
+alias value r0
+alias one r1
+set one 1
+set value 3
+loop value {
+	write value
+	sub value one
+}
+write value
+`),
+			parseOk:        true,
+			expectedOutput: []byte{3, 2, 1, 0},
+		},
+		integrationTest{
+			parseFunc: asm.Parse,
+			source: []byte(`
+alias tape s0
+alias index r1
+alias value r2
+pushi tape 0
+pushi tape 64
+call tape_new tape
+pop tape index
+set value 42
+push tape value
+push tape index
+call tape_write_head tape
+set value 0
+push tape index
+call tape_read_head tape
+pop tape value
+write value
+`),
+			parseOk:        true,
+			expectedOutput: []byte{42},
+		},
+		integrationTest{
+			parseFunc:      asm.Parse,
+			source:         []byte("read r0\nwrite r0\nread r0\nwrite r0"),
+			parseOk:        true,
+			input:          []byte("hi"),
+			expectedOutput: []byte("hi"),
+		},
+		integrationTest{
+			parseFunc: asm.Parse,
+			source: []byte(`
+alias args s1
+alias n r0
+alias digit r1
+# Print the digits n down to 1 by recursion.
+proc countdown args {
+	pop args n
+	jz n done
+	copy digit n
+	addi digit 48
+	write digit
+	subi n 1
+	push args n
+	callsub countdown args
+	done:
+}
+pushi args 3
+callsub countdown args
+`),
+			parseOk:        true,
+			expectedOutput: []byte("321"),
+		},
+		integrationTest{
+			parseFunc: asm.Parse,
+			source:    []byte("loop r0 {\nwrite r0"),
+			parseOk:   false,
+		},
+	}
+
+	for i, test := range testCases {
+		t.Logf("Running test case %d", i)
+		passed := integrationTestHelper(t, test)
+
+		if !passed {
+			t.Errorf("Test case %d failed", i)
+		}
+	}
+}
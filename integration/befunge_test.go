@@ -0,0 +1,127 @@
+package integration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/johnny-morrice/shapes"
+	"github.com/johnny-morrice/shapes/befunge"
+)
+
+func TestBefunge(t *testing.T) {
+	testCases := []integrationTest{
+		integrationTest{
+			source:         []byte(`"!dlroW ,olleH">:#,_@`),
+			expectedOutput: []byte("Hello, World!"),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("34+.93-.32*.72/.72%.30/.12`.21`.@"),
+			expectedOutput: []byte("7 6 6 3 1 0 0 1 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("0!.5!.@"),
+			expectedOutput: []byte("1 0 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte(":..12\\..12$.@"),
+			expectedOutput: []byte("0 0 1 2 1 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte(`"a b",,,@`),
+			expectedOutput: []byte("b a"),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("v\n>1.@"),
+			expectedOutput: []byte("1 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("<@.1"),
+			expectedOutput: []byte("1 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:  []byte("0_@.3"),
+			parseOk: true,
+		},
+		integrationTest{
+			source:         []byte("1_@.3"),
+			expectedOutput: []byte("3 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("0|\n >1.@"),
+			expectedOutput: []byte("1 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("5>:.1-:v\n ^     _@"),
+			expectedOutput: []byte("5 4 3 2 1 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("1#2.@"),
+			expectedOutput: []byte("1 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:  []byte("v@\n>?@\n @"),
+			parseOk: true,
+		},
+		integrationTest{
+			source:         []byte(`"7"60p .@`),
+			expectedOutput: []byte("7 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("20g,@"),
+			expectedOutput: []byte("g"),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("&&+.&.@"),
+			input:          []byte("12 30\n-5"),
+			expectedOutput: []byte("42 -5 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("~,~,~.@"),
+			input:          []byte("hi"),
+			expectedOutput: []byte("hi-1 "),
+			parseOk:        true,
+			eof:            shapes.EOF_ALL_ONES,
+		},
+		integrationTest{
+			source:       []byte("~.@"),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+		integrationTest{
+			source:       []byte("@"),
+			runtimeFails: true,
+			parseOk:      true,
+			memoryLimit:  1000,
+		},
+		integrationTest{
+			source: []byte(strings.Repeat(" ", 80) + "@"),
+		},
+		integrationTest{
+			source: []byte(strings.Repeat("\n", 25) + "@"),
+		},
+	}
+
+	for i, test := range testCases {
+		t.Logf("Running test case %d", i)
+		test.parseFunc = befunge.Parse
+		passed := integrationTestHelper(t, test)
+
+		if !passed {
+			t.Errorf("Test case %d failed", i)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package integration
 import (
 	"testing"
 
+	"github.com/johnny-morrice/shapes"
+	"github.com/johnny-morrice/shapes/asm"
 	"github.com/johnny-morrice/shapes/brainfuck"
+	"github.com/johnny-morrice/shapes/optimize"
 )
 
 func TestBrainfuck(t *testing.T) {
@@ -39,6 +42,54 @@ func TestBrainfuck(t *testing.T) {
 			input:          []byte("hello"),
 			expectedOutput: []byte("hello"),
 		},
+		integrationTest{
+			parseFunc:      brainfuck.Parse,
+			source:         []byte("[.+++.]++."),
+			parseOk:        true,
+			expectedOutput: []byte{2},
+		},
+		integrationTest{
+			parseFunc:      brainfuck.Parse,
+			source:         []byte("[[.+][.-]]+."),
+			parseOk:        true,
+			expectedOutput: []byte{1},
+		},
+		integrationTest{
+			parseFunc:      brainfuck.Parse,
+			source:         []byte("[-]."),
+			parseOk:        true,
+			expectedOutput: []byte{0},
+		},
+		integrationTest{
+			parseFunc:      brainfuck.Parse,
+			source:         []byte("+++[-]."),
+			parseOk:        true,
+			expectedOutput: []byte{0},
+		},
+		integrationTest{
+			parseFunc:      brainfuck.Parse,
+			source:         []byte("[This is a comment, with punctuation.]+."),
+			parseOk:        true,
+			expectedOutput: []byte{1},
+		},
+		integrationTest{
+			parseFunc:      brainfuck.Parse,
+			source:         []byte("+++++[->++>+++<<]>.>.<<."),
+			parseOk:        true,
+			expectedOutput: []byte{10, 15, 0},
+		},
+		integrationTest{
+			parseFunc:      brainfuck.Parse,
+			source:         []byte("+>+>+>>+<<<<[>]<.>>."),
+			parseOk:        true,
+			expectedOutput: []byte{1, 1},
+		},
+		integrationTest{
+			parseFunc:    brainfuck.Parse,
+			source:       []byte("+[]"),
+			parseOk:      true,
+			runtimeFails: true,
+		},
 		integrationTest{
 			parseFunc: brainfuck.Parse,
 			source:    []byte("[[[]]"),
@@ -51,12 +102,258 @@ func TestBrainfuck(t *testing.T) {
 		},
 	}
 
+	for level := 0; level <= 2; level++ {
+		for i, test := range testCases {
+			t.Logf("Running test case %d at optimization level %d", i, level)
+			test.parseFunc = optimizedParse(test.parseFunc, level)
+			passed := integrationTestHelper(t, test)
+
+			if !passed {
+				t.Errorf("Test case %d failed at optimization level %d", i, level)
+			}
+		}
+	}
+}
+
+func TestBrainfuck_EOF(t *testing.T) {
+	testCases := []integrationTest{
+		integrationTest{
+			source:         []byte(",[.,]"),
+			input:          []byte("hi"),
+			expectedOutput: []byte("hi"),
+			eof:            shapes.EOF_ZERO,
+		},
+		integrationTest{
+			source:         []byte(",+[-.,+]"),
+			input:          []byte("hi"),
+			expectedOutput: []byte("hi"),
+			eof:            shapes.EOF_ALL_ONES,
+		},
+		integrationTest{
+			source:         []byte(",[.[-],]"),
+			input:          []byte("hi"),
+			expectedOutput: []byte("hi"),
+			eof:            shapes.EOF_UNCHANGED,
+		},
+		integrationTest{
+			source:         []byte("+>,[.,]<."),
+			input:          []byte("hi"),
+			expectedOutput: []byte("hi\x01"),
+			eof:            shapes.EOF_ZERO,
+		},
+		integrationTest{
+			source:       []byte(",[.,]"),
+			input:        []byte("hi"),
+			runtimeFails: true,
+			eof:          shapes.EOF_ERROR,
+		},
+	}
+
+	for level := 0; level <= 2; level++ {
+		for i, test := range testCases {
+			t.Logf("Running test case %d at optimization level %d", i, level)
+			test.parseOk = true
+			test.parseFunc = optimizedParse(brainfuck.Parse, level)
+			passed := integrationTestHelper(t, test)
+
+			if !passed {
+				t.Errorf("Test case %d failed at optimization level %d", i, level)
+			}
+		}
+	}
+}
+
+func TestBrainfuck_BoundedTape(t *testing.T) {
+	testCases := []integrationTest{
+		integrationTest{
+			source:         []byte("+++>++<<.>>>>."),
+			expectedOutput: []byte{0, 3},
+			override:       shapes.BoundedTapeLibrary(3, shapes.TAPE_EDGE_WRAP),
+		},
+		integrationTest{
+			source:         []byte("+>>>>>+[<]>."),
+			expectedOutput: []byte{1},
+			override:       shapes.BoundedTapeLibrary(3, shapes.TAPE_EDGE_CLAMP),
+		},
+		integrationTest{
+			source:         []byte("+<<<<.>."),
+			expectedOutput: []byte{1, 0},
+			override:       shapes.BoundedTapeLibrary(3, shapes.TAPE_EDGE_CLAMP),
+		},
+		integrationTest{
+			source:       []byte("<"),
+			runtimeFails: true,
+			override:     shapes.BoundedTapeLibrary(3, shapes.TAPE_EDGE_ERROR),
+		},
+		integrationTest{
+			source:       []byte("+[>+]"),
+			runtimeFails: true,
+			override:     shapes.BoundedTapeLibrary(30000, shapes.TAPE_EDGE_ERROR),
+		},
+		integrationTest{
+			source:         []byte(">+>+>[>]<[<]>."),
+			expectedOutput: []byte{1},
+			override:       shapes.BoundedTapeLibrary(5, shapes.TAPE_EDGE_ERROR),
+		},
+	}
+
+	for level := 0; level <= 2; level++ {
+		for i, test := range testCases {
+			t.Logf("Running test case %d at optimization level %d", i, level)
+			test.parseOk = true
+			test.parseFunc = optimizedParse(brainfuck.Parse, level)
+			passed := integrationTestHelper(t, test)
+
+			if !passed {
+				t.Errorf("Test case %d failed at optimization level %d", i, level)
+			}
+		}
+	}
+}
+
+func TestBrainfuck_MemoryLimit(t *testing.T) {
+	testCases := []integrationTest{
+		integrationTest{
+			source:       []byte("+[>+]"),
+			runtimeFails: true,
+			memoryLimit:  4096,
+		},
+		integrationTest{
+			source:         []byte("+>+>+<<[>]<."),
+			expectedOutput: []byte{1},
+			memoryLimit:    4096,
+		},
+		integrationTest{
+			source:       []byte("+"),
+			runtimeFails: true,
+			override:     shapes.BoundedTapeLibrary(30000, shapes.TAPE_EDGE_ERROR),
+			memoryLimit:  4096,
+		},
+	}
+
+	for level := 0; level <= 2; level++ {
+		for i, test := range testCases {
+			t.Logf("Running test case %d at optimization level %d", i, level)
+			test.parseOk = true
+			test.parseFunc = optimizedParse(brainfuck.Parse, level)
+			passed := integrationTestHelper(t, test)
+
+			if !passed {
+				t.Errorf("Test case %d failed at optimization level %d", i, level)
+			}
+		}
+	}
+}
+
+func TestBrainfuck_Cells(t *testing.T) {
+	testCases := []struct {
+		cellBits int
+		overflow int
+		integrationTest
+	}{
+		{8, asm.TAPE_OVERFLOW_WRAP, integrationTest{
+			source:         []byte("-.+."),
+			expectedOutput: []byte{255, 0},
+			parseOk:        true,
+		}},
+		{8, asm.TAPE_OVERFLOW_WRAP, integrationTest{
+			source:         []byte("-[>+<-]>."),
+			expectedOutput: []byte{255},
+			parseOk:        true,
+		}},
+		{8, asm.TAPE_OVERFLOW_WRAP, integrationTest{
+			source:         []byte("++++++++++++++++[>++++++++++++++++<-]>[[-]>+<]>."),
+			expectedOutput: []byte{0},
+			parseOk:        true,
+		}},
+		{16, asm.TAPE_OVERFLOW_WRAP, integrationTest{
+			source:         []byte("++++++++++++++++[>++++++++++++++++<-]>[[-]>+<]>."),
+			expectedOutput: []byte{1},
+			parseOk:        true,
+		}},
+		{32, asm.TAPE_OVERFLOW_WRAP, integrationTest{
+			source:         []byte("-+."),
+			expectedOutput: []byte{0},
+			parseOk:        true,
+		}},
+		{64, asm.TAPE_OVERFLOW_WRAP, integrationTest{
+			source:         []byte("-.[+]."),
+			expectedOutput: []byte{255, 0},
+			parseOk:        true,
+		}},
+		{64, asm.TAPE_OVERFLOW_WRAP, integrationTest{
+			source:         []byte("-+."),
+			expectedOutput: []byte{0},
+			parseOk:        true,
+		}},
+		{8, asm.TAPE_OVERFLOW_SATURATE, integrationTest{
+			source:         []byte("--.+."),
+			expectedOutput: []byte{0, 1},
+			parseOk:        true,
+		}},
+		{8, asm.TAPE_OVERFLOW_SATURATE, integrationTest{
+			source:         []byte("-[+]-."),
+			expectedOutput: []byte{0},
+			parseOk:        true,
+		}},
+		{8, asm.TAPE_OVERFLOW_SATURATE, integrationTest{
+			source:         []byte("++++++++++++++++[>++++++++++++++++<-]>.-."),
+			expectedOutput: []byte{255, 254},
+			parseOk:        true,
+		}},
+		{16, asm.TAPE_OVERFLOW_SATURATE, integrationTest{
+			source:         []byte("-[-]+."),
+			expectedOutput: []byte{1},
+			parseOk:        true,
+		}},
+		{8, asm.TAPE_OVERFLOW_ERROR, integrationTest{
+			source:       []byte("+.--."),
+			runtimeFails: true,
+			parseOk:      true,
+		}},
+		{8, asm.TAPE_OVERFLOW_ERROR, integrationTest{
+			source:         []byte("+.-."),
+			expectedOutput: []byte{1, 0},
+			parseOk:        true,
+		}},
+		{32, asm.TAPE_OVERFLOW_ERROR, integrationTest{
+			source:       []byte("-"),
+			runtimeFails: true,
+			parseOk:      true,
+		}},
+		{64, asm.TAPE_OVERFLOW_SATURATE, integrationTest{
+			source:  []byte("-."),
+			parseOk: false,
+		}},
+		{64, asm.TAPE_OVERFLOW_ERROR, integrationTest{
+			source:  []byte("-."),
+			parseOk: false,
+		}},
+	}
+
 	for i, test := range testCases {
-		t.Logf("Running test case %d", i)
-		passed := integrationTestHelper(t, test)
+		t.Logf("Running test case %d with %d-bit cells", i, test.cellBits)
+		options := brainfuck.Options{CellBits: test.cellBits, Overflow: test.overflow}
+		test.parseFunc = func(source []byte) (*asm.AST, error) {
+			return brainfuck.ParseOptions(source, options)
+		}
+
+		passed := integrationTestHelper(t, test.integrationTest)
 
 		if !passed {
 			t.Errorf("Test case %d failed", i)
 		}
 	}
 }
+
+func optimizedParse(parse parseFunc, level int) parseFunc {
+	return func(source []byte) (*asm.AST, error) {
+		ast, err := parse(source)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return optimize.Optimize(ast, level)
+	}
+}
@@ -0,0 +1,165 @@
+package integration
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/johnny-morrice/shapes/brainfuck"
+)
+
+func TestBrainfuckDialect(t *testing.T) {
+	ook, _ := brainfuck.LookupDialect("ook")
+	pikalang, _ := brainfuck.LookupDialect("pikalang")
+	words := &brainfuck.Dialect{
+		Right:     "right",
+		Left:      "left",
+		Increment: "inc",
+		Decrement: "dec",
+		Output:    "out",
+		Input:     "in",
+		Open:      "while",
+		Close:     "end",
+	}
+
+	testCases := []integrationTest{
+		integrationTest{
+			parseFunc:      ook.Parse,
+			source:         []byte("Ook. Ook. Ook. Ook. Ook! Ook."),
+			expectedOutput: []byte{2},
+			parseOk:        true,
+		},
+		// Tokens may be broken across lines, and anything else is a comment.
+		integrationTest{
+			parseFunc:      ook.Parse,
+			source:         []byte("Ook.\r\nOok. Ook!? Ook. Ook. Ook! Ook."),
+			expectedOutput: []byte{2},
+			parseOk:        true,
+		},
+		integrationTest{
+			parseFunc:      pikalang.Parse,
+			source:         []byte("pi pi pika pipi pi pichu ka chu pipi pikachu"),
+			expectedOutput: []byte{2},
+			parseOk:        true,
+		},
+		integrationTest{
+			parseFunc:      words.Parse,
+			source:         []byte("in inc out"),
+			input:          []byte("a"),
+			expectedOutput: []byte("b"),
+			parseOk:        true,
+		},
+		integrationTest{
+			parseFunc: ook.Parse,
+			source:    []byte("Ook! Ook?"),
+			parseOk:   false,
+		},
+		integrationTest{
+			parseFunc: (&brainfuck.Dialect{Right: "x"}).Parse,
+			source:    []byte("x"),
+			parseOk:   false,
+		},
+		integrationTest{
+			parseFunc: (&brainfuck.Dialect{Right: "a", Left: "b", Increment: "c", Decrement: "d", Output: "e", Input: "f", Open: "g", Close: "a"}).Parse,
+			source:    []byte("a"),
+			parseOk:   false,
+		},
+	}
+
+	for i, test := range testCases {
+		t.Logf("Running test case %d", i)
+		passed := integrationTestHelper(t, test)
+
+		if !passed {
+			t.Errorf("Test case %d failed", i)
+		}
+	}
+}
+
+// TestBrainfuckDialect_SameAST translates brainfuck into each built-in dialect
+// and expects the same commands.
+func TestBrainfuckDialect_SameAST(t *testing.T) {
+	source, err := ioutil.ReadFile("../sample/brainfuck/hello-world-no-comment.bf")
+
+	if err != nil {
+		t.Fatalf("Failed to read sample: %s", err.Error())
+	}
+
+	source = []byte(strings.TrimSpace(string(source)))
+	expected, err := brainfuck.ParseCommands(source)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	for _, name := range brainfuck.DialectNames() {
+		dialect, _ := brainfuck.LookupDialect(name)
+		actual, err := dialect.ParseCommands(translateBrainfuck(source, dialect))
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err.Error())
+			continue
+		}
+
+		if !reflect.DeepEqual(stripPositions(expected), stripPositions(actual)) {
+			t.Errorf("%s: expected %v but received %v", name, expected, actual)
+		}
+	}
+}
+
+func TestBrainfuckDialect_Sample(t *testing.T) {
+	source, err := ioutil.ReadFile("../sample/brainfuck/hello-world.ook")
+
+	if err != nil {
+		t.Fatalf("Failed to read sample: %s", err.Error())
+	}
+
+	ook, _ := brainfuck.LookupDialect("ook")
+	test := integrationTest{
+		parseFunc:      ook.Parse,
+		source:         source,
+		expectedOutput: []byte("Hello World!\n"),
+		parseOk:        true,
+	}
+
+	if !integrationTestHelper(t, test) {
+		t.Error("Sample failed")
+	}
+}
+
+// translateBrainfuck spells each command with its token in the dialect,
+// separated by spaces.
+func translateBrainfuck(source []byte, dialect *brainfuck.Dialect) []byte {
+	tokens := map[byte]string{
+		'>': dialect.Right,
+		'<': dialect.Left,
+		'+': dialect.Increment,
+		'-': dialect.Decrement,
+		'.': dialect.Output,
+		',': dialect.Input,
+		'[': dialect.Open,
+		']': dialect.Close,
+	}
+
+	translated := []string{}
+
+	for _, chr := range source {
+		translated = append(translated, tokens[chr])
+	}
+
+	return []byte(strings.Join(translated, " "))
+}
+
+func stripPositions(commands []brainfuck.Command) []brainfuck.Command {
+	stripped := make([]brainfuck.Command, len(commands))
+
+	for i, cmd := range commands {
+		cmd.Pos.Line = 0
+		cmd.Pos.Column = 0
+		cmd.Nest = stripPositions(cmd.Nest)
+		stripped[i] = cmd
+	}
+
+	return stripped
+}
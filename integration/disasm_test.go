@@ -0,0 +1,60 @@
+package integration
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/johnny-morrice/shapes"
+	"github.com/johnny-morrice/shapes/brainfuck"
+)
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../sample/brainfuck/*.bf")
+
+	if err != nil || len(files) == 0 {
+		t.Errorf("Failed to find brainfuck samples")
+		return
+	}
+
+	for _, file := range files {
+		source, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			t.Errorf("Failed to read %s: %s", file, err.Error())
+			continue
+		}
+
+		ast, err := brainfuck.Parse(source)
+
+		if err != nil {
+			t.Errorf("Failed to parse %s: %s", file, err.Error())
+			continue
+		}
+
+		expected, err := shapes.Compile(ast, shapes.StdLib())
+
+		if err != nil {
+			t.Errorf("Failed to compile %s: %s", file, err.Error())
+			continue
+		}
+
+		text, err := shapes.Disassemble(expected.ByteCode, shapes.StdLib())
+
+		if err != nil {
+			t.Errorf("Failed to disassemble %s: %s", file, err.Error())
+			continue
+		}
+
+		actual, err := shapes.Assemble([]byte(text), shapes.StdLib())
+
+		if err != nil {
+			t.Errorf("Failed to assemble %s: %s", file, err.Error())
+			continue
+		}
+
+		if !expected.IsSameByteCode(actual) {
+			t.Errorf("Round trip changed bytecode for %s", file)
+		}
+	}
+}
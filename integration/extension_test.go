@@ -0,0 +1,228 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/johnny-morrice/shapes/asm"
+	"github.com/johnny-morrice/shapes/brainfuck"
+)
+
+func TestBrainfuckPbrain(t *testing.T) {
+	testCases := []integrationTest{
+		integrationTest{
+			source:         []byte("+(+++):."),
+			expectedOutput: []byte{4},
+			parseOk:        true,
+		},
+		// Control skips over the body of a definition.
+		integrationTest{
+			source:         []byte("+(.)."),
+			expectedOutput: []byte{1},
+			parseOk:        true,
+		},
+		// A procedure may be redefined.
+		integrationTest{
+			source:         []byte("+(++)(+++):."),
+			expectedOutput: []byte{4},
+			parseOk:        true,
+		},
+		// Procedures are found by the cell when called, and share the tape.
+		integrationTest{
+			source:         []byte("+([-]++++++++[>++++++<-]>+.<)>+:<:"),
+			expectedOutput: []byte("11"),
+			parseOk:        true,
+		},
+		// Recursion.
+		integrationTest{
+			source:         []byte(">+++<+(>.-[<:>]<):"),
+			expectedOutput: []byte{3, 2, 1},
+			parseOk:        true,
+		},
+		// Definitions within procedures.
+		integrationTest{
+			source:         []byte("++(-(.)+):-:"),
+			expectedOutput: []byte{1},
+			parseOk:        true,
+		},
+		integrationTest{
+			source:       []byte("+(-)-:"),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+		integrationTest{
+			source:       []byte("+(:):"),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+		integrationTest{
+			source:  []byte("(+"),
+			parseOk: false,
+		},
+		integrationTest{
+			source:  []byte("+)"),
+			parseOk: false,
+		},
+		integrationTest{
+			source:  []byte("([)]"),
+			parseOk: false,
+		},
+	}
+
+	extensionTestHelper(t, brainfuck.EXTENSION_PBRAIN, testCases)
+}
+
+func TestBrainfuckBrainfork(t *testing.T) {
+	testCases := []integrationTest{
+		// Only the new thread finds a cell that is not zero.
+		integrationTest{
+			source:         []byte("Y[>++++++++[<++++++>-]<.[-]]"),
+			expectedOutput: []byte("1"),
+			parseOk:        true,
+		},
+		// Threads take turns, beginning with the one that forked.
+		integrationTest{
+			source:         []byte("Y++++++++++++++++++++++++++++++++++++++++++++++++."),
+			expectedOutput: []byte("01"),
+			parseOk:        true,
+		},
+		// Threads share the tape: the new thread waits for the first to
+		// clear its cell.
+		integrationTest{
+			source:         []byte("Y[[]>++++++++[<++++++>-]<.[-]]>-"),
+			expectedOutput: []byte("0"),
+			parseOk:        true,
+		},
+		// Each thread keeps its own head.
+		integrationTest{
+			source:         []byte("Y>>>.<<<."),
+			expectedOutput: []byte{0, 0, 0, 1},
+			parseOk:        true,
+		},
+		integrationTest{
+			source:       []byte("+[Y+]"),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+		integrationTest{
+			source:       []byte("+[Y+]"),
+			runtimeFails: true,
+			parseOk:      true,
+			memoryLimit:  20000,
+		},
+	}
+
+	extensionTestHelper(t, brainfuck.EXTENSION_BRAINFORK, testCases)
+}
+
+func TestBrainfuckExtendedTypeI(t *testing.T) {
+	testCases := []integrationTest{
+		integrationTest{
+			source:         []byte("+++$>!."),
+			expectedOutput: []byte{3},
+			parseOk:        true,
+		},
+		// Storage starts at zero.
+		integrationTest{
+			source:         []byte("+++!."),
+			expectedOutput: []byte{0},
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("+++{.}}.--{."),
+			expectedOutput: []byte{6, 1, 254},
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("~.+++~."),
+			expectedOutput: []byte{255, 253},
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("+++$++++++^.!++++++&.!++++++|."),
+			expectedOutput: []byte{10, 1, 11},
+			parseOk:        true,
+		},
+		// What follows the end of the program is data.
+		integrationTest{
+			source:         []byte("+.@+.]]["),
+			expectedOutput: []byte{1},
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("+[.@]+."),
+			expectedOutput: []byte{1},
+			parseOk:        true,
+		},
+		integrationTest{
+			source:  []byte("+[.@"),
+			parseOk: false,
+		},
+	}
+
+	extensionTestHelper(t, brainfuck.EXTENSION_EXTENDED_TYPE_I, testCases)
+}
+
+// The commands of each extension are comments in brainfuck and the other
+// extensions.
+func TestBrainfuckExtension_Comments(t *testing.T) {
+	source := []byte("+():Y@$!}{~^&|.")
+	testCases := map[brainfuck.Extension][]byte{
+		brainfuck.EXTENSION_NONE:            []byte{1},
+		brainfuck.EXTENSION_PBRAIN:          []byte{1},
+		brainfuck.EXTENSION_BRAINFORK:       []byte{0, 1},
+		brainfuck.EXTENSION_EXTENDED_TYPE_I: nil,
+	}
+
+	for extension, expected := range testCases {
+		tests := []integrationTest{
+			integrationTest{
+				source:         source,
+				expectedOutput: expected,
+				parseOk:        true,
+			},
+		}
+
+		extensionTestHelper(t, extension, tests)
+	}
+}
+
+func TestBrainfuckExtension_Names(t *testing.T) {
+	for _, name := range brainfuck.ExtensionNames() {
+		extension, ok := brainfuck.LookupExtension(name)
+
+		if !ok || extension.String() != name {
+			t.Errorf("Expected extension '%s' but received %v", name, extension)
+		}
+	}
+
+	if _, ok := brainfuck.LookupExtension("brainfuck"); ok {
+		t.Error("Expected no extension called brainfuck")
+	}
+
+	ook, _ := brainfuck.LookupDialect("ook")
+	options := brainfuck.DefaultOptions()
+	options.Extension = brainfuck.EXTENSION_PBRAIN
+
+	if _, err := ook.ParseOptions([]byte("Ook. Ook."), options); err == nil {
+		t.Error("Expected error for dialect with extension")
+	}
+}
+
+func extensionTestHelper(t *testing.T, extension brainfuck.Extension, testCases []integrationTest) {
+	t.Helper()
+
+	options := brainfuck.DefaultOptions()
+	options.Extension = extension
+
+	for i, test := range testCases {
+		t.Logf("Running %v test case %d", extension, i)
+		test.parseFunc = func(source []byte) (*asm.AST, error) {
+			return brainfuck.ParseOptions(source, options)
+		}
+		passed := integrationTestHelper(t, test)
+
+		if !passed {
+			t.Errorf("%v test case %d failed", extension, i)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package integration
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johnny-morrice/shapes"
+	"github.com/johnny-morrice/shapes/befunge"
+)
+
+func TestFunge98(t *testing.T) {
+	testCases := []integrationTest{
+		integrationTest{
+			source:         []byte(`"!dlroW ,olleH">:#,_@`),
+			expectedOutput: []byte("Hello, World!"),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("ab+.f.@"),
+			expectedOutput: []byte("21 15 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("1.  ;2.; 3.@"),
+			expectedOutput: []byte("1 3 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("<@.1"),
+			expectedOutput: []byte("1 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("v\r\n\r\n>2.@"),
+			expectedOutput: []byte("2 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte(`'a,'Qsz@`),
+			expectedOutput: []byte("a"),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("3k1....@"),
+			expectedOutput: []byte("1 1 1 0 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte(`"7"ff*5p ff*5g.@`),
+			expectedOutput: []byte("55 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("3q.@"),
+			expectedOutput: nil,
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("&.&.~,~,@"),
+			input:          []byte("12 -30 ok"),
+			expectedOutput: []byte("12 -30 ok"),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte(`"AMOR"4(XI+.@`),
+			expectedOutput: []byte("11 "),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("~1.@"),
+			expectedOutput: nil,
+			parseOk:        true,
+		},
+		integrationTest{
+			source:       []byte("   "),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+		integrationTest{
+			source:       []byte("1+:::p"),
+			runtimeFails: true,
+			parseOk:      true,
+			memoryLimit:  1000,
+		},
+		// Huge counts fail before the cells are made.
+		integrationTest{
+			source:       []byte(`"~~~~"***:*0\-{@`),
+			runtimeFails: true,
+			parseOk:      true,
+			memoryLimit:  1000000,
+		},
+		integrationTest{
+			source:       []byte(`"~~~~"***:*{@`),
+			runtimeFails: true,
+			parseOk:      true,
+			memoryLimit:  1000000,
+		},
+		integrationTest{
+			source:       []byte(`0{"~~~~"***u@`),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+		// A huge jump is a single step, so the step limit stops the loop
+		// that follows it.
+		integrationTest{
+			source:       []byte(`"~~~~"***:*j@`),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+	}
+
+	for i, test := range testCases {
+		t.Logf("Running test case %d", i)
+		test.parseFunc = befunge.Parse98
+		passed := integrationTestHelper(t, test)
+
+		if !passed {
+			t.Errorf("Test case %d failed", i)
+		}
+	}
+}
+
+// TestFunge98_Conformance runs the checks in the style of the Mycology test
+// suite, which print a line starting with GOOD or BAD for each check.
+func TestFunge98_Conformance(t *testing.T) {
+	expectedChecks := map[string]int{
+		"core.b98":       34,
+		"navigation.b98": 5,
+	}
+
+	files, err := filepath.Glob("../sample/funge98/conformance/*.b98")
+
+	if err != nil || len(files) != len(expectedChecks) {
+		t.Errorf("Failed to find funge98 conformance samples")
+		return
+	}
+
+	for _, file := range files {
+		source, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			t.Errorf("Failed to read %s: %s", file, err.Error())
+			continue
+		}
+
+		ast, err := befunge.Parse98(source)
+
+		if err != nil {
+			t.Errorf("Failed to parse %s: %s", file, err.Error())
+			continue
+		}
+
+		process, err := shapes.Compile(ast, shapes.StdLib())
+
+		if err != nil {
+			t.Errorf("Failed to compile %s: %s", file, err.Error())
+			continue
+		}
+
+		output := &bytes.Buffer{}
+		builder := &shapes.RuntimeBuilder{
+			Process:  process,
+			Library:  shapes.StdLib(),
+			Input:    &bytes.Buffer{},
+			Output:   output,
+			MaxSteps: __MAX_TEST_STEPS,
+		}
+		err = builder.Build().Execute()
+
+		if err != nil {
+			t.Errorf("Runtime crash in %s: %s", file, err.Error())
+			continue
+		}
+
+		lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+
+		for _, line := range lines {
+			if !strings.HasPrefix(line, "GOOD: ") {
+				t.Errorf("%s: %s", file, line)
+			}
+		}
+
+		if expected := expectedChecks[filepath.Base(file)]; len(lines) != expected {
+			t.Errorf("Expected %d checks in %s but there were %d", expected, file, len(lines))
+		}
+	}
+}
@@ -17,6 +17,11 @@ type integrationTest struct {
 	parseOk        bool
 	input          []byte
 	expectedOutput []byte
+	runtimeFails   bool
+	eof            shapes.EOFPolicy
+	memoryLimit    uint64
+	// Replaces functions of the StdLib at runtime.
+	override *shapes.Library
 }
 
 func integrationTestHelper(t *testing.T, test integrationTest) bool {
@@ -46,8 +51,41 @@ func integrationTestHelper(t *testing.T, test integrationTest) bool {
 		return false
 	}
 
+	process, err := shapes.Compile(ast, shapes.StdLib())
+
+	if err != nil {
+		t.Errorf("Compile failed: %s", err.Error())
+		return false
+	}
+
+	library := shapes.StdLib()
+
+	if test.override != nil {
+		library = library.Override(test.override)
+	}
+
 	outputBuff := &bytes.Buffer{}
-	err = shapes.InterpretProgramAST(ast, inputBuff, outputBuff)
+	builder := &shapes.RuntimeBuilder{
+		Process: process,
+		Library: library,
+		Input:   inputBuff,
+		Output:  outputBuff,
+		EOF:     test.eof,
+		// Zero allows any amount of memory.
+		MemoryLimit: test.memoryLimit,
+		// Fail rather than hang on programs that do not terminate.
+		MaxSteps: __MAX_TEST_STEPS,
+	}
+	err = builder.Build().Execute()
+
+	if test.runtimeFails {
+		if err == nil {
+			t.Error("Expected runtime error")
+			return false
+		}
+
+		return true
+	}
 
 	if err != nil {
 		t.Errorf("Runtime crash: %s", err.Error())
@@ -63,3 +101,5 @@ func integrationTestHelper(t *testing.T, test integrationTest) bool {
 
 	return true
 }
+
+const __MAX_TEST_STEPS = 10000000
@@ -0,0 +1,79 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/johnny-morrice/shapes"
+	"github.com/johnny-morrice/shapes/brainfuck"
+)
+
+// TestRunner_Brainfuck grades many brainfuck programs at once, as a service
+// would.  Run it with -race.
+func TestRunner_Brainfuck(t *testing.T) {
+	sources := []string{
+		",[.,]",
+		",[>,]<[.<]",
+		"++++++++[>++++++++<-]>+.",
+	}
+	library := shapes.StdLib()
+	processes := []*shapes.Process{}
+
+	for _, source := range sources {
+		ast, err := brainfuck.Parse([]byte(source))
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+
+		process, err := shapes.Compile(ast, library)
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+
+		processes = append(processes, process)
+	}
+
+	jobs := []shapes.Job{}
+	expected := [][]byte{}
+
+	for i := 0; i < 300; i++ {
+		input := []byte(fmt.Sprintf("job %d", i))
+		jobs = append(jobs, shapes.Job{Process: processes[i%len(processes)], Input: input})
+
+		switch i % len(processes) {
+		case 0:
+			expected = append(expected, input)
+		case 1:
+			reversed := make([]byte, len(input))
+
+			for j, b := range input {
+				reversed[len(input)-j-1] = b
+			}
+
+			expected = append(expected, reversed)
+		case 2:
+			expected = append(expected, []byte("A"))
+		}
+	}
+
+	runner := &shapes.Runner{
+		Library:  library,
+		EOF:      shapes.EOF_ZERO,
+		MaxSteps: __MAX_TEST_STEPS,
+	}
+	results := runner.Run(context.Background(), jobs)
+
+	for i, result := range results {
+		if result.Error != nil {
+			t.Errorf("Job %d: unexpected error: %s", i, result.Error.Error())
+		}
+
+		if !bytes.Equal(expected[i], result.Output) {
+			t.Errorf("Job %d: expected '%s' but received '%s'", i, expected[i], result.Output)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package integration
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/johnny-morrice/shapes"
+	"github.com/johnny-morrice/shapes/whitespace"
+)
+
+func TestWhitespace(t *testing.T) {
+	testCases := []integrationTest{
+		integrationTest{
+			source:         whitespaceSource("SSSTL TLST LLL"),
+			expectedOutput: []byte("1"),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         []byte("push1   \t\nprint\t\n \tend\n\n\n"),
+			expectedOutput: []byte("1"),
+			parseOk:        true,
+		},
+		// 7-2, -7/2, -7%2, 7%-2 and 6*7.
+		integrationTest{
+			source:         whitespaceSource("SSSTTTL SSSTSL TSST TLST SSTTTTL SSSTSL TSTS TLST SSTTTTL SSSTSL TSTT TLST SSSTTTL SSTTSL TSTT TLST SSSTTSL SSSTTTL TSSL TLST LLL"),
+			expectedOutput: []byte("5-41-142"),
+			parseOk:        true,
+		},
+		// Copy and slide.
+		integrationTest{
+			source:         whitespaceSource("SSSTSSTL SSSTL SSSTSL SSSTTL STSSTSL TLST STLSTSL TLST TLST LLL"),
+			expectedOutput: []byte("139"),
+			parseOk:        true,
+		},
+		// Swap, duplicate and discard.
+		integrationTest{
+			source:         whitespaceSource("SSSTL SSSTSL SLT TLST TLST SSSTSSL SLS TSSS TLST SSSTSTL SLL LLL"),
+			expectedOutput: []byte("128"),
+			parseOk:        true,
+		},
+		// Store and retrieve.
+		integrationTest{
+			source:         whitespaceSource("SSSTSTL SSSTSTSTSL TTS SSSTSTL TTT TLST SSSTTSL TTT TLST LLL"),
+			expectedOutput: []byte("420"),
+			parseOk:        true,
+		},
+		// Count down with jumps.
+		integrationTest{
+			source:         whitespaceSource("SSSTTL LSSSL SLS TLST SSSTL TSST SLS LTSTL LSLSL LSSTL LLL"),
+			expectedOutput: []byte("321"),
+			parseOk:        true,
+		},
+		// Call and return.
+		integrationTest{
+			source:         whitespaceSource("LSTTL SSSTSSSL TLST LLL LSSTL SSSTTTL TLST LTL"),
+			expectedOutput: []byte("78"),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         whitespaceSource("SSTTL LTTTL SSSTL TLST LSSTL SSSTSL TLST LLL"),
+			expectedOutput: []byte("2"),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:         whitespaceSource("SSSL TLTS SSSL TTT TLSS SSSTL TLTT SSSTL TTT TLST LLL"),
+			input:          []byte("a-12\n"),
+			expectedOutput: []byte("a-12"),
+			parseOk:        true,
+		},
+		// Programs may run off the end.
+		integrationTest{
+			source:         whitespaceSource("SSSTL TLST"),
+			expectedOutput: []byte("1"),
+			parseOk:        true,
+		},
+		integrationTest{
+			source:       whitespaceSource("SLL"),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+		integrationTest{
+			source:       whitespaceSource("SSSTL SSSTSL SSSTTL STSSTTL"),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+		integrationTest{
+			source:       whitespaceSource("LTL"),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+		integrationTest{
+			source:       whitespaceSource("SSSTL SSSL TSTS"),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+		integrationTest{
+			source:       whitespaceSource("SSSTL SSSL TSTT"),
+			runtimeFails: true,
+			parseOk:      true,
+		},
+		integrationTest{
+			source:       whitespaceSource("SSSL LSSSL SSSTL TSSS SLS SLS TTS LSLSL"),
+			runtimeFails: true,
+			parseOk:      true,
+			memoryLimit:  1000,
+		},
+		integrationTest{
+			source:  whitespaceSource("TLSL"),
+			parseOk: false,
+		},
+		integrationTest{
+			source:  whitespaceSource("SSST"),
+			parseOk: false,
+		},
+		integrationTest{
+			source:  whitespaceSource("STSTTL"),
+			parseOk: false,
+		},
+		integrationTest{
+			source:  whitespaceSource("SSS" + strings.Repeat("T", 64) + "L"),
+			parseOk: false,
+		},
+	}
+
+	for i, test := range testCases {
+		t.Logf("Running test case %d", i)
+		test.parseFunc = whitespace.Parse
+		passed := integrationTestHelper(t, test)
+
+		if !passed {
+			t.Errorf("Test case %d failed", i)
+		}
+	}
+}
+
+func TestWhitespace_Labels(t *testing.T) {
+	sources := []string{
+		// Jump to a label that is not marked.
+		"LSLSTL",
+		// Mark a label twice.
+		"LSSSL LSSSL",
+	}
+
+	for i, source := range sources {
+		ast, err := whitespace.Parse(whitespaceSource(source))
+
+		if err != nil {
+			t.Errorf("Test case %d: unexpected parse error: %s", i, err.Error())
+			continue
+		}
+
+		if _, err := shapes.Compile(ast, shapes.StdLib()); err == nil {
+			t.Errorf("Test case %d: expected compile error", i)
+		}
+	}
+}
+
+func TestWhitespace_Sample(t *testing.T) {
+	source, err := ioutil.ReadFile("../sample/whitespace/hello-world.ws")
+
+	if err != nil {
+		t.Fatalf("Failed to read sample: %s", err.Error())
+	}
+
+	test := integrationTest{
+		parseFunc:      whitespace.Parse,
+		source:         source,
+		expectedOutput: []byte("Hello, World!\n"),
+		parseOk:        true,
+	}
+
+	if !integrationTestHelper(t, test) {
+		t.Error("Sample failed")
+	}
+}
+
+// whitespaceSource spells out S, T and L as space, tab and linefeed, and
+// drops everything else, so that tests can show the instructions.
+func whitespaceSource(instructions string) []byte {
+	source := []byte{}
+
+	for _, chr := range []byte(instructions) {
+		switch chr {
+		case 'S':
+			source = append(source, ' ')
+		case 'T':
+			source = append(source, '\t')
+		case 'L':
+			source = append(source, '\n')
+		}
+	}
+
+	return source
+}
@@ -0,0 +1,117 @@
+package shapes
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// WriteInt writes the signed decimal of the value it takes from the stack.
+func WriteInt(runtime *Runtime, stackAddr Address) {
+	const errMsg = "io_write_int failed"
+
+	runtime.Process.Pop(stackAddr)
+	val := runtime.Process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	_, err := fmt.Fprint(runtime.Output, int64(val))
+
+	if err != nil {
+		runtime.Process.Error = errors.Wrap(err, errMsg)
+		return
+	}
+
+	runtime.Process.IncrementPC()
+}
+
+// ReadInt reads a signed decimal and pushes it.  Bytes before the number are
+// skipped, and the byte after it is consumed.  If the input ends before a
+// number, the EOF policy of the runtime decides what is pushed, with zero
+// for EOF_UNCHANGED.
+func ReadInt(runtime *Runtime, stackAddr Address) {
+	const errMsg = "io_read_int failed"
+
+	runtime.Process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	val, err := readDecimal(runtime.Input)
+
+	if err == io.EOF {
+		switch runtime.EOF {
+		case EOF_ERROR:
+		case EOF_ALL_ONES:
+			val, err = ^uint64(0), nil
+		default:
+			val, err = 0, nil
+		}
+	}
+
+	if err != nil {
+		runtime.Process.Error = errors.Wrap(err, errMsg)
+		return
+	}
+
+	runtime.Process.Push(stackAddr, val)
+
+	if runtime.hasError() {
+		return
+	}
+
+	runtime.Process.IncrementPC()
+}
+
+func readDecimal(r io.Reader) (uint64, error) {
+	buff := []byte{0}
+	digits := []byte{}
+
+	for {
+		_, err := io.ReadFull(r, buff)
+
+		if err == io.EOF && len(digits) > 0 && digits[len(digits)-1] != '-' {
+			break
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		chr := buff[0]
+
+		if chr >= '0' && chr <= '9' {
+			digits = append(digits, chr)
+		} else if len(digits) > 0 && digits[len(digits)-1] != '-' {
+			break
+		} else if chr == '-' {
+			digits = []byte{chr}
+		} else {
+			digits = digits[:0]
+		}
+	}
+
+	val, err := strconv.ParseInt(string(digits), 10, 64)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(val), nil
+}
+
+// IOLibrary registers the VmFunctions that read and write numbers.
+func IOLibrary() *Library {
+	lib := &Library{}
+	lib.AddFunction(asm.IO_READ_INT, ReadInt)
+	lib.AddFunction(asm.IO_WRITE_INT, WriteInt)
+
+	return lib
+}
@@ -0,0 +1,41 @@
+package shapes
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadDecimal(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int64
+		rest     string
+		err      error
+	}{
+		{input: "42", expected: 42},
+		{input: "  -17\nx", expected: -17, rest: "x"},
+		{input: "a-b12 3", expected: 12, rest: "3"},
+		{input: "--5", expected: -5},
+		{input: "", err: io.EOF},
+		{input: "x-", err: io.EOF},
+	}
+
+	for i, test := range testCases {
+		input := bytes.NewBufferString(test.input)
+		actual, err := readDecimal(input)
+
+		if err != test.err {
+			t.Errorf("Test case %d: expected error %v but received %v", i, test.err, err)
+			continue
+		}
+
+		if int64(actual) != test.expected {
+			t.Errorf("Test case %d: expected %d but received %d", i, test.expected, int64(actual))
+		}
+
+		if input.String() != test.rest {
+			t.Errorf("Test case %d: expected '%s' left but was '%s'", i, test.rest, input.String())
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package shapes
+
+import (
+	"fmt"
+)
+
+// MemoryAccount tracks the bytes used by the stacks and tapes of a process,
+// failing allocations that would take it over Limit.  A zero Limit allows any
+// amount, and a nil account allows anything without tracking it.
+type MemoryAccount struct {
+	Limit uint64
+	used  uint64
+}
+
+func (account *MemoryAccount) Allocate(resource Resource, bytes uint64) error {
+	err := account.Check(resource, bytes)
+
+	if err != nil || account == nil {
+		return err
+	}
+
+	account.used += bytes
+
+	return nil
+}
+
+// Check fails as Allocate would, without allocating, so that a caller can
+// refuse a request before building anything.
+func (account *MemoryAccount) Check(resource Resource, bytes uint64) error {
+	if account == nil || account.Limit == 0 {
+		return nil
+	}
+
+	if account.used > account.Limit || bytes > account.Limit-account.used {
+		return &QuotaError{
+			Resource:  resource,
+			Limit:     account.Limit,
+			Used:      account.used,
+			Requested: bytes,
+		}
+	}
+
+	return nil
+}
+
+func (account *MemoryAccount) Free(bytes uint64) {
+	if account == nil {
+		return
+	}
+
+	if bytes > account.used {
+		bytes = account.used
+	}
+
+	account.used -= bytes
+}
+
+// Used is the number of bytes allocated and not yet freed.
+func (account *MemoryAccount) Used() uint64 {
+	if account == nil {
+		return 0
+	}
+
+	return account.used
+}
+
+// MemoryMeter charges the growth of one resource to an account.
+type MemoryMeter struct {
+	Account  *MemoryAccount
+	Resource Resource
+}
+
+func (meter MemoryMeter) Allocate(bytes uint64) error {
+	return meter.Account.Allocate(meter.Resource, bytes)
+}
+
+func (meter MemoryMeter) Free(bytes uint64) {
+	meter.Account.Free(bytes)
+}
+
+type ResourceKind byte
+
+const (
+	RESOURCE_STACK = ResourceKind(iota)
+	RESOURCE_TAPE
+	RESOURCE_PLAYFIELD
+	RESOURCE_HEAP
+	RESOURCE_THREAD
+)
+
+var __RESOURCE_STRING = []string{
+	"stack",
+	"tape",
+	"playfield",
+	"heap",
+	"thread",
+}
+
+func (kind ResourceKind) String() string {
+	return __RESOURCE_STRING[kind]
+}
+
+// Resource names a stack, tape, playfield, heap or thread by its index.
+type Resource struct {
+	Kind  ResourceKind
+	Index uint64
+}
+
+func (resource Resource) String() string {
+	return fmt.Sprintf("%v %d", resource.Kind, resource.Index)
+}
+
+// QuotaError is the error of a process whose memory use would exceed its
+// limit.
+type QuotaError struct {
+	Resource  Resource
+	Limit     uint64
+	Used      uint64
+	Requested uint64
+}
+
+func (err *QuotaError) Error() string {
+	return fmt.Sprintf("%v needs %d more bytes but %d of %d are used: memory quota exceeded", err.Resource, err.Requested, err.Used, err.Limit)
+}
+
+// Every stack entry and tape cell takes the room of a uint64.
+const __CELL_BYTES = 8
@@ -0,0 +1,53 @@
+package shapes
+
+import (
+	"testing"
+)
+
+func TestMemoryAccount(t *testing.T) {
+	stack := Resource{Kind: RESOURCE_STACK, Index: 1}
+	account := &MemoryAccount{Limit: 16}
+
+	if err := account.Allocate(stack, 16); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+
+	err := account.Allocate(stack, 1)
+	expected := &QuotaError{Resource: stack, Limit: 16, Used: 16, Requested: 1}
+
+	if quota, ok := err.(*QuotaError); !ok || *quota != *expected {
+		t.Errorf("Expected %v but received %v", expected, err)
+	}
+
+	account.Free(8)
+
+	if err := account.Allocate(stack, 8); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+
+	if err := account.Check(stack, 1); err == nil {
+		t.Error("Expected Check to fail on a full account")
+	}
+
+	account.Free(8)
+
+	if err := account.Check(stack, 8); err != nil || account.Used() != 8 {
+		t.Errorf("Expected Check to pass without allocating but received %v with %d used", err, account.Used())
+	}
+
+	unlimited := &MemoryAccount{}
+
+	if err := unlimited.Allocate(stack, ^uint64(0)); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+
+	var untracked *MemoryAccount
+
+	if err := untracked.Allocate(stack, 8); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+
+	if untracked.Used() != 0 {
+		t.Errorf("Expected nil account to use nothing but was %d", untracked.Used())
+	}
+}
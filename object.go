@@ -0,0 +1,291 @@
+package shapes
+
+import (
+	"bufio"
+	"bytes"
+	endian "encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Object files hold compiled bytecode so that programs need not be parsed and
+// compiled on every run.  All integers are little endian.
+//
+//	magic           4 bytes, "SHPC"
+//	format version  uint16
+//	opcode version  uint16
+//	import count    uint32
+//	imports         uint16 length followed by the VmFunction name
+//	operation count uint32
+//	operations      opcode byte followed by two uint64 operands
+//
+// The first operand of OP_CALL is an index into the import table rather than
+// into a Library, so an object file does not depend on the order in which
+// VmFunctions were registered.
+func WriteObject(w io.Writer, process *Process, lib *Library) error {
+	const errMsg = "WriteObject failed"
+
+	imports := []string{}
+	importIndex := map[string]int{}
+	byteCode := make([]Operation, len(process.ByteCode))
+	copy(byteCode, process.ByteCode)
+
+	for i, op := range byteCode {
+		if op.OpCode != OP_CALL {
+			continue
+		}
+
+		name, err := lib.GetFunctionName(int(op.Operand[0]))
+
+		if err != nil {
+			return errors.Wrap(err, errMsg)
+		}
+
+		index, ok := importIndex[name]
+
+		if !ok {
+			index = len(imports)
+			imports = append(imports, name)
+			importIndex[name] = index
+		}
+
+		byteCode[i].Operand[0] = Operand(index)
+	}
+
+	buff := bufio.NewWriter(w)
+	buff.Write(__OBJECT_MAGIC)
+	writeUint(buff, uint16(__OBJECT_FORMAT_VERSION))
+	writeUint(buff, uint16(OPCODE_SET_VERSION))
+	writeUint(buff, uint32(len(imports)))
+
+	for _, name := range imports {
+		writeUint(buff, uint16(len(name)))
+		buff.WriteString(name)
+	}
+
+	writeUint(buff, uint32(len(byteCode)))
+
+	for _, op := range byteCode {
+		buff.WriteByte(byte(op.OpCode))
+		writeUint(buff, uint64(op.Operand[0]))
+		writeUint(buff, uint64(op.Operand[1]))
+	}
+
+	err := buff.Flush()
+
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	return nil
+}
+
+// ReadObject loads an object file written by WriteObject, linking its imports
+// against lib.
+func ReadObject(r io.Reader, lib *Library) (*Process, error) {
+	const errMsg = "ReadObject failed"
+
+	reader := &objectReader{r: bufio.NewReader(r)}
+
+	magic := reader.readBytes(len(__OBJECT_MAGIC))
+	formatVersion := reader.readUint16()
+	opCodeVersion := reader.readUint16()
+
+	if reader.err != nil {
+		return nil, errors.Wrap(reader.err, errMsg)
+	}
+
+	if !bytes.Equal(magic, __OBJECT_MAGIC) {
+		return nil, errors.New("Not a shapes object file")
+	}
+
+	if formatVersion != __OBJECT_FORMAT_VERSION {
+		return nil, fmt.Errorf("Unsupported object format version %d", formatVersion)
+	}
+
+	if opCodeVersion > OPCODE_SET_VERSION {
+		return nil, fmt.Errorf("Unsupported opcode set version %d", opCodeVersion)
+	}
+
+	importCount := reader.readUint32()
+	imports := []int{}
+
+	for i := uint32(0); i < importCount && reader.err == nil; i++ {
+		nameLength := reader.readUint16()
+		name := string(reader.readBytes(int(nameLength)))
+
+		if reader.err != nil {
+			break
+		}
+
+		index, err := lib.GetFunctionIndex(name)
+
+		if err != nil {
+			return nil, errors.Wrap(err, errMsg)
+		}
+
+		imports = append(imports, index)
+	}
+
+	opCount := reader.readUint32()
+
+	if reader.err == nil && opCount > MAX_BYTECODE {
+		return nil, fmt.Errorf("Object has %d operations, more than the maximum %d", opCount, MAX_BYTECODE)
+	}
+
+	byteCode := []Operation{}
+
+	for i := uint32(0); i < opCount && reader.err == nil; i++ {
+		op := Operation{}
+		op.OpCode = OpCode(reader.readByte())
+		op.Operand[0] = Operand(reader.readUint64())
+		op.Operand[1] = Operand(reader.readUint64())
+
+		if reader.err != nil {
+			break
+		}
+
+		if int(op.OpCode) >= len(__OPCODE_STRING) {
+			return nil, fmt.Errorf("Unknown opcode %d at %d", op.OpCode, i)
+		}
+
+		if op.OpCode == OP_CALL {
+			if op.Operand[0] >= Operand(len(imports)) {
+				return nil, fmt.Errorf("Unknown import %d at %d", op.Operand[0], i)
+			}
+
+			op.Operand[0] = Operand(imports[op.Operand[0]])
+		}
+
+		byteCode = append(byteCode, op)
+	}
+
+	if reader.err != nil {
+		return nil, errors.Wrap(reader.err, errMsg)
+	}
+
+	for i, op := range byteCode {
+		err := checkOperands(op, len(byteCode))
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid %v at %d", op.OpCode, i)
+		}
+	}
+
+	return MakeProcess(byteCode), nil
+}
+
+// checkOperands fails if an operand names a register or stack that does not
+// exist, or jumps beyond the end of the bytecode.
+func checkOperands(op Operation, opCount int) error {
+	kinds := __OPERAND_KINDS[op.OpCode]
+
+	for i, kind := range kinds {
+		operand := op.Operand[i]
+
+		switch kind {
+		case __REGISTER_OPERAND:
+			if operand >= REGISTER_COUNT {
+				return fmt.Errorf("No register %d", operand)
+			}
+		case __STACK_OPERAND:
+			if operand >= REGISTER_COUNT {
+				return fmt.Errorf("No stack %d", operand)
+			}
+		case __ADDRESS_OPERAND:
+			if operand > Operand(opCount) {
+				return fmt.Errorf("Jump to %d beyond the end of the bytecode", operand)
+			}
+		}
+	}
+
+	return nil
+}
+
+type objectReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (reader *objectReader) readBytes(length int) []byte {
+	buff := make([]byte, length)
+
+	if reader.err == nil {
+		_, reader.err = io.ReadFull(reader.r, buff)
+	}
+
+	return buff
+}
+
+func (reader *objectReader) readByte() byte {
+	return reader.readBytes(1)[0]
+}
+
+func (reader *objectReader) readUint16() uint16 {
+	return endian.LittleEndian.Uint16(reader.readBytes(2))
+}
+
+func (reader *objectReader) readUint32() uint32 {
+	return endian.LittleEndian.Uint32(reader.readBytes(4))
+}
+
+func (reader *objectReader) readUint64() uint64 {
+	return endian.LittleEndian.Uint64(reader.readBytes(8))
+}
+
+func writeUint(w io.Writer, val interface{}) {
+	endian.Write(w, endian.LittleEndian, val)
+}
+
+// operandKind says what an operand refers to.  Immediate operands, and those
+// of CALL that are checked as imports, may take any value.
+type operandKind byte
+
+const (
+	__IMMEDIATE_OPERAND = operandKind(iota)
+	__REGISTER_OPERAND
+	__STACK_OPERAND
+	__ADDRESS_OPERAND
+)
+
+var __OPERAND_KINDS = map[OpCode][2]operandKind{
+	OP_JMPNZ:   {__REGISTER_OPERAND, __ADDRESS_OPERAND},
+	OP_ADD:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_SUB:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_PUSH:    {__STACK_OPERAND, __REGISTER_OPERAND},
+	OP_POP:     {__STACK_OPERAND, __REGISTER_OPERAND},
+	OP_READ:    {__REGISTER_OPERAND, __IMMEDIATE_OPERAND},
+	OP_WRITE:   {__REGISTER_OPERAND, __IMMEDIATE_OPERAND},
+	OP_COPY:    {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_SET:     {__REGISTER_OPERAND, __IMMEDIATE_OPERAND},
+	OP_CALL:    {__IMMEDIATE_OPERAND, __STACK_OPERAND},
+	OP_JZ:      {__REGISTER_OPERAND, __ADDRESS_OPERAND},
+	OP_ADDI:    {__REGISTER_OPERAND, __IMMEDIATE_OPERAND},
+	OP_SUBI:    {__REGISTER_OPERAND, __IMMEDIATE_OPERAND},
+	OP_PUSHI:   {__STACK_OPERAND, __IMMEDIATE_OPERAND},
+	OP_MUL:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_DIV:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_MOD:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_SDIV:    {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_SMOD:    {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_AND:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_OR:      {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_XOR:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_NOT:     {__REGISTER_OPERAND, __IMMEDIATE_OPERAND},
+	OP_SHL:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_SHR:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_SAR:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_JMP:     {__IMMEDIATE_OPERAND, __ADDRESS_OPERAND},
+	OP_EQ:      {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_LT:      {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_GT:      {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_SLT:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_SGT:     {__REGISTER_OPERAND, __REGISTER_OPERAND},
+	OP_CALLSUB: {__STACK_OPERAND, __ADDRESS_OPERAND},
+}
+
+var __OBJECT_MAGIC = []byte("SHPC")
+
+const __OBJECT_FORMAT_VERSION = 1
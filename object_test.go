@@ -0,0 +1,164 @@
+package shapes
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestObjectRoundTrip(t *testing.T) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_SET, Operand: [2]Operand{0, makeOperand(-1)}},
+		Operation{OpCode: OP_CALL, Operand: [2]Operand{1, 3}},
+		Operation{OpCode: OP_CALL, Operand: [2]Operand{0, 4}},
+		Operation{OpCode: OP_CALL, Operand: [2]Operand{1, 5}},
+		Operation{OpCode: OP_JZ, Operand: [2]Operand{0, 6}},
+		Operation{OpCode: OP_JMPNZ, Operand: [2]Operand{0, 5}},
+	}
+
+	buff := &bytes.Buffer{}
+	err := WriteObject(buff, MakeProcess(byteCode), LibTest())
+
+	if err != nil {
+		t.Errorf("Unexpected write error: %s", err.Error())
+		return
+	}
+
+	object := buff.Bytes()
+
+	actual, err := ReadObject(bytes.NewReader(object), LibTest())
+
+	if err != nil {
+		t.Errorf("Unexpected read error: %s", err.Error())
+		return
+	}
+
+	if !MakeProcess(byteCode).IsSameByteCode(actual) {
+		t.Error("Expected same bytecode")
+		logByteCode(actual)
+	}
+
+	// The same program linked against a library with a different
+	// registration order.
+	reversedLib := &Library{}
+	reversedLib.AddFunction(VM_FUNC_B, vmFuncB)
+	reversedLib.AddFunction(VM_FUNC_A, vmFuncA)
+
+	relinked, err := ReadObject(bytes.NewReader(object), reversedLib)
+
+	if err != nil {
+		t.Errorf("Unexpected read error: %s", err.Error())
+		return
+	}
+
+	expectRelinked := make([]Operation, len(byteCode))
+	copy(expectRelinked, byteCode)
+	expectRelinked[1].Operand[0] = 0
+	expectRelinked[2].Operand[0] = 1
+	expectRelinked[3].Operand[0] = 0
+
+	if !MakeProcess(expectRelinked).IsSameByteCode(relinked) {
+		t.Error("Expected relinked bytecode")
+		logByteCode(relinked)
+	}
+}
+
+func TestReadObject_Failure(t *testing.T) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_CALL, Operand: [2]Operand{0, 0}},
+	}
+
+	buff := &bytes.Buffer{}
+	err := WriteObject(buff, MakeProcess(byteCode), LibTest())
+
+	if err != nil {
+		t.Errorf("Unexpected write error: %s", err.Error())
+		return
+	}
+
+	valid := buff.Bytes()
+
+	corrupt := func(index int, val byte) []byte {
+		object := make([]byte, len(valid))
+		copy(object, valid)
+		object[index] = val
+		return object
+	}
+
+	const formatVersionIndex = 4
+	const opCodeVersionIndex = 6
+
+	failureCases := [][]byte{
+		[]byte{},
+		valid[:len(valid)-1],
+		corrupt(0, 'X'),
+		corrupt(formatVersionIndex, 99),
+		corrupt(opCodeVersionIndex, 99),
+		corrupt(len(valid)-17, 255),
+	}
+
+	for i, object := range failureCases {
+		_, err := ReadObject(bytes.NewReader(object), LibTest())
+
+		if err == nil {
+			t.Errorf("Expected failure in case %d", i)
+		}
+	}
+
+	_, err = ReadObject(bytes.NewReader(valid), &Library{})
+
+	if err == nil {
+		t.Error("Expected failure linking against empty library")
+	}
+}
+
+func TestReadObject_InvalidOperand(t *testing.T) {
+	failureCases := []Operation{
+		Operation{OpCode: OP_WRITE, Operand: [2]Operand{1000, 0}},
+		Operation{OpCode: OP_ADD, Operand: [2]Operand{0, REGISTER_COUNT}},
+		Operation{OpCode: OP_PUSH, Operand: [2]Operand{REGISTER_COUNT, 0}},
+		Operation{OpCode: OP_CALL, Operand: [2]Operand{0, REGISTER_COUNT}},
+		Operation{OpCode: OP_JMP, Operand: [2]Operand{0, 3}},
+		Operation{OpCode: OP_JMPNZ, Operand: [2]Operand{0, 3}},
+		Operation{OpCode: OP_CALLSUB, Operand: [2]Operand{0, 3}},
+	}
+
+	for i, bad := range failureCases {
+		byteCode := []Operation{
+			bad,
+			Operation{OpCode: OP_RET},
+		}
+
+		buff := &bytes.Buffer{}
+		err := WriteObject(buff, MakeProcess(byteCode), LibTest())
+
+		if err != nil {
+			t.Errorf("Unexpected write error in case %d: %s", i, err.Error())
+			continue
+		}
+
+		_, err = ReadObject(buff, LibTest())
+
+		if err == nil {
+			t.Errorf("Expected failure in case %d", i)
+		}
+	}
+
+	byteCode := []Operation{
+		Operation{OpCode: OP_JMP, Operand: [2]Operand{0, 2}},
+		Operation{OpCode: OP_SET, Operand: [2]Operand{REGISTER_COUNT - 1, 1000}},
+	}
+
+	buff := &bytes.Buffer{}
+	err := WriteObject(buff, MakeProcess(byteCode), LibTest())
+
+	if err != nil {
+		t.Errorf("Unexpected write error: %s", err.Error())
+		return
+	}
+
+	_, err = ReadObject(buff, LibTest())
+
+	if err != nil {
+		t.Errorf("Unexpected read error: %s", err.Error())
+	}
+}
@@ -0,0 +1,225 @@
+// Package optimize rewrites ASTs produced by the brainfuck frontend into
+// equivalent ASTs that execute fewer instructions.
+package optimize
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
+	"github.com/johnny-morrice/shapes/brainfuck"
+)
+
+type Pass func(commands []brainfuck.Command) []brainfuck.Command
+
+// Optimize applies the passes for level to an AST.  Level 0 leaves the AST
+// alone, level 1 folds runs and removes redundant tape writes, and level 2 also
+// replaces clear, multiply and scan loops.
+func Optimize(ast *asm.AST, level int) (*asm.AST, error) {
+	const errMsg = "Optimize failed"
+
+	passes, err := Passes(level)
+
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	if len(passes) == 0 {
+		return ast, nil
+	}
+
+	commands, options, err := brainfuck.Lift(ast)
+
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	// Folding and removing writes assume that cell arithmetic is modular.
+	if options.Overflow != asm.TAPE_OVERFLOW_WRAP {
+		return nil, errors.New("Optimize requires the wrap overflow policy")
+	}
+
+	for _, pass := range passes {
+		commands = pass(commands)
+	}
+
+	return brainfuck.Lower(commands, options), nil
+}
+
+func Passes(level int) ([]Pass, error) {
+	switch level {
+	case 0:
+		return []Pass{}, nil
+	case 1:
+		return []Pass{RemoveStores, FoldRuns, RemoveStores}, nil
+	case 2:
+		return []Pass{RemoveStores, FoldRuns, RemoveStores, ReplaceLoops, RemoveStores}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown optimization level %d", level)
+}
+
+// FoldRuns merges neighbouring ADDs and MOVEs, dropping any that come to zero.
+func FoldRuns(commands []brainfuck.Command) []brainfuck.Command {
+	folded := []brainfuck.Command{}
+
+	for _, cmd := range commands {
+		if cmd.Kind == brainfuck.LOOP {
+			cmd.Nest = FoldRuns(cmd.Nest)
+		}
+
+		tip := len(folded) - 1
+		isFoldable := cmd.Kind == brainfuck.ADD || cmd.Kind == brainfuck.MOVE
+
+		if isFoldable && tip >= 0 && folded[tip].Kind == cmd.Kind {
+			folded[tip].Value += cmd.Value
+
+			if folded[tip].Value == 0 {
+				folded = folded[:tip]
+			}
+
+			continue
+		}
+
+		if isFoldable && cmd.Value == 0 {
+			continue
+		}
+
+		folded = append(folded, cmd)
+	}
+
+	return folded
+}
+
+// RemoveStores drops a STORE when the cell already holds the value register,
+// or when another STORE follows before anything could read the tape.  The
+// cell and the value register agree at the start and end of every block, so
+// a STORE is only needed after the value register changes.
+func RemoveStores(commands []brainfuck.Command) []brainfuck.Command {
+	kept := []brainfuck.Command{}
+
+	for i, cmd := range commands {
+		if cmd.Kind == brainfuck.LOOP {
+			cmd.Nest = RemoveStores(cmd.Nest)
+		}
+
+		if cmd.Kind == brainfuck.STORE && (isClean(kept) || isOverwritten(commands[i+1:])) {
+			continue
+		}
+
+		kept = append(kept, cmd)
+	}
+
+	return kept
+}
+
+func isClean(kept []brainfuck.Command) bool {
+	if len(kept) == 0 {
+		return true
+	}
+
+	switch kept[len(kept)-1].Kind {
+	case brainfuck.ADD, brainfuck.OUTPUT, brainfuck.INPUT, brainfuck.CLEAR:
+		return false
+	}
+
+	return true
+}
+
+func isOverwritten(commands []brainfuck.Command) bool {
+	for _, cmd := range commands {
+		switch cmd.Kind {
+		case brainfuck.STORE:
+			return true
+		case brainfuck.ADD, brainfuck.OUTPUT, brainfuck.INPUT, brainfuck.CLEAR:
+			continue
+		}
+
+		return false
+	}
+
+	return false
+}
+
+// ReplaceLoops turns loops that clear a cell, scan for a zero cell, or add
+// multiples of a cell to its neighbours into single commands.
+func ReplaceLoops(commands []brainfuck.Command) []brainfuck.Command {
+	replaced := []brainfuck.Command{}
+
+	for _, cmd := range commands {
+		if cmd.Kind != brainfuck.LOOP {
+			replaced = append(replaced, cmd)
+			continue
+		}
+
+		cmd.Nest = ReplaceLoops(cmd.Nest)
+
+		// Replacements take the position of the loop.
+		if isClearLoop(cmd.Nest) {
+			replaced = append(replaced, brainfuck.Command{Kind: brainfuck.CLEAR, Pos: cmd.Pos}, brainfuck.Command{Kind: brainfuck.STORE, Pos: cmd.Pos})
+		} else if isScanLoop(cmd.Nest) {
+			replaced = append(replaced, brainfuck.Command{Kind: brainfuck.SCAN, Value: cmd.Nest[0].Value, Pos: cmd.Pos})
+		} else if targets, ok := multiplyTargets(cmd.Nest); ok {
+			replaced = append(replaced, brainfuck.Command{Kind: brainfuck.MULTIPLY, Targets: targets, Pos: cmd.Pos})
+		} else {
+			replaced = append(replaced, cmd)
+		}
+	}
+
+	return replaced
+}
+
+func isClearLoop(nest []brainfuck.Command) bool {
+	if len(nest) != 2 || nest[1].Kind != brainfuck.STORE {
+		return false
+	}
+
+	add := nest[0]
+
+	return add.Kind == brainfuck.ADD && (add.Value == 1 || add.Value == -1)
+}
+
+func isScanLoop(nest []brainfuck.Command) bool {
+	return len(nest) == 1 && nest[0].Kind == brainfuck.MOVE
+}
+
+// A multiply loop only adds and moves, returns to where it started, and
+// decrements the starting cell once per iteration.
+func multiplyTargets(nest []brainfuck.Command) ([]brainfuck.MultiplyTarget, bool) {
+	head := 0
+	offsets := []int{}
+	factors := map[int]int{}
+
+	for _, cmd := range nest {
+		switch cmd.Kind {
+		case brainfuck.MOVE:
+			head += cmd.Value
+		case brainfuck.ADD:
+			if _, ok := factors[head]; !ok {
+				offsets = append(offsets, head)
+			}
+			factors[head] += cmd.Value
+		case brainfuck.STORE:
+		default:
+			return nil, false
+		}
+	}
+
+	if head != 0 || factors[0] != -1 {
+		return nil, false
+	}
+
+	targets := []brainfuck.MultiplyTarget{}
+
+	for _, offset := range offsets {
+		if offset != 0 && factors[offset] != 0 {
+			targets = append(targets, brainfuck.MultiplyTarget{
+				Offset: offset,
+				Factor: factors[offset],
+			})
+		}
+	}
+
+	return targets, true
+}
@@ -0,0 +1,242 @@
+package optimize
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/johnny-morrice/shapes/asm"
+	"github.com/johnny-morrice/shapes/brainfuck"
+)
+
+func TestOptimizeLevels(t *testing.T) {
+	testCases := []struct {
+		source   string
+		level    int
+		expected []brainfuck.Command
+	}{
+		{
+			source: "+++--",
+			level:  1,
+			expected: []brainfuck.Command{
+				add(1), store(),
+			},
+		},
+		{
+			source:   "><+-<>",
+			level:    1,
+			expected: []brainfuck.Command{},
+		},
+		{
+			source: "+.+.>>>",
+			level:  1,
+			expected: []brainfuck.Command{
+				add(1), output(), add(1), store(), output(), move(3),
+			},
+		},
+		{
+			source: "[-]",
+			level:  1,
+			expected: []brainfuck.Command{
+				loop(add(-1), store()),
+			},
+		},
+		{
+			source: "[-]>[+]",
+			level:  2,
+			expected: []brainfuck.Command{
+				clear(), store(), move(1), clear(), store(),
+			},
+		},
+		{
+			source: "[>>][<]",
+			level:  2,
+			expected: []brainfuck.Command{
+				scan(2), scan(-1),
+			},
+		},
+		{
+			source: "[->+>++<<]",
+			level:  2,
+			expected: []brainfuck.Command{
+				multiply(brainfuck.MultiplyTarget{Offset: 1, Factor: 1}, brainfuck.MultiplyTarget{Offset: 2, Factor: 2}),
+			},
+		},
+		{
+			source: ">[<--->-]",
+			level:  2,
+			expected: []brainfuck.Command{
+				move(1), multiply(brainfuck.MultiplyTarget{Offset: -1, Factor: -3}),
+			},
+		},
+		{
+			source: "[->+<<]",
+			level:  2,
+			expected: []brainfuck.Command{
+				loop(add(-1), store(), move(1), add(1), store(), move(-2)),
+			},
+		},
+		{
+			source: "[-.]",
+			level:  2,
+			expected: []brainfuck.Command{
+				loop(add(-1), store(), output()),
+			},
+		},
+	}
+
+	for i, test := range testCases {
+		ast, err := brainfuck.Parse([]byte(test.source))
+
+		if err != nil {
+			t.Errorf("Parse failed in case %d: %s", i, err.Error())
+			continue
+		}
+
+		optimized, err := Optimize(ast, test.level)
+
+		if err != nil {
+			t.Errorf("Optimize failed in case %d: %s", i, err.Error())
+			continue
+		}
+
+		expected := brainfuck.Lower(test.expected, brainfuck.DefaultOptions())
+		clearPositions(optimized)
+
+		if !reflect.DeepEqual(expected, optimized) {
+			t.Errorf("Case %d: expected %v but received %v", i, expected, optimized)
+		}
+	}
+}
+
+func TestOptimize_UnknownLevel(t *testing.T) {
+	ast, err := brainfuck.Parse([]byte("+"))
+
+	if err != nil {
+		t.Errorf("Parse failed: %s", err.Error())
+		return
+	}
+
+	_, err = Optimize(ast, 3)
+
+	if err == nil {
+		t.Error("Expected failure")
+	}
+}
+
+func TestOptimize_Overflow(t *testing.T) {
+	testCases := []struct {
+		options brainfuck.Options
+		ok      bool
+	}{
+		{brainfuck.Options{CellBits: 8, Overflow: asm.TAPE_OVERFLOW_WRAP}, true},
+		{brainfuck.Options{CellBits: 8, Overflow: asm.TAPE_OVERFLOW_SATURATE}, false},
+		{brainfuck.Options{CellBits: 16, Overflow: asm.TAPE_OVERFLOW_ERROR}, false},
+		{brainfuck.Options{CellBits: 64, Overflow: asm.TAPE_OVERFLOW_WRAP}, true},
+	}
+
+	for i, test := range testCases {
+		ast, err := brainfuck.ParseOptions([]byte("++-"), test.options)
+
+		if err != nil {
+			t.Errorf("Parse failed in case %d: %s", i, err.Error())
+			continue
+		}
+
+		optimized, err := Optimize(ast, 1)
+
+		if (err == nil) != test.ok {
+			t.Errorf("Unexpected error state in case %d: %v", i, err)
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+
+		expected := brainfuck.Lower([]brainfuck.Command{add(1), store()}, test.options)
+		clearPositions(optimized)
+
+		if !reflect.DeepEqual(expected, optimized) {
+			t.Errorf("Case %d: expected %v but received %v", i, expected, optimized)
+		}
+	}
+}
+
+func TestOptimize_Positions(t *testing.T) {
+	ast, err := brainfuck.Parse([]byte("+\n +[-]"))
+
+	if err != nil {
+		t.Errorf("Parse failed: %s", err.Error())
+		return
+	}
+
+	optimized, err := Optimize(ast, 2)
+
+	if err != nil {
+		t.Errorf("Optimize failed: %s", err.Error())
+		return
+	}
+
+	commands, _, err := brainfuck.Lift(optimized)
+
+	if err != nil {
+		t.Errorf("Lift failed: %s", err.Error())
+		return
+	}
+
+	expected := []asm.SourcePos{
+		asm.SourcePos{Line: 1, Column: 1},
+		asm.SourcePos{Line: 2, Column: 3},
+		asm.SourcePos{Line: 2, Column: 3},
+	}
+
+	if len(commands) != len(expected) {
+		t.Fatalf("Expected %d commands but received %v", len(expected), commands)
+	}
+
+	for i, cmd := range commands {
+		if cmd.Pos != expected[i] {
+			t.Errorf("Expected %v at %v but was %v", cmd, expected[i], cmd.Pos)
+		}
+	}
+}
+
+// Optimized ASTs keep the positions of the source, which the expected ASTs
+// lack.
+func clearPositions(ast *asm.AST) {
+	ast.Walk(func(stmt asm.Statement) {
+		asm.Locate(asm.SourcePos{}, stmt)
+	})
+}
+
+func add(value int) brainfuck.Command {
+	return brainfuck.Command{Kind: brainfuck.ADD, Value: value}
+}
+
+func move(value int) brainfuck.Command {
+	return brainfuck.Command{Kind: brainfuck.MOVE, Value: value}
+}
+
+func scan(value int) brainfuck.Command {
+	return brainfuck.Command{Kind: brainfuck.SCAN, Value: value}
+}
+
+func store() brainfuck.Command {
+	return brainfuck.Command{Kind: brainfuck.STORE}
+}
+
+func output() brainfuck.Command {
+	return brainfuck.Command{Kind: brainfuck.OUTPUT}
+}
+
+func clear() brainfuck.Command {
+	return brainfuck.Command{Kind: brainfuck.CLEAR}
+}
+
+func loop(nest ...brainfuck.Command) brainfuck.Command {
+	return brainfuck.Command{Kind: brainfuck.LOOP, Nest: nest}
+}
+
+func multiply(targets ...brainfuck.MultiplyTarget) brainfuck.Command {
+	return brainfuck.Command{Kind: brainfuck.MULTIPLY, Targets: targets}
+}
@@ -0,0 +1,87 @@
+package shapes
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// PbrainVmWrapper keeps the procedure table of pbrain, which finds each
+// procedure by the value of the cell it was defined under.  Procedures are
+// numbered by the program.  Each Runtime has one table.
+type PbrainVmWrapper struct{}
+
+// pbrainKey keeps the procedure table in the Runtime.
+type pbrainKey struct{}
+
+// The table is a heap of procedure numbers, offset by one so that zero means
+// no procedure.
+func (wrapper *PbrainVmWrapper) procedures(runtime *Runtime) *Heap {
+	return runtime.State(pbrainKey{}, func() interface{} {
+		return &Heap{
+			meter: MemoryMeter{
+				Account:  runtime.Process.Memory,
+				Resource: Resource{Kind: RESOURCE_HEAP},
+			},
+		}
+	}).(*Heap)
+}
+
+// Define takes the cell value and then the procedure number, replacing any
+// procedure defined under that value.
+func (wrapper *PbrainVmWrapper) Define(runtime *Runtime, stackAddr Address) {
+	const errMsg = "pbrain_define failed"
+
+	runtime.Process.Pop(stackAddr)
+	id := runtime.Process.Pop(stackAddr)
+	procedure := runtime.Process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	err := wrapper.procedures(runtime).Store(int64(id), procedure+1)
+
+	if err != nil {
+		runtime.Process.Error = errors.Wrap(err, errMsg)
+		return
+	}
+
+	runtime.Process.IncrementPC()
+}
+
+// Lookup takes the cell value and pushes the number of the procedure defined
+// under it, failing if there is none.
+func (wrapper *PbrainVmWrapper) Lookup(runtime *Runtime, stackAddr Address) {
+	runtime.Process.Pop(stackAddr)
+	id := runtime.Process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	procedure := wrapper.procedures(runtime).Retrieve(int64(id))
+
+	if procedure == 0 {
+		runtime.Process.Error = errors.Errorf("pbrain_lookup failed: no procedure %d", id)
+		return
+	}
+
+	runtime.Process.Push(stackAddr, procedure-1)
+
+	if runtime.hasError() {
+		return
+	}
+
+	runtime.Process.IncrementPC()
+}
+
+// PbrainLibrary registers the pbrain VmFunctions.
+func PbrainLibrary() *Library {
+	wrapper := &PbrainVmWrapper{}
+	lib := &Library{}
+	lib.AddFunction(asm.PBRAIN_DEFINE, wrapper.Define)
+	lib.AddFunction(asm.PBRAIN_LOOKUP, wrapper.Lookup)
+
+	return lib
+}
@@ -0,0 +1,33 @@
+package shapes
+
+import (
+	"testing"
+)
+
+func TestPbrainVmWrapper(t *testing.T) {
+	wrapper := &PbrainVmWrapper{}
+	runtime := (&RuntimeBuilder{Process: &Process{}}).Build()
+	process := runtime.Process
+
+	pushArgs(process, 5, 0)
+	wrapper.Define(runtime, 0)
+	pushArgs(process, 5, 2)
+	wrapper.Define(runtime, 0)
+	pushArgs(process, 5)
+	wrapper.Lookup(runtime, 0)
+
+	if process.Error != nil {
+		t.Fatalf("Unexpected error: %s", process.Error.Error())
+	}
+
+	if procedure := process.Pop(0); procedure != 2 {
+		t.Errorf("Expected procedure 2 but received %d", procedure)
+	}
+
+	pushArgs(process, 0)
+	wrapper.Lookup(runtime, 0)
+
+	if process.Error == nil {
+		t.Error("Expected error for undefined procedure")
+	}
+}
@@ -0,0 +1,770 @@
+package shapes
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Playfield is a grid of cells with an instruction pointer that moves across
+// it, as Befunge programs run on.  The pointer starts at the top left heading
+// east.  Every cell starts as a space.
+//
+// A bounded playfield wraps the pointer around its edges.  An unbounded
+// playfield is the Lahey-space of Funge-98: its cells are kept sparsely, and
+// the pointer wraps around the smallest rectangle holding every cell that is
+// not a space.
+type Playfield struct {
+	width  int
+	height int
+	cells  []uint64
+	sparse map[point]uint64
+	// The bounds of the cells that are not spaces, in an unbounded playfield.
+	least    point
+	greatest point
+	meter    MemoryMeter
+	x        int64
+	y        int64
+	dx       int64
+	dy       int64
+}
+
+type point struct {
+	x int64
+	y int64
+}
+
+// MakePlayfield gives a bounded playfield, or an unbounded one if width and
+// height are both zero.
+func MakePlayfield(width, height int) (*Playfield, error) {
+	if width == 0 && height == 0 {
+		return &Playfield{sparse: map[point]uint64{}, dx: 1}, nil
+	}
+
+	count, err := playfieldCells(width, height)
+
+	if err != nil {
+		return nil, err
+	}
+
+	field := &Playfield{
+		width:  width,
+		height: height,
+		cells:  make([]uint64, count),
+		dx:     1,
+	}
+
+	for i := range field.cells {
+		field.cells[i] = ' '
+	}
+
+	return field, nil
+}
+
+// playfieldCells gives the number of cells of a playfield, which is zero for
+// an unbounded one, failing if there would be more than MAX_PLAYFIELD_CELLS.
+func playfieldCells(width, height int) (int, error) {
+	if width == 0 && height == 0 {
+		return 0, nil
+	}
+
+	if width < 1 || height < 1 {
+		return 0, fmt.Errorf("Invalid playfield size %dx%d", width, height)
+	}
+
+	if width > MAX_PLAYFIELD_CELLS/height {
+		return 0, fmt.Errorf("Playfield size %dx%d has more than %d cells", width, height, MAX_PLAYFIELD_CELLS)
+	}
+
+	return width * height, nil
+}
+
+func (field *Playfield) IsBounded() bool {
+	return field.sparse == nil
+}
+
+// Get gives the cell at x and y, or zero if it is off a bounded playfield.
+func (field *Playfield) Get(x, y int64) uint64 {
+	if !field.IsBounded() {
+		val, ok := field.sparse[point{x, y}]
+
+		if !ok {
+			return ' '
+		}
+
+		return val
+	}
+
+	index, ok := field.index(x, y)
+
+	if !ok {
+		return 0
+	}
+
+	return field.cells[index]
+}
+
+// Put changes the cell at x and y, unless it is off a bounded playfield.  An
+// unbounded playfield charges its cells to the meter as they are written.
+func (field *Playfield) Put(x, y int64, val uint64) error {
+	if !field.IsBounded() {
+		return field.putSparse(point{x, y}, val)
+	}
+
+	index, ok := field.index(x, y)
+
+	if ok {
+		field.cells[index] = val
+	}
+
+	return nil
+}
+
+func (field *Playfield) putSparse(at point, val uint64) error {
+	_, ok := field.sparse[at]
+
+	if val == ' ' {
+		if ok {
+			delete(field.sparse, at)
+			field.meter.Free(__CELL_BYTES)
+		}
+
+		return nil
+	}
+
+	if !ok {
+		err := field.meter.Allocate(__CELL_BYTES)
+
+		if err != nil {
+			return err
+		}
+
+		field.grow(at)
+	}
+
+	field.sparse[at] = val
+
+	return nil
+}
+
+// grow stretches the bounds to include a new cell.  They never shrink.
+func (field *Playfield) grow(at point) {
+	if len(field.sparse) == 0 {
+		field.least, field.greatest = at, at
+		return
+	}
+
+	field.least.x = min64(field.least.x, at.x)
+	field.least.y = min64(field.least.y, at.y)
+	field.greatest.x = max64(field.greatest.x, at.x)
+	field.greatest.y = max64(field.greatest.y, at.y)
+}
+
+// Bounds gives the least and greatest corners of the playfield.  Those of an
+// unbounded playfield hold every cell that has been written with other than a
+// space.
+func (field *Playfield) Bounds() (leastX, leastY, greatestX, greatestY int64) {
+	if field.IsBounded() {
+		return 0, 0, int64(field.width) - 1, int64(field.height) - 1
+	}
+
+	return field.least.x, field.least.y, field.greatest.x, field.greatest.y
+}
+
+func (field *Playfield) inBounds(x, y int64) bool {
+	if !field.IsBounded() && len(field.sparse) == 0 {
+		return false
+	}
+
+	leastX, leastY, greatestX, greatestY := field.Bounds()
+
+	return x >= leastX && y >= leastY && x <= greatestX && y <= greatestY
+}
+
+func (field *Playfield) index(x, y int64) (int, bool) {
+	if x < 0 || y < 0 || x >= int64(field.width) || y >= int64(field.height) {
+		return 0, false
+	}
+
+	return int(y)*field.width + int(x), true
+}
+
+// Current gives the cell under the instruction pointer.
+func (field *Playfield) Current() uint64 {
+	return field.Get(field.x, field.y)
+}
+
+// Step moves the instruction pointer one cell.  Leaving the bounds of an
+// unbounded playfield, the pointer goes back along its path to the far side.
+// It travels on if it started out of bounds, so that it may come back.
+func (field *Playfield) Step() {
+	if field.IsBounded() {
+		field.x = wrap(field.x+field.dx, int64(field.width))
+		field.y = wrap(field.y+field.dy, int64(field.height))
+		return
+	}
+
+	if field.dx == 0 && field.dy == 0 {
+		return
+	}
+
+	x, y := field.x+field.dx, field.y+field.dy
+
+	if !field.inBounds(x, y) && field.inBounds(field.x, field.y) {
+		x, y = field.x, field.y
+
+		for field.inBounds(x-field.dx, y-field.dy) {
+			x, y = x-field.dx, y-field.dy
+		}
+	}
+
+	field.x, field.y = x, y
+}
+
+// Skip moves the instruction pointer over spaces, and over comments between
+// semicolons if comments is set, to the next instruction.  It fails if there
+// is none in the pointer's path.  After a few steps over an unbounded
+// playfield, it looks for the instruction among the cells on the pointer's
+// line rather than stepping over every space.
+func (field *Playfield) Skip(comments bool) error {
+	limit := field.skipLimit()
+	inComment := false
+
+	for steps := uint64(0); ; steps++ {
+		if steps > limit {
+			return ErrNoInstruction
+		}
+
+		if !field.IsBounded() && steps == __SKIP_STEPS {
+			return field.skipSparse(comments, inComment)
+		}
+
+		chr := field.Current()
+
+		if comments && chr == ';' {
+			inComment = !inComment
+		} else if chr != ' ' && !inComment {
+			return nil
+		}
+
+		field.Step()
+	}
+}
+
+// skipSparse finds the next instruction from the cells of an unbounded
+// playfield that lie on the pointer's path, beginning with the current cell.
+// Within the bounds, the pointer goes round the path, so the cells are put in
+// the order the pointer meets them, and passed over twice in case it enters
+// the second time within a comment.
+func (field *Playfield) skipSparse(comments, inComment bool) error {
+	first, last, ok := field.pathInBounds()
+
+	if !ok || last.Sign() < 0 {
+		return ErrNoInstruction
+	}
+
+	// The pointer meets the cells from start, where it is or where it reaches
+	// the bounds, to the last, and then from the first.
+	start := new(big.Int)
+
+	if first.Sign() > 0 {
+		start.Set(first)
+	}
+
+	period := new(big.Int).Sub(last, first)
+	period.Add(period, big.NewInt(1))
+	path := []pathCell{}
+
+	for at := range field.sparse {
+		k, onPath := field.stepsTo(at)
+
+		if !onPath {
+			continue
+		}
+
+		order := new(big.Int).Sub(k, start)
+		order.Mod(order, period)
+		path = append(path, pathCell{at: at, order: order})
+	}
+
+	sort.Slice(path, func(i, j int) bool {
+		return path[i].order.Cmp(path[j].order) < 0
+	})
+
+	for pass := 0; pass < 2; pass++ {
+		for _, cell := range path {
+			if comments && field.sparse[cell.at] == ';' {
+				inComment = !inComment
+			} else if !inComment {
+				field.x, field.y = cell.at.x, cell.at.y
+				return nil
+			}
+		}
+	}
+
+	return ErrNoInstruction
+}
+
+// pathCell is a cell on the pointer's path, with the number of steps after
+// the pointer first meets the bounds that it meets the cell.
+type pathCell struct {
+	at    point
+	order *big.Int
+}
+
+// stepsTo gives the number of steps along the pointer's line, ahead or
+// behind, to the cell at, and false if the line misses it.
+func (field *Playfield) stepsTo(at point) (*big.Int, bool) {
+	if field.dx == 0 {
+		k, ok := stepsAlong(field.y, field.dy, at.y)
+
+		return k, ok && at.x == field.x
+	}
+
+	k, ok := stepsAlong(field.x, field.dx, at.x)
+
+	if !ok {
+		return nil, false
+	}
+
+	y := new(big.Int).Mul(k, big.NewInt(field.dy))
+	y.Add(y, big.NewInt(field.y))
+
+	return k, y.Cmp(big.NewInt(at.y)) == 0
+}
+
+// stepsAlong gives k such that from + k*delta is to, and false if there is
+// none.  Delta is not zero.
+func stepsAlong(from, delta, to int64) (*big.Int, bool) {
+	k := new(big.Int).Sub(big.NewInt(to), big.NewInt(from))
+	remainder := new(big.Int)
+	k.QuoRem(k, big.NewInt(delta), remainder)
+
+	return k, remainder.Sign() == 0
+}
+
+// skipLimit is enough steps for the instruction pointer to reach the bounds
+// and cross them.
+func (field *Playfield) skipLimit() uint64 {
+	if !field.IsBounded() && len(field.sparse) == 0 {
+		return 0
+	}
+
+	leastX, leastY, greatestX, greatestY := field.Bounds()
+	area := uint64(greatestX-leastX+1) * uint64(greatestY-leastY+1)
+
+	return area + distance(field.x, leastX, greatestX) + distance(field.y, leastY, greatestY)
+}
+
+func distance(coordinate, least, greatest int64) uint64 {
+	if coordinate < least {
+		return uint64(least - coordinate)
+	}
+
+	if coordinate > greatest {
+		return uint64(coordinate - greatest)
+	}
+
+	return 0
+}
+
+// Jump steps the instruction pointer n times, backwards if n is negative.
+// The pointer goes round the same path once it is within the bounds, so it
+// takes only the steps left over from whole trips round the path.
+func (field *Playfield) Jump(n int64) {
+	if n < 0 {
+		field.Turn(asm.PLAYFIELD_REVERSE)
+		defer field.Turn(asm.PLAYFIELD_REVERSE)
+	}
+
+	steps := new(big.Int).SetUint64(magnitude(n))
+
+	if steps.Sign() == 0 {
+		return
+	}
+
+	if field.IsBounded() {
+		field.x = wrap(field.x+stride(steps, field.dx, field.width), int64(field.width))
+		field.y = wrap(field.y+stride(steps, field.dy, field.height), int64(field.height))
+		return
+	}
+
+	first, last, ok := field.pathInBounds()
+
+	// The pointer travels on until it reaches the bounds, if they lie ahead.
+	if ok && last.Sign() >= 0 && first.Sign() > 0 {
+		if steps.Cmp(first) < 0 {
+			field.advance(steps)
+			return
+		}
+
+		steps.Sub(steps, first)
+		last.Sub(last, first)
+		field.advance(first)
+		first.SetInt64(0)
+	}
+
+	// Within the bounds, it goes from the first step of its path to the last
+	// and back to the first.
+	if ok && first.Sign() <= 0 && last.Sign() >= 0 {
+		period := new(big.Int).Sub(last, first)
+		period.Add(period, big.NewInt(1))
+		steps.Sub(steps, first)
+		steps.Mod(steps, period)
+		steps.Add(steps, first)
+	}
+
+	field.advance(steps)
+}
+
+// pathInBounds gives the first and last number of steps along the line of the
+// instruction pointer, ahead or behind, that land within the bounds of an
+// unbounded playfield.  It gives false if none do.
+func (field *Playfield) pathInBounds() (first, last *big.Int, ok bool) {
+	if len(field.sparse) == 0 || (field.dx == 0 && field.dy == 0) {
+		return nil, nil, false
+	}
+
+	leastX, leastY, greatestX, greatestY := field.Bounds()
+	first, last, ok = stepsInRange(field.x, field.dx, leastX, greatestX)
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	firstY, lastY, ok := stepsInRange(field.y, field.dy, leastY, greatestY)
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	if first == nil || (firstY != nil && firstY.Cmp(first) > 0) {
+		first = firstY
+	}
+
+	if last == nil || (lastY != nil && lastY.Cmp(last) < 0) {
+		last = lastY
+	}
+
+	return first, last, first.Cmp(last) <= 0
+}
+
+// stepsInRange gives the least and greatest k for which coordinate + k*delta
+// lies from least to greatest.  If delta is zero, they are nil, and it gives
+// false if the coordinate is out of range.
+func stepsInRange(coordinate, delta, least, greatest int64) (first, last *big.Int, ok bool) {
+	if delta == 0 {
+		return nil, nil, coordinate >= least && coordinate <= greatest
+	}
+
+	c, d := big.NewInt(coordinate), big.NewInt(delta)
+	low, high := big.NewInt(least), big.NewInt(greatest)
+
+	// Heading backwards is heading forwards on the mirrored line.
+	if delta < 0 {
+		c.Neg(c)
+		d.Neg(d)
+		low, high = new(big.Int).Neg(high), new(big.Int).Neg(low)
+	}
+
+	// first is the ceiling of (low - c) / d, and last the floor of
+	// (high - c) / d.  Euclidean division floors for positive d.
+	first = new(big.Int).Sub(c, low)
+	first.Div(first, d)
+	first.Neg(first)
+	last = new(big.Int).Sub(high, c)
+	last.Div(last, d)
+
+	return first, last, true
+}
+
+// advance moves the instruction pointer straight on by steps, which may be
+// negative, wrapping like int64 arithmetic.
+func (field *Playfield) advance(steps *big.Int) {
+	mask := new(big.Int).SetUint64(^uint64(0))
+	k := int64(new(big.Int).And(steps, mask).Uint64())
+	field.x += k * field.dx
+	field.y += k * field.dy
+}
+
+// stride gives the movement of steps of delta, modulo size.
+func stride(steps *big.Int, delta int64, size int) int64 {
+	remainder := new(big.Int).Mod(steps, big.NewInt(int64(size))).Int64()
+
+	return remainder * wrap(delta, int64(size)) % int64(size)
+}
+
+func wrap(coordinate, size int64) int64 {
+	coordinate %= size
+
+	if coordinate < 0 {
+		coordinate += size
+	}
+
+	return coordinate
+}
+
+// Turn points the instruction pointer in one of the asm.PLAYFIELD directions.
+func (field *Playfield) Turn(direction int) error {
+	if direction == asm.PLAYFIELD_RANDOM {
+		direction = rand.Intn(asm.PLAYFIELD_RANDOM)
+	}
+
+	switch direction {
+	case asm.PLAYFIELD_EAST:
+		field.dx, field.dy = 1, 0
+	case asm.PLAYFIELD_SOUTH:
+		field.dx, field.dy = 0, 1
+	case asm.PLAYFIELD_WEST:
+		field.dx, field.dy = -1, 0
+	case asm.PLAYFIELD_NORTH:
+		field.dx, field.dy = 0, -1
+	case asm.PLAYFIELD_LEFT:
+		field.dx, field.dy = field.dy, -field.dx
+	case asm.PLAYFIELD_RIGHT:
+		field.dx, field.dy = -field.dy, field.dx
+	case asm.PLAYFIELD_REVERSE:
+		field.dx, field.dy = -field.dx, -field.dy
+	default:
+		return fmt.Errorf("Unknown direction %d", direction)
+	}
+
+	return nil
+}
+
+// Pointer gives the position of the instruction pointer.
+func (field *Playfield) Pointer() (x, y int64) {
+	return field.x, field.y
+}
+
+// MoveTo puts the instruction pointer at x and y.
+func (field *Playfield) MoveTo(x, y int64) {
+	field.x, field.y = x, y
+}
+
+// Delta gives the movement of the instruction pointer on each step.
+func (field *Playfield) Delta() (dx, dy int64) {
+	return field.dx, field.dy
+}
+
+// SetDelta changes the movement of the instruction pointer, as Funge-98's x
+// does.
+func (field *Playfield) SetDelta(dx, dy int64) {
+	field.dx, field.dy = dx, dy
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+type playfieldList struct {
+	list []*Playfield
+}
+
+func (fields *playfieldList) getPlayfield(index uint64) (*Playfield, error) {
+	if index >= uint64(len(fields.list)) {
+		return nil, fmt.Errorf("No playfield at index %d", index)
+	}
+
+	return fields.list[index], nil
+}
+
+// PlayfieldVmWrapper exposes playfields to the VM.  Each VmFunction but
+// playfield_new takes the playfield index and then its arguments from the
+// stack.  The playfields are kept in the Runtime.
+type PlayfieldVmWrapper struct{}
+
+// playfieldKey keeps the playfields in the Runtime.  The wrapper has no size,
+// so pointers to it are not distinct keys.
+type playfieldKey struct{}
+
+func (wrapper *PlayfieldVmWrapper) playfields(runtime *Runtime) *playfieldList {
+	return runtime.State(playfieldKey{}, func() interface{} {
+		return &playfieldList{}
+	}).(*playfieldList)
+}
+
+// NewPlayfield takes the width and then the height, and charges every cell to
+// the process memory.  Zero for both gives an unbounded playfield.
+func (wrapper *PlayfieldVmWrapper) NewPlayfield(runtime *Runtime, stackAddr Address) {
+	const errMsg = "playfield_new failed"
+
+	runtime.Process.Pop(stackAddr)
+	width := runtime.Process.Pop(stackAddr)
+	height := runtime.Process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	fields := wrapper.playfields(runtime)
+	meter := MemoryMeter{
+		Account:  runtime.Process.Memory,
+		Resource: Resource{Kind: RESOURCE_PLAYFIELD, Index: uint64(len(fields.list))},
+	}
+
+	// The cells are charged before they are made, so that a playfield too
+	// large for the quota is never allocated.
+	var field *Playfield
+	count, err := playfieldCells(int(width), int(height))
+
+	if err == nil {
+		err = meter.Allocate(uint64(count) * __CELL_BYTES)
+	}
+
+	if err == nil {
+		field, err = MakePlayfield(int(width), int(height))
+	}
+
+	if err != nil {
+		runtime.Process.Error = errors.Wrap(err, errMsg)
+		return
+	}
+
+	field.meter = meter
+
+	fields.list = append(fields.list, field)
+	runtime.Process.Push(stackAddr, uint64(len(fields.list)-1))
+
+	if runtime.hasError() {
+		return
+	}
+
+	runtime.Process.IncrementPC()
+}
+
+// Get takes x and then y, and pushes the cell.
+func (wrapper *PlayfieldVmWrapper) Get(runtime *Runtime, stackAddr Address) {
+	const errMsg = "playfield_get failed"
+
+	wrapper.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		x := runtime.Process.Pop(stackAddr)
+		y := runtime.Process.Pop(stackAddr)
+		runtime.Process.Push(stackAddr, field.Get(int64(x), int64(y)))
+	})
+}
+
+// Put takes x, y and then the value, as Befunge's p does.
+func (wrapper *PlayfieldVmWrapper) Put(runtime *Runtime, stackAddr Address) {
+	const errMsg = "playfield_put failed"
+
+	wrapper.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		x := runtime.Process.Pop(stackAddr)
+		y := runtime.Process.Pop(stackAddr)
+		val := runtime.Process.Pop(stackAddr)
+
+		if runtime.hasError() {
+			return
+		}
+
+		err := field.Put(int64(x), int64(y), val)
+
+		if err != nil {
+			runtime.Process.Error = err
+		}
+	})
+}
+
+// Current pushes the cell under the instruction pointer.
+func (wrapper *PlayfieldVmWrapper) Current(runtime *Runtime, stackAddr Address) {
+	const errMsg = "playfield_current failed"
+
+	wrapper.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		runtime.Process.Push(stackAddr, field.Current())
+	})
+}
+
+func (wrapper *PlayfieldVmWrapper) Step(runtime *Runtime, stackAddr Address) {
+	const errMsg = "playfield_step failed"
+
+	wrapper.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		field.Step()
+	})
+}
+
+// Turn takes one of the asm.PLAYFIELD directions.
+func (wrapper *PlayfieldVmWrapper) Turn(runtime *Runtime, stackAddr Address) {
+	const errMsg = "playfield_turn failed"
+
+	wrapper.withPlayfield(runtime, stackAddr, errMsg, func(field *Playfield) {
+		direction := runtime.Process.Pop(stackAddr)
+
+		if runtime.hasError() {
+			return
+		}
+
+		err := field.Turn(int(direction))
+
+		if err != nil {
+			runtime.Process.Error = err
+		}
+	})
+}
+
+// withPlayfield pops the return address and the playfield index, then applies
+// f to the playfield.
+func (wrapper *PlayfieldVmWrapper) withPlayfield(runtime *Runtime, stackAddr Address, errMsg string, f func(field *Playfield)) {
+	runtime.Process.Pop(stackAddr)
+	index := runtime.Process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	field, err := wrapper.playfields(runtime).getPlayfield(index)
+
+	if err != nil {
+		runtime.Process.Error = errors.Wrap(err, errMsg)
+		return
+	}
+
+	f(field)
+
+	if runtime.hasError() {
+		runtime.Process.Error = errors.Wrap(runtime.Process.Error, errMsg)
+		return
+	}
+
+	runtime.Process.IncrementPC()
+}
+
+// PlayfieldLibrary registers the playfield VmFunctions.
+func PlayfieldLibrary() *Library {
+	wrapper := &PlayfieldVmWrapper{}
+	lib := &Library{}
+	lib.AddFunction(asm.PLAYFIELD_NEW, wrapper.NewPlayfield)
+	lib.AddFunction(asm.PLAYFIELD_GET, wrapper.Get)
+	lib.AddFunction(asm.PLAYFIELD_PUT, wrapper.Put)
+	lib.AddFunction(asm.PLAYFIELD_CURRENT, wrapper.Current)
+	lib.AddFunction(asm.PLAYFIELD_STEP, wrapper.Step)
+	lib.AddFunction(asm.PLAYFIELD_TURN, wrapper.Turn)
+
+	return lib
+}
+
+var ErrNoInstruction = errors.New("No instruction in the path of the instruction pointer")
+
+// Skip takes this many steps over an unbounded playfield before looking for
+// the next instruction among its cells, since most are only a few steps away.
+const __SKIP_STEPS = 64
+
+// MAX_PLAYFIELD_CELLS limits the size of a bounded playfield, so that a huge
+// size fails without a memory quota.
+const MAX_PLAYFIELD_CELLS = 1 << 30
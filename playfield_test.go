@@ -0,0 +1,370 @@
+package shapes
+
+import (
+	"math"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+func TestPlayfieldStep(t *testing.T) {
+	field, err := MakePlayfield(3, 2)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	testCases := []struct {
+		direction int
+		x         int64
+		y         int64
+	}{
+		{direction: asm.PLAYFIELD_EAST, x: 1, y: 0},
+		{direction: asm.PLAYFIELD_EAST, x: 2, y: 0},
+		{direction: asm.PLAYFIELD_EAST, x: 0, y: 0},
+		{direction: asm.PLAYFIELD_WEST, x: 2, y: 0},
+		{direction: asm.PLAYFIELD_NORTH, x: 2, y: 1},
+		{direction: asm.PLAYFIELD_SOUTH, x: 2, y: 0},
+	}
+
+	for i, test := range testCases {
+		err := field.Turn(test.direction)
+
+		if err != nil {
+			t.Fatalf("Test case %d: unexpected error: %s", i, err.Error())
+		}
+
+		field.Step()
+		x, y := field.Pointer()
+
+		if x != test.x || y != test.y {
+			t.Errorf("Test case %d: expected (%d, %d) but was (%d, %d)", i, test.x, test.y, x, y)
+		}
+	}
+
+	if field.Turn(asm.PLAYFIELD_RANDOM) != nil {
+		t.Error("Expected random turn")
+	}
+
+	if field.Turn(asm.PLAYFIELD_REVERSE+1) == nil {
+		t.Error("Expected error for unknown direction")
+	}
+}
+
+func TestPlayfieldGetPut(t *testing.T) {
+	field, err := MakePlayfield(3, 2)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if field.Current() != ' ' {
+		t.Errorf("Expected space but was %d", field.Current())
+	}
+
+	field.Put(0, 0, '@')
+	field.Put(2, 1, 7)
+	field.Put(3, 0, 8)
+	field.Put(-1, 0, 8)
+
+	testCases := []struct {
+		x        int64
+		y        int64
+		expected uint64
+	}{
+		{x: 0, y: 0, expected: '@'},
+		{x: 2, y: 1, expected: 7},
+		{x: 1, y: 1, expected: ' '},
+		{x: 3, y: 0, expected: 0},
+		{x: 0, y: -1, expected: 0},
+	}
+
+	for i, test := range testCases {
+		if actual := field.Get(test.x, test.y); actual != test.expected {
+			t.Errorf("Test case %d: expected %d but was %d", i, test.expected, actual)
+		}
+	}
+
+	if field.Current() != '@' {
+		t.Errorf("Expected '@' but was %d", field.Current())
+	}
+
+	_, err = MakePlayfield(0, 2)
+
+	if err == nil {
+		t.Error("Expected error for empty playfield")
+	}
+
+	_, err = MakePlayfield(3037000500, 3037000500)
+
+	if err == nil {
+		t.Error("Expected error for huge playfield")
+	}
+}
+
+func TestPlayfieldVmWrapper_NewPlayfieldLimit(t *testing.T) {
+	testCases := []struct {
+		width  uint64
+		height uint64
+		limit  uint64
+		quota  bool
+	}{
+		{3037000500, 3037000500, 1000, false},
+		{^uint64(0), 1, 0, false},
+		{MAX_PLAYFIELD_CELLS, 2, 0, false},
+		{100, 100, 1000, true},
+	}
+
+	for i, test := range testCases {
+		builder := &RuntimeBuilder{Process: &Process{}, MemoryLimit: test.limit}
+		runtime := builder.Build()
+		pushArgs(runtime.Process, test.width, test.height)
+		wrapper := &PlayfieldVmWrapper{}
+		wrapper.NewPlayfield(runtime, 0)
+
+		if runtime.Process.Error == nil {
+			t.Errorf("Expected error in case %d", i)
+			continue
+		}
+
+		if _, ok := errors.Cause(runtime.Process.Error).(*QuotaError); ok != test.quota {
+			t.Errorf("Unexpected error in case %d: %v", i, runtime.Process.Error)
+		}
+	}
+}
+
+func TestPlayfieldLaheySpace(t *testing.T) {
+	field, err := MakePlayfield(0, 0)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if empty, _ := MakePlayfield(0, 0); empty.Skip(true) == nil {
+		t.Error("Expected error for empty playfield")
+	}
+
+	field.Put(0, 0, '>')
+	field.Put(-2, 0, '<')
+	field.Put(3, 2, 'v')
+	field.Put(3, 2, ' ')
+
+	if field.Get(3, 2) != ' ' || field.Get(100, -100) != ' ' || field.Get(-2, 0) != '<' {
+		t.Error("Unexpected cells")
+	}
+
+	leastX, leastY, greatestX, greatestY := field.Bounds()
+
+	if leastX != -2 || leastY != 0 || greatestX != 3 || greatestY != 2 {
+		t.Errorf("Unexpected bounds (%d, %d) to (%d, %d)", leastX, leastY, greatestX, greatestY)
+	}
+
+	testCases := []struct {
+		direction int
+		x         int64
+		y         int64
+	}{
+		{direction: asm.PLAYFIELD_EAST, x: 1, y: 0},
+		{direction: asm.PLAYFIELD_EAST, x: 2, y: 0},
+		{direction: asm.PLAYFIELD_EAST, x: 3, y: 0},
+		{direction: asm.PLAYFIELD_EAST, x: -2, y: 0},
+		{direction: asm.PLAYFIELD_RIGHT, x: -2, y: 1},
+		{direction: asm.PLAYFIELD_REVERSE, x: -2, y: 0},
+		{direction: asm.PLAYFIELD_SOUTH, x: -2, y: 1},
+		{direction: asm.PLAYFIELD_LEFT, x: -1, y: 1},
+		{direction: asm.PLAYFIELD_LEFT, x: -1, y: 0},
+		{direction: asm.PLAYFIELD_NORTH, x: -1, y: 2},
+	}
+
+	for i, test := range testCases {
+		err := field.Turn(test.direction)
+
+		if err != nil {
+			t.Fatalf("Test case %d: unexpected error: %s", i, err.Error())
+		}
+
+		field.Step()
+		x, y := field.Pointer()
+
+		if x != test.x || y != test.y {
+			t.Errorf("Test case %d: expected (%d, %d) but was (%d, %d)", i, test.x, test.y, x, y)
+		}
+	}
+
+	field.MoveTo(1, 0)
+	field.SetDelta(1, 0)
+	field.Jump(-4)
+
+	if x, y := field.Pointer(); x != 3 || y != 0 {
+		t.Errorf("Expected jump to (3, 0) but was (%d, %d)", x, y)
+	}
+
+	field.Put(1, 0, ';')
+	field.Put(2, 0, '@')
+	field.Put(3, 0, ';')
+	field.MoveTo(1, 0)
+
+	if field.Skip(true) != nil || field.Current() != '<' {
+		t.Errorf("Expected to skip the comment to '<' but was at %d", field.Current())
+	}
+
+	field.MoveTo(1, 0)
+
+	if field.Skip(false) != nil || field.Current() != ';' {
+		t.Errorf("Expected to stop at ';' but was at %d", field.Current())
+	}
+}
+
+func TestPlayfieldSkip_Sparse(t *testing.T) {
+	field, _ := MakePlayfield(0, 0)
+	cells := map[point]uint64{
+		{0, 0}: 'a', {3, 0}: ';', {5, 0}: 'b', {-1, 0}: ';',
+		{2, 2}: 'c', {4, 4}: ';', {-3, 1}: 'd', {0, 3}: ';',
+	}
+
+	for at, val := range cells {
+		field.Put(at.x, at.y, val)
+	}
+
+	starts := [][2]int64{{0, 0}, {4, 0}, {1, 1}, {-9, 0}, {8, 3}, {0, -5}}
+	deltas := [][2]int64{{1, 0}, {-1, 0}, {0, 1}, {1, 1}, {-2, -1}, {3, 0}}
+
+	// Looking among the cells agrees with stepping over them.
+	for _, comments := range []bool{true, false} {
+		for _, start := range starts {
+			for _, delta := range deltas {
+				for _, inComment := range []bool{false, true} {
+					field.SetDelta(delta[0], delta[1])
+					field.MoveTo(start[0], start[1])
+					err := field.skipSparse(comments, inComment)
+					x, y := field.Pointer()
+
+					field.MoveTo(start[0], start[1])
+					expectErr := skipByStepping(field, comments, inComment)
+					expectX, expectY := field.Pointer()
+
+					if (err == nil) != (expectErr == nil) || (err == nil && (x != expectX || y != expectY)) {
+						t.Errorf("Skip from %v by %v: expected (%d, %d), %v but was (%d, %d), %v", start, delta, expectX, expectY, expectErr, x, y, err)
+					}
+				}
+			}
+		}
+	}
+
+	// A far cell is found without stepping over the spaces between.
+	field.Put(1000000000000, 0, '@')
+	field.MoveTo(6, 0)
+	field.SetDelta(1, 0)
+
+	if err := field.Skip(true); err != nil || field.Current() != '@' {
+		t.Errorf("Expected to skip to '@' but was at %d: %v", field.Current(), err)
+	}
+}
+
+func skipByStepping(field *Playfield, comments, inComment bool) error {
+	for steps := 0; steps < 1000; steps++ {
+		chr := field.Current()
+
+		if comments && chr == ';' {
+			inComment = !inComment
+		} else if chr != ' ' && !inComment {
+			return nil
+		}
+
+		field.Step()
+	}
+
+	return ErrNoInstruction
+}
+
+func TestPlayfieldJump(t *testing.T) {
+	bounded, _ := MakePlayfield(5, 3)
+	lahey, _ := MakePlayfield(0, 0)
+	lahey.Put(-2, 1, '<')
+	lahey.Put(4, 3, '>')
+	empty, _ := MakePlayfield(0, 0)
+
+	starts := [][2]int64{{0, 1}, {3, 2}, {-6, 1}, {9, 9}, {-2, -4}}
+	deltas := [][2]int64{{1, 0}, {0, -1}, {2, 1}, {-3, 2}, {0, 0}}
+
+	// Jump agrees with stepping n times.
+	for _, field := range []*Playfield{bounded, lahey, empty} {
+		for _, start := range starts {
+			for _, delta := range deltas {
+				for n := int64(-20); n <= 20; n++ {
+					field.MoveTo(start[0], start[1])
+					field.SetDelta(delta[0], delta[1])
+					field.Jump(n)
+					x, y := field.Pointer()
+
+					field.MoveTo(start[0], start[1])
+					field.SetDelta(-delta[0], -delta[1])
+
+					if n >= 0 {
+						field.SetDelta(delta[0], delta[1])
+					}
+
+					for i := int64(0); i < n || i < -n; i++ {
+						field.Step()
+					}
+
+					if expectX, expectY := field.Pointer(); x != expectX || y != expectY {
+						t.Errorf("Jump(%d) from %v by %v: expected (%d, %d) but was (%d, %d)", n, start, delta, expectX, expectY, x, y)
+					}
+				}
+			}
+		}
+	}
+
+	// Huge counts go round the path rather than taking every step.
+	bounded.MoveTo(0, 0)
+	bounded.SetDelta(1, 1)
+	bounded.Jump(math.MaxInt64)
+
+	if x, y := bounded.Pointer(); x != math.MaxInt64%5 || y != math.MaxInt64%3 {
+		t.Errorf("Expected huge jump to (%d, %d) but was (%d, %d)", math.MaxInt64%5, math.MaxInt64%3, x, y)
+	}
+
+	// The pointer reaches the bounds at x = -2 after 4 steps, and then goes
+	// round the 7 cells from -2 to 4.
+	lahey.MoveTo(-6, 1)
+	lahey.SetDelta(1, 0)
+	lahey.Jump(math.MaxInt64)
+	expectX := -2 + (math.MaxInt64-4)%7
+
+	if x, y := lahey.Pointer(); x != int64(expectX) || y != 1 {
+		t.Errorf("Expected huge jump to (%d, 1) but was (%d, %d)", expectX, x, y)
+	}
+}
+
+func TestPlayfieldVmWrapper_MemoryLimit(t *testing.T) {
+	wrapper := &PlayfieldVmWrapper{}
+	runtime := (&RuntimeBuilder{Process: &Process{}}).Build()
+	process := runtime.Process
+
+	pushArgs(process, 0, 0)
+	wrapper.NewPlayfield(runtime, 0)
+	index := process.Pop(0)
+	pushArgs(process, index, 0, 0, 'a')
+	wrapper.Put(runtime, 0)
+
+	if process.Error != nil {
+		t.Fatalf("Unexpected error: %s", process.Error.Error())
+	}
+
+	pushArgs(process, index, 1, 0, 'b')
+	process.Memory.Limit = process.Memory.Used() - 5*__CELL_BYTES
+	wrapper.Put(runtime, 0)
+
+	quota, ok := errors.Cause(process.Error).(*QuotaError)
+
+	if !ok {
+		t.Fatalf("Expected quota error but received %v", process.Error)
+	}
+
+	if quota.Resource.Kind != RESOURCE_PLAYFIELD {
+		t.Errorf("Expected quota exceeded by playfield but was %v", quota.Resource)
+	}
+}
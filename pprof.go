@@ -0,0 +1,283 @@
+package shapes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// WritePprof writes the profile in the gzipped protocol buffer format read by
+// go tool pprof.  Each sample is a call stack of procedures, with the loops
+// enclosing each address shown as inlined functions.
+func (profiler *Profiler) WritePprof(w io.Writer) error {
+	const errMsg = "WritePprof failed"
+
+	encoder := &pprofEncoder{
+		profiler:  profiler,
+		strings:   map[string]uint64{},
+		functions: map[pprofFunction]uint64{},
+		locations: map[pprofLocation]uint64{},
+	}
+	encoder.intern("")
+
+	top := make([]uint64, len(profiler.counts))
+	copy(top, profiler.counts)
+
+	for _, key := range sortedStackKeys(profiler.stacks) {
+		sample := profiler.stacks[key]
+		top[sample.frames[0]] -= sample.count
+		encoder.sample(sample.frames, sample.count)
+	}
+
+	for pc, count := range top {
+		if count > 0 {
+			encoder.sample([]Address{Address(pc)}, count)
+		}
+	}
+
+	zipper := gzip.NewWriter(w)
+	_, err := zipper.Write(encoder.profile())
+
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	err = zipper.Close()
+
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	return nil
+}
+
+type pprofFunction struct {
+	name string
+	file string
+}
+
+// A pprofLocation is an address within the procedure entered at function.
+type pprofLocation struct {
+	address  Address
+	function Address
+	isMain   bool
+}
+
+type pprofEncoder struct {
+	profiler    *Profiler
+	strings     map[string]uint64
+	stringTable []string
+	functions   map[pprofFunction]uint64
+	locations   map[pprofLocation]uint64
+	samples     protoBuffer
+	body        protoBuffer
+}
+
+func (encoder *pprofEncoder) intern(text string) uint64 {
+	index, ok := encoder.strings[text]
+
+	if !ok {
+		index = uint64(len(encoder.stringTable))
+		encoder.strings[text] = index
+		encoder.stringTable = append(encoder.stringTable, text)
+	}
+
+	return index
+}
+
+// sample adds a sample whose frames run from the innermost out.  The caller of
+// each frame is a CALLSUB, so its target is the procedure of the frame.
+func (encoder *pprofEncoder) sample(frames []Address, count uint64) {
+	locationIds := make([]uint64, len(frames))
+
+	for i, address := range frames {
+		location := pprofLocation{address: address, isMain: true}
+
+		if i+1 < len(frames) {
+			callSite := encoder.profiler.process.ByteCode[frames[i+1]]
+			location.function = callSite.Address(1)
+			location.isMain = false
+		}
+
+		locationIds[i] = encoder.location(location)
+	}
+
+	sample := protoBuffer{}
+	sample.packed(1, locationIds)
+	sample.packed(2, []uint64{count})
+	encoder.samples.message(2, &sample)
+}
+
+func (encoder *pprofEncoder) location(location pprofLocation) uint64 {
+	id, ok := encoder.locations[location]
+
+	if ok {
+		return id
+	}
+
+	id = uint64(len(encoder.locations) + 1)
+	encoder.locations[location] = id
+
+	profiler := encoder.profiler
+	message := protoBuffer{}
+	message.uint(1, id)
+	message.uint(3, uint64(location.address))
+
+	enclosing := profiler.enclosing[location.address]
+
+	// Innermost loop first, with the procedure last as the caller into which
+	// the loops are inlined.
+	for i := len(enclosing) - 1; i >= 0; i-- {
+		entry := enclosing[i]
+
+		if !location.isMain && Address(entry) < location.function {
+			break
+		}
+
+		line := encoder.line(profiler.loopName(entry), Address(entry), location.address)
+		message.message(4, &line)
+	}
+
+	name := "main"
+	entry := Address(0)
+
+	if !location.isMain {
+		name = profiler.procedureName(location.function)
+		entry = location.function
+	}
+
+	line := encoder.line(name, entry, location.address)
+	message.message(4, &line)
+	encoder.body.message(4, &message)
+
+	return id
+}
+
+// line locates address within the function that starts at entry.  Line
+// numbers are taken from source positions, or else the address.
+func (encoder *pprofEncoder) line(name string, entry, address Address) protoBuffer {
+	process := encoder.profiler.process
+	function := pprofFunction{name: name, file: process.Position(entry).File}
+	lineNumber := uint64(address)
+
+	if pos := process.Position(address); pos.IsKnown() {
+		lineNumber = uint64(pos.Line)
+	}
+
+	line := protoBuffer{}
+	line.uint(1, encoder.function(function))
+	line.uint(2, lineNumber)
+
+	return line
+}
+
+func (encoder *pprofEncoder) function(function pprofFunction) uint64 {
+	id, ok := encoder.functions[function]
+
+	if ok {
+		return id
+	}
+
+	id = uint64(len(encoder.functions) + 1)
+	encoder.functions[function] = id
+
+	message := protoBuffer{}
+	message.uint(1, id)
+	message.uint(2, encoder.intern(function.name))
+	message.uint(3, encoder.intern(function.name))
+	message.uint(4, encoder.intern(function.file))
+	encoder.body.message(5, &message)
+
+	return id
+}
+
+func (encoder *pprofEncoder) profile() []byte {
+	steps := encoder.intern("steps")
+	count := encoder.intern("count")
+
+	valueType := protoBuffer{}
+	valueType.uint(1, steps)
+	valueType.uint(2, count)
+
+	profile := protoBuffer{}
+	profile.message(1, &valueType)
+	profile.Write(encoder.samples.Bytes())
+	profile.Write(encoder.body.Bytes())
+
+	for _, text := range encoder.stringTable {
+		profile.bytes(6, []byte(text))
+	}
+
+	profile.message(11, &valueType)
+	profile.uint(12, 1)
+
+	return profile.Bytes()
+}
+
+func sortedStackKeys(stacks map[string]*stackSample) []string {
+	keys := []string{}
+
+	for key := range stacks {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// protoBuffer encodes the few protocol buffer wire types used by pprof.
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+func (buff *protoBuffer) varint(value uint64) {
+	for value >= 0x80 {
+		buff.WriteByte(byte(value) | 0x80)
+		value >>= 7
+	}
+
+	buff.WriteByte(byte(value))
+}
+
+func (buff *protoBuffer) tag(field int, wireType int) {
+	buff.varint(uint64(field<<3 | wireType))
+}
+
+// uint writes a varint field, leaving out the default of zero.
+func (buff *protoBuffer) uint(field int, value uint64) {
+	if value == 0 {
+		return
+	}
+
+	buff.tag(field, __WIRE_VARINT)
+	buff.varint(value)
+}
+
+func (buff *protoBuffer) bytes(field int, data []byte) {
+	buff.tag(field, __WIRE_BYTES)
+	buff.varint(uint64(len(data)))
+	buff.Write(data)
+}
+
+func (buff *protoBuffer) message(field int, message *protoBuffer) {
+	buff.bytes(field, message.Bytes())
+}
+
+func (buff *protoBuffer) packed(field int, values []uint64) {
+	packed := protoBuffer{}
+
+	for _, value := range values {
+		packed.varint(value)
+	}
+
+	buff.message(field, &packed)
+}
+
+const (
+	__WIRE_VARINT = 0
+	__WIRE_BYTES  = 2
+)
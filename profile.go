@@ -0,0 +1,293 @@
+package shapes
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Profiler counts the operations executed by a Runtime.  Install its Hook on
+// the RuntimeBuilder.  Besides counts per address and per VmFunction, it
+// samples the call stack whenever a subroutine is in progress.
+type Profiler struct {
+	process   *Process
+	counts    []uint64
+	functions map[string]uint64
+	// Counts of call stacks, keyed by stackKey, for steps taken inside a
+	// subroutine.  Other steps are found in counts.
+	stacks map[string]*stackSample
+	// Entry of each loop to its exit.
+	loops map[int]int
+	// Loop entries enclosing each address, outermost first.
+	enclosing [][]int
+	total     uint64
+}
+
+type stackSample struct {
+	// Addresses from the innermost frame out.
+	frames []Address
+	count  uint64
+}
+
+// MakeProfiler creates a profiler for process, whose loops are found from its
+// bytecode.
+func MakeProfiler(process *Process) *Profiler {
+	profiler := &Profiler{
+		process:   process,
+		counts:    make([]uint64, len(process.ByteCode)),
+		functions: map[string]uint64{},
+		stacks:    map[string]*stackSample{},
+		loops:     findLoops(process.ByteCode),
+		enclosing: make([][]int, len(process.ByteCode)),
+	}
+
+	for _, entry := range sortedKeys(profiler.loops) {
+		for i := entry; i < profiler.loops[entry]; i++ {
+			profiler.enclosing[i] = append(profiler.enclosing[i], entry)
+		}
+	}
+
+	return profiler
+}
+
+func findLoops(byteCode []Operation) map[int]int {
+	dis := &disassembler{
+		byteCode: byteCode,
+		loops:    map[int]int{},
+		backEdge: map[int]bool{},
+	}
+	dis.findLoops(0, len(byteCode))
+
+	return dis.loops
+}
+
+// Hook is a StepHook.
+func (profiler *Profiler) Hook(runtime *Runtime) error {
+	process := runtime.Process
+	pc := process.PC
+	op := process.ByteCode[pc]
+
+	profiler.total++
+	profiler.counts[pc]++
+
+	if op.OpCode == OP_CALL {
+		name, err := runtime.Library.GetFunctionName(int(op.Operand[0]))
+
+		if err != nil {
+			name = fmt.Sprintf("function %d", op.Operand[0])
+		}
+
+		profiler.functions[name]++
+	}
+
+	if len(process.CallStack) > 0 {
+		frames := make([]Address, 0, len(process.CallStack)+1)
+		frames = append(frames, pc)
+
+		for i := len(process.CallStack) - 1; i >= 0; i-- {
+			// The return address follows the CALLSUB.
+			frames = append(frames, process.CallStack[i]-1)
+		}
+
+		key := stackKey(frames)
+		sample, ok := profiler.stacks[key]
+
+		if !ok {
+			sample = &stackSample{frames: frames}
+			profiler.stacks[key] = sample
+		}
+
+		sample.count++
+	}
+
+	return nil
+}
+
+func stackKey(frames []Address) string {
+	parts := make([]string, len(frames))
+
+	for i, frame := range frames {
+		parts[i] = fmt.Sprint(frame)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Total is the number of steps profiled.
+func (profiler *Profiler) Total() uint64 {
+	return profiler.total
+}
+
+// Count is the number of times the operation at pc was executed.
+func (profiler *Profiler) Count(pc Address) uint64 {
+	if pc < Address(len(profiler.counts)) {
+		return profiler.counts[pc]
+	}
+
+	return 0
+}
+
+// FunctionCount is the number of calls to a VmFunction.
+func (profiler *Profiler) FunctionCount(name string) uint64 {
+	return profiler.functions[name]
+}
+
+// LoopCount is the number of steps taken inside the loop that starts at
+// entry, including nested loops.
+func (profiler *Profiler) LoopCount(entry Address) uint64 {
+	exit, ok := profiler.loops[int(entry)]
+
+	if !ok {
+		return 0
+	}
+
+	var count uint64
+
+	for i := int(entry); i < exit; i++ {
+		count += profiler.counts[i]
+	}
+
+	return count
+}
+
+// PositionCount is the number of steps taken by operations compiled from pos.
+func (profiler *Profiler) PositionCount(pos asm.SourcePos) uint64 {
+	var count uint64
+
+	for i, n := range profiler.counts {
+		if profiler.process.Position(Address(i)) == pos {
+			count += n
+		}
+	}
+
+	return count
+}
+
+type hotSpot struct {
+	name  string
+	count uint64
+}
+
+// Report writes the top hot spots by address, loop, source position and
+// VmFunction, most executed first.
+func (profiler *Profiler) Report(w io.Writer, top int) {
+	fmt.Fprintf(w, "TOTAL STEPS: %d\n", profiler.total)
+
+	addresses := []hotSpot{}
+	positions := map[asm.SourcePos]uint64{}
+
+	for i, count := range profiler.counts {
+		if count == 0 {
+			continue
+		}
+
+		pc := Address(i)
+		addresses = append(addresses, hotSpot{name: profiler.describe(pc), count: count})
+
+		if pos := profiler.process.Position(pc); pos.IsKnown() {
+			positions[pos] += count
+		}
+	}
+
+	profiler.reportSection(w, "ADDRESSES", addresses, top)
+
+	loops := []hotSpot{}
+
+	for _, entry := range sortedKeys(profiler.loops) {
+		count := profiler.LoopCount(Address(entry))
+
+		if count > 0 {
+			loops = append(loops, hotSpot{name: profiler.loopName(entry), count: count})
+		}
+	}
+
+	profiler.reportSection(w, "LOOPS", loops, top)
+
+	if len(positions) > 0 {
+		spots := []hotSpot{}
+
+		for pos, count := range positions {
+			spots = append(spots, hotSpot{name: pos.String(), count: count})
+		}
+
+		profiler.reportSection(w, "POSITIONS", spots, top)
+	}
+
+	functions := []hotSpot{}
+
+	for name, count := range profiler.functions {
+		functions = append(functions, hotSpot{name: name, count: count})
+	}
+
+	profiler.reportSection(w, "FUNCTIONS", functions, top)
+}
+
+// reportSection ranks spots by count, then by name so that reports are
+// stable.
+func (profiler *Profiler) reportSection(w io.Writer, title string, spots []hotSpot, top int) {
+	sort.Slice(spots, func(i, j int) bool {
+		if spots[i].count != spots[j].count {
+			return spots[i].count > spots[j].count
+		}
+
+		return spots[i].name < spots[j].name
+	})
+
+	if top > 0 && len(spots) > top {
+		spots = spots[:top]
+	}
+
+	fmt.Fprintf(w, "%s:\n", title)
+
+	for _, spot := range spots {
+		percent := 0.0
+
+		if profiler.total > 0 {
+			percent = 100 * float64(spot.count) / float64(profiler.total)
+		}
+
+		fmt.Fprintf(w, "%12d %6.2f%% %s\n", spot.count, percent, spot.name)
+	}
+}
+
+func (profiler *Profiler) describe(pc Address) string {
+	text := fmt.Sprintf("%d %v", pc, profiler.process.ByteCode[pc])
+
+	if pos := profiler.process.Position(pc); pos.IsKnown() {
+		text += " " + pos.String()
+	}
+
+	return text
+}
+
+func (profiler *Profiler) loopName(entry int) string {
+	if pos := profiler.process.Position(Address(entry)); pos.IsKnown() {
+		return fmt.Sprintf("loop %d %v", entry, pos)
+	}
+
+	return fmt.Sprintf("loop %d", entry)
+}
+
+// procedureName names the procedure entered at entry.
+func (profiler *Profiler) procedureName(entry Address) string {
+	if name, ok := profiler.process.Procedures[entry]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("sub %d", entry)
+}
+
+func sortedKeys(set map[int]int) []int {
+	keys := []int{}
+
+	for key := range set {
+		keys = append(keys, key)
+	}
+
+	sort.Ints(keys)
+
+	return keys
+}
@@ -0,0 +1,182 @@
+package shapes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+const profileTestSource = `set r1 5
+set r2 1
+proc spin s1 {
+	set r3 20
+	loop r3 {
+		sub r3 r2
+	}
+	ret
+}
+loop r1 {
+	callsub spin s1
+	sub r1 r2
+}
+`
+
+func runProfile(t *testing.T) *Profiler {
+	t.Helper()
+
+	ast, err := asm.Parse([]byte(profileTestSource))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	process, err := Compile(ast, LibTest())
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	profiler := MakeProfiler(process)
+	builder := &RuntimeBuilder{
+		Process: process,
+		Library: LibTest(),
+		Input:   &bytes.Buffer{},
+		Output:  &bytes.Buffer{},
+		Hooks:   []StepHook{profiler.Hook},
+	}
+	err = builder.Build().Execute()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	return profiler
+}
+
+func TestProfiler(t *testing.T) {
+	profiler := runProfile(t)
+
+	if profiler.Total() != 234 {
+		t.Errorf("Expected 234 steps but was %d", profiler.Total())
+	}
+
+	counts := []struct {
+		name     string
+		count    uint64
+		expected uint64
+	}{
+		{name: "procedure entry", count: profiler.Count(3), expected: 5},
+		{name: "inner loop body", count: profiler.Count(5), expected: 100},
+		{name: "inner loop", count: profiler.LoopCount(4), expected: 205},
+		{name: "outer loop", count: profiler.LoopCount(9), expected: 16},
+		{name: "not a loop", count: profiler.LoopCount(5), expected: 0},
+		{name: "out of range", count: profiler.Count(1000), expected: 0},
+	}
+
+	for _, test := range counts {
+		if test.count != test.expected {
+			t.Errorf("Expected %s count %d but was %d", test.name, test.expected, test.count)
+		}
+	}
+
+	if profiler.procedureName(3) != "spin" {
+		t.Errorf("Expected procedure 'spin' but was '%s'", profiler.procedureName(3))
+	}
+
+	buff := &bytes.Buffer{}
+	profiler.Report(buff, 2)
+	expected := `TOTAL STEPS: 234
+ADDRESSES:
+         100  42.74% 5 SUB 3 2
+         100  42.74% 6 JMPNZ 3 5
+LOOPS:
+         205  87.61% loop 4
+          16   6.84% loop 9
+FUNCTIONS:
+`
+
+	if buff.String() != expected {
+		t.Errorf("Expected:\n%s\nbut received:\n%s", expected, buff.String())
+	}
+}
+
+func TestProfiler_Functions(t *testing.T) {
+	canned := makeInputProcess(traceTestByteCode(), []byte{})
+	canned.process.Positions = []asm.SourcePos{
+		asm.SourcePos{Line: 1, Column: 1},
+		asm.SourcePos{Line: 1, Column: 1},
+		asm.SourcePos{Line: 1, Column: 2},
+		asm.SourcePos{Line: 1, Column: 2},
+	}
+	profiler := MakeProfiler(canned.process)
+	runtime := canned.makeRuntime()
+	runtime.Hooks = []StepHook{profiler.Hook}
+	err := runtime.Execute()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if profiler.FunctionCount("vm_func_a") != 1 {
+		t.Errorf("Expected 1 call but was %d", profiler.FunctionCount("vm_func_a"))
+	}
+
+	if count := profiler.PositionCount(asm.SourcePos{Line: 1, Column: 2}); count != 2 {
+		t.Errorf("Expected 2 steps at 1:2 but was %d", count)
+	}
+
+	buff := &bytes.Buffer{}
+	profiler.Report(buff, 0)
+
+	for _, expect := range []string{"POSITIONS:\n           2  50.00% 1:1\n", "FUNCTIONS:\n           1  25.00% vm_func_a\n"} {
+		if !strings.Contains(buff.String(), expect) {
+			t.Errorf("Expected '%s' in:\n%s", expect, buff.String())
+		}
+	}
+}
+
+func TestProfiler_WritePprof(t *testing.T) {
+	profiler := runProfile(t)
+	buff := &bytes.Buffer{}
+	err := profiler.WritePprof(buff)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	reader, err := gzip.NewReader(buff)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	profile, err := ioutil.ReadAll(reader)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	for _, name := range []string{"main", "spin", "loop 4", "loop 9", "steps", "count"} {
+		if !bytes.Contains(profile, []byte(name)) {
+			t.Errorf("Expected '%s' in string table", name)
+		}
+	}
+}
+
+func TestProtoBuffer(t *testing.T) {
+	buff := &protoBuffer{}
+	buff.uint(1, 150)
+	buff.uint(2, 0)
+	buff.bytes(3, []byte("hi"))
+	buff.packed(4, []uint64{1, 300})
+
+	expected := []byte{0x08, 0x96, 0x01, 0x1a, 0x02, 'h', 'i', 0x22, 0x03, 0x01, 0xac, 0x02}
+
+	if !bytes.Equal(expected, buff.Bytes()) {
+		t.Errorf("Expected %x but received %x", expected, buff.Bytes())
+	}
+}
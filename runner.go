@@ -0,0 +1,96 @@
+package shapes
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	goruntime "runtime"
+	"sync"
+)
+
+// Job is a program to run with its input.  The process is copied before it
+// runs, so jobs may share one.
+type Job struct {
+	Process *Process
+	Input   []byte
+}
+
+type JobResult struct {
+	Output []byte
+	Steps  uint64
+	Error  error
+}
+
+// Runner executes batches of jobs on a pool of workers, each job in a Runtime
+// of its own.
+type Runner struct {
+	// Workers is the number of jobs run at once, or one per CPU if it is zero.
+	Workers int
+	// Library is shared by every job, or a StdLib if it is nil.
+	Library     *Library
+	EOF         EOFPolicy
+	MaxSteps    uint64
+	MemoryLimit uint64
+}
+
+// Run executes the jobs and gives their results in the same order.  Jobs not
+// finished when ctx is done fail with its error.
+func (runner *Runner) Run(ctx context.Context, jobs []Job) []JobResult {
+	results := make([]JobResult, len(jobs))
+	library := runner.Library
+
+	if library == nil {
+		library = StdLib()
+	}
+
+	workers := runner.Workers
+
+	if workers < 1 {
+		workers = goruntime.NumCPU()
+	}
+
+	queue := make(chan int)
+	wait := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+
+			for index := range queue {
+				results[index] = runner.runJob(ctx, library, jobs[index])
+			}
+		}()
+	}
+
+	for index := range jobs {
+		queue <- index
+	}
+
+	close(queue)
+	wait.Wait()
+
+	return results
+}
+
+func (runner *Runner) runJob(ctx context.Context, library *Library, job Job) JobResult {
+	output := &bytes.Buffer{}
+	builder := &RuntimeBuilder{
+		Process:     job.Process.Copy(),
+		Library:     library,
+		Input:       bytes.NewReader(job.Input),
+		Output:      output,
+		EOF:         runner.EOF,
+		MaxSteps:    runner.MaxSteps,
+		MemoryLimit: runner.MemoryLimit,
+		DebugOutput: ioutil.Discard,
+	}
+	runtime := builder.Build()
+	err := runtime.ExecuteContext(ctx)
+
+	return JobResult{
+		Output: output.Bytes(),
+		Steps:  runtime.Steps(),
+		Error:  err,
+	}
+}
@@ -0,0 +1,108 @@
+package shapes
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRunner(t *testing.T) {
+	library := StdLib()
+	process, err := Assemble([]byte(isolationTestSource), library)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	echo, err := Assemble([]byte("read r0\nwrite r0\n"), library)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	jobs := []Job{}
+	expected := [][]byte{}
+
+	for i := 0; i < 100; i++ {
+		jobs = append(jobs, Job{Process: process})
+		expected = append(expected, []byte{0, 200})
+		jobs = append(jobs, Job{Process: echo, Input: []byte{byte(i)}})
+		expected = append(expected, []byte{byte(i)})
+	}
+
+	runner := &Runner{Workers: 8, Library: library}
+	results := runner.Run(context.Background(), jobs)
+
+	for i, result := range results {
+		if result.Error != nil {
+			t.Errorf("Job %d: unexpected error: %s", i, result.Error.Error())
+		}
+
+		if !bytes.Equal(expected[i], result.Output) {
+			t.Errorf("Job %d: expected %v but received %v", i, expected[i], result.Output)
+		}
+	}
+
+	if process.PC != 0 || process.Memory != nil {
+		t.Error("Expected jobs to leave their process alone")
+	}
+}
+
+func TestRunner_Failure(t *testing.T) {
+	echo, err := Assemble([]byte("read r0\nwrite r0\n"), StdLib())
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	jobs := []Job{
+		Job{Process: echo, Input: []byte{1}},
+		Job{Process: echo},
+	}
+
+	runner := &Runner{MaxSteps: 1}
+	results := runner.Run(context.Background(), jobs)
+
+	if errors.Cause(results[0].Error) != ErrStepLimitExceeded {
+		t.Errorf("Expected step limit but received %v", results[0].Error)
+	}
+
+	if results[0].Steps != 1 {
+		t.Errorf("Expected 1 step but was %d", results[0].Steps)
+	}
+
+	if errors.Cause(results[1].Error) != io.EOF {
+		t.Errorf("Expected EOF but received %v", results[1].Error)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = (&Runner{}).Run(ctx, jobs)
+
+	for i, result := range results {
+		if errors.Cause(result.Error) != context.Canceled {
+			t.Errorf("Job %d: expected cancellation but received %v", i, result.Error)
+		}
+	}
+}
+
+func TestProcessCopy(t *testing.T) {
+	process := MakeProcess(traceTestByteCode())
+	process.Stack[2] = []uint64{5}
+	process.CallStack = []Address{1}
+	copied := process.Copy()
+	copied.Stack[2][0] = 6
+	copied.CallStack[0] = 2
+	copied.Register[0] = 1
+
+	if process.Stack[2][0] != 5 || process.CallStack[0] != 1 || process.Register[0] != 0 {
+		t.Error("Expected copy to share no mutable state")
+	}
+
+	if copied.Stack[3] != nil {
+		t.Error("Expected empty stacks to stay nil")
+	}
+}
@@ -2,6 +2,7 @@ package shapes
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -41,8 +42,36 @@ const (
 	OP_COPY
 	OP_SET
 	OP_CALL
+	OP_JZ
+	OP_ADDI
+	OP_SUBI
+	OP_PUSHI
+	OP_MUL
+	OP_DIV
+	OP_MOD
+	OP_SDIV
+	OP_SMOD
+	OP_AND
+	OP_OR
+	OP_XOR
+	OP_NOT
+	OP_SHL
+	OP_SHR
+	OP_SAR
+	OP_JMP
+	OP_EQ
+	OP_LT
+	OP_GT
+	OP_SLT
+	OP_SGT
+	OP_CALLSUB
+	OP_RET
 )
 
+// OPCODE_SET_VERSION is recorded in object files and must be incremented
+// whenever an OpCode is added.
+const OPCODE_SET_VERSION = 5
+
 var __OPCODE_STRING = []string{
 	"JMPNZ",
 	"ADD",
@@ -54,6 +83,30 @@ var __OPCODE_STRING = []string{
 	"COPY",
 	"SET",
 	"CALL",
+	"JZ",
+	"ADDI",
+	"SUBI",
+	"PUSHI",
+	"MUL",
+	"DIV",
+	"MOD",
+	"SDIV",
+	"SMOD",
+	"AND",
+	"OR",
+	"XOR",
+	"NOT",
+	"SHL",
+	"SHR",
+	"SAR",
+	"JMP",
+	"EQ",
+	"LT",
+	"GT",
+	"SLT",
+	"SGT",
+	"CALLSUB",
+	"RET",
 }
 
 func (opCode OpCode) String() string {
@@ -65,7 +118,16 @@ type Process struct {
 	ByteCode []Operation
 	Register [REGISTER_COUNT]uint64
 	Stack    [REGISTER_COUNT][]uint64
-	Error    error
+	// CallStack holds the return addresses of the subroutines in progress.
+	CallStack []Address
+	// Positions locate each operation in the source, when known.  It is
+	// either empty or parallel to ByteCode.
+	Positions []asm.SourcePos
+	// Procedures names the entry address of each compiled ProcedureStmt.
+	Procedures map[Address]string
+	// Memory accounts for the stacks, and for tapes created by VmFunctions.
+	Memory *MemoryAccount
+	Error  error
 }
 
 func MakeProcess(byteCode []Operation) *Process {
@@ -74,6 +136,29 @@ func MakeProcess(byteCode []Operation) *Process {
 	}
 }
 
+// Copy gives a process in the same state that shares nothing it may change.
+// The bytecode, positions and procedures are shared, because no process
+// changes them.
+func (process *Process) Copy() *Process {
+	copied := &Process{
+		PC:         process.PC,
+		ByteCode:   process.ByteCode,
+		Register:   process.Register,
+		CallStack:  append([]Address(nil), process.CallStack...),
+		Positions:  process.Positions,
+		Procedures: process.Procedures,
+		Error:      process.Error,
+	}
+
+	for i, stack := range process.Stack {
+		if stack != nil {
+			copied.Stack[i] = append([]uint64(nil), stack...)
+		}
+	}
+
+	return copied
+}
+
 func Compile(ast *asm.AST, lib *Library) (*Process, error) {
 	compiler := &CompileVisitor{
 		Library: lib,
@@ -91,18 +176,30 @@ func (process *Process) DumpBytecode(w io.Writer) {
 			fmt.Fprint(w, " *")
 		}
 		fmt.Fprintf(w, " %v", op)
+		if pos := process.Position(Address(i)); pos.IsKnown() {
+			fmt.Fprintf(w, " %v", pos)
+		}
 		fmt.Fprint(w, "\n")
 	}
 }
 
+// Position locates the operation at pc in the source, if known.
+func (process *Process) Position(pc Address) asm.SourcePos {
+	if pc < Address(len(process.Positions)) {
+		return process.Positions[pc]
+	}
+
+	return asm.SourcePos{}
+}
+
 func (process *Process) DumpRegisters(w io.Writer) {
-	fmt.Println("REGISTER DUMP:\n")
+	fmt.Fprint(w, "REGISTER DUMP:\n")
 
 	for i, reg := range process.Register {
 		fmt.Fprintf(w, "%d %d\n", i, reg)
 	}
 
-	fmt.Println("STACK DUMP:\n")
+	fmt.Fprint(w, "STACK DUMP:\n")
 	for i, stk := range process.Stack {
 		if len(stk) == 0 {
 			fmt.Fprintf(w, "%d EMPTY\n", i)
@@ -112,6 +209,11 @@ func (process *Process) DumpRegisters(w io.Writer) {
 			}
 		}
 	}
+
+	fmt.Fprint(w, "CALL STACK DUMP:\n")
+	for i, returnAddress := range process.CallStack {
+		fmt.Fprintf(w, "%d %d\n", i, returnAddress)
+	}
 }
 
 func (process *Process) IsSameByteCode(other *Process) bool {
@@ -162,6 +264,7 @@ func (process *Process) Pop(stackAddr Address) uint64 {
 
 	stack := process.Stack[stackAddr]
 	process.Stack[stackAddr] = stack[:len(stack)-1]
+	process.Memory.Free(__CELL_BYTES)
 
 	return tip
 }
@@ -171,6 +274,14 @@ func (process *Process) failEmptyStack(stackAddr Address) {
 }
 
 func (process *Process) Push(stackAddr Address, tip uint64) {
+	resource := Resource{Kind: RESOURCE_STACK, Index: uint64(stackAddr)}
+	err := process.Memory.Allocate(resource, __CELL_BYTES)
+
+	if err != nil {
+		process.Error = err
+		return
+	}
+
 	process.Stack[stackAddr] = append(process.Stack[stackAddr], tip)
 }
 
@@ -188,12 +299,21 @@ func (process *Process) IncrementPC() {
 
 type RuntimeCall func(op Operation)
 
+// StepHook runs before each step, with the PC on the operation about to be
+// executed.  Returning an error fails the process, except for ErrHalted, which
+// stops it quietly.
+type StepHook func(runtime *Runtime) error
+
 type Runtime struct {
 	RuntimeBuilder
 	Error       error
 	callTable   []RuntimeCall
 	readBuffer  []byte
 	writeBuffer []byte
+	steps       uint64
+	halted      bool
+	// state is kept by VmFunctions, by key.
+	state map[interface{}]interface{}
 }
 
 type RuntimeBuilder struct {
@@ -201,9 +321,44 @@ type RuntimeBuilder struct {
 	Library *Library
 	Input   io.Reader
 	Output  io.Writer
-}
+	// EOF decides what READ does once Input is exhausted.
+	EOF EOFPolicy
+	// MaxSteps limits the number of operations executed, unless it is zero.
+	MaxSteps uint64
+	// MemoryLimit caps the bytes used by stacks and tapes, unless it is zero.
+	MemoryLimit uint64
+	// Hooks run in order before each step.
+	Hooks []StepHook
+	// Trace receives an event after each step, unless it is nil.
+	Trace TraceSink
+	// DebugOutput receives a DebugDump if the process fails, or os.Stderr if
+	// it is nil.
+	DebugOutput io.Writer
+}
+
+type EOFPolicy byte
 
+const (
+	// Fail the process.
+	EOF_ERROR = EOFPolicy(iota)
+	// Leave the register as it was.
+	EOF_UNCHANGED
+	EOF_ZERO
+	// Set every bit of the register, which is -1 when signed.
+	EOF_ALL_ONES
+)
+
+// Build gives the process a fresh MemoryAccount, charged for anything already
+// on its stacks.
 func (builder *RuntimeBuilder) Build() *Runtime {
+	memory := &MemoryAccount{Limit: builder.MemoryLimit}
+
+	for _, stack := range builder.Process.Stack {
+		memory.used += uint64(len(stack)) * __CELL_BYTES
+	}
+
+	builder.Process.Memory = memory
+
 	runtime := &Runtime{
 		RuntimeBuilder: *builder,
 		readBuffer:     []byte{0},
@@ -221,6 +376,30 @@ func (builder *RuntimeBuilder) Build() *Runtime {
 		runtime.copy,
 		runtime.set,
 		runtime.call,
+		runtime.jz,
+		runtime.addi,
+		runtime.subi,
+		runtime.pushi,
+		runtime.mul,
+		runtime.div,
+		runtime.mod,
+		runtime.sdiv,
+		runtime.smod,
+		runtime.and,
+		runtime.or,
+		runtime.xor,
+		runtime.not,
+		runtime.shl,
+		runtime.shr,
+		runtime.sar,
+		runtime.jmp,
+		runtime.eq,
+		runtime.lt,
+		runtime.gt,
+		runtime.slt,
+		runtime.sgt,
+		runtime.callsub,
+		runtime.ret,
 	}
 
 	return runtime
@@ -244,6 +423,20 @@ func (runtime *Runtime) jmpnz(op Operation) {
 	}
 }
 
+func (runtime *Runtime) jz(op Operation) {
+	val := runtime.Process.GetRegister(op.Address(0))
+
+	if runtime.hasError() {
+		return
+	}
+
+	if val == 0 {
+		runtime.Process.PC = op.Address(1)
+	} else {
+		runtime.Process.IncrementPC()
+	}
+}
+
 func (runtime *Runtime) onRegisters(op Operation, f func(valZero, valOne uint64) uint64) {
 	valZero := runtime.Process.GetRegister(op.Address(0))
 
@@ -275,6 +468,213 @@ func (runtime *Runtime) sub(op Operation) {
 	runtime.Process.IncrementPC()
 }
 
+func (runtime *Runtime) mul(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return valZero * valOne
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) div(op Operation) {
+	runtime.onDivisor(op, func(valZero, valOne uint64) uint64 {
+		return valZero / valOne
+	})
+}
+
+func (runtime *Runtime) mod(op Operation) {
+	runtime.onDivisor(op, func(valZero, valOne uint64) uint64 {
+		return valZero % valOne
+	})
+}
+
+func (runtime *Runtime) sdiv(op Operation) {
+	runtime.onDivisor(op, func(valZero, valOne uint64) uint64 {
+		return uint64(int64(valZero) / int64(valOne))
+	})
+}
+
+func (runtime *Runtime) smod(op Operation) {
+	runtime.onDivisor(op, func(valZero, valOne uint64) uint64 {
+		return uint64(int64(valZero) % int64(valOne))
+	})
+}
+
+// onDivisor fails the process instead of dividing by zero.
+func (runtime *Runtime) onDivisor(op Operation, f func(valZero, valOne uint64) uint64) {
+	divisor := runtime.Process.GetRegister(op.Address(1))
+
+	if divisor == 0 {
+		runtime.Process.Error = errors.Wrapf(ErrDivisionByZero, "%v at %d", op, runtime.Process.PC)
+		return
+	}
+
+	runtime.onRegisters(op, f)
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) and(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return valZero & valOne
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) or(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return valZero | valOne
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) xor(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return valZero ^ valOne
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) not(op Operation) {
+	val := runtime.Process.GetRegister(op.Address(0))
+
+	if runtime.hasError() {
+		return
+	}
+
+	runtime.Process.SetRegister(op.Address(0), ^val)
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) shl(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return valZero << valOne
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) shr(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return valZero >> valOne
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) sar(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return uint64(int64(valZero) >> valOne)
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) jmp(op Operation) {
+	runtime.Process.PC = op.Address(1)
+}
+
+func (runtime *Runtime) eq(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return boolValue(valZero == valOne)
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) lt(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return boolValue(valZero < valOne)
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) gt(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return boolValue(valZero > valOne)
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) slt(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return boolValue(int64(valZero) < int64(valOne))
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) sgt(op Operation) {
+	runtime.onRegisters(op, func(valZero, valOne uint64) uint64 {
+		return boolValue(int64(valZero) > int64(valOne))
+	})
+	runtime.Process.IncrementPC()
+}
+
+// callsub jumps to the subroutine at Operand[1].  Operand[0] names the stack
+// on which parameters are passed, but the caller and callee manage that stack
+// themselves.
+func (runtime *Runtime) callsub(op Operation) {
+	process := runtime.Process
+
+	if len(process.CallStack) >= MAX_CALL_DEPTH {
+		process.Error = errors.Wrapf(ErrStackOverflow, "%v at %d", op, process.PC)
+		return
+	}
+
+	process.CallStack = append(process.CallStack, process.PC+1)
+	process.PC = op.Address(1)
+}
+
+func (runtime *Runtime) ret(op Operation) {
+	process := runtime.Process
+
+	if len(process.CallStack) == 0 {
+		process.Error = fmt.Errorf("%v at %d with empty call stack", op, process.PC)
+		return
+	}
+
+	tip := len(process.CallStack) - 1
+	process.PC = process.CallStack[tip]
+	process.CallStack = process.CallStack[:tip]
+}
+
+func boolValue(b bool) uint64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (runtime *Runtime) onImmediate(op Operation, f func(val, imm uint64) uint64) {
+	val := runtime.Process.GetRegister(op.Address(0))
+
+	if runtime.hasError() {
+		return
+	}
+
+	newVal := f(val, uint64(op.Operand[1]))
+	runtime.Process.SetRegister(op.Address(0), newVal)
+}
+
+func (runtime *Runtime) addi(op Operation) {
+	runtime.onImmediate(op, func(val, imm uint64) uint64 {
+		return val + imm
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) subi(op Operation) {
+	runtime.onImmediate(op, func(val, imm uint64) uint64 {
+		return val - imm
+	})
+	runtime.Process.IncrementPC()
+}
+
+func (runtime *Runtime) pushi(op Operation) {
+	runtime.Process.Push(op.Address(0), uint64(op.Operand[1]))
+
+	if runtime.hasError() {
+		return
+	}
+
+	runtime.Process.IncrementPC()
+}
+
 func (runtime *Runtime) push(op Operation) {
 	val := runtime.Process.GetRegister(op.Address(1))
 
@@ -283,6 +683,11 @@ func (runtime *Runtime) push(op Operation) {
 	}
 
 	runtime.Process.Push(op.Address(0), val)
+
+	if runtime.hasError() {
+		return
+	}
+
 	runtime.Process.IncrementPC()
 }
 
@@ -300,7 +705,12 @@ func (runtime *Runtime) pop(op Operation) {
 func (runtime *Runtime) read(op Operation) {
 	const errMsg = "Runtime.read failed"
 
-	_, err := runtime.Input.Read(runtime.readBuffer)
+	_, err := io.ReadFull(runtime.Input, runtime.readBuffer)
+
+	if err == io.EOF && runtime.EOF != EOF_ERROR {
+		runtime.readEOF(op)
+		return
+	}
 
 	if err != nil {
 		runtime.Process.Error = errors.Wrap(err, errMsg)
@@ -311,6 +721,17 @@ func (runtime *Runtime) read(op Operation) {
 	runtime.Process.IncrementPC()
 }
 
+func (runtime *Runtime) readEOF(op Operation) {
+	switch runtime.EOF {
+	case EOF_ZERO:
+		runtime.Process.SetRegister(op.Address(0), 0)
+	case EOF_ALL_ONES:
+		runtime.Process.SetRegister(op.Address(0), ^uint64(0))
+	}
+
+	runtime.Process.IncrementPC()
+}
+
 func (runtime *Runtime) write(op Operation) {
 	const errMsg = "Runtime.Write failed"
 
@@ -346,26 +767,113 @@ func (runtime *Runtime) set(op Operation) {
 func (runtime *Runtime) call(op Operation) {
 	callee := runtime.Library.Functions[op.Operand[0]]
 	runtime.Process.Push(op.Address(1), uint64(runtime.Process.PC))
+
+	if runtime.hasError() {
+		return
+	}
+
 	callee(runtime, op.Address(1))
 	// Callee moves PC.
 }
 
 func (runtime *Runtime) Execute() error {
+	return runtime.ExecuteContext(context.Background())
+}
+
+// ExecuteContext runs the process until it terminates, fails, is halted by a
+// hook, runs out of steps or ctx is done.  The context is checked every
+// __CONTEXT_CHECK_STEPS steps, since checking it on every step is slow.
+func (runtime *Runtime) ExecuteContext(ctx context.Context) error {
+	done := ctx.Done()
+
 	for !runtime.Process.IsTerminated() {
+		if runtime.MaxSteps != 0 && runtime.steps >= runtime.MaxSteps {
+			runtime.Process.Error = errors.Wrapf(ErrStepLimitExceeded, "%d steps", runtime.steps)
+			break
+		}
+
+		if done != nil && runtime.steps%__CONTEXT_CHECK_STEPS == 0 {
+			select {
+			case <-done:
+				runtime.Process.Error = errors.Wrapf(ctx.Err(), "after %d steps", runtime.steps)
+			default:
+			}
+
+			if runtime.Process.Error != nil {
+				break
+			}
+		}
+
+		runtime.runHooks()
+
+		if runtime.halted || runtime.hasError() {
+			break
+		}
+
+		pc := runtime.Process.PC
 		runtime.Process.ExecuteStep(runtime.callTable)
+		runtime.steps++
+
+		if runtime.Trace != nil {
+			runtime.trace(pc)
+		}
 	}
 
 	if runtime.Process.Error != nil {
-		// Maybe we should expose an error buffer to the process too.
-		runtime.DebugDump(os.Stderr)
+		runtime.Process.Error = runtime.locateError(runtime.Process.Error)
+		debugOutput := runtime.DebugOutput
+
+		if debugOutput == nil {
+			debugOutput = os.Stderr
+		}
+
+		runtime.DebugDump(debugOutput)
 		return runtime.Process.Error
 	}
 
 	return nil
 }
 
+// locateError adds the PC, and its source position when known, to err.
+func (runtime *Runtime) locateError(err error) error {
+	pc := runtime.Process.PC
+	pos := runtime.Process.Position(pc)
+
+	if pos.IsKnown() {
+		return errors.Wrapf(err, "%v (PC %d)", pos, pc)
+	}
+
+	return errors.Wrapf(err, "PC %d", pc)
+}
+
+func (runtime *Runtime) runHooks() {
+	for _, hook := range runtime.Hooks {
+		err := hook(runtime)
+
+		if err == ErrHalted {
+			runtime.halted = true
+			return
+		}
+
+		if err != nil {
+			runtime.Process.Error = err
+			return
+		}
+	}
+}
+
+// Halted is true when a StepHook stopped the process before it terminated.
+func (runtime *Runtime) Halted() bool {
+	return runtime.halted
+}
+
+// Steps is the number of operations executed so far.
+func (runtime *Runtime) Steps() uint64 {
+	return runtime.steps
+}
+
 func (runtime *Runtime) DebugDump(w io.Writer) {
-	buff := bufio.NewWriter(os.Stderr)
+	buff := bufio.NewWriter(w)
 	fmt.Fprint(w, "FUNCTION MAP:\n")
 
 	for i, _ := range runtime.Library.Functions {
@@ -374,7 +882,7 @@ func (runtime *Runtime) DebugDump(w io.Writer) {
 		if err == nil {
 			fmt.Fprintf(w, "%d %s\n", i, fname)
 		} else {
-			fmt.Fprintf(w, "%d UNKNOWN FUNCTION\n")
+			fmt.Fprintf(w, "%d UNKNOWN FUNCTION\n", i)
 		}
 	}
 
@@ -385,3 +893,17 @@ func (runtime *Runtime) DebugDump(w io.Writer) {
 }
 
 const REGISTER_COUNT = 256
+
+// MAX_CALL_DEPTH limits the number of subroutines in progress, so that runaway
+// recursion fails with ErrStackOverflow rather than exhausting memory.
+const MAX_CALL_DEPTH = 4096
+
+var ErrDivisionByZero = errors.New("division by zero")
+
+var ErrStackOverflow = errors.New("call stack overflow")
+
+var ErrStepLimitExceeded = errors.New("step limit exceeded")
+
+var ErrHalted = errors.New("halted")
+
+const __CONTEXT_CHECK_STEPS = 1024
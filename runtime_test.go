@@ -2,8 +2,16 @@ package shapes
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
 )
 
 func TestRuntimeExecute(t *testing.T) {
@@ -17,6 +25,12 @@ func TestRuntimeExecute(t *testing.T) {
 	setInput, setExpect := setTestData()
 	jmpnzInput, jmpnzExpect := jmpnzTestData()
 	callInput, callExpect := callVmFuncTestData()
+	jzInput, jzExpect := jzTestData()
+	jmpInput, jmpExpect := jmpTestData()
+	callSubInput, callSubExpect := callSubTestData()
+	addiInput, addiExpect := addiTestData()
+	subiInput, subiExpect := subiTestData()
+	pushiInput, pushiExpect := pushiTestData()
 
 	table := [][2]cannedProcess{
 		[2]cannedProcess{addInput, addExpect},
@@ -29,6 +43,12 @@ func TestRuntimeExecute(t *testing.T) {
 		[2]cannedProcess{setInput, setExpect},
 		[2]cannedProcess{jmpnzInput, jmpnzExpect},
 		[2]cannedProcess{callInput, callExpect},
+		[2]cannedProcess{jzInput, jzExpect},
+		[2]cannedProcess{jmpInput, jmpExpect},
+		[2]cannedProcess{callSubInput, callSubExpect},
+		[2]cannedProcess{addiInput, addiExpect},
+		[2]cannedProcess{subiInput, subiExpect},
+		[2]cannedProcess{pushiInput, pushiExpect},
 	}
 
 	for i, test := range table {
@@ -39,6 +59,301 @@ func TestRuntimeExecute(t *testing.T) {
 	}
 }
 
+func TestRuntimeExecute_ALU(t *testing.T) {
+	const minusOne = 0xFFFFFFFFFFFFFFFF
+	const minusSeven = 0xFFFFFFFFFFFFFFF9
+	const minusTwo = 0xFFFFFFFFFFFFFFFE
+	const minusFour = 0xFFFFFFFFFFFFFFFC
+
+	table := []struct {
+		opCode  OpCode
+		valZero uint64
+		valOne  uint64
+		expect  uint64
+	}{
+		{OP_MUL, 6, 7, 42},
+		{OP_MUL, minusOne, 3, minusOne - 2},
+		{OP_DIV, 42, 5, 8},
+		{OP_DIV, minusSeven, 2, minusSeven / 2},
+		{OP_MOD, 42, 5, 2},
+		{OP_SDIV, minusSeven, 2, uint64(minusFour + 1)},
+		{OP_SDIV, 7, minusOne, minusSeven},
+		{OP_SMOD, minusSeven, 2, minusOne},
+		{OP_SMOD, 7, minusTwo, 1},
+		{OP_AND, binary("1100", 64), binary("1010", 64), binary("1000", 64)},
+		{OP_OR, binary("1100", 64), binary("1010", 64), binary("1110", 64)},
+		{OP_XOR, binary("1100", 64), binary("1010", 64), binary("0110", 64)},
+		{OP_NOT, 0, 0, minusOne},
+		{OP_SHL, binary("1011", 64), 2, binary("101100", 64)},
+		{OP_SHL, 1, 64, 0},
+		{OP_SHR, binary("1011", 64), 2, binary("10", 64)},
+		{OP_SHR, minusOne, 63, 1},
+		{OP_SAR, minusFour, 1, minusTwo},
+		{OP_SAR, binary("1011", 64), 2, binary("10", 64)},
+		{OP_EQ, 5, 5, 1},
+		{OP_EQ, 5, 6, 0},
+		{OP_LT, 5, 6, 1},
+		{OP_LT, minusOne, 6, 0},
+		{OP_GT, minusOne, 6, 1},
+		{OP_GT, 6, 6, 0},
+		{OP_SLT, minusOne, 6, 1},
+		{OP_SLT, 6, minusOne, 0},
+		{OP_SGT, 6, minusOne, 1},
+		{OP_SGT, minusTwo, minusOne, 0},
+	}
+
+	for i, test := range table {
+		byteCode := []Operation{
+			Operation{OpCode: OP_SET, Operand: [2]Operand{0, Operand(test.valZero)}},
+			Operation{OpCode: OP_SET, Operand: [2]Operand{1, Operand(test.valOne)}},
+			Operation{OpCode: test.opCode, Operand: [2]Operand{0, 1}},
+		}
+
+		if test.opCode == OP_NOT {
+			byteCode[2].Operand[1] = 0
+		}
+
+		input := makeInputProcess(byteCode, []byte{})
+
+		register := [REGISTER_COUNT]uint64{}
+		register[0] = test.expect
+		register[1] = test.valOne
+		expect := makeExpectProcess(byteCode, register, [REGISTER_COUNT][]uint64{}, []byte{})
+
+		ok := runtimeExecuteHelper(t, input, expect)
+		if !ok {
+			t.Errorf("Failure in test case %d (%v)", i, test.opCode)
+		}
+	}
+}
+
+func TestRuntimeExecute_DivisionByZero(t *testing.T) {
+	for _, opCode := range []OpCode{OP_DIV, OP_MOD, OP_SDIV, OP_SMOD} {
+		byteCode := []Operation{
+			Operation{OpCode: OP_SET, Operand: [2]Operand{0, 10}},
+			Operation{OpCode: opCode, Operand: [2]Operand{0, 1}},
+		}
+
+		canned := makeInputProcess(byteCode, []byte{})
+		runtime := canned.makeRuntime()
+		err := runtime.Execute()
+
+		if errors.Cause(err) != ErrDivisionByZero {
+			t.Errorf("Expected division by zero for %v but received %v", opCode, err)
+		}
+
+		if canned.process.PC != 1 {
+			t.Errorf("Expected PC to stay at 1 for %v but was %d", opCode, canned.process.PC)
+		}
+	}
+}
+
+func TestRuntimeExecute_ErrorPosition(t *testing.T) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_SET, Operand: [2]Operand{0, 10}},
+		Operation{OpCode: OP_DIV, Operand: [2]Operand{0, 1}},
+	}
+
+	canned := makeInputProcess(byteCode, []byte{})
+	runtime := canned.makeRuntime()
+	err := runtime.Execute()
+
+	if err == nil || !strings.HasPrefix(err.Error(), "PC 1: ") {
+		t.Errorf("Expected error at PC 1 but received %v", err)
+	}
+
+	canned = makeInputProcess(byteCode, []byte{})
+	canned.process.Positions = []asm.SourcePos{
+		asm.SourcePos{File: "prog.bf", Line: 1, Column: 1},
+		asm.SourcePos{File: "prog.bf", Line: 12, Column: 4},
+	}
+	runtime = canned.makeRuntime()
+	err = runtime.Execute()
+
+	if err == nil || !strings.HasPrefix(err.Error(), "prog.bf:12:4 (PC 1): ") {
+		t.Errorf("Expected error at prog.bf:12:4 but received %v", err)
+	}
+
+	if errors.Cause(err) != ErrDivisionByZero {
+		t.Errorf("Expected division by zero but received %v", err)
+	}
+}
+
+func TestRuntimeExecute_EOF(t *testing.T) {
+	const allOnes = 0xFFFFFFFFFFFFFFFF
+
+	table := []struct {
+		policy EOFPolicy
+		expect uint64
+	}{
+		{EOF_UNCHANGED, 7},
+		{EOF_ZERO, 0},
+		{EOF_ALL_ONES, allOnes},
+	}
+
+	byteCode := []Operation{
+		Operation{OpCode: OP_SET, Operand: [2]Operand{0, 7}},
+		Operation{OpCode: OP_READ, Operand: [2]Operand{1, 0}},
+		Operation{OpCode: OP_READ, Operand: [2]Operand{0, 0}},
+	}
+
+	for _, test := range table {
+		canned := makeInputProcess(byteCode, []byte("a"))
+		runtime := canned.makeRuntime()
+		runtime.EOF = test.policy
+		err := runtime.Execute()
+
+		if err != nil {
+			t.Errorf("Unexpected error for policy %d: %s", test.policy, err.Error())
+			continue
+		}
+
+		if canned.process.Register[1] != 'a' {
+			t.Errorf("Expected to read 'a' for policy %d but received %d", test.policy, canned.process.Register[1])
+		}
+
+		if canned.process.Register[0] != test.expect {
+			t.Errorf("Expected %d for policy %d but received %d", test.expect, test.policy, canned.process.Register[0])
+		}
+	}
+
+	canned := makeInputProcess(byteCode, []byte{})
+	runtime := canned.makeRuntime()
+	err := runtime.Execute()
+
+	if errors.Cause(err) != io.EOF {
+		t.Errorf("Expected EOF error but received %v", err)
+	}
+}
+
+func TestRuntimeExecute_StepLimit(t *testing.T) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_ADDI, Operand: [2]Operand{0, 1}},
+		Operation{OpCode: OP_JMP, Operand: [2]Operand{0, 0}},
+	}
+
+	canned := makeInputProcess(byteCode, []byte{})
+	runtime := canned.makeRuntime()
+	runtime.MaxSteps = 101
+	err := runtime.Execute()
+
+	if errors.Cause(err) != ErrStepLimitExceeded {
+		t.Errorf("Expected step limit exceeded but received %v", err)
+	}
+
+	if runtime.Steps() != 101 {
+		t.Errorf("Expected 101 steps but was %d", runtime.Steps())
+	}
+
+	if canned.process.Register[0] != 51 {
+		t.Errorf("Expected 51 additions but was %d", canned.process.Register[0])
+	}
+
+	canned = makeInputProcess(byteCode[:1], []byte{})
+	runtime = canned.makeRuntime()
+	runtime.MaxSteps = 1
+	err = runtime.Execute()
+
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
+
+func TestRuntimeExecuteContext(t *testing.T) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_JMP, Operand: [2]Operand{0, 0}},
+	}
+
+	canned := makeInputProcess(byteCode, []byte{})
+	runtime := canned.makeRuntime()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := runtime.ExecuteContext(ctx)
+
+	if errors.Cause(err) != context.Canceled {
+		t.Errorf("Expected cancellation but received %v", err)
+	}
+
+	canned = makeInputProcess(byteCode, []byte{})
+	runtime = canned.makeRuntime()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = runtime.ExecuteContext(ctx)
+
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded but received %v", err)
+	}
+}
+
+func TestRuntimeExecute_StackOverflow(t *testing.T) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_CALLSUB, Operand: [2]Operand{0, 0}},
+	}
+
+	canned := makeInputProcess(byteCode, []byte{})
+	runtime := canned.makeRuntime()
+	err := runtime.Execute()
+
+	if errors.Cause(err) != ErrStackOverflow {
+		t.Errorf("Expected stack overflow but received %v", err)
+	}
+
+	if len(canned.process.CallStack) != MAX_CALL_DEPTH {
+		t.Errorf("Expected call stack depth %d but was %d", MAX_CALL_DEPTH, len(canned.process.CallStack))
+	}
+}
+
+func TestRuntimeExecute_MemoryLimit(t *testing.T) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_PUSHI, Operand: [2]Operand{3, 1}},
+		Operation{OpCode: OP_JMP, Operand: [2]Operand{0, 0}},
+	}
+
+	canned := makeInputProcess(byteCode, []byte{})
+	builder := &RuntimeBuilder{
+		Process:     canned.process,
+		Input:       canned.input,
+		Output:      canned.output,
+		Library:     LibTest(),
+		MemoryLimit: 10 * __CELL_BYTES,
+	}
+	err := builder.Build().Execute()
+
+	quota, ok := errors.Cause(err).(*QuotaError)
+
+	if !ok {
+		t.Fatalf("Expected quota error but received %v", err)
+	}
+
+	expected := Resource{Kind: RESOURCE_STACK, Index: 3}
+
+	if quota.Resource != expected {
+		t.Errorf("Expected quota exceeded by %v but was %v", expected, quota.Resource)
+	}
+
+	if len(canned.process.Stack[3]) != 10 {
+		t.Errorf("Expected 10 stack entries but was %d", len(canned.process.Stack[3]))
+	}
+
+	if canned.process.Memory.Used() != 10*__CELL_BYTES {
+		t.Errorf("Expected %d bytes used but was %d", 10*__CELL_BYTES, canned.process.Memory.Used())
+	}
+}
+
+func TestRuntimeExecute_ReturnWithoutCall(t *testing.T) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_RET, Operand: [2]Operand{0, 0}},
+	}
+
+	canned := makeInputProcess(byteCode, []byte{})
+	runtime := canned.makeRuntime()
+	err := runtime.Execute()
+
+	if err == nil {
+		t.Error("Expected error returning with an empty call stack")
+	}
+}
+
 func jmpnzTestData() (cannedProcess, cannedProcess) {
 	byteCode := []Operation{
 		Operation{OpCode: OP_SET, Operand: [2]Operand{0, 10}},
@@ -58,6 +373,112 @@ func jmpnzTestData() (cannedProcess, cannedProcess) {
 	return input, expect
 }
 
+func jzTestData() (cannedProcess, cannedProcess) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_JZ, Operand: [2]Operand{0, 2}},
+		Operation{OpCode: OP_SET, Operand: [2]Operand{1, 10}},
+		Operation{OpCode: OP_SET, Operand: [2]Operand{0, 5}},
+		Operation{OpCode: OP_JZ, Operand: [2]Operand{0, 5}},
+		Operation{OpCode: OP_SET, Operand: [2]Operand{2, 20}},
+	}
+
+	input := makeInputProcess(byteCode, []byte{})
+
+	register := [REGISTER_COUNT]uint64{}
+	register[0] = 5
+	register[2] = 20
+	expect := makeExpectProcess(byteCode, register, [REGISTER_COUNT][]uint64{}, []byte{})
+
+	return input, expect
+}
+
+func jmpTestData() (cannedProcess, cannedProcess) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_JMP, Operand: [2]Operand{0, 2}},
+		Operation{OpCode: OP_SET, Operand: [2]Operand{0, 10}},
+		Operation{OpCode: OP_SET, Operand: [2]Operand{1, 20}},
+	}
+
+	input := makeInputProcess(byteCode, []byte{})
+
+	register := [REGISTER_COUNT]uint64{}
+	register[1] = 20
+	expect := makeExpectProcess(byteCode, register, [REGISTER_COUNT][]uint64{}, []byte{})
+
+	return input, expect
+}
+
+func callSubTestData() (cannedProcess, cannedProcess) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_PUSHI, Operand: [2]Operand{1, 21}},
+		Operation{OpCode: OP_CALLSUB, Operand: [2]Operand{1, 4}},
+		Operation{OpCode: OP_POP, Operand: [2]Operand{1, 2}},
+		Operation{OpCode: OP_JMP, Operand: [2]Operand{0, 8}},
+		Operation{OpCode: OP_POP, Operand: [2]Operand{1, 0}},
+		Operation{OpCode: OP_ADD, Operand: [2]Operand{0, 0}},
+		Operation{OpCode: OP_PUSH, Operand: [2]Operand{1, 0}},
+		Operation{OpCode: OP_RET, Operand: [2]Operand{0, 0}},
+	}
+
+	input := makeInputProcess(byteCode, []byte{})
+
+	register := [REGISTER_COUNT]uint64{}
+	register[0] = 42
+	register[2] = 42
+	expect := makeExpectProcess(byteCode, register, [REGISTER_COUNT][]uint64{}, []byte{})
+
+	return input, expect
+}
+
+func addiTestData() (cannedProcess, cannedProcess) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_SET, Operand: [2]Operand{0, 10}},
+		Operation{OpCode: OP_ADDI, Operand: [2]Operand{0, 25}},
+		Operation{OpCode: OP_ADDI, Operand: [2]Operand{1, makeOperand(-1)}},
+	}
+
+	input := makeInputProcess(byteCode, []byte{})
+
+	register := [REGISTER_COUNT]uint64{}
+	register[0] = 35
+	register[1] = binary("1111111111111111111111111111111111111111111111111111111111111111", 64)
+	expect := makeExpectProcess(byteCode, register, [REGISTER_COUNT][]uint64{}, []byte{})
+
+	return input, expect
+}
+
+func subiTestData() (cannedProcess, cannedProcess) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_SET, Operand: [2]Operand{0, 10}},
+		Operation{OpCode: OP_SUBI, Operand: [2]Operand{0, 4}},
+		Operation{OpCode: OP_SUBI, Operand: [2]Operand{1, 1}},
+	}
+
+	input := makeInputProcess(byteCode, []byte{})
+
+	register := [REGISTER_COUNT]uint64{}
+	register[0] = 6
+	register[1] = binary("1111111111111111111111111111111111111111111111111111111111111111", 64)
+	expect := makeExpectProcess(byteCode, register, [REGISTER_COUNT][]uint64{}, []byte{})
+
+	return input, expect
+}
+
+func pushiTestData() (cannedProcess, cannedProcess) {
+	byteCode := []Operation{
+		Operation{OpCode: OP_PUSHI, Operand: [2]Operand{3, 7}},
+		Operation{OpCode: OP_PUSHI, Operand: [2]Operand{3, 9}},
+	}
+
+	input := makeInputProcess(byteCode, []byte{})
+
+	stack := [REGISTER_COUNT][]uint64{}
+	stack[3] = []uint64{7, 9}
+	expect := makeExpectProcess(byteCode, [REGISTER_COUNT]uint64{}, stack, []byte{})
+
+	return input, expect
+}
+
 func callVmFuncTestData() (cannedProcess, cannedProcess) {
 	byteCode := []Operation{
 		Operation{OpCode: OP_CALL, Operand: [2]Operand{0, 0}},
@@ -1,3 +1,12 @@
+// Package shapes compiles esoteric programs to bytecode and runs them.
+//
+// Concurrency: a Runtime and its Process own all the state that changes as a
+// program runs, including the state of its VmFunctions, so runtimes may run at
+// once in different goroutines but each must be used by one goroutine at a
+// time.  A Library must not be changed once constructed, and may then be
+// shared by any number of runtimes.  Bytecode is never changed by running it,
+// but a Process is, so use Process.Copy to run one program many times.  Runner
+// runs batches of programs this way.
 package shapes
 
 import (
@@ -17,6 +26,18 @@ func InterpretProgramAST(ast *asm.AST, input io.Reader, output io.Writer) error
 		return errors.Wrap(err, errMsg)
 	}
 
+	err = InterpretProcess(process, input, output)
+
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	return nil
+}
+
+func InterpretProcess(process *Process, input io.Reader, output io.Writer) error {
+	const errMsg = "InterpretProcess failed"
+
 	builder := &RuntimeBuilder{
 		Process: process,
 		Input:   input,
@@ -26,7 +47,7 @@ func InterpretProgramAST(ast *asm.AST, input io.Reader, output io.Writer) error
 
 	runtime := builder.Build()
 
-	err = runtime.Execute()
+	err := runtime.Execute()
 
 	if err != nil {
 		return errors.Wrap(err, errMsg)
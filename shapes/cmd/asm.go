@@ -0,0 +1,63 @@
+// Copyright © 2017 Johnny Morrice <john@functorama.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// asmCmd represents the asm command
+var asmCmd = &cobra.Command{
+	Use:     "asm",
+	Short:   "Shapes assembly interpreter",
+	Example: "shapes asm --file prog." + __ASM_EXTENSION,
+	Run:     runAsm,
+}
+
+func runAsm(cmd *cobra.Command, args []string) {
+	source := getSource(cmd)
+
+	ast, err := asm.Parse(source)
+
+	if err != nil {
+		die(err)
+	}
+
+	runProcess(compileAST(ast))
+}
+
+func init() {
+	RootCmd.AddCommand(asmCmd)
+
+	asmCmd.Flags().StringVar(&sourceFile, __ASM_FILE_PARAM, __ASM_FILE_DEFAULT, __ASM_FILE_USAGE)
+	asmCmd.Flags().StringVar(&expression, __ASM_EXPRESSION_PARAM, __ASM_EXPRESSION_DEFAULT, __ASM_EXPRESSION_USAGE)
+	addRuntimeFlags(asmCmd)
+}
+
+const __ASM_EXTENSION = "sasm"
+const __ASM_FILE_PARAM = "file"
+const __ASM_FILE_USAGE = "Shapes assembly source code file"
+const __ASM_FILE_DEFAULT = ""
+const __ASM_EXPRESSION_PARAM = "expression"
+const __ASM_EXPRESSION_USAGE = "Shapes assembly source code"
+const __ASM_EXPRESSION_DEFAULT = ""
@@ -0,0 +1,62 @@
+// Copyright © 2017 Johnny Morrice <john@functorama.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/johnny-morrice/shapes/befunge"
+)
+
+// befungeCmd represents the befunge command
+var befungeCmd = &cobra.Command{
+	Use:     "befunge",
+	Short:   "Befunge-93 interpreter",
+	Example: "shapes befunge --file prog." + __BEFUNGE_EXTENSION,
+	Run:     runBefunge,
+}
+
+func runBefunge(cmd *cobra.Command, args []string) {
+	ast, err := befunge.Parse(getSource(cmd))
+
+	if err != nil {
+		die(err)
+	}
+
+	runProcess(compileAST(ast))
+}
+
+func init() {
+	RootCmd.AddCommand(befungeCmd)
+
+	befungeCmd.Flags().StringVar(&sourceFile, __BEFUNGE_FILE_PARAM, __BEFUNGE_FILE_DEFAULT, __BEFUNGE_FILE_USAGE)
+	befungeCmd.Flags().StringVar(&expression, __BEFUNGE_EXPRESSION_PARAM, __BEFUNGE_EXPRESSION_DEFAULT, __BEFUNGE_EXPRESSION_USAGE)
+	addRuntimeFlags(befungeCmd)
+}
+
+const __BEFUNGE_EXTENSION = "bf93"
+const __BEFUNGE_LONG_EXTENSION = "befunge"
+const __BEFUNGE_FILE_PARAM = "file"
+const __BEFUNGE_FILE_USAGE = "Befunge-93 source code file"
+const __BEFUNGE_FILE_DEFAULT = ""
+const __BEFUNGE_EXPRESSION_PARAM = "expression"
+const __BEFUNGE_EXPRESSION_USAGE = "Befunge-93 source code"
+const __BEFUNGE_EXPRESSION_DEFAULT = ""
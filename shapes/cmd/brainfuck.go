@@ -21,61 +21,129 @@
 package cmd
 
 import (
-	"io/ioutil"
-	"os"
+	"fmt"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	"github.com/johnny-morrice/shapes"
+	"github.com/johnny-morrice/shapes/asm"
 	"github.com/johnny-morrice/shapes/brainfuck"
+	"github.com/johnny-morrice/shapes/optimize"
 )
 
 // brainfuckCmd represents the brainfuck command
 var brainfuckCmd = &cobra.Command{
 	Use:     "brainfuck",
 	Short:   "Brainfuck interpreter",
-	Example: "shapes brainfuck --file prog." + __BRAINFUCK_EXTENSION,
+	Example: "shapes brainfuck --file prog." + __BRAINFUCK_EXTENSION + "\nshapes brainfuck --dialect ook --file prog.ook\nshapes brainfuck --extension pbrain --file prog.b",
 	Run:     runBrainfuck,
 }
 
 func runBrainfuck(cmd *cobra.Command, args []string) {
-	source := getBrainfuckSource(cmd)
+	source := getSource(cmd)
 
-	ast, err := brainfuck.Parse(source)
+	overflow, ok := __OVERFLOW_POLICIES[overflowPolicy]
 
-	err = shapes.InterpretProgramAST(ast, os.Stdin, os.Stdout)
+	if !ok {
+		die(fmt.Errorf("Unknown overflow policy '%s'", overflowPolicy))
+	}
 
-	if err != nil {
-		die(err)
+	options := brainfuck.Options{
+		CellBits: cellBits,
+		Overflow: overflow,
 	}
-}
 
-var sourceFile string
-var expression string
+	if extensionName != "" {
+		options.Extension, ok = brainfuck.LookupExtension(extensionName)
 
-func init() {
-	RootCmd.AddCommand(brainfuckCmd)
+		if !ok {
+			die(fmt.Errorf("Unknown extension '%s'", extensionName))
+		}
 
-	brainfuckCmd.Flags().StringVar(&sourceFile, __BRAINFUCK_FILE_PARAM, __BRAINFUCK_FILE_DEFAULT, __BRAINFUCK_FILE_USAGE)
-	brainfuckCmd.Flags().StringVar(&expression, __BRAINFUCK_EXPRESSION_PARAM, __BRAINFUCK_EXPRESSION_DEFAULT, __BRAINFUCK_EXPRESSION_USAGE)
-}
+		if optimizeLevel != 0 {
+			die(fmt.Errorf("Cannot optimize the %v extension", options.Extension))
+		}
+	}
 
-func getBrainfuckSource(cmd *cobra.Command) []byte {
-	if expression != "" {
-		return []byte(expression)
+	// Folded moves skip the cells between, so they would not meet a bounded
+	// tape's edge where the unoptimized program does.
+	if optimizeLevel != 0 && tapeBackend == __TAPE_BOUNDED && __TAPE_EDGES[tapeEdge] != shapes.TAPE_EDGE_WRAP {
+		die(fmt.Errorf("Cannot optimize for a bounded tape whose edge is '%s'", tapeEdge))
 	}
 
-	if sourceFile == "" {
-		dieHelp(cmd)
+	var ast *asm.AST
+	var err error
+
+	if dialectName == "" {
+		ast, err = brainfuck.ParseOptions(source, options)
+	} else {
+		ast, err = getDialect(dialectName).ParseOptions(source, options)
 	}
 
-	source, err := ioutil.ReadFile(sourceFile)
+	if err != nil {
+		die(err)
+	}
+
+	locateSource(ast)
+
+	ast, err = optimize.Optimize(ast, optimizeLevel)
 
 	if err != nil {
 		die(err)
 	}
 
-	return source
+	runProcess(compileAST(ast))
+}
+
+// getDialect finds a dialect defined in the config file, or else a built-in
+// dialect, by name.
+func getDialect(name string) *brainfuck.Dialect {
+	key := __DIALECTS_CONFIG_KEY + "." + name
+
+	if viper.IsSet(key) {
+		dialect := &brainfuck.Dialect{}
+		err := viper.UnmarshalKey(key, dialect)
+
+		if err != nil {
+			die(err)
+		}
+
+		return dialect
+	}
+
+	dialect, ok := brainfuck.LookupDialect(name)
+
+	if !ok {
+		die(fmt.Errorf("Unknown dialect '%s'", name))
+	}
+
+	return dialect
+}
+
+var optimizeLevel int
+var cellBits int
+var overflowPolicy string
+var dialectName string
+var extensionName string
+
+var __OVERFLOW_POLICIES = map[string]int{
+	"wrap":     asm.TAPE_OVERFLOW_WRAP,
+	"saturate": asm.TAPE_OVERFLOW_SATURATE,
+	"error":    asm.TAPE_OVERFLOW_ERROR,
+}
+
+func init() {
+	RootCmd.AddCommand(brainfuckCmd)
+
+	brainfuckCmd.Flags().StringVar(&sourceFile, __BRAINFUCK_FILE_PARAM, __BRAINFUCK_FILE_DEFAULT, __BRAINFUCK_FILE_USAGE)
+	brainfuckCmd.Flags().StringVar(&expression, __BRAINFUCK_EXPRESSION_PARAM, __BRAINFUCK_EXPRESSION_DEFAULT, __BRAINFUCK_EXPRESSION_USAGE)
+	brainfuckCmd.Flags().IntVarP(&optimizeLevel, __BRAINFUCK_OPTIMIZE_PARAM, __BRAINFUCK_OPTIMIZE_SHORTHAND, __BRAINFUCK_OPTIMIZE_DEFAULT, __BRAINFUCK_OPTIMIZE_USAGE)
+	brainfuckCmd.Flags().IntVar(&cellBits, __BRAINFUCK_CELL_BITS_PARAM, __BRAINFUCK_CELL_BITS_DEFAULT, __BRAINFUCK_CELL_BITS_USAGE)
+	brainfuckCmd.Flags().StringVar(&overflowPolicy, __BRAINFUCK_OVERFLOW_PARAM, __BRAINFUCK_OVERFLOW_DEFAULT, __BRAINFUCK_OVERFLOW_USAGE)
+	brainfuckCmd.Flags().StringVar(&dialectName, __BRAINFUCK_DIALECT_PARAM, __BRAINFUCK_DIALECT_DEFAULT, __BRAINFUCK_DIALECT_USAGE)
+	brainfuckCmd.Flags().StringVar(&extensionName, __BRAINFUCK_EXTENSION_PARAM, __BRAINFUCK_EXTENSION_DEFAULT, __BRAINFUCK_EXTENSION_USAGE)
+	addRuntimeFlags(brainfuckCmd)
 }
 
 const __BRAINFUCK_EXTENSION = "bf"
@@ -85,3 +153,23 @@ const __BRAINFUCK_FILE_DEFAULT = ""
 const __BRAINFUCK_EXPRESSION_PARAM = "expression"
 const __BRAINFUCK_EXPRESSION_USAGE = "Brainfuck source code"
 const __BRAINFUCK_EXPRESSION_DEFAULT = ""
+const __BRAINFUCK_OPTIMIZE_PARAM = "optimize"
+const __BRAINFUCK_OPTIMIZE_SHORTHAND = "O"
+const __BRAINFUCK_OPTIMIZE_USAGE = "Optimization level: 0 for none, 1 to fold runs of commands, 2 to also replace common loops"
+const __BRAINFUCK_OPTIMIZE_DEFAULT = 0
+const __BRAINFUCK_CELL_BITS_PARAM = "cell-bits"
+const __BRAINFUCK_CELL_BITS_USAGE = "Width of each tape cell: 8, 16, 32 or 64 bits"
+const __BRAINFUCK_CELL_BITS_DEFAULT = 8
+const __BRAINFUCK_OVERFLOW_PARAM = "overflow"
+const __BRAINFUCK_OVERFLOW_USAGE = "What to do when a cell overflows: wrap, saturate or error.  64-bit cells must wrap"
+const __BRAINFUCK_OVERFLOW_DEFAULT = "wrap"
+const __BRAINFUCK_DIALECT_PARAM = "dialect"
+const __BRAINFUCK_DIALECT_USAGE = "Brainfuck spelled with other tokens: alphuck, blub, ook, pikalang, trollscript, or a dialect defined under '" + __DIALECTS_CONFIG_KEY + "' in the config file"
+const __BRAINFUCK_DIALECT_DEFAULT = ""
+const __BRAINFUCK_EXTENSION_PARAM = "extension"
+const __BRAINFUCK_EXTENSION_USAGE = "Brainfuck with extra commands: pbrain, brainfork or extended1 for Extended Brainfuck Type I"
+const __BRAINFUCK_EXTENSION_DEFAULT = ""
+
+// Each dialect in the config file gives the token of each command, named
+// right, left, increment, decrement, output, input, open and close.
+const __DIALECTS_CONFIG_KEY = "dialects"
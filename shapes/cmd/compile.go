@@ -0,0 +1,90 @@
+// Copyright © 2017 Johnny Morrice <john@functorama.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/johnny-morrice/shapes"
+)
+
+// compileCmd represents the compile command
+var compileCmd = &cobra.Command{
+	Use:     "compile",
+	Short:   "Compile a program to a Shapes object file",
+	Example: "shapes compile -o prog." + __OBJECT_EXTENSION + " --file prog." + __BRAINFUCK_EXTENSION,
+	Run:     runCompile,
+}
+
+func runCompile(cmd *cobra.Command, args []string) {
+	if objectFile == "" {
+		dieHelp(cmd)
+	}
+
+	process := compileSource(cmd)
+
+	file, err := os.Create(objectFile)
+
+	if err != nil {
+		die(err)
+	}
+
+	err = shapes.WriteObject(file, process, shapes.StdLib())
+
+	if err != nil {
+		file.Close()
+		die(err)
+	}
+
+	err = file.Close()
+
+	if err != nil {
+		die(err)
+	}
+}
+
+var objectFile string
+
+func init() {
+	RootCmd.AddCommand(compileCmd)
+
+	compileCmd.Flags().StringVar(&sourceFile, __COMPILE_FILE_PARAM, __COMPILE_FILE_DEFAULT, __COMPILE_FILE_USAGE)
+	compileCmd.Flags().StringVar(&expression, __COMPILE_EXPRESSION_PARAM, __COMPILE_EXPRESSION_DEFAULT, __COMPILE_EXPRESSION_USAGE)
+	compileCmd.Flags().StringVar(&language, __COMPILE_LANGUAGE_PARAM, __COMPILE_LANGUAGE_DEFAULT, __COMPILE_LANGUAGE_USAGE)
+	compileCmd.Flags().StringVarP(&objectFile, __COMPILE_OUTPUT_PARAM, __COMPILE_OUTPUT_SHORTHAND, __COMPILE_OUTPUT_DEFAULT, __COMPILE_OUTPUT_USAGE)
+}
+
+const __OBJECT_EXTENSION = "shc"
+const __COMPILE_FILE_PARAM = "file"
+const __COMPILE_FILE_USAGE = "Source code file"
+const __COMPILE_FILE_DEFAULT = ""
+const __COMPILE_EXPRESSION_PARAM = "expression"
+const __COMPILE_EXPRESSION_USAGE = "Source code"
+const __COMPILE_EXPRESSION_DEFAULT = ""
+const __COMPILE_LANGUAGE_PARAM = "language"
+const __COMPILE_LANGUAGE_USAGE = "Source language (" + __BRAINFUCK_EXTENSION + ", " + __BEFUNGE_EXTENSION + ", " + __FUNGE98_EXTENSION + ", " + __WHITESPACE_EXTENSION + " or " + __ASM_EXTENSION + "), by default chosen from the file extension"
+const __COMPILE_LANGUAGE_DEFAULT = ""
+const __COMPILE_OUTPUT_PARAM = "output"
+const __COMPILE_OUTPUT_SHORTHAND = "o"
+const __COMPILE_OUTPUT_USAGE = "Object file to write"
+const __COMPILE_OUTPUT_DEFAULT = ""
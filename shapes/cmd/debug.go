@@ -0,0 +1,83 @@
+// Copyright © 2017 Johnny Morrice <john@functorama.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/johnny-morrice/shapes/debug"
+)
+
+// debugCmd represents the debug command
+var debugCmd = &cobra.Command{
+	Use:     "debug",
+	Short:   "Step through a program, taking commands from stdin",
+	Example: "shapes debug prog." + __BRAINFUCK_EXTENSION,
+	Args:    cobra.ExactArgs(1),
+	Run:     runDebug,
+}
+
+func runDebug(cmd *cobra.Command, args []string) {
+	sourceFile = args[0]
+	builder := runtimeBuilder(compileSource(cmd))
+	// Stdin carries debugger commands, so the program reads elsewhere.
+	builder.Input = programInput()
+
+	debugger := debug.New(os.Stdin, os.Stdout)
+	builder.Hooks = append(builder.Hooks, debugger.Hook)
+
+	executeBuilder(builder)
+}
+
+func programInput() io.Reader {
+	if debugInputFile == "" {
+		return &bytes.Buffer{}
+	}
+
+	file, err := os.Open(debugInputFile)
+
+	if err != nil {
+		die(err)
+	}
+
+	return file
+}
+
+var debugInputFile string
+
+func init() {
+	RootCmd.AddCommand(debugCmd)
+
+	debugCmd.Flags().StringVar(&language, __DEBUG_LANGUAGE_PARAM, __DEBUG_LANGUAGE_DEFAULT, __DEBUG_LANGUAGE_USAGE)
+	debugCmd.Flags().StringVar(&debugInputFile, __DEBUG_INPUT_PARAM, __DEBUG_INPUT_DEFAULT, __DEBUG_INPUT_USAGE)
+	addRuntimeFlags(debugCmd)
+}
+
+const __DEBUG_LANGUAGE_PARAM = "language"
+const __DEBUG_LANGUAGE_USAGE = "Source language (" + __BRAINFUCK_EXTENSION + ", " + __BEFUNGE_EXTENSION + ", " + __FUNGE98_EXTENSION + ", " + __WHITESPACE_EXTENSION + " or " + __ASM_EXTENSION + "), by default chosen from the file extension"
+const __DEBUG_LANGUAGE_DEFAULT = ""
+const __DEBUG_INPUT_PARAM = "input"
+const __DEBUG_INPUT_USAGE = "File read by the program; it reads nothing by default"
+const __DEBUG_INPUT_DEFAULT = ""
@@ -0,0 +1,74 @@
+// Copyright © 2017 Johnny Morrice <john@functorama.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/johnny-morrice/shapes"
+)
+
+// disasmCmd represents the disasm command
+var disasmCmd = &cobra.Command{
+	Use:     "disasm",
+	Short:   "Print the compiled bytecode of a program as Shapes assembly",
+	Example: "shapes disasm --file prog." + __BRAINFUCK_EXTENSION,
+	Run:     runDisasm,
+}
+
+func runDisasm(cmd *cobra.Command, args []string) {
+	var process *shapes.Process
+
+	if language == "" && strings.HasSuffix(sourceFile, "."+__OBJECT_EXTENSION) {
+		process = readObjectFile(sourceFile)
+	} else {
+		process = compileSource(cmd)
+	}
+
+	text, err := shapes.DisassembleProcess(process, shapes.StdLib())
+
+	if err != nil {
+		die(err)
+	}
+
+	fmt.Print(text)
+}
+
+func init() {
+	RootCmd.AddCommand(disasmCmd)
+
+	disasmCmd.Flags().StringVar(&sourceFile, __DISASM_FILE_PARAM, __DISASM_FILE_DEFAULT, __DISASM_FILE_USAGE)
+	disasmCmd.Flags().StringVar(&expression, __DISASM_EXPRESSION_PARAM, __DISASM_EXPRESSION_DEFAULT, __DISASM_EXPRESSION_USAGE)
+	disasmCmd.Flags().StringVar(&language, __DISASM_LANGUAGE_PARAM, __DISASM_LANGUAGE_DEFAULT, __DISASM_LANGUAGE_USAGE)
+}
+
+const __DISASM_FILE_PARAM = "file"
+const __DISASM_FILE_USAGE = "Source code or object file"
+const __DISASM_FILE_DEFAULT = ""
+const __DISASM_EXPRESSION_PARAM = "expression"
+const __DISASM_EXPRESSION_USAGE = "Source code"
+const __DISASM_EXPRESSION_DEFAULT = ""
+const __DISASM_LANGUAGE_PARAM = "language"
+const __DISASM_LANGUAGE_USAGE = "Source language (" + __BRAINFUCK_EXTENSION + ", " + __BEFUNGE_EXTENSION + ", " + __FUNGE98_EXTENSION + ", " + __WHITESPACE_EXTENSION + " or " + __ASM_EXTENSION + "), by default chosen from the file extension"
+const __DISASM_LANGUAGE_DEFAULT = ""
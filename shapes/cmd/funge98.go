@@ -0,0 +1,62 @@
+// Copyright © 2017 Johnny Morrice <john@functorama.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/johnny-morrice/shapes/befunge"
+)
+
+// funge98Cmd represents the funge98 command
+var funge98Cmd = &cobra.Command{
+	Use:     "funge98",
+	Short:   "Befunge-98 interpreter",
+	Long:    "Befunge-98 interpreter, with the NULL, BOOL, ROMA and MODU fingerprints",
+	Example: "shapes funge98 --file prog." + __FUNGE98_EXTENSION,
+	Run:     runFunge98,
+}
+
+func runFunge98(cmd *cobra.Command, args []string) {
+	ast, err := befunge.Parse98(getSource(cmd))
+
+	if err != nil {
+		die(err)
+	}
+
+	runProcess(compileAST(ast))
+}
+
+func init() {
+	RootCmd.AddCommand(funge98Cmd)
+
+	funge98Cmd.Flags().StringVar(&sourceFile, __FUNGE98_FILE_PARAM, __FUNGE98_FILE_DEFAULT, __FUNGE98_FILE_USAGE)
+	funge98Cmd.Flags().StringVar(&expression, __FUNGE98_EXPRESSION_PARAM, __FUNGE98_EXPRESSION_DEFAULT, __FUNGE98_EXPRESSION_USAGE)
+	addRuntimeFlags(funge98Cmd)
+}
+
+const __FUNGE98_EXTENSION = "b98"
+const __FUNGE98_FILE_PARAM = "file"
+const __FUNGE98_FILE_USAGE = "Befunge-98 source code file"
+const __FUNGE98_FILE_DEFAULT = ""
+const __FUNGE98_EXPRESSION_PARAM = "expression"
+const __FUNGE98_EXPRESSION_USAGE = "Befunge-98 source code"
+const __FUNGE98_EXPRESSION_DEFAULT = ""
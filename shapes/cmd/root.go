@@ -14,38 +14,86 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/johnny-morrice/shapes"
+	"github.com/johnny-morrice/shapes/asm"
+	"github.com/johnny-morrice/shapes/befunge"
+	"github.com/johnny-morrice/shapes/brainfuck"
+	"github.com/johnny-morrice/shapes/whitespace"
 )
 
 var cfgFile string
+var sourceFile string
+var expression string
+var language string
+var eofPolicy string
+var tapeBackend string
+var tapeLength int
+var tapeEdge string
+var timeout time.Duration
+var maxSteps uint64
+var maxMemory uint64
+var traceFormat string
+var traceFile string
+var traceSteps int
+var profileFile string
+var profileTop int
+
+// traceRing keeps the last steps for dumping if the program fails.
+var traceRing *shapes.RingTraceSink
+
+// profiler is set when either profile flag is given.
+var profiler *shapes.Profiler
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
-	Use:   "shapes",
-	Short: "Esoteric programming language interpreter",
-	// Later we'll support more languages, but just hardcode brainfuck now.
-	Example: "shapes prog." + __BRAINFUCK_EXTENSION,
+	Use:     "shapes",
+	Short:   "Esoteric programming language interpreter",
+	Example: "shapes prog." + __BRAINFUCK_EXTENSION + "\nshapes prog." + __BEFUNGE_EXTENSION + "\nshapes prog." + __FUNGE98_EXTENSION + "\nshapes prog." + __WHITESPACE_EXTENSION,
 	Args:    cobra.ExactArgs(1),
 	PreRun: func(cmd *cobra.Command, args []string) {
-		const extension = "." + __BRAINFUCK_EXTENSION
-
 		if len(args) == 0 {
 			dieHelp(cmd)
 		}
 
-		if strings.HasSuffix(args[0], extension) {
-			sourceFile = args[0]
-		} else {
+		sourceFile = args[0]
+
+		if getInterpreter() == nil {
 			dieHelp(cmd)
 		}
 	},
-	Run: runBrainfuck,
+	Run: func(cmd *cobra.Command, args []string) {
+		getInterpreter()(cmd, args)
+	},
+}
+
+// getInterpreter chooses the command that runs the source file by its
+// extension, or nil if there is none.
+func getInterpreter() func(cmd *cobra.Command, args []string) {
+	extension := strings.TrimPrefix(filepath.Ext(sourceFile), ".")
+
+	return __INTERPRETERS[extension]
+}
+
+var __INTERPRETERS = map[string]func(cmd *cobra.Command, args []string){
+	__BRAINFUCK_EXTENSION:    runBrainfuck,
+	__BEFUNGE_EXTENSION:      runBefunge,
+	__BEFUNGE_LONG_EXTENSION: runBefunge,
+	__FUNGE98_EXTENSION:      runFunge98,
+	__WHITESPACE_EXTENSION:   runWhitespace,
 }
 
 func dieHelp(cmd *cobra.Command) {
@@ -62,6 +110,290 @@ func die(err error) {
 	os.Exit(__EXIT_FAILURE)
 }
 
+func getSource(cmd *cobra.Command) []byte {
+	if expression != "" {
+		return []byte(expression)
+	}
+
+	if sourceFile == "" {
+		dieHelp(cmd)
+	}
+
+	source, err := ioutil.ReadFile(sourceFile)
+
+	if err != nil {
+		die(err)
+	}
+
+	return source
+}
+
+type parseFunc func(source []byte) (*asm.AST, error)
+
+var __FRONTENDS = map[string]parseFunc{
+	__BRAINFUCK_EXTENSION:    brainfuck.Parse,
+	__BEFUNGE_EXTENSION:      befunge.Parse,
+	__BEFUNGE_LONG_EXTENSION: befunge.Parse,
+	__FUNGE98_EXTENSION:      befunge.Parse98,
+	__WHITESPACE_EXTENSION:   whitespace.Parse,
+	__ASM_EXTENSION:          asm.Parse,
+}
+
+// getFrontend chooses a parser by language name, falling back on the source
+// file extension.
+func getFrontend(language string) parseFunc {
+	if language == "" {
+		language = strings.TrimPrefix(filepath.Ext(sourceFile), ".")
+	}
+
+	frontend, ok := __FRONTENDS[language]
+
+	if !ok {
+		die(fmt.Errorf("Unknown language '%s'", language))
+	}
+
+	return frontend
+}
+
+func compileSource(cmd *cobra.Command) *shapes.Process {
+	source := getSource(cmd)
+	parse := getFrontend(language)
+
+	ast, err := parse(source)
+
+	if err != nil {
+		die(err)
+	}
+
+	locateSource(ast)
+
+	return compileAST(ast)
+}
+
+// locateSource names the source file in positions recorded by the frontend.
+func locateSource(ast *asm.AST) {
+	if expression == "" && sourceFile != "" {
+		ast.SetSourceFile(sourceFile)
+	}
+}
+
+func compileAST(ast *asm.AST) *shapes.Process {
+	process, err := shapes.Compile(ast, shapes.StdLib())
+
+	if err != nil {
+		die(err)
+	}
+
+	return process
+}
+
+// runProcess executes a process on stdin and stdout, configured by the flags
+// added with addRuntimeFlags.
+func runProcess(process *shapes.Process) {
+	executeBuilder(runtimeBuilder(process))
+}
+
+func runtimeBuilder(process *shapes.Process) *shapes.RuntimeBuilder {
+	eof, ok := __EOF_POLICIES[eofPolicy]
+
+	if !ok {
+		die(fmt.Errorf("Unknown EOF policy '%s'", eofPolicy))
+	}
+
+	builder := &shapes.RuntimeBuilder{
+		Process:     process,
+		Library:     runtimeLibrary(),
+		Input:       os.Stdin,
+		Output:      os.Stdout,
+		EOF:         eof,
+		MaxSteps:    maxSteps,
+		MemoryLimit: maxMemory,
+		Trace:       traceSink(),
+	}
+
+	if profileFile != "" || profileTop > 0 {
+		profiler = shapes.MakeProfiler(process)
+		builder.Hooks = append(builder.Hooks, profiler.Hook)
+	}
+
+	return builder
+}
+
+func traceSink() shapes.TraceSink {
+	switch traceFormat {
+	case __TRACE_NONE:
+		return nil
+	case __TRACE_RING:
+		traceRing = shapes.MakeRingTraceSink(traceSteps)
+		return traceRing
+	case __TRACE_TEXT:
+		return &shapes.TextTraceSink{W: traceOutput()}
+	case __TRACE_JSONL:
+		return &shapes.JSONTraceSink{W: traceOutput()}
+	}
+
+	die(fmt.Errorf("Unknown trace format '%s'", traceFormat))
+	return nil
+}
+
+// traceOutput is buffered, so it must be flushed with flushTrace.
+func traceOutput() io.Writer {
+	if traceWriter != nil {
+		return traceWriter
+	}
+
+	var file io.Writer = os.Stderr
+
+	if traceFile != "" {
+		created, err := os.Create(traceFile)
+
+		if err != nil {
+			die(err)
+		}
+
+		file = created
+	}
+
+	traceWriter = bufio.NewWriter(file)
+
+	return traceWriter
+}
+
+var traceWriter *bufio.Writer
+
+func flushTrace() {
+	if traceWriter != nil {
+		traceWriter.Flush()
+	}
+}
+
+func dumpTraceRing(process *shapes.Process) {
+	if traceRing == nil {
+		return
+	}
+
+	fmt.Fprintf(traceOutput(), "LAST %d STEPS:\n", len(traceRing.Events()))
+	traceRing.Dump(traceOutput(), process)
+}
+
+func executeBuilder(builder *shapes.RuntimeBuilder) {
+	ctx := context.Background()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := builder.Build().ExecuteContext(ctx)
+
+	if err != nil {
+		dumpTraceRing(builder.Process)
+		flushTrace()
+		writeProfile()
+		die(err)
+	}
+
+	flushTrace()
+	writeProfile()
+}
+
+// writeProfile reports on the profiled program, even if it failed.
+func writeProfile() {
+	if profiler == nil {
+		return
+	}
+
+	if profileTop > 0 {
+		profiler.Report(os.Stderr, profileTop)
+	}
+
+	if profileFile == "" {
+		return
+	}
+
+	file, err := os.Create(profileFile)
+
+	if err != nil {
+		die(err)
+	}
+
+	defer file.Close()
+
+	err = profiler.WritePprof(file)
+
+	if err != nil {
+		die(err)
+	}
+}
+
+// runtimeLibrary gives the StdLib with its tape functions replaced by the
+// chosen backend.  Functions keep their indices, so bytecode compiled against
+// the StdLib runs unchanged.
+func runtimeLibrary() *shapes.Library {
+	switch tapeBackend {
+	case __TAPE_INFINITE:
+		return shapes.StdLib()
+	case __TAPE_BOUNDED:
+		edge, ok := __TAPE_EDGES[tapeEdge]
+
+		if !ok {
+			die(fmt.Errorf("Unknown tape edge '%s'", tapeEdge))
+		}
+
+		return shapes.StdLib().Override(shapes.BoundedTapeLibrary(tapeLength, edge))
+	}
+
+	die(fmt.Errorf("Unknown tape '%s'", tapeBackend))
+	return nil
+}
+
+func addRuntimeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&eofPolicy, __EOF_PARAM, __EOF_DEFAULT, __EOF_USAGE)
+	cmd.Flags().StringVar(&tapeBackend, __TAPE_PARAM, __TAPE_DEFAULT, __TAPE_USAGE)
+	cmd.Flags().IntVar(&tapeLength, __TAPE_LENGTH_PARAM, __TAPE_LENGTH_DEFAULT, __TAPE_LENGTH_USAGE)
+	cmd.Flags().StringVar(&tapeEdge, __TAPE_EDGE_PARAM, __TAPE_EDGE_DEFAULT, __TAPE_EDGE_USAGE)
+	cmd.Flags().DurationVar(&timeout, __TIMEOUT_PARAM, __TIMEOUT_DEFAULT, __TIMEOUT_USAGE)
+	cmd.Flags().Uint64Var(&maxSteps, __MAX_STEPS_PARAM, __MAX_STEPS_DEFAULT, __MAX_STEPS_USAGE)
+	cmd.Flags().Uint64Var(&maxMemory, __MAX_MEMORY_PARAM, __MAX_MEMORY_DEFAULT, __MAX_MEMORY_USAGE)
+	cmd.Flags().StringVar(&traceFormat, __TRACE_PARAM, __TRACE_DEFAULT, __TRACE_USAGE)
+	cmd.Flags().StringVar(&traceFile, __TRACE_FILE_PARAM, __TRACE_FILE_DEFAULT, __TRACE_FILE_USAGE)
+	cmd.Flags().IntVar(&traceSteps, __TRACE_STEPS_PARAM, __TRACE_STEPS_DEFAULT, __TRACE_STEPS_USAGE)
+	cmd.Flags().StringVar(&profileFile, __PROFILE_PARAM, __PROFILE_DEFAULT, __PROFILE_USAGE)
+	cmd.Flags().IntVar(&profileTop, __PROFILE_TOP_PARAM, __PROFILE_TOP_DEFAULT, __PROFILE_TOP_USAGE)
+}
+
+var __TAPE_EDGES = map[string]shapes.TapeEdge{
+	"error": shapes.TAPE_EDGE_ERROR,
+	"wrap":  shapes.TAPE_EDGE_WRAP,
+	"clamp": shapes.TAPE_EDGE_CLAMP,
+}
+
+var __EOF_POLICIES = map[string]shapes.EOFPolicy{
+	"error":     shapes.EOF_ERROR,
+	"unchanged": shapes.EOF_UNCHANGED,
+	"zero":      shapes.EOF_ZERO,
+	"all-ones":  shapes.EOF_ALL_ONES,
+}
+
+func readObjectFile(path string) *shapes.Process {
+	file, err := os.Open(path)
+
+	if err != nil {
+		die(err)
+	}
+
+	defer file.Close()
+
+	process, err := shapes.ReadObject(file, shapes.StdLib())
+
+	if err != nil {
+		die(err)
+	}
+
+	return process
+}
+
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -101,3 +433,45 @@ func initConfig() {
 }
 
 const __EXIT_FAILURE = 1
+const __EOF_PARAM = "eof"
+const __EOF_USAGE = "What reading past the end of input gives: error, unchanged, zero or all-ones"
+const __EOF_DEFAULT = "error"
+const __TAPE_INFINITE = "infinite"
+const __TAPE_BOUNDED = "bounded"
+const __TAPE_PARAM = "tape"
+const __TAPE_USAGE = "Tape backend: infinite, or bounded with --tape-length cells"
+const __TAPE_DEFAULT = __TAPE_INFINITE
+const __TAPE_LENGTH_PARAM = "tape-length"
+const __TAPE_LENGTH_USAGE = "Number of cells on a bounded tape"
+const __TAPE_LENGTH_DEFAULT = 30000
+const __TAPE_EDGE_PARAM = "tape-edge"
+const __TAPE_EDGE_USAGE = "What moving past the end of a bounded tape does: error, wrap or clamp"
+const __TAPE_EDGE_DEFAULT = "error"
+const __TIMEOUT_PARAM = "timeout"
+const __TIMEOUT_USAGE = "Stop the program after this long, such as 10s; zero for no limit"
+const __TIMEOUT_DEFAULT = 0
+const __MAX_STEPS_PARAM = "max-steps"
+const __MAX_STEPS_USAGE = "Stop the program after executing this many operations; zero for no limit"
+const __MAX_STEPS_DEFAULT = 0
+const __MAX_MEMORY_PARAM = "max-memory"
+const __MAX_MEMORY_USAGE = "Stop the program when its stacks and tapes would use more than this many bytes; zero for no limit"
+const __MAX_MEMORY_DEFAULT = 0
+const __TRACE_NONE = "none"
+const __TRACE_TEXT = "text"
+const __TRACE_JSONL = "jsonl"
+const __TRACE_RING = "ring"
+const __TRACE_PARAM = "trace"
+const __TRACE_USAGE = "Trace each step: none, text, jsonl, or ring to keep the last --trace-steps and print them if the program fails"
+const __TRACE_DEFAULT = __TRACE_NONE
+const __TRACE_FILE_PARAM = "trace-file"
+const __TRACE_FILE_USAGE = "File to write the trace to, instead of stderr"
+const __TRACE_FILE_DEFAULT = ""
+const __TRACE_STEPS_PARAM = "trace-steps"
+const __TRACE_STEPS_USAGE = "Number of steps kept by the ring trace"
+const __TRACE_STEPS_DEFAULT = 64
+const __PROFILE_PARAM = "profile"
+const __PROFILE_USAGE = "File to write a pprof profile of the program to, for go tool pprof"
+const __PROFILE_DEFAULT = ""
+const __PROFILE_TOP_PARAM = "profile-top"
+const __PROFILE_TOP_USAGE = "Print a report of the N most executed addresses, loops, positions and functions"
+const __PROFILE_TOP_DEFAULT = 0
@@ -0,0 +1,61 @@
+// Copyright © 2017 Johnny Morrice <john@functorama.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/johnny-morrice/shapes/whitespace"
+)
+
+// whitespaceCmd represents the whitespace command
+var whitespaceCmd = &cobra.Command{
+	Use:     "whitespace",
+	Short:   "Whitespace interpreter",
+	Example: "shapes whitespace --file prog." + __WHITESPACE_EXTENSION,
+	Run:     runWhitespace,
+}
+
+func runWhitespace(cmd *cobra.Command, args []string) {
+	ast, err := whitespace.Parse(getSource(cmd))
+
+	if err != nil {
+		die(err)
+	}
+
+	runProcess(compileAST(ast))
+}
+
+func init() {
+	RootCmd.AddCommand(whitespaceCmd)
+
+	whitespaceCmd.Flags().StringVar(&sourceFile, __WHITESPACE_FILE_PARAM, __WHITESPACE_FILE_DEFAULT, __WHITESPACE_FILE_USAGE)
+	whitespaceCmd.Flags().StringVar(&expression, __WHITESPACE_EXPRESSION_PARAM, __WHITESPACE_EXPRESSION_DEFAULT, __WHITESPACE_EXPRESSION_USAGE)
+	addRuntimeFlags(whitespaceCmd)
+}
+
+const __WHITESPACE_EXTENSION = "ws"
+const __WHITESPACE_FILE_PARAM = "file"
+const __WHITESPACE_FILE_USAGE = "Whitespace source code file"
+const __WHITESPACE_FILE_DEFAULT = ""
+const __WHITESPACE_EXPRESSION_PARAM = "expression"
+const __WHITESPACE_EXPRESSION_USAGE = "Whitespace source code"
+const __WHITESPACE_EXPRESSION_DEFAULT = ""
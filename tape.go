@@ -1,117 +1,383 @@
 package shapes
 
 import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
 	"github.com/johnny-morrice/shapes/asm"
 )
 
+// Tape is the storage behind the tape VmFunctions.  The head starts on the
+// first cell, and every cell starts at zero.
+type Tape interface {
+	MoveHead(offset int) error
+	ReadHead() uint64
+	WriteHead(val uint64) error
+	// Scan moves the head by step until it reaches a zero cell.
+	Scan(step int) error
+}
+
+// TapeView is a Tape whose cells may be inspected without moving the head, as
+// debuggers do.
+type TapeView interface {
+	Head() int
+	// Cell gives the value at index, which is zero for cells never written.
+	Cell(index int) uint64
+}
+
+// TapeMaker creates a tape with cells cellBits wide, which handles overflow
+// with one of the asm.TAPE_OVERFLOW policies and charges its cells to meter.
+type TapeMaker func(cellBits, overflow int, meter MemoryMeter) (Tape, error)
+
+// cellFormat decides which values a cell may hold.  The zero value has 64-bit
+// cells, which hold any register value, so its overflow policy never applies.
+type cellFormat struct {
+	cellBits int
+	overflow int
+}
+
+func makeCellFormat(cellBits, overflow int) (cellFormat, error) {
+	switch cellBits {
+	case 8, 16, 32, 64:
+	default:
+		return cellFormat{}, fmt.Errorf("Unsupported cell width %d bits", cellBits)
+	}
+
+	switch overflow {
+	case asm.TAPE_OVERFLOW_WRAP, asm.TAPE_OVERFLOW_SATURATE, asm.TAPE_OVERFLOW_ERROR:
+	default:
+		return cellFormat{}, fmt.Errorf("Unknown overflow policy %d", overflow)
+	}
+
+	// A 64-bit cell holds whatever the register held, so an overflow cannot
+	// be told from a large value.
+	if cellBits == 64 && overflow != asm.TAPE_OVERFLOW_WRAP {
+		return cellFormat{}, errors.New("64-bit cells must use the wrap overflow policy")
+	}
+
+	return cellFormat{cellBits: cellBits, overflow: overflow}, nil
+}
+
+// fit applies the overflow policy to a value that does not fit in a cell.
+// Saturation treats values with the top bit set as negative, so that
+// decrementing zero saturates at zero.
+func (format cellFormat) fit(val uint64) (uint64, error) {
+	max := format.maxCell()
+
+	if val <= max {
+		return val, nil
+	}
+
+	switch format.overflow {
+	case asm.TAPE_OVERFLOW_SATURATE:
+		if int64(val) < 0 {
+			return 0, nil
+		}
+
+		return max, nil
+	case asm.TAPE_OVERFLOW_ERROR:
+		return 0, errors.Wrapf(ErrCellOverflow, "%d does not fit in %d bits", int64(val), format.cellBits)
+	}
+
+	return val & max, nil
+}
+
+func (format cellFormat) maxCell() uint64 {
+	if format.cellBits == 0 || format.cellBits == 64 {
+		return ^uint64(0)
+	}
+
+	return (1 << uint(format.cellBits)) - 1
+}
+
+// InfiniteTape is unbounded in both directions.  Cells are allocated when
+// first written, up to the head.
 type InfiniteTape struct {
+	cellFormat
 	left  []uint64
 	right []uint64
 	// Negative index is on left tape.
 	index int
+	meter MemoryMeter
 }
 
-func (tape *InfiniteTape) MoveHead(offset int) {
+// MakeInfiniteTape creates a tape whose cells are cellBits wide, which must be
+// 8, 16, 32 or 64, handling overflow with one of the asm.TAPE_OVERFLOW
+// policies.
+func MakeInfiniteTape(cellBits, overflow int) (*InfiniteTape, error) {
+	format, err := makeCellFormat(cellBits, overflow)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &InfiniteTape{cellFormat: format}, nil
+}
+
+func (tape *InfiniteTape) MoveHead(offset int) error {
 	tape.index += offset
+
+	return nil
 }
 
 func (tape *InfiniteTape) ReadHead() uint64 {
-	return *tape.getCell()
+	return tape.Cell(tape.index)
 }
 
-func (tape *InfiniteTape) WriteHead(val uint64) {
-	cell := tape.getCell()
-	*cell = val
+func (tape *InfiniteTape) Head() int {
+	return tape.index
 }
 
-func (tape *InfiniteTape) getCell() *uint64 {
+func (tape *InfiniteTape) Cell(index int) uint64 {
+	side := tape.right
+
+	if index < 0 {
+		side = tape.left
+		index = (-index) - 1
+	}
+
+	if index < len(side) {
+		return side[index]
+	}
+
+	return 0
+}
+
+func (tape *InfiniteTape) WriteHead(val uint64) error {
+	val, err := tape.fit(val)
+
+	if err != nil {
+		return err
+	}
+
+	side, index := tape.side()
+	err = tape.extendTape(side, index)
+
+	if err != nil {
+		return err
+	}
+
+	(*side)[index] = val
+
+	return nil
+}
+
+// side gives the half of the tape under the head, and the head's index in it.
+func (tape *InfiniteTape) side() (*[]uint64, int) {
 	if tape.index >= 0 {
-		extendTape(&tape.right, tape.index)
-		return &tape.right[tape.index]
-	} else {
-		leftIndex := (-tape.index) - 1
-		extendTape(&tape.left, leftIndex)
-		return &tape.left[leftIndex]
+		return &tape.right, tape.index
 	}
+
+	return &tape.left, (-tape.index) - 1
 }
 
-func extendTape(tape *[]uint64, index int) {
-	t := *tape
+func (tape *InfiniteTape) extendTape(side *[]uint64, index int) error {
+	t := *side
 	grow := (index - len(t)) + 1
 
-	if grow > 0 {
-		*tape = append(t, make([]uint64, grow)...)
+	if grow <= 0 {
+		return nil
 	}
+
+	err := tape.meter.Allocate(uint64(grow) * __CELL_BYTES)
+
+	if err != nil {
+		return err
+	}
+
+	*side = append(t, make([]uint64, grow)...)
+
+	return nil
 }
 
-type infiniteTapeList struct {
-	list []*InfiniteTape
+func (tape *InfiniteTape) Scan(step int) error {
+	for tape.ReadHead() != 0 {
+		tape.MoveHead(step)
+	}
+
+	return nil
 }
 
-func (tape *infiniteTapeList) NewTape() int {
-	index := len(tape.list)
-	tape.list = append(tape.list, &InfiniteTape{})
-	return index
+func makeInfiniteTape(cellBits, overflow int, meter MemoryMeter) (Tape, error) {
+	tape, err := MakeInfiniteTape(cellBits, overflow)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tape.meter = meter
+
+	return tape, nil
 }
 
-func (tape *infiniteTapeList) MoveHead(index int, offset int) {
-	tape.list[index].MoveHead(offset)
+type tapeList struct {
+	list []Tape
 }
 
-func (tape *infiniteTapeList) ReadHead(index int) uint64 {
-	return tape.list[index].ReadHead()
+func (tape *tapeList) NewTape(makeTape TapeMaker, cellBits, overflow int, memory *MemoryAccount) (int, error) {
+	index := len(tape.list)
+	meter := MemoryMeter{
+		Account:  memory,
+		Resource: Resource{Kind: RESOURCE_TAPE, Index: uint64(index)},
+	}
+
+	newTape, err := makeTape(cellBits, overflow, meter)
+
+	if err != nil {
+		return 0, err
+	}
+
+	tape.list = append(tape.list, newTape)
+	return index, nil
 }
 
-func (tape *infiniteTapeList) WriteHead(index int, val uint64) {
-	tape.list[index].WriteHead(val)
+func (tape *tapeList) getTape(index uint64) (Tape, error) {
+	if tape == nil {
+		return nil, fmt.Errorf("No tape at index %d", index)
+	}
+
+	if index >= uint64(len(tape.list)) {
+		return nil, fmt.Errorf("No tape at index %d", index)
+	}
+
+	return tape.list[index], nil
 }
 
-type InfiniteTapeVmWrapper struct {
-	list *infiniteTapeList
+// TapeVmWrapper exposes tapes made by MakeTape to the VM.  Each VmFunction
+// takes the tape index and then its arguments from the stack.  The tapes are
+// kept in the Runtime, so one wrapper may serve many runtimes.
+type TapeVmWrapper struct {
+	MakeTape TapeMaker
 }
 
-func (tape *InfiniteTapeVmWrapper) NewTape(runtime *Runtime, stackAddr Address) {
-	if tape.list == nil {
-		tape.list = &infiniteTapeList{}
-	}
+func (tape *TapeVmWrapper) tapes(runtime *Runtime) *tapeList {
+	return runtime.State(tape, func() interface{} {
+		return &tapeList{}
+	}).(*tapeList)
+}
+
+// NewTape takes the cell width in bits and then the overflow policy.
+func (tape *TapeVmWrapper) NewTape(runtime *Runtime, stackAddr Address) {
+	const errMsg = "tape_new failed"
 
 	runtime.Process.Pop(stackAddr)
-	index := tape.list.NewTape()
+	cellBits := runtime.Process.Pop(stackAddr)
+	overflow := runtime.Process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	index, err := tape.tapes(runtime).NewTape(tape.MakeTape, int(cellBits), int(overflow), runtime.Process.Memory)
+
+	if err != nil {
+		runtime.Process.Error = errors.Wrap(err, errMsg)
+		return
+	}
+
 	runtime.Process.Push(stackAddr, uint64(index))
+
+	if runtime.hasError() {
+		return
+	}
+
 	runtime.Process.IncrementPC()
 }
 
-func (tape *InfiniteTapeVmWrapper) MoveHead(runtime *Runtime, stackAddr Address) {
-	runtime.Process.Pop(stackAddr)
-	index := runtime.Process.Pop(stackAddr)
-	offset := runtime.Process.Pop(stackAddr)
-	tape.list.MoveHead(int(index), int(offset))
-	runtime.Process.IncrementPC()
+func (tape *TapeVmWrapper) MoveHead(runtime *Runtime, stackAddr Address) {
+	const errMsg = "tape_move_head failed"
+
+	tape.withTape(runtime, stackAddr, errMsg, func(t Tape) error {
+		offset := runtime.Process.Pop(stackAddr)
+		return t.MoveHead(int(offset))
+	})
 }
 
-func (tape *InfiniteTapeVmWrapper) ReadHead(runtime *Runtime, stackAddr Address) {
-	runtime.Process.Pop(stackAddr)
-	index := runtime.Process.Pop(stackAddr)
-	val := tape.list.ReadHead(int(index))
-	runtime.Process.Push(stackAddr, val)
-	runtime.Process.IncrementPC()
+func (tape *TapeVmWrapper) Scan(runtime *Runtime, stackAddr Address) {
+	const errMsg = "tape_scan failed"
+
+	tape.withTape(runtime, stackAddr, errMsg, func(t Tape) error {
+		step := runtime.Process.Pop(stackAddr)
+		return t.Scan(int(step))
+	})
 }
 
-func (tape *InfiniteTapeVmWrapper) WriteHead(runtime *Runtime, stackAddr Address) {
+func (tape *TapeVmWrapper) ReadHead(runtime *Runtime, stackAddr Address) {
+	const errMsg = "tape_read_head failed"
+
+	tape.withTape(runtime, stackAddr, errMsg, func(t Tape) error {
+		runtime.Process.Push(stackAddr, t.ReadHead())
+		return nil
+	})
+}
+
+func (tape *TapeVmWrapper) WriteHead(runtime *Runtime, stackAddr Address) {
+	const errMsg = "tape_write_head failed"
+
+	tape.withTape(runtime, stackAddr, errMsg, func(t Tape) error {
+		val := runtime.Process.Pop(stackAddr)
+		return t.WriteHead(val)
+	})
+}
+
+// withTape pops the return address and the tape index, then applies f to the
+// tape.
+func (tape *TapeVmWrapper) withTape(runtime *Runtime, stackAddr Address, errMsg string, f func(t Tape) error) {
 	runtime.Process.Pop(stackAddr)
 	index := runtime.Process.Pop(stackAddr)
-	val := runtime.Process.Pop(stackAddr)
-	tape.list.WriteHead(int(index), val)
+
+	if runtime.hasError() {
+		return
+	}
+
+	target, err := tape.tapes(runtime).getTape(index)
+
+	if err == nil {
+		err = f(target)
+	}
+
+	if err == nil {
+		err = runtime.Process.Error
+	}
+
+	if err != nil {
+		runtime.Process.Error = errors.Wrap(err, errMsg)
+		return
+	}
+
 	runtime.Process.IncrementPC()
 }
 
-func init() {
-	lib := &Library{}
-	tape := &InfiniteTapeVmWrapper{}
+// TapeLibrary registers the tape VmFunctions for tapes made by makeTape.  The
+// functions are always added in the same order, so tape libraries may
+// Override one another.
+func TapeLibrary(makeTape TapeMaker) *Library {
+	tape := &TapeVmWrapper{MakeTape: makeTape}
+	lib := &Library{tapes: tape}
 	lib.AddFunction(asm.TAPE_NEW, tape.NewTape)
 	lib.AddFunction(asm.TAPE_MOVE_HEAD, tape.MoveHead)
 	lib.AddFunction(asm.TAPE_READ_HEAD, tape.ReadHead)
 	lib.AddFunction(asm.TAPE_WRITE_HEAD, tape.WriteHead)
+	lib.AddFunction(asm.TAPE_SCAN, tape.Scan)
 
-	StdLib().AddLibrary(lib)
+	return lib
 }
+
+// InfiniteTapeLibrary registers the tape VmFunctions for infinite tapes, and is
+// the tape module of the StdLib.
+func InfiniteTapeLibrary() *Library {
+	return TapeLibrary(makeInfiniteTape)
+}
+
+// Tape finds a tape created by the tape_new VmFunction of the runtime's
+// Library.
+func (runtime *Runtime) Tape(index uint64) (Tape, error) {
+	if runtime.Library.tapes == nil {
+		return nil, errors.New("Library has no tapes")
+	}
+
+	return runtime.Library.tapes.tapes(runtime).getTape(index)
+}
+
+var ErrCellOverflow = errors.New("cell overflow")
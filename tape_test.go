@@ -2,6 +2,10 @@ package shapes
 
 import (
 	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
 )
 
 func TestInfiniteTapeMoveHead(t *testing.T) {
@@ -32,6 +36,70 @@ func TestInfiniteTapeReadWriteHead(t *testing.T) {
 	readExpect(t, expect, tape)
 }
 
+func TestInfiniteTapeOverflow(t *testing.T) {
+	const minusOne = 0xFFFFFFFFFFFFFFFF
+
+	testCases := []struct {
+		cellBits int
+		overflow int
+		write    uint64
+		expect   uint64
+		ok       bool
+	}{
+		{8, asm.TAPE_OVERFLOW_WRAP, 256, 0, true},
+		{8, asm.TAPE_OVERFLOW_WRAP, minusOne, 255, true},
+		{16, asm.TAPE_OVERFLOW_WRAP, 65537, 1, true},
+		{32, asm.TAPE_OVERFLOW_WRAP, 1 << 32, 0, true},
+		{64, asm.TAPE_OVERFLOW_WRAP, minusOne, minusOne, true},
+		{8, asm.TAPE_OVERFLOW_SATURATE, 300, 255, true},
+		{8, asm.TAPE_OVERFLOW_SATURATE, minusOne, 0, true},
+		{16, asm.TAPE_OVERFLOW_SATURATE, 70000, 65535, true},
+		{8, asm.TAPE_OVERFLOW_ERROR, 255, 255, true},
+		{8, asm.TAPE_OVERFLOW_ERROR, 256, 0, false},
+		{32, asm.TAPE_OVERFLOW_ERROR, minusOne, 0, false},
+	}
+
+	for i, test := range testCases {
+		tape, err := MakeInfiniteTape(test.cellBits, test.overflow)
+
+		if err != nil {
+			t.Errorf("Unexpected error in case %d: %s", i, err.Error())
+			continue
+		}
+
+		err = tape.WriteHead(test.write)
+
+		if (err == nil) != test.ok {
+			t.Errorf("Unexpected error state in case %d: %v", i, err)
+			continue
+		}
+
+		if err != nil && errors.Cause(err) != ErrCellOverflow {
+			t.Errorf("Expected cell overflow in case %d but received %v", i, err)
+		}
+
+		readExpect(t, test.expect, tape)
+	}
+}
+
+func TestMakeInfiniteTape_Failure(t *testing.T) {
+	failureCases := [][2]int{
+		{0, asm.TAPE_OVERFLOW_WRAP},
+		{12, asm.TAPE_OVERFLOW_WRAP},
+		{8, 3},
+		{64, asm.TAPE_OVERFLOW_SATURATE},
+		{64, asm.TAPE_OVERFLOW_ERROR},
+	}
+
+	for i, test := range failureCases {
+		_, err := MakeInfiniteTape(test[0], test[1])
+
+		if err == nil {
+			t.Errorf("Expected failure in case %d", i)
+		}
+	}
+}
+
 func readExpect(t *testing.T, expect uint64, tape *InfiniteTape) {
 	t.Helper()
 	actual := tape.ReadHead()
@@ -40,3 +108,36 @@ func readExpect(t *testing.T, expect uint64, tape *InfiniteTape) {
 		t.Errorf("Expected %d but received %d", expect, actual)
 	}
 }
+
+func TestInfiniteTape_MemoryLimit(t *testing.T) {
+	account := &MemoryAccount{Limit: 4 * __CELL_BYTES}
+	resource := Resource{Kind: RESOURCE_TAPE, Index: 2}
+	tape := &InfiniteTape{meter: MemoryMeter{Account: account, Resource: resource}}
+
+	tape.MoveHead(1000)
+	readExpect(t, 0, tape)
+
+	if account.Used() != 0 {
+		t.Errorf("Expected reading to allocate nothing but used %d bytes", account.Used())
+	}
+
+	tape.MoveHead(-997)
+	err := tape.WriteHead(1)
+
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+
+	tape.MoveHead(1)
+	err = tape.WriteHead(1)
+
+	quota, ok := err.(*QuotaError)
+
+	if !ok {
+		t.Fatalf("Expected quota error but received %v", err)
+	}
+
+	if quota.Resource != resource {
+		t.Errorf("Expected quota exceeded by %v but was %v", resource, quota.Resource)
+	}
+}
@@ -0,0 +1,174 @@
+package shapes
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// threadContext is the state of a thread while another runs.  Threads share
+// the tapes and other resources of the process, and all but one of its
+// stacks.
+type threadContext struct {
+	pc        Address
+	registers [REGISTER_COUNT]uint64
+	callStack []Address
+	// The stack the thread VmFunctions were called with.
+	stack []uint64
+}
+
+func saveThread(process *Process, stackAddr Address) *threadContext {
+	return &threadContext{
+		pc:        process.PC,
+		registers: process.Register,
+		callStack: process.CallStack,
+		stack:     process.Stack[stackAddr],
+	}
+}
+
+func (thread *threadContext) restore(process *Process, stackAddr Address) {
+	process.PC = thread.pc
+	process.Register = thread.registers
+	process.CallStack = thread.callStack
+	process.Stack[stackAddr] = thread.stack
+}
+
+// threadQueue holds the threads that are waiting to run, in the order they
+// will run.  Each thread beside the first is charged to the meter.
+type threadQueue struct {
+	waiting []*threadContext
+	meter   MemoryMeter
+}
+
+func (queue *threadQueue) next() *threadContext {
+	thread := queue.waiting[0]
+	queue.waiting = queue.waiting[1:]
+
+	return thread
+}
+
+// ThreadVmWrapper runs threads in turn on a process.  A thread runs until it
+// yields or exits, and then the thread that has waited longest takes over.
+// Each Runtime has one queue of threads.
+type ThreadVmWrapper struct{}
+
+// threadKey keeps the thread queue in the Runtime.
+type threadKey struct{}
+
+func (wrapper *ThreadVmWrapper) queue(runtime *Runtime) *threadQueue {
+	return runtime.State(threadKey{}, func() interface{} {
+		return &threadQueue{
+			meter: MemoryMeter{
+				Account:  runtime.Process.Memory,
+				Resource: Resource{Kind: RESOURCE_THREAD},
+			},
+		}
+	}).(*threadQueue)
+}
+
+// Fork starts a thread that will resume after the call, with copies of the
+// registers, the call stack and the stack.  The new thread finds 1 pushed on
+// its stack, and the current thread, which carries on, finds 0.
+func (wrapper *ThreadVmWrapper) Fork(runtime *Runtime, stackAddr Address) {
+	const errMsg = "thread_fork failed"
+
+	process := runtime.Process
+	process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	queue := wrapper.queue(runtime)
+
+	if len(queue.waiting)+1 >= MAX_THREADS {
+		process.Error = errors.Wrap(ErrTooManyThreads, errMsg)
+		return
+	}
+
+	stack := process.Stack[stackAddr]
+	err := queue.meter.Allocate(__THREAD_BYTES + uint64(len(stack)+1)*__CELL_BYTES)
+
+	if err != nil {
+		process.Error = errors.Wrap(err, errMsg)
+		return
+	}
+
+	child := &threadContext{
+		pc:        process.PC + 1,
+		registers: process.Register,
+		callStack: append([]Address(nil), process.CallStack...),
+		stack:     append(append([]uint64(nil), stack...), 1),
+	}
+	queue.waiting = append(queue.waiting, child)
+
+	process.Push(stackAddr, 0)
+
+	if runtime.hasError() {
+		return
+	}
+
+	process.IncrementPC()
+}
+
+// Yield lets the next thread run, if any is waiting.
+func (wrapper *ThreadVmWrapper) Yield(runtime *Runtime, stackAddr Address) {
+	process := runtime.Process
+	process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	process.IncrementPC()
+
+	queue := wrapper.queue(runtime)
+
+	if len(queue.waiting) == 0 {
+		return
+	}
+
+	queue.waiting = append(queue.waiting, saveThread(process, stackAddr))
+	queue.next().restore(process, stackAddr)
+}
+
+// Exit ends the current thread and lets the next run.  The process
+// terminates when the last thread exits.
+func (wrapper *ThreadVmWrapper) Exit(runtime *Runtime, stackAddr Address) {
+	process := runtime.Process
+	process.Pop(stackAddr)
+
+	if runtime.hasError() {
+		return
+	}
+
+	queue := wrapper.queue(runtime)
+
+	if len(queue.waiting) == 0 {
+		process.PC = Address(len(process.ByteCode))
+		return
+	}
+
+	queue.meter.Free(__THREAD_BYTES + uint64(len(process.Stack[stackAddr]))*__CELL_BYTES)
+	queue.next().restore(process, stackAddr)
+}
+
+// ThreadLibrary registers the thread VmFunctions.
+func ThreadLibrary() *Library {
+	wrapper := &ThreadVmWrapper{}
+	lib := &Library{}
+	lib.AddFunction(asm.THREAD_FORK, wrapper.Fork)
+	lib.AddFunction(asm.THREAD_YIELD, wrapper.Yield)
+	lib.AddFunction(asm.THREAD_EXIT, wrapper.Exit)
+
+	return lib
+}
+
+// MAX_THREADS limits the number of threads, so that runaway forking fails
+// with ErrTooManyThreads.
+const MAX_THREADS = 4096
+
+var ErrTooManyThreads = errors.New("too many threads")
+
+// A thread is charged for its registers.
+const __THREAD_BYTES = REGISTER_COUNT * __CELL_BYTES
@@ -0,0 +1,79 @@
+package shapes
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestThreadVmWrapper(t *testing.T) {
+	wrapper := &ThreadVmWrapper{}
+	runtime := (&RuntimeBuilder{Process: &Process{ByteCode: make([]Operation, 10)}}).Build()
+	process := runtime.Process
+	call := func(vmFunc VmFunction, pc Address) {
+		process.PC = pc
+		pushArgs(process)
+		vmFunc(runtime, 0)
+
+		if process.Error != nil {
+			t.Fatalf("Unexpected error: %s", process.Error.Error())
+		}
+	}
+
+	process.Register[0] = 7
+	call(wrapper.Fork, 3)
+
+	if process.PC != 4 || process.Pop(0) != 0 {
+		t.Fatal("Expected the forking thread to carry on")
+	}
+
+	process.Register[0] = 8
+	call(wrapper.Yield, 6)
+
+	if process.PC != 4 || process.Register[0] != 7 || process.Pop(0) != 1 {
+		t.Fatal("Expected the new thread to run")
+	}
+
+	call(wrapper.Exit, 5)
+
+	if process.PC != 7 || process.Register[0] != 8 || len(process.Stack[0]) != 0 {
+		t.Fatal("Expected the forking thread to resume")
+	}
+
+	call(wrapper.Yield, 7)
+
+	if process.PC != 8 {
+		t.Errorf("Expected yield to carry on alone but PC was %d", process.PC)
+	}
+
+	call(wrapper.Exit, 8)
+
+	if !process.IsTerminated() {
+		t.Error("Expected the last exit to terminate the process")
+	}
+
+	if used := process.Memory.Used(); used != 0 {
+		t.Errorf("Expected threads to free their memory but %d bytes are used", used)
+	}
+}
+
+func TestThreadVmWrapper_TooManyThreads(t *testing.T) {
+	wrapper := &ThreadVmWrapper{}
+	runtime := (&RuntimeBuilder{Process: &Process{ByteCode: make([]Operation, 1)}}).Build()
+	process := runtime.Process
+
+	for i := 0; i < MAX_THREADS; i++ {
+		pushArgs(process)
+		wrapper.Fork(runtime, 0)
+
+		if process.Error != nil {
+			break
+		}
+
+		process.Pop(0)
+	}
+
+	if errors.Cause(process.Error) != ErrTooManyThreads {
+		t.Errorf("Expected too many threads but received %v", process.Error)
+	}
+}
@@ -0,0 +1,284 @@
+package shapes
+
+import (
+	"bytes"
+	endian "encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// TraceEvent describes one executed operation.
+type TraceEvent struct {
+	// Step counts from 1.
+	Step      uint64
+	PC        Address
+	Operation Operation
+	Position  asm.SourcePos
+	// Registers are those the operation read or wrote, with their values
+	// afterwards.
+	Registers []RegisterValue
+	// Function is the VmFunction called by OP_CALL.
+	Function string
+}
+
+type RegisterValue struct {
+	Register Address
+	Value    uint64
+}
+
+// TraceSink records the TraceEvents of a Runtime.  An error from Trace fails
+// the process.
+type TraceSink interface {
+	Trace(event *TraceEvent) error
+}
+
+func (runtime *Runtime) trace(pc Address) {
+	op := runtime.Process.ByteCode[pc]
+	event := &TraceEvent{
+		Step:      runtime.steps,
+		PC:        pc,
+		Operation: op,
+		Position:  runtime.Process.Position(pc),
+	}
+
+	for _, reg := range touchedRegisters(op) {
+		event.Registers = append(event.Registers, RegisterValue{
+			Register: reg,
+			Value:    runtime.Process.Register[reg],
+		})
+	}
+
+	if op.OpCode == OP_CALL {
+		event.Function, _ = runtime.Library.GetFunctionName(int(op.Operand[0]))
+	}
+
+	err := runtime.Trace.Trace(event)
+
+	if err != nil && runtime.Process.Error == nil {
+		runtime.Process.Error = err
+	}
+}
+
+func touchedRegisters(op Operation) []Address {
+	switch op.OpCode {
+	case OP_ADD, OP_SUB, OP_COPY, OP_MUL, OP_DIV, OP_MOD, OP_SDIV, OP_SMOD, OP_AND, OP_OR, OP_XOR, OP_SHL, OP_SHR, OP_SAR, OP_EQ, OP_LT, OP_GT, OP_SLT, OP_SGT:
+		if op.Operand[0] == op.Operand[1] {
+			return []Address{op.Address(0)}
+		}
+
+		return []Address{op.Address(0), op.Address(1)}
+	case OP_PUSH, OP_POP:
+		return []Address{op.Address(1)}
+	case OP_READ, OP_WRITE, OP_NOT, OP_SET, OP_ADDI, OP_SUBI, OP_JMPNZ, OP_JZ:
+		return []Address{op.Address(0)}
+	}
+
+	return nil
+}
+
+// TextTraceSink writes one line per event for people to read.
+type TextTraceSink struct {
+	W io.Writer
+}
+
+func (sink *TextTraceSink) Trace(event *TraceEvent) error {
+	_, err := fmt.Fprintln(sink.W, event.String())
+	return err
+}
+
+func (event *TraceEvent) String() string {
+	parts := []string{fmt.Sprintf("%d %d %v", event.Step, event.PC, event.Operation)}
+
+	if event.Function != "" {
+		parts = append(parts, event.Function)
+	}
+
+	for _, reg := range event.Registers {
+		parts = append(parts, fmt.Sprintf("r%d=%d", reg.Register, reg.Value))
+	}
+
+	if event.Position.IsKnown() {
+		parts = append(parts, event.Position.String())
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// JSONTraceSink writes each event as a line of JSON.
+type JSONTraceSink struct {
+	W       io.Writer
+	encoder *json.Encoder
+}
+
+type jsonTraceEvent struct {
+	Step      uint64            `json:"step"`
+	PC        Address           `json:"pc"`
+	OpCode    string            `json:"op"`
+	Operands  [2]Operand        `json:"operands"`
+	Registers map[string]uint64 `json:"registers,omitempty"`
+	Function  string            `json:"function,omitempty"`
+	Position  string            `json:"position,omitempty"`
+}
+
+func (sink *JSONTraceSink) Trace(event *TraceEvent) error {
+	if sink.encoder == nil {
+		sink.encoder = json.NewEncoder(sink.W)
+	}
+
+	record := jsonTraceEvent{
+		Step:     event.Step,
+		PC:       event.PC,
+		OpCode:   event.Operation.OpCode.String(),
+		Operands: event.Operation.Operand,
+		Function: event.Function,
+	}
+
+	if len(event.Registers) > 0 {
+		record.Registers = map[string]uint64{}
+
+		for _, reg := range event.Registers {
+			record.Registers[fmt.Sprintf("r%d", reg.Register)] = reg.Value
+		}
+	}
+
+	if event.Position.IsKnown() {
+		record.Position = event.Position.String()
+	}
+
+	return sink.encoder.Encode(record)
+}
+
+// RingTraceSink keeps the last events in a fixed amount of memory, for dumping
+// after a crash.  Events are packed into records of __TRACE_RECORD_SIZE
+// bytes; positions are not kept, but may be found from the PC.
+type RingTraceSink struct {
+	records []byte
+	// Index of the next record to write.
+	next  int
+	count int
+	// Function names, referred to by index plus one so that zero means none.
+	functions     []string
+	functionIndex map[string]uint16
+}
+
+// MakeRingTraceSink creates a sink that keeps the last size events.
+func MakeRingTraceSink(size int) *RingTraceSink {
+	if size < 1 {
+		size = 1
+	}
+
+	return &RingTraceSink{
+		records:       make([]byte, size*__TRACE_RECORD_SIZE),
+		functionIndex: map[string]uint16{},
+	}
+}
+
+// A record is the step, PC, opcode, both operands, the number of registers,
+// two register and value pairs, and the function.
+func (sink *RingTraceSink) Trace(event *TraceEvent) error {
+	buff := bytes.NewBuffer(make([]byte, 0, __TRACE_RECORD_SIZE))
+	writeUint(buff, event.Step)
+	writeUint(buff, uint64(event.PC))
+	buff.WriteByte(byte(event.Operation.OpCode))
+	writeUint(buff, uint64(event.Operation.Operand[0]))
+	writeUint(buff, uint64(event.Operation.Operand[1]))
+	buff.WriteByte(byte(len(event.Registers)))
+
+	for i := 0; i < __TRACE_MAX_REGISTERS; i++ {
+		var reg RegisterValue
+
+		if i < len(event.Registers) {
+			reg = event.Registers[i]
+		}
+
+		buff.WriteByte(byte(reg.Register))
+		writeUint(buff, reg.Value)
+	}
+
+	writeUint(buff, sink.internFunction(event.Function))
+
+	length := len(sink.records) / __TRACE_RECORD_SIZE
+	copy(sink.records[sink.next*__TRACE_RECORD_SIZE:], buff.Bytes())
+	sink.next = (sink.next + 1) % length
+
+	if sink.count < length {
+		sink.count++
+	}
+
+	return nil
+}
+
+func (sink *RingTraceSink) internFunction(name string) uint16 {
+	if name == "" {
+		return 0
+	}
+
+	index, ok := sink.functionIndex[name]
+
+	if !ok {
+		sink.functions = append(sink.functions, name)
+		index = uint16(len(sink.functions))
+		sink.functionIndex[name] = index
+	}
+
+	return index
+}
+
+// Events gives the events kept, oldest first.
+func (sink *RingTraceSink) Events() []TraceEvent {
+	length := len(sink.records) / __TRACE_RECORD_SIZE
+	start := (sink.next - sink.count + length) % length
+	events := make([]TraceEvent, sink.count)
+
+	for i := range events {
+		offset := ((start + i) % length) * __TRACE_RECORD_SIZE
+		events[i] = sink.decode(sink.records[offset : offset+__TRACE_RECORD_SIZE])
+	}
+
+	return events
+}
+
+func (sink *RingTraceSink) decode(record []byte) TraceEvent {
+	order := endian.LittleEndian
+	event := TraceEvent{
+		Step: order.Uint64(record[0:]),
+		PC:   Address(order.Uint64(record[8:])),
+	}
+	event.Operation.OpCode = OpCode(record[16])
+	event.Operation.Operand[0] = Operand(order.Uint64(record[17:]))
+	event.Operation.Operand[1] = Operand(order.Uint64(record[25:]))
+	registerCount := int(record[33])
+
+	for i := 0; i < registerCount; i++ {
+		offset := 34 + i*9
+		event.Registers = append(event.Registers, RegisterValue{
+			Register: Address(record[offset]),
+			Value:    order.Uint64(record[offset+1:]),
+		})
+	}
+
+	if function := order.Uint16(record[52:]); function != 0 {
+		event.Function = sink.functions[function-1]
+	}
+
+	return event
+}
+
+// Dump writes the events kept, oldest first, in the format of TextTraceSink.
+// Positions are looked up in process, which may be nil.
+func (sink *RingTraceSink) Dump(w io.Writer, process *Process) {
+	for _, event := range sink.Events() {
+		if process != nil {
+			event.Position = process.Position(event.PC)
+		}
+
+		fmt.Fprintln(w, event.String())
+	}
+}
+
+const __TRACE_MAX_REGISTERS = 2
+const __TRACE_RECORD_SIZE = 54
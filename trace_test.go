@@ -0,0 +1,149 @@
+package shapes
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+func traceTestByteCode() []Operation {
+	return []Operation{
+		Operation{OpCode: OP_SET, Operand: [2]Operand{1, 3}},
+		Operation{OpCode: OP_ADD, Operand: [2]Operand{0, 1}},
+		Operation{OpCode: OP_PUSHI, Operand: [2]Operand{2, 7}},
+		Operation{OpCode: OP_CALL, Operand: [2]Operand{0, 2}},
+	}
+}
+
+func runTrace(t *testing.T, sink TraceSink) *Process {
+	t.Helper()
+
+	canned := makeInputProcess(traceTestByteCode(), []byte{})
+	canned.process.Positions = []asm.SourcePos{
+		asm.SourcePos{},
+		asm.SourcePos{File: "prog.bf", Line: 2, Column: 5},
+		asm.SourcePos{},
+		asm.SourcePos{},
+	}
+	runtime := canned.makeRuntime()
+	runtime.Trace = sink
+	err := runtime.Execute()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	return canned.process
+}
+
+func TestTextTraceSink(t *testing.T) {
+	buff := &bytes.Buffer{}
+	runTrace(t, &TextTraceSink{W: buff})
+
+	expected := `1 0 SET 1 3 r1=3
+2 1 ADD 0 1 r0=3 r1=3 prog.bf:2:5
+3 2 PUSHI 2 7
+4 3 CALL 0 2 vm_func_a
+`
+
+	if buff.String() != expected {
+		t.Errorf("Expected:\n%s\nbut received:\n%s", expected, buff.String())
+	}
+}
+
+func TestJSONTraceSink(t *testing.T) {
+	buff := &bytes.Buffer{}
+	runTrace(t, &JSONTraceSink{W: buff})
+
+	lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 lines but received:\n%s", buff.String())
+	}
+
+	record := map[string]interface{}{}
+	err := json.Unmarshal([]byte(lines[1]), &record)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	expected := map[string]interface{}{
+		"step":      float64(2),
+		"pc":        float64(1),
+		"op":        "ADD",
+		"operands":  []interface{}{float64(0), float64(1)},
+		"registers": map[string]interface{}{"r0": float64(3), "r1": float64(3)},
+		"position":  "prog.bf:2:5",
+	}
+
+	if !reflect.DeepEqual(expected, record) {
+		t.Errorf("Expected %v but received %v", expected, record)
+	}
+
+	if !strings.Contains(lines[3], `"function":"vm_func_a"`) {
+		t.Errorf("Expected function in %s", lines[3])
+	}
+}
+
+func TestRingTraceSink(t *testing.T) {
+	ring := MakeRingTraceSink(2)
+	process := runTrace(t, ring)
+	events := ring.Events()
+
+	expected := []TraceEvent{
+		TraceEvent{
+			Step:      3,
+			PC:        2,
+			Operation: traceTestByteCode()[2],
+		},
+		TraceEvent{
+			Step:      4,
+			PC:        3,
+			Operation: traceTestByteCode()[3],
+			Function:  "vm_func_a",
+		},
+	}
+
+	if !reflect.DeepEqual(expected, events) {
+		t.Errorf("Expected %v but received %v", expected, events)
+	}
+
+	ring = MakeRingTraceSink(10)
+	runTrace(t, ring)
+	buff := &bytes.Buffer{}
+	ring.Dump(buff, process)
+
+	if !strings.Contains(buff.String(), "2 1 ADD 0 1 r0=3 r1=3 prog.bf:2:5\n") {
+		t.Errorf("Expected ADD with position in dump:\n%s", buff.String())
+	}
+}
+
+type failingSink struct{}
+
+func (sink failingSink) Trace(event *TraceEvent) error {
+	return errTraceTest
+}
+
+var errTraceTest = errors.New("trace failed")
+
+func TestTraceSink_Failure(t *testing.T) {
+	canned := makeInputProcess(traceTestByteCode(), []byte{})
+	runtime := canned.makeRuntime()
+	runtime.Trace = failingSink{}
+	err := runtime.Execute()
+
+	if errors.Cause(err) != errTraceTest {
+		t.Errorf("Expected trace failure but received %v", err)
+	}
+
+	if runtime.Steps() != 1 {
+		t.Errorf("Expected 1 step but was %d", runtime.Steps())
+	}
+}
@@ -2,19 +2,49 @@ package shapes
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 )
 
 type VmFunction func(runtime *Runtime, stackAddr Address)
 
+// State gives the value kept in the runtime under key, which create makes on
+// first use.  VmFunctions keep their state here rather than in their Library.
+func (runtime *Runtime) State(key interface{}, create func() interface{}) interface{} {
+	if runtime.state == nil {
+		runtime.state = map[interface{}]interface{}{}
+	}
+
+	value, ok := runtime.state[key]
+
+	if !ok {
+		value = create()
+		runtime.state[key] = value
+	}
+
+	return value
+}
+
+// Library holds the VmFunctions of a Runtime.  It must not be changed once
+// constructed, and then may be shared by runtimes, because VmFunctions keep
+// their state in the Runtime.  Make one with StdLib or NewLibrary.
 type Library struct {
 	Functions []VmFunction
 	index     map[string]int
+	// The wrapper behind the tape VmFunctions, if any.
+	tapes *TapeVmWrapper
 }
 
+// AddLibrary appends the functions of other in their order, so libraries
+// composed of the same modules in the same order agree on function indices.
 func (lib *Library) AddLibrary(other *Library) {
-	for name, index := range other.index {
+	for index, name := range other.functionNames() {
 		lib.AddFunction(name, other.Functions[index])
 	}
+
+	if other.tapes != nil {
+		lib.tapes = other.tapes
+	}
 }
 
 func (lib *Library) AddFunction(name string, vmFunc VmFunction) {
@@ -27,6 +57,49 @@ func (lib *Library) AddFunction(name string, vmFunc VmFunction) {
 	lib.index[name] = index
 }
 
+// Override copies lib, replacing its functions with those of other that have
+// the same name.  The replacements keep their indices, so bytecode compiled
+// against lib runs against the copy.  Functions that lib lacks are appended.
+func (lib *Library) Override(other *Library) *Library {
+	copied := &Library{
+		Functions: make([]VmFunction, len(lib.Functions)),
+		index:     map[string]int{},
+		tapes:     lib.tapes,
+	}
+	copy(copied.Functions, lib.Functions)
+
+	for name, index := range lib.index {
+		copied.index[name] = index
+	}
+
+	for name, otherIndex := range other.index {
+		vmFunc := other.Functions[otherIndex]
+
+		if index, ok := copied.index[name]; ok {
+			copied.Functions[index] = vmFunc
+		} else {
+			copied.AddFunction(name, vmFunc)
+		}
+	}
+
+	if other.tapes != nil {
+		copied.tapes = other.tapes
+	}
+
+	return copied
+}
+
+// functionNames gives the name of each function by index.
+func (lib *Library) functionNames() []string {
+	names := make([]string, len(lib.Functions))
+
+	for name, index := range lib.index {
+		names[index] = name
+	}
+
+	return names
+}
+
 func (lib *Library) GetFunctionIndex(name string) (int, error) {
 	index, ok := lib.index[name]
 
@@ -57,12 +130,104 @@ func (lib *Library) GetFunction(name string) (VmFunction, error) {
 	return lib.Functions[index], nil
 }
 
-var __STD_LIB *Library
+// LibraryModule constructs a Library.
+type LibraryModule func() *Library
+
+// RegisterModule names a LibraryModule so that NewLibrary may compose it.  It
+// panics if the name is taken.
+func RegisterModule(name string, module LibraryModule) {
+	__MODULE_LOCK.Lock()
+	defer __MODULE_LOCK.Unlock()
+
+	if _, ok := __MODULES[name]; ok {
+		panic(fmt.Sprintf("Library module '%s' registered twice", name))
+	}
 
+	__MODULES[name] = module
+}
+
+// ModuleNames lists the registered library modules in order.
+func ModuleNames() []string {
+	__MODULE_LOCK.RLock()
+	defer __MODULE_LOCK.RUnlock()
+
+	names := []string{}
+
+	for name := range __MODULES {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// NewLibrary constructs the named modules and adds them, in order, to a new
+// Library.
+func NewLibrary(modules ...string) (*Library, error) {
+	__MODULE_LOCK.RLock()
+	defer __MODULE_LOCK.RUnlock()
+
+	lib := &Library{}
+
+	for _, name := range modules {
+		module, ok := __MODULES[name]
+
+		if !ok {
+			return nil, fmt.Errorf("Unknown library module '%s'", name)
+		}
+
+		lib.AddLibrary(module())
+	}
+
+	return lib, nil
+}
+
+func lookupModule(name string) (LibraryModule, bool) {
+	__MODULE_LOCK.RLock()
+	defer __MODULE_LOCK.RUnlock()
+
+	module, ok := __MODULES[name]
+
+	return module, ok
+}
+
+// StdLib constructs a new Library of the standard modules.
 func StdLib() *Library {
-	if __STD_LIB == nil {
-		__STD_LIB = &Library{}
+	lib, err := NewLibrary(__STD_MODULES...)
+
+	if err != nil {
+		panic(err)
 	}
 
-	return __STD_LIB
+	return lib
 }
+
+const MODULE_TAPE = "tape"
+const MODULE_PLAYFIELD = "playfield"
+const MODULE_IO = "io"
+const MODULE_FUNGE = "funge"
+const MODULE_HEAP = "heap"
+const MODULE_THREAD = "thread"
+const MODULE_PBRAIN = "pbrain"
+
+// New modules go last, so that functions keep their indices in the StdLib.
+var __STD_MODULES = []string{MODULE_TAPE, MODULE_PLAYFIELD, MODULE_IO, MODULE_FUNGE, MODULE_HEAP, MODULE_THREAD, MODULE_PBRAIN}
+
+var __MODULES map[string]LibraryModule
+
+// The funge module loads fingerprints from the registry, so the registry is
+// filled here rather than in its declaration.
+func init() {
+	__MODULES = map[string]LibraryModule{
+		MODULE_TAPE:      InfiniteTapeLibrary,
+		MODULE_PLAYFIELD: PlayfieldLibrary,
+		MODULE_IO:        IOLibrary,
+		MODULE_FUNGE:     FungeLibrary,
+		MODULE_HEAP:      HeapLibrary,
+		MODULE_THREAD:    ThreadLibrary,
+		MODULE_PBRAIN:    PbrainLibrary,
+	}
+}
+
+var __MODULE_LOCK sync.RWMutex
@@ -0,0 +1,187 @@
+package shapes
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+func TestLibraryOverride(t *testing.T) {
+	calls := []string{}
+	record := func(name string) VmFunction {
+		return func(runtime *Runtime, stackAddr Address) {
+			calls = append(calls, name)
+		}
+	}
+
+	lib := &Library{}
+	lib.AddFunction("a", record("old a"))
+	lib.AddFunction("b", record("old b"))
+
+	other := &Library{}
+	other.AddFunction("c", record("new c"))
+	other.AddFunction("b", record("new b"))
+
+	overridden := lib.Override(other)
+
+	for _, name := range []string{"a", "b", "c"} {
+		vmFunc, err := overridden.GetFunction(name)
+
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+			return
+		}
+
+		vmFunc(nil, 0)
+	}
+
+	expected := []string{"old a", "new b", "new c"}
+
+	for i, call := range expected {
+		if calls[i] != call {
+			t.Errorf("Expected call %d to be '%s' but was '%s'", i, call, calls[i])
+		}
+	}
+
+	for _, name := range []string{"a", "b"} {
+		expectIndex, _ := lib.GetFunctionIndex(name)
+		actualIndex, _ := overridden.GetFunctionIndex(name)
+
+		if expectIndex != actualIndex {
+			t.Errorf("Expected '%s' at %d but was at %d", name, expectIndex, actualIndex)
+		}
+	}
+
+	if _, err := lib.GetFunctionIndex("c"); err == nil {
+		t.Error("Expected Override to leave the original library alone")
+	}
+}
+
+func TestNewLibrary(t *testing.T) {
+	first := StdLib()
+	second, err := NewLibrary(MODULE_TAPE)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if first.tapes == second.tapes {
+		t.Error("Expected libraries to have their own tapes")
+	}
+
+	for _, name := range []string{asm.TAPE_NEW, asm.TAPE_MOVE_HEAD, asm.TAPE_READ_HEAD, asm.TAPE_WRITE_HEAD, asm.TAPE_SCAN} {
+		firstIndex, _ := first.GetFunctionIndex(name)
+		secondIndex, err := second.GetFunctionIndex(name)
+
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+		}
+
+		if firstIndex != secondIndex {
+			t.Errorf("Expected '%s' at %d but was at %d", name, firstIndex, secondIndex)
+		}
+	}
+
+	_, err = NewLibrary(MODULE_TAPE, "no_such_module")
+
+	if err == nil {
+		t.Error("Expected error for unknown module")
+	}
+}
+
+func TestRegisterModule(t *testing.T) {
+	expected := []string{MODULE_FUNGE, MODULE_HEAP, MODULE_IO, MODULE_PBRAIN, MODULE_PLAYFIELD, MODULE_TAPE, MODULE_THREAD}
+
+	if names := ModuleNames(); !reflect.DeepEqual(expected, names) {
+		t.Errorf("Expected modules %v but were %v", expected, names)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic registering module twice")
+		}
+	}()
+
+	RegisterModule(MODULE_TAPE, InfiniteTapeLibrary)
+}
+
+// isolationTestSource creates a tape, then increments its first cell 200
+// times.  It writes the index of the tape and the final cell.
+const isolationTestSource = `pushi s0 0
+pushi s0 64
+call tape_new s0
+pop s0 r0
+write r0
+set r1 200
+loop r1 {
+	push s0 r0
+	call tape_read_head s0
+	pop s0 r2
+	addi r2 1
+	push s0 r2
+	push s0 r0
+	call tape_write_head s0
+	subi r1 1
+}
+push s0 r0
+call tape_read_head s0
+pop s0 r2
+write r2
+`
+
+func TestStdLib_Isolated(t *testing.T) {
+	process, err := Assemble([]byte(isolationTestSource), StdLib())
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	const runtimeCount = 8
+	outputs := make([]*bytes.Buffer, runtimeCount)
+	errs := make([]error, runtimeCount)
+	wait := sync.WaitGroup{}
+
+	for i := 0; i < runtimeCount; i++ {
+		outputs[i] = &bytes.Buffer{}
+		builder := &RuntimeBuilder{
+			Process: MakeProcess(process.ByteCode),
+			Library: StdLib(),
+			Input:   &bytes.Buffer{},
+			Output:  outputs[i],
+		}
+		runtime := builder.Build()
+
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			errs[i] = runtime.Execute()
+		}(i)
+	}
+
+	wait.Wait()
+
+	expected := []byte{0, 200}
+
+	for i := 0; i < runtimeCount; i++ {
+		if errs[i] != nil {
+			t.Errorf("Runtime %d: unexpected error: %s", i, errs[i].Error())
+		}
+
+		if !bytes.Equal(expected, outputs[i].Bytes()) {
+			t.Errorf("Runtime %d: expected %v but received %v", i, expected, outputs[i].Bytes())
+		}
+	}
+}
+
+// pushArgs pushes args and a return address for a VmFunction, which pops the
+// first of args first, after the return address.
+func pushArgs(process *Process, args ...uint64) {
+	for i := len(args) - 1; i >= 0; i-- {
+		process.Push(0, args[i])
+	}
+
+	process.Push(0, 0)
+}
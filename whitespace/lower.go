@@ -0,0 +1,411 @@
+package whitespace
+
+import (
+	"fmt"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Instruction is a Whitespace instruction and its argument, if it takes one.
+type Instruction struct {
+	Kind InstructionKind
+	// Number is the argument of PUSH, COPY and SLIDE.
+	Number int64
+	// Label is the argument of flow control, as binary digits.
+	Label string
+	// Pos is the source of the instruction, given to the statements it
+	// lowers to.
+	Pos asm.SourcePos
+}
+
+type InstructionKind byte
+
+const (
+	PUSH = InstructionKind(iota)
+	DUPLICATE
+	// Copy the item Number places below the top to the top.
+	COPY
+	SWAP
+	DISCARD
+	// Discard Number items below the top.
+	SLIDE
+	ADD
+	SUB
+	MUL
+	DIV
+	MOD
+	STORE
+	RETRIEVE
+	MARK
+	CALL
+	JUMP
+	JUMP_ZERO
+	JUMP_NEGATIVE
+	RETURN
+	END
+	OUTPUT_CHAR
+	OUTPUT_NUMBER
+	READ_CHAR
+	READ_NUMBER
+)
+
+var __INSTRUCTION_STRING = []string{
+	"PUSH",
+	"DUPLICATE",
+	"COPY",
+	"SWAP",
+	"DISCARD",
+	"SLIDE",
+	"ADD",
+	"SUB",
+	"MUL",
+	"DIV",
+	"MOD",
+	"STORE",
+	"RETRIEVE",
+	"MARK",
+	"CALL",
+	"JUMP",
+	"JUMP_ZERO",
+	"JUMP_NEGATIVE",
+	"RETURN",
+	"END",
+	"OUTPUT_CHAR",
+	"OUTPUT_NUMBER",
+	"READ_CHAR",
+	"READ_NUMBER",
+}
+
+func (kind InstructionKind) String() string {
+	return __INSTRUCTION_STRING[kind]
+}
+
+func (instruction Instruction) String() string {
+	switch instruction.Kind {
+	case PUSH, COPY, SLIDE:
+		return fmt.Sprintf("%v(%d)", instruction.Kind, instruction.Number)
+	case MARK, CALL, JUMP, JUMP_ZERO, JUMP_NEGATIVE:
+		return fmt.Sprintf("%v(%s)", instruction.Kind, instruction.Label)
+	}
+
+	return instruction.Kind.String()
+}
+
+// Lower translates instructions into an AST.  Popping the empty stack and
+// returning from outside a subroutine are runtime errors, as are jumps to
+// labels that are not marked, which the compiler reports.
+func Lower(instructions []Instruction) *asm.AST {
+	lower := &lowerer{builder: &asm.ASTBuilder{}}
+
+	for _, instruction := range instructions {
+		lower.builder.Pos = instruction.Pos
+		lower.instruction(instruction)
+	}
+
+	lower.builder.Pos = asm.SourcePos{}
+	lower.builder.Append(&asm.LabelStmt{Name: __END_LABEL})
+
+	return lower.builder.AST
+}
+
+type lowerer struct {
+	builder    *asm.ASTBuilder
+	labelCount int
+}
+
+func (lower *lowerer) instruction(instruction Instruction) {
+	builder := lower.builder
+
+	switch instruction.Kind {
+	case PUSH:
+		stmt := &asm.PushImmediateStmt{}
+		stmt.Operand = [2]int{__DATA_STACK, int(instruction.Number)}
+		builder.Append(stmt)
+	case DUPLICATE:
+		builder.Append(
+			popData(__A_REGISTER),
+			pushData(__A_REGISTER),
+			pushData(__A_REGISTER),
+		)
+	case COPY:
+		lower.copy(int(instruction.Number))
+	case SWAP:
+		builder.Append(
+			popData(__A_REGISTER),
+			popData(__B_REGISTER),
+			pushData(__A_REGISTER),
+			pushData(__B_REGISTER),
+		)
+	case DISCARD:
+		builder.Append(popData(__A_REGISTER))
+	case SLIDE:
+		builder.Append(
+			popData(__B_REGISTER),
+			set(__COUNT_REGISTER, int(instruction.Number)),
+		)
+		builder.OpenLoop(__COUNT_REGISTER)
+		builder.Append(
+			popData(__A_REGISTER),
+			subImmediate(__COUNT_REGISTER, 1),
+		)
+		builder.LeaveBlock()
+		builder.Append(pushData(__B_REGISTER))
+	case ADD:
+		lower.arithmetic(&asm.AddStmt{TwoOperandStmt: operands(__B_REGISTER, __A_REGISTER)})
+	case SUB:
+		lower.arithmetic(&asm.SubStmt{TwoOperandStmt: operands(__B_REGISTER, __A_REGISTER)})
+	case MUL:
+		lower.arithmetic(&asm.MulStmt{TwoOperandStmt: operands(__B_REGISTER, __A_REGISTER)})
+	case DIV:
+		lower.divide(false)
+	case MOD:
+		lower.divide(true)
+	case STORE:
+		builder.Append(
+			popData(__A_REGISTER),
+			popData(__B_REGISTER),
+			push(__A_REGISTER),
+			push(__B_REGISTER),
+			call(asm.HEAP_STORE),
+		)
+	case RETRIEVE:
+		builder.Append(
+			popData(__A_REGISTER),
+			push(__A_REGISTER),
+			call(asm.HEAP_RETRIEVE),
+			pop(__A_REGISTER),
+			pushData(__A_REGISTER),
+		)
+	case MARK:
+		builder.Append(&asm.LabelStmt{Name: label(instruction.Label)})
+	case CALL:
+		stmt := &asm.CallSubStmt{Label: label(instruction.Label)}
+		stmt.Operand[0] = __DATA_STACK
+		builder.Append(stmt)
+	case JUMP:
+		builder.Append(&asm.JumpAlwaysStmt{Label: label(instruction.Label)})
+	case JUMP_ZERO:
+		builder.Append(
+			popData(__A_REGISTER),
+			jumpZero(__A_REGISTER, label(instruction.Label)),
+		)
+	case JUMP_NEGATIVE:
+		jump := &asm.JumpStmt{Label: label(instruction.Label)}
+		jump.Operand[0] = __A_REGISTER
+
+		builder.Append(
+			popData(__A_REGISTER),
+			set(__CONSTANT_REGISTER, 0),
+			&asm.SignedLessStmt{TwoOperandStmt: operands(__A_REGISTER, __CONSTANT_REGISTER)},
+			jump,
+		)
+	case RETURN:
+		builder.Append(&asm.ReturnStmt{})
+	case END:
+		builder.Append(&asm.JumpAlwaysStmt{Label: __END_LABEL})
+	case OUTPUT_CHAR:
+		write := &asm.WriteStmt{}
+		write.Operand = __A_REGISTER
+
+		builder.Append(popData(__A_REGISTER), write)
+	case OUTPUT_NUMBER:
+		builder.Append(
+			popData(__A_REGISTER),
+			push(__A_REGISTER),
+			call(asm.IO_WRITE_INT),
+		)
+	case READ_CHAR:
+		read := &asm.ReadStmt{}
+		read.Operand = __B_REGISTER
+
+		builder.Append(popData(__A_REGISTER), read)
+		lower.storeInput()
+	case READ_NUMBER:
+		builder.Append(
+			popData(__A_REGISTER),
+			call(asm.IO_READ_INT),
+			pop(__B_REGISTER),
+		)
+		lower.storeInput()
+	default:
+		panic(fmt.Sprintf("Unknown instruction %v", instruction))
+	}
+}
+
+// copy moves the items above the one copied to the scratch stack, and then
+// back.
+func (lower *lowerer) copy(n int) {
+	builder := lower.builder
+	builder.Append(set(__COUNT_REGISTER, n))
+	builder.OpenLoop(__COUNT_REGISTER)
+	builder.Append(
+		popData(__A_REGISTER),
+		pushStack(__SCRATCH_STACK, __A_REGISTER),
+		subImmediate(__COUNT_REGISTER, 1),
+	)
+	builder.LeaveBlock()
+	builder.Append(
+		popData(__B_REGISTER),
+		pushData(__B_REGISTER),
+		set(__COUNT_REGISTER, n),
+	)
+	builder.OpenLoop(__COUNT_REGISTER)
+	builder.Append(
+		popStack(__SCRATCH_STACK, __A_REGISTER),
+		pushData(__A_REGISTER),
+		subImmediate(__COUNT_REGISTER, 1),
+	)
+	builder.LeaveBlock()
+	builder.Append(pushData(__B_REGISTER))
+}
+
+// arithmetic pops the right operand into A and the left into B, and pushes
+// the result that op leaves in B.
+func (lower *lowerer) arithmetic(op asm.Statement) {
+	lower.builder.Append(
+		popData(__A_REGISTER),
+		popData(__B_REGISTER),
+		op,
+		pushData(__B_REGISTER),
+	)
+}
+
+// divide rounds the quotient toward negative infinity, so that the
+// remainder takes the sign of the divisor, as in the Haskell reference
+// interpreter.  The VM rounds toward zero, so when the remainder is not zero
+// and its sign differs from the divisor's, the quotient is one too large and
+// the remainder is short by the divisor.
+func (lower *lowerer) divide(modulo bool) {
+	builder := lower.builder
+	done := lower.newLabel()
+	remainder := &asm.SignedModStmt{TwoOperandStmt: operands(__C_REGISTER, __A_REGISTER)}
+
+	builder.Append(
+		popData(__A_REGISTER),
+		popData(__B_REGISTER),
+		&asm.CopyStmt{TwoOperandStmt: operands(__C_REGISTER, __B_REGISTER)},
+		remainder,
+	)
+
+	if modulo {
+		builder.Append(&asm.CopyStmt{TwoOperandStmt: operands(__B_REGISTER, __C_REGISTER)})
+	} else {
+		builder.Append(&asm.SignedDivStmt{TwoOperandStmt: operands(__B_REGISTER, __A_REGISTER)})
+	}
+
+	builder.Append(
+		jumpZero(__C_REGISTER, done),
+		&asm.XorStmt{TwoOperandStmt: operands(__C_REGISTER, __A_REGISTER)},
+		set(__CONSTANT_REGISTER, 0),
+		&asm.SignedLessStmt{TwoOperandStmt: operands(__C_REGISTER, __CONSTANT_REGISTER)},
+		jumpZero(__C_REGISTER, done),
+	)
+
+	if modulo {
+		builder.Append(&asm.AddStmt{TwoOperandStmt: operands(__B_REGISTER, __A_REGISTER)})
+	} else {
+		builder.Append(subImmediate(__B_REGISTER, 1))
+	}
+
+	builder.Append(
+		&asm.LabelStmt{Name: done},
+		pushData(__B_REGISTER),
+	)
+}
+
+// storeInput stores the value read into B at the heap address in A.
+func (lower *lowerer) storeInput() {
+	lower.builder.Append(
+		push(__B_REGISTER),
+		push(__A_REGISTER),
+		call(asm.HEAP_STORE),
+	)
+}
+
+func (lower *lowerer) newLabel() string {
+	lower.labelCount++
+	return fmt.Sprintf("whitespace_label_%d", lower.labelCount)
+}
+
+func label(bits string) string {
+	return __LABEL_PREFIX + bits
+}
+
+func operands(first, second int) asm.TwoOperandStmt {
+	stmt := asm.TwoOperandStmt{}
+	stmt.Operand = [2]int{first, second}
+	return stmt
+}
+
+func set(register, value int) *asm.SetStmt {
+	stmt := &asm.SetStmt{}
+	stmt.Operand = [2]int{register, value}
+	return stmt
+}
+
+func subImmediate(register, value int) *asm.SubImmediateStmt {
+	stmt := &asm.SubImmediateStmt{}
+	stmt.Operand = [2]int{register, value}
+	return stmt
+}
+
+func jumpZero(register int, label string) *asm.JumpZeroStmt {
+	stmt := &asm.JumpZeroStmt{Label: label}
+	stmt.Operand[0] = register
+	return stmt
+}
+
+func pushStack(stack, register int) *asm.PushStmt {
+	stmt := &asm.PushStmt{}
+	stmt.Operand = [2]int{stack, register}
+	return stmt
+}
+
+func popStack(stack, register int) *asm.PopStmt {
+	stmt := &asm.PopStmt{}
+	stmt.Operand = [2]int{stack, register}
+	return stmt
+}
+
+func pushData(register int) *asm.PushStmt {
+	return pushStack(__DATA_STACK, register)
+}
+
+func popData(register int) *asm.PopStmt {
+	return popStack(__DATA_STACK, register)
+}
+
+func push(register int) *asm.PushStmt {
+	return pushStack(__CALL_STACK, register)
+}
+
+func pop(register int) *asm.PopStmt {
+	return popStack(__CALL_STACK, register)
+}
+
+func call(vmFunc string) *asm.CallStmt {
+	stmt := &asm.CallStmt{VmFunc: vmFunc}
+	stmt.Operand = __CALL_STACK
+	return stmt
+}
+
+// VmFunctions take their arguments on the call stack, the Whitespace stack is
+// the data stack, and COPY sets items aside on the scratch stack.
+const (
+	__CALL_STACK = iota
+	__DATA_STACK
+	__SCRATCH_STACK
+)
+
+const (
+	__A_REGISTER = iota
+	__B_REGISTER
+	__C_REGISTER
+	__COUNT_REGISTER
+	__CONSTANT_REGISTER
+)
+
+// The labels of the program are binary digits after the prefix, so they never
+// clash with the labels of the frontend.
+const __LABEL_PREFIX = "whitespace_"
+const __END_LABEL = "whitespace_end"
@@ -0,0 +1,206 @@
+package whitespace
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/johnny-morrice/shapes/asm"
+)
+
+// Parse compiles Whitespace.  The Whitespace stack is a VM stack, and labels,
+// subroutines and the heap are those of the VM.
+func Parse(source []byte) (*asm.AST, error) {
+	instructions, err := ParseInstructions(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Lower(instructions), nil
+}
+
+// ParseInstructions reads the instructions spelled by the spaces, tabs and
+// linefeeds of the source, which ignores all other characters.
+func ParseInstructions(source []byte) ([]Instruction, error) {
+	tokens := tokenize(source)
+	instructions := []Instruction{}
+
+	for len(tokens) > 0 {
+		pos := tokens[0].pos
+		code := ""
+		spec, ok := instructionSpec{}, false
+
+		for !ok {
+			if len(code) == __MAX_CODE_LENGTH {
+				return nil, fmt.Errorf("Unknown instruction at %v", pos)
+			}
+
+			if len(tokens) == 0 {
+				return nil, fmt.Errorf("Incomplete instruction at %v", pos)
+			}
+
+			code += string(tokens[0].chr)
+			tokens = tokens[1:]
+			spec, ok = __INSTRUCTIONS[code]
+		}
+
+		instruction := Instruction{Kind: spec.kind, Pos: pos}
+		var err error
+
+		switch spec.argument {
+		case __NUMBER_ARGUMENT:
+			instruction.Number, tokens, err = parseNumber(tokens, pos)
+		case __LABEL_ARGUMENT:
+			instruction.Label, tokens, err = parseLabel(tokens, pos)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if instruction.Number < 0 && (spec.kind == COPY || spec.kind == SLIDE) {
+			return nil, fmt.Errorf("Negative argument to %v at %v", spec.kind, pos)
+		}
+
+		instructions = append(instructions, instruction)
+	}
+
+	return instructions, nil
+}
+
+// parseNumber reads a sign, then binary digits up to a linefeed.
+func parseNumber(tokens []token, pos asm.SourcePos) (int64, []token, error) {
+	if len(tokens) == 0 {
+		return 0, nil, fmt.Errorf("Unterminated number at %v", pos)
+	}
+
+	sign := tokens[0].chr
+
+	if sign == __LINEFEED {
+		return 0, tokens[1:], nil
+	}
+
+	bits, rest, err := parseLabel(tokens[1:], pos)
+
+	if err != nil {
+		return 0, nil, fmt.Errorf("Unterminated number at %v", pos)
+	}
+
+	bits = strings.TrimLeft(bits, "0")
+
+	if len(bits) > __MAX_NUMBER_BITS {
+		return 0, nil, fmt.Errorf("Number too large at %v", pos)
+	}
+
+	number := int64(0)
+
+	for _, bit := range bits {
+		number = number<<1 | int64(bit-'0')
+	}
+
+	if sign == __TAB {
+		number = -number
+	}
+
+	return number, rest, nil
+}
+
+// parseLabel reads binary digits up to a linefeed, giving them as a string of
+// zeros and ones.
+func parseLabel(tokens []token, pos asm.SourcePos) (string, []token, error) {
+	bits := []byte{}
+
+	for i, tok := range tokens {
+		switch tok.chr {
+		case __SPACE:
+			bits = append(bits, '0')
+		case __TAB:
+			bits = append(bits, '1')
+		default:
+			return string(bits), tokens[i+1:], nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("Unterminated label at %v", pos)
+}
+
+type token struct {
+	chr byte
+	pos asm.SourcePos
+}
+
+func tokenize(source []byte) []token {
+	tokens := []token{}
+	pos := asm.SourcePos{Line: 1, Column: 1}
+
+	for _, chr := range source {
+		switch chr {
+		case ' ':
+			tokens = append(tokens, token{chr: __SPACE, pos: pos})
+		case '\t':
+			tokens = append(tokens, token{chr: __TAB, pos: pos})
+		case '\n':
+			tokens = append(tokens, token{chr: __LINEFEED, pos: pos})
+		}
+
+		if chr == '\n' {
+			pos.Line++
+			pos.Column = 1
+		} else {
+			pos.Column++
+		}
+	}
+
+	return tokens
+}
+
+type argumentKind byte
+
+const (
+	__NO_ARGUMENT = argumentKind(iota)
+	__NUMBER_ARGUMENT
+	__LABEL_ARGUMENT
+)
+
+type instructionSpec struct {
+	kind     InstructionKind
+	argument argumentKind
+}
+
+// Instructions are spelled with S for space, T for tab and L for linefeed.
+// No instruction is a prefix of another.
+var __INSTRUCTIONS = map[string]instructionSpec{
+	"SS":   {PUSH, __NUMBER_ARGUMENT},
+	"SLS":  {DUPLICATE, __NO_ARGUMENT},
+	"STS":  {COPY, __NUMBER_ARGUMENT},
+	"SLT":  {SWAP, __NO_ARGUMENT},
+	"SLL":  {DISCARD, __NO_ARGUMENT},
+	"STL":  {SLIDE, __NUMBER_ARGUMENT},
+	"TSSS": {ADD, __NO_ARGUMENT},
+	"TSST": {SUB, __NO_ARGUMENT},
+	"TSSL": {MUL, __NO_ARGUMENT},
+	"TSTS": {DIV, __NO_ARGUMENT},
+	"TSTT": {MOD, __NO_ARGUMENT},
+	"TTS":  {STORE, __NO_ARGUMENT},
+	"TTT":  {RETRIEVE, __NO_ARGUMENT},
+	"LSS":  {MARK, __LABEL_ARGUMENT},
+	"LST":  {CALL, __LABEL_ARGUMENT},
+	"LSL":  {JUMP, __LABEL_ARGUMENT},
+	"LTS":  {JUMP_ZERO, __LABEL_ARGUMENT},
+	"LTT":  {JUMP_NEGATIVE, __LABEL_ARGUMENT},
+	"LTL":  {RETURN, __NO_ARGUMENT},
+	"LLL":  {END, __NO_ARGUMENT},
+	"TLSS": {OUTPUT_CHAR, __NO_ARGUMENT},
+	"TLST": {OUTPUT_NUMBER, __NO_ARGUMENT},
+	"TLTS": {READ_CHAR, __NO_ARGUMENT},
+	"TLTT": {READ_NUMBER, __NO_ARGUMENT},
+}
+
+const __MAX_CODE_LENGTH = 4
+
+// Numbers are cells, which keep 63 bits beside the sign.
+const __MAX_NUMBER_BITS = 63
+
+const __SPACE = 'S'
+const __TAB = 'T'
+const __LINEFEED = 'L'